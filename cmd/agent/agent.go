@@ -70,7 +70,7 @@ func main() {
 		wg.Add(1)
 		go func(ctx context.Context) {
 			defer wg.Done()
-			agent.SendMetrics(ctx, metricsChan, cfg)
+			agent.SendMetrics(ctx, metricsChan, cfg, memoryStats)
 		}(ctx)
 	}
 
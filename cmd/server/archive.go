@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	config "github.com/igortoigildin/go-metrics-altering/config/server"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage/archive"
+)
+
+const archiveUsage = `usage: server export -storage url [-o file]
+       server import -storage url [-i file] [-replace]
+
+Metrics are written and read as JSON lines, stdout and stdin by default.
+Storage URL defaults to STORAGE_URL env, file:// backups are restored before export
+and are not changed by it. Postgres schema is not migrated on export, it must be up to date.
+Imported counters and histograms are added to the existing ones unless -replace is set.
+`
+
+// archiveConfig is used to open storage for export and import. Backup file of in-memory storage
+// is restored, changes are kept in its write-ahead log and saved to the file once the storage is closed.
+func archiveConfig() *config.ConfigServer {
+	return &config.ConfigServer{
+		FlagStoreInterval:    -1,
+		FlagRestore:          true,
+		FlagStoreGenerations: 3,
+		HistoryRetention:     time.Hour,
+	}
+}
+
+// closeStorage closes the storage, if it holds resources such as database connections.
+func closeStorage(s storage.Storage) error {
+	if c, ok := s.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// runExport dumps all metrics of the storage. The number of exported series is reported to out,
+// unless the archive is written there.
+func runExport(args []string, out io.Writer) (err error) {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() { fmt.Fprint(out, archiveUsage) }
+	storageURL := fs.String("storage", os.Getenv("STORAGE_URL"), "storage URL")
+	path := fs.String("o", "", "archive file, stdout if empty")
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if *storageURL == "" {
+		return errors.New("storage URL not set")
+	}
+
+	// source is only read, so its files are left as they are and its schema is checked rather than migrated
+	cfg := archiveConfig()
+	cfg.ReadOnly = true
+	cfg.FlagCheckSchema = true
+	s, err := storage.Open(*storageURL, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, closeStorage(s)) }()
+
+	w := out
+	if *path != "" {
+		f, err := os.Create(*path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	n, err := archive.Export(context.Background(), s, w)
+	if err != nil {
+		return err
+	}
+	if w != out {
+		fmt.Fprintf(out, "exported %d series\n", n)
+	}
+	return nil
+}
+
+// runImport loads metrics into the storage.
+func runImport(args []string, out io.Writer) (err error) {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() { fmt.Fprint(out, archiveUsage) }
+	storageURL := fs.String("storage", os.Getenv("STORAGE_URL"), "storage URL")
+	path := fs.String("i", "", "archive file, stdin if empty")
+	replace := fs.Bool("replace", false, "delete all metrics of the storage before import")
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if *storageURL == "" {
+		return errors.New("storage URL not set")
+	}
+
+	var r io.Reader = os.Stdin
+	if *path != "" {
+		f, err := os.Open(*path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	s, err := storage.Open(*storageURL, archiveConfig())
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, closeStorage(s)) }()
+
+	ctx := context.Background()
+	if *replace {
+		n, err := s.Delete(ctx, "", "*")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "deleted %d series\n", n)
+	}
+
+	n, err := archive.Import(ctx, s, r)
+	fmt.Fprintf(out, "imported %d series\n", n)
+	return err
+}
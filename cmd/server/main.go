@@ -2,23 +2,54 @@ package main
 
 import (
 	"context"
+	"io"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	config "github.com/igortoigildin/go-metrics-altering/config/server"
+	"github.com/igortoigildin/go-metrics-altering/internal/audit"
+	"github.com/igortoigildin/go-metrics-altering/internal/replication"
+	"github.com/igortoigildin/go-metrics-altering/internal/server/graphite"
 	grpcapp "github.com/igortoigildin/go-metrics-altering/internal/server/grpc/app"
 	server "github.com/igortoigildin/go-metrics-altering/internal/server/http/api"
+	"github.com/igortoigildin/go-metrics-altering/internal/server/statsd"
 	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	_ "github.com/igortoigildin/go-metrics-altering/internal/storage/all"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage/cache"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage/ingest"
 	httpServer "github.com/igortoigildin/go-metrics-altering/pkg/httpServer"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 
 	"github.com/igortoigildin/go-metrics-altering/pkg/crypt"
 	"github.com/igortoigildin/go-metrics-altering/pkg/logger"
+	pb "github.com/igortoigildin/go-metrics-altering/pkg/metrics_v1"
 )
 
+// shutdownTimeout limits waiting for gRPC requests and streams on shutdown.
+const shutdownTimeout = 5 * time.Second
+
+// subcommands are run instead of the server when stated as the first argument.
+var subcommands = map[string]func(args []string, out io.Writer) error{
+	"migrate": runMigrate,
+	"export":  runExport,
+	"import":  runImport,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:], os.Stdout); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	cfg, err := config.LoadConfig()
 	if err != nil {
 		log.Fatal("error while logading config", err)
@@ -37,9 +68,75 @@ func main() {
 	if err != nil {
 		logger.Log.Fatal("failed to init storage", zap.Error(err))
 	}
+	backend := storage
+	// stopFollower stops applying changes of the primary, so that storage is not changed once closed
+	stopFollower := func() {}
+
+	// cache is beneath replication, so that changes applied by follower invalidate it
+	if cfg.FlagCacheSize > 0 {
+		storage = cache.New(storage, cache.WithSize(cfg.FlagCacheSize), cache.WithTTL(cfg.CacheTTL))
+	}
+
+	if cfg.FlagReplication || cfg.FlagReplicaOf != "" {
+		opts := []replication.Option{replication.WithLogSize(cfg.FlagReplicationLog)}
+		if cfg.FlagReplicaOf != "" {
+			opts = append(opts, replication.WithPrimary(cfg.FlagReplicaOf))
+		}
+		node := replication.New(storage, opts...)
+		storage = node
+
+		if cfg.FlagReplicaOf != "" {
+			conn, err := grpc.NewClient(cfg.FlagReplicaOf, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				logger.Log.Fatal("failed to connect to primary", zap.Error(err))
+			}
+			defer conn.Close()
+
+			logger.Log.Info("Following primary", zap.String("address", cfg.FlagReplicaOf))
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				if err := node.Follow(ctx, pb.NewReplicationClient(conn)); err != nil {
+					logger.Log.Error("replication stopped", zap.Error(err))
+				}
+			}()
+			stopFollower = func() {
+				cancel()
+				<-done
+			}
+		}
+	}
+
+	// queue is above replication, so that changes are logged for followers only once written,
+	// coalesced into batches, and writes are not serialized by the replication lock while queued
+	var queue *ingest.Queue
+	if cfg.FlagIngestMode != "" {
+		queue = ingest.New(storage,
+			ingest.WithMode(ingest.Mode(cfg.FlagIngestMode)),
+			ingest.WithCapacity(cfg.FlagIngestCapacity),
+			ingest.WithInterval(cfg.IngestInterval),
+		)
+		storage = queue
+	}
+
+	auditor := audit.New()
+	if cfg.FlagAuditFile != "" {
+		file, err := audit.NewFile(cfg.FlagAuditFile,
+			audit.WithMaxSize(int64(cfg.FlagAuditFileSize)<<20),
+			audit.WithBackups(cfg.FlagAuditFileBackups),
+		)
+		if err != nil {
+			logger.Log.Fatal("failed to open audit file", zap.Error(err))
+		}
+		auditor.Register(file)
+	}
+	if cfg.FlagAuditURL != "" {
+		auditor.Register(audit.NewHTTP(cfg.FlagAuditURL, nil))
+	}
 
 	// gRPC
-	application := grpcapp.New(cfg, storage)
+	application := grpcapp.New(cfg, storage, auditor)
 
 	go func() {
 		err := application.GRPCServer.MustRun()
@@ -48,8 +145,30 @@ func main() {
 		}
 	}()
 
+	// StatsD
+	var statsdSrv *statsd.Server
+	if cfg.FlagStatsDAddr != "" {
+		statsdSrv = statsd.New(cfg.FlagStatsDAddr, storage, statsd.WithInterval(cfg.StatsDInterval))
+		if err := statsdSrv.Start(); err != nil {
+			logger.Log.Fatal("error while starting statsd server", zap.Error(err))
+		}
+	}
+
+	// Graphite
+	var graphiteSrv *graphite.Server
+	if cfg.FlagGraphiteAddr != "" {
+		templates, err := graphite.ParseTemplates(cfg.FlagGraphiteTemplates)
+		if err != nil {
+			logger.Log.Fatal("invalid graphite templates", zap.Error(err))
+		}
+		graphiteSrv = graphite.New(cfg.FlagGraphiteAddr, storage, graphite.WithTemplates(templates))
+		if err := graphiteSrv.Start(); err != nil {
+			logger.Log.Fatal("error while starting graphite server", zap.Error(err))
+		}
+	}
+
 	// http
-	r := server.Router(context.Background(), cfg, storage)
+	r := server.Router(context.Background(), cfg, storage, auditor)
 
 	logger.Log.Info("Starting server on", zap.String("address", cfg.FlagRunAddrHTTP))
 
@@ -74,5 +193,45 @@ func main() {
 		logger.Log.Error("error:", zap.Error(err))
 	}
 
+	// every server is given its own timeout, so that e.g. follower streams holding gRPC server
+	// do not leave statsd without time to write aggregated metrics
+	if graphiteSrv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := graphiteSrv.Shutdown(ctx); err != nil {
+			logger.Log.Error("failed to stop graphite server", zap.Error(err))
+		}
+		cancel()
+	}
+
+	// write metrics aggregated since the last flush
+	if statsdSrv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := statsdSrv.Shutdown(ctx); err != nil {
+			logger.Log.Error("failed to write statsd metrics", zap.Error(err))
+		}
+		cancel()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	application.GRPCServer.Stop(ctx)
+
+	auditor.Close()
+
+	stopFollower()
+
+	// write updates left in the queue
+	if queue != nil {
+		if err := queue.Close(); err != nil {
+			logger.Log.Error("failed to flush ingestion queue", zap.Error(err))
+		}
+	}
+
+	if closer, ok := backend.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logger.Log.Error("failed to close storage", zap.Error(err))
+		}
+	}
+
 	logger.Log.Info("Graceful server shutdown complete...")
 }
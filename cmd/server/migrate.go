@@ -0,0 +1,113 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/igortoigildin/go-metrics-altering/pkg/migrations"
+	_ "github.com/lib/pq"
+)
+
+const migrateUsage = `usage: server migrate [-d dsn] command
+
+commands:
+  up             apply all pending migrations
+  down N         roll back N last migrations
+  status         print applied and latest schema versions
+  force VERSION  set schema version without running migrations, -1 for none
+
+DSN defaults to DATABASE_DSN env.
+`
+
+var errMigrateUsage = errors.New("invalid migrate command")
+
+// runMigrate manages schema of the postgres database.
+func runMigrate(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() { fmt.Fprint(out, migrateUsage) }
+	dsn := fs.String("d", os.Getenv("DATABASE_DSN"), "string with DB DSN")
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	args = fs.Args()
+	if len(args) == 0 {
+		fs.Usage()
+		return errMigrateUsage
+	}
+	if *dsn == "" {
+		return errors.New("database DSN not set")
+	}
+
+	command, n, err := parseMigrateCommand(args)
+	if err != nil {
+		fs.Usage()
+		return err
+	}
+
+	db, err := sql.Open("postgres", *dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	switch command {
+	case "up":
+		err = migrator.Up()
+	case "down":
+		err = migrator.Down(n)
+	case "force":
+		err = migrator.Force(n)
+	}
+	if err != nil {
+		return err
+	}
+
+	status, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, status)
+	return nil
+}
+
+// parseMigrateCommand returns migrate command and its numeric argument.
+func parseMigrateCommand(args []string) (string, int, error) {
+	command := args[0]
+	switch command {
+	case "up", "status":
+		if len(args) != 1 {
+			return "", 0, fmt.Errorf("%w: %s takes no arguments", errMigrateUsage, command)
+		}
+		return command, 0, nil
+	case "down", "force":
+		if len(args) != 2 {
+			return "", 0, fmt.Errorf("%w: %s takes one argument", errMigrateUsage, command)
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return "", 0, fmt.Errorf("%w: %s: %w", errMigrateUsage, command, err)
+		}
+		if command == "down" && n <= 0 {
+			return "", 0, fmt.Errorf("%w: down takes positive number of migrations", errMigrateUsage)
+		}
+		if command == "force" && n < -1 {
+			return "", 0, fmt.Errorf("%w: invalid version %d", errMigrateUsage, n)
+		}
+		return command, n, nil
+	}
+	return "", 0, fmt.Errorf("%w: unknown command %q", errMigrateUsage, command)
+}
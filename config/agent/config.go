@@ -2,10 +2,12 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -13,10 +15,12 @@ const (
 	PollInterval   = 2
 	GaugeType      = "gauge"
 	CountType      = "counter"
+	HistogramType  = "histogram"
 	PollCount      = "PollCount"
 	StatusOK       = 200
 	ProtocolScheme = "http://"
 	cfgName        = "config/agent/configAgent.json"
+	defaultBuckets = "0.005,0.01,0.025,0.05,0.1,0.25,0.5,1,2.5,5,10"
 )
 
 const defaultAgentConfig = `{
@@ -40,6 +44,11 @@ type ConfigAgent struct {
 	FlagConfigName     string `json:"config_name"`
 	FlagRSAEncryption  bool
 	FlagRealIP         string
+	// FlagHistogramBuckets is a comma separated list of latency histogram bucket bounds in seconds.
+	FlagHistogramBuckets string    `json:"histogram_buckets"`
+	HistogramBuckets     []float64 // parsed FlagHistogramBuckets
+	// FlagAgentID identifies the agent to the server as the source of its metrics, client IP is used if empty.
+	FlagAgentID string `json:"agent_id"`
 }
 
 func LoadConfig() (*ConfigAgent, error) {
@@ -72,6 +81,8 @@ func LoadConfig() (*ConfigAgent, error) {
 	flag.StringVar(&cfg.FlagConfigName, "c", "configAgent.json", "name of the config with json data")
 	flag.BoolVar(&cfg.FlagRSAEncryption, "rsa-bool", true, "whether communication should be encrypted using rsa keys")
 	flag.StringVar(&cfg.FlagRealIP, "t", "127.0.0.2", "X-Real-IP")
+	flag.StringVar(&cfg.FlagHistogramBuckets, "buckets", defaultBuckets, "comma separated latency histogram bucket bounds in seconds")
+	flag.StringVar(&cfg.FlagAgentID, "id", "", "agent ID sent to the server as the source of metrics")
 	flag.Parse()
 
 	if envRunAddr := os.Getenv("ADDRESS"); envRunAddr != "" {
@@ -115,7 +126,41 @@ func LoadConfig() (*ConfigAgent, error) {
 		cfg.FlagLogLevel = envLogLevel
 	}
 
+	if envBuckets := os.Getenv("HISTOGRAM_BUCKETS"); envBuckets != "" {
+		cfg.FlagHistogramBuckets = envBuckets
+	}
+
+	if envAgentID := os.Getenv("AGENT_ID"); envAgentID != "" {
+		cfg.FlagAgentID = envAgentID
+	}
+
+	cfg.HistogramBuckets, err = ParseBuckets(cfg.FlagHistogramBuckets)
+	if err != nil {
+		log.Fatal("error while parsing histogram buckets", err)
+	}
+
 	cfg.PauseDuration = time.Duration(cfg.FlagReportInterval) * time.Second
 	cfg.URL = ProtocolScheme + cfg.FlagRunAddrHTTP
 	return cfg, err
 }
+
+// ParseBuckets parses comma separated, strictly increasing histogram bucket bounds.
+func ParseBuckets(s string) ([]float64, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(s, ",")
+	bounds := make([]float64, 0, len(parts))
+	for _, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, err
+		}
+		if len(bounds) > 0 && v <= bounds[len(bounds)-1] {
+			return nil, errors.New("bucket bounds must be strictly increasing")
+		}
+		bounds = append(bounds, v)
+	}
+	return bounds, nil
+}
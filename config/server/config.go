@@ -13,11 +13,12 @@ import (
 )
 
 const (
-	GaugeType = "gauge"
-	CountType = "counter"
-	PollCount = "PollCount"
-	timeout   = 10
-	cfgName   = "config/server/configServer.json"
+	GaugeType     = "gauge"
+	CountType     = "counter"
+	HistogramType = "histogram"
+	PollCount     = "PollCount"
+	timeout       = 10
+	cfgName       = "config/server/configServer.json"
 )
 
 const defaultSrvConfig = `{
@@ -42,10 +43,64 @@ type ConfigServer struct {
 	FlagDBDSN         string `json:"database_dsn"`
 	FlagHashKey       string `json:"hash_key"`
 	ContextTimout     time.Duration
+	HistoryRetention  time.Duration
 	FlagCryptoKey     string `json:"crypto_key"`
 	FlagConfigName    string `json:"config_name"`
 	FlagRSAEncryption bool
 	FlagTrustedSubnet string `json:"trusted_subnet"`
+	// FlagHistoryRetention is how long, in seconds, metric samples are kept.
+	FlagHistoryRetention int `json:"history_retention"`
+	// FlagRollupRetention is how long, in seconds, metric samples aggregated into rollups are kept.
+	FlagRollupRetention int `json:"rollup_retention"`
+	RollupRetention     time.Duration
+	// FlagStoreGenerations is how many rotated snapshot files of in-memory storage are kept.
+	FlagStoreGenerations int `json:"store_generations"`
+	// FlagStorageURL selects storage backend by scheme, e.g. memory://, file:///var/lib/metrics.json,
+	// bolt:///var/lib/metrics.db or postgres://host/db. Legacy flags are used when it is not set.
+	FlagStorageURL string `json:"storage_url"`
+	// FlagCheckSchema makes the server refuse to start when postgres schema is behind instead of migrating it.
+	FlagCheckSchema bool `json:"check_schema"`
+	// FlagReplication makes the server primary streaming applied changes to followers over gRPC.
+	FlagReplication bool `json:"replication"`
+	// FlagReplicaOf is gRPC address of the primary server. When set, the server is read-only follower
+	// of the primary until promoted.
+	FlagReplicaOf string `json:"replica_of"`
+	// FlagReplicationLog is how many of the latest changes are kept for followers to resume from.
+	FlagReplicationLog int `json:"replication_log"`
+	// FlagCacheSize is how many metric values are cached in front of the storage, cache is off if zero.
+	FlagCacheSize int `json:"cache_size"`
+	// FlagCacheTTL is how long, in seconds, metric values are cached.
+	FlagCacheTTL int `json:"cache_ttl"`
+	CacheTTL     time.Duration
+	// FlagIngestMode turns on write-behind ingestion: "async" acknowledges updates once queued,
+	// "group" once written. Updates are written as they come if empty.
+	FlagIngestMode string `json:"ingest_mode"`
+	// FlagIngestCapacity is how many series may be queued before updates are rejected.
+	FlagIngestCapacity int `json:"ingest_capacity"`
+	// FlagIngestInterval is how often, in milliseconds, queued updates are written.
+	FlagIngestInterval int `json:"ingest_interval"`
+	IngestInterval     time.Duration
+	// FlagAuditFile is path of JSON lines file metric updates are logged to, off if empty.
+	FlagAuditFile string `json:"audit_file"`
+	// FlagAuditFileSize is size of the audit file in megabytes, starting from which it is rotated.
+	FlagAuditFileSize int `json:"audit_file_size"`
+	// FlagAuditFileBackups is how many rotated audit files are kept.
+	FlagAuditFileBackups int `json:"audit_file_backups"`
+	// FlagAuditURL is endpoint metric updates are posted to, off if empty.
+	FlagAuditURL string `json:"audit_url"`
+	// FlagStatsDAddr is address StatsD metrics are received at over UDP and TCP, off if empty.
+	FlagStatsDAddr string `json:"statsd_address"`
+	// FlagStatsDInterval is how often, in seconds, aggregated StatsD metrics are written.
+	FlagStatsDInterval int `json:"statsd_interval"`
+	StatsDInterval     time.Duration
+	// FlagGraphiteAddr is address Graphite plaintext metrics are received at over TCP, off if empty.
+	FlagGraphiteAddr string `json:"graphite_address"`
+	// FlagGraphiteTemplates are templates, separated by semicolons, turning Graphite paths into metric names
+	// and labels, e.g. "servers.* .host.measurement*".
+	FlagGraphiteTemplates string `json:"graphite_templates"`
+	// ReadOnly makes storage open without changing its files, e.g. for export: backup file
+	// of in-memory storage and its write-ahead log are loaded, but never written.
+	ReadOnly bool
 }
 
 func LoadConfig() (*ConfigServer, error) {
@@ -78,6 +133,27 @@ func LoadConfig() (*ConfigServer, error) {
 	flag.StringVar(&cfg.FlagConfigName, "c", "configServer.json", "name of the config with json data")
 	flag.BoolVar(&cfg.FlagRSAEncryption, "rsa-bool", false, "whether communication should be encrypted using rsa keys")
 	flag.StringVar(&cfg.FlagTrustedSubnet, "t", "127.0.0.0/8", "trusted_subnet")
+	flag.IntVar(&cfg.FlagHistoryRetention, "history-retention", 3600, "metric history retention in seconds")
+	flag.IntVar(&cfg.FlagRollupRetention, "rollup-retention", 30*24*3600, "retention of metric history rollups in seconds")
+	flag.IntVar(&cfg.FlagStoreGenerations, "store-generations", 3, "number of kept metrics backup generations")
+	flag.StringVar(&cfg.FlagStorageURL, "storage", "", "storage URL, e.g. memory://, file:///path, bolt:///path, postgres://host/db")
+	flag.BoolVar(&cfg.FlagCheckSchema, "check-schema", false, "refuse to start when database schema is behind instead of migrating it")
+	flag.BoolVar(&cfg.FlagReplication, "replication", false, "stream applied changes to followers")
+	flag.StringVar(&cfg.FlagReplicaOf, "replica-of", "", "gRPC address of the primary server to follow")
+	flag.IntVar(&cfg.FlagReplicationLog, "replication-log", 100000, "number of the latest changes kept for followers")
+	flag.IntVar(&cfg.FlagCacheSize, "cache-size", 0, "number of cached metric values, 0 turns cache off")
+	flag.IntVar(&cfg.FlagCacheTTL, "cache-ttl", 5, "metric values cache TTL in seconds")
+	flag.StringVar(&cfg.FlagIngestMode, "ingest", "", "write-behind ingestion mode: async or group, off if empty")
+	flag.IntVar(&cfg.FlagIngestCapacity, "ingest-capacity", 100000, "number of queued series before updates are rejected")
+	flag.IntVar(&cfg.FlagIngestInterval, "ingest-interval", 1000, "interval of writing queued updates in milliseconds")
+	flag.StringVar(&cfg.FlagAuditFile, "audit-file", "", "path of the audit log of metric updates")
+	flag.IntVar(&cfg.FlagAuditFileSize, "audit-file-size", 100, "size of the audit log in megabytes before rotation")
+	flag.IntVar(&cfg.FlagAuditFileBackups, "audit-file-backups", 5, "number of kept rotated audit logs")
+	flag.StringVar(&cfg.FlagAuditURL, "audit-url", "", "URL metric updates are posted to for audit")
+	flag.StringVar(&cfg.FlagStatsDAddr, "statsd", "", "address and port to receive StatsD metrics at over UDP and TCP")
+	flag.IntVar(&cfg.FlagStatsDInterval, "statsd-interval", 10, "interval of writing aggregated StatsD metrics in seconds")
+	flag.StringVar(&cfg.FlagGraphiteAddr, "graphite", "", "address and port to receive Graphite plaintext metrics at")
+	flag.StringVar(&cfg.FlagGraphiteTemplates, "graphite-templates", "", "templates of Graphite paths separated by semicolons")
 	flag.Parse()
 
 	if envRunAddr := os.Getenv("ADDRESS_HTTP"); envRunAddr != "" {
@@ -124,6 +200,134 @@ func LoadConfig() (*ConfigServer, error) {
 		cfg.FlagDBDSN = envDBDSN
 	}
 
+	if envStorageURL := os.Getenv("STORAGE_URL"); envStorageURL != "" {
+		cfg.FlagStorageURL = envStorageURL
+	}
+
+	if envHistoryRetention := os.Getenv("HISTORY_RETENTION"); envHistoryRetention != "" {
+		v, err := strconv.Atoi(envHistoryRetention)
+		if err != nil {
+			return nil, err
+		}
+		cfg.FlagHistoryRetention = v
+	}
+
+	if envRollupRetention := os.Getenv("ROLLUP_RETENTION"); envRollupRetention != "" {
+		v, err := strconv.Atoi(envRollupRetention)
+		if err != nil {
+			return nil, err
+		}
+		cfg.FlagRollupRetention = v
+	}
+
+	if envStoreGenerations := os.Getenv("STORE_GENERATIONS"); envStoreGenerations != "" {
+		v, err := strconv.Atoi(envStoreGenerations)
+		if err != nil {
+			return nil, err
+		}
+		cfg.FlagStoreGenerations = v
+	}
+
+	if envCheckSchema := os.Getenv("CHECK_SCHEMA"); envCheckSchema != "" {
+		v, err := strconv.ParseBool(envCheckSchema)
+		if err != nil {
+			return nil, err
+		}
+		cfg.FlagCheckSchema = v
+	}
+
+	if envReplication := os.Getenv("REPLICATION"); envReplication != "" {
+		v, err := strconv.ParseBool(envReplication)
+		if err != nil {
+			return nil, err
+		}
+		cfg.FlagReplication = v
+	}
+
+	if envReplicaOf := os.Getenv("REPLICA_OF"); envReplicaOf != "" {
+		cfg.FlagReplicaOf = envReplicaOf
+	}
+
+	if envCacheSize := os.Getenv("CACHE_SIZE"); envCacheSize != "" {
+		v, err := strconv.Atoi(envCacheSize)
+		if err != nil {
+			return nil, err
+		}
+		cfg.FlagCacheSize = v
+	}
+
+	if envCacheTTL := os.Getenv("CACHE_TTL"); envCacheTTL != "" {
+		v, err := strconv.Atoi(envCacheTTL)
+		if err != nil {
+			return nil, err
+		}
+		cfg.FlagCacheTTL = v
+	}
+
+	if envIngestMode := os.Getenv("INGEST_MODE"); envIngestMode != "" {
+		cfg.FlagIngestMode = envIngestMode
+	}
+
+	if envIngestCapacity := os.Getenv("INGEST_CAPACITY"); envIngestCapacity != "" {
+		v, err := strconv.Atoi(envIngestCapacity)
+		if err != nil {
+			return nil, err
+		}
+		cfg.FlagIngestCapacity = v
+	}
+
+	if envIngestInterval := os.Getenv("INGEST_INTERVAL"); envIngestInterval != "" {
+		v, err := strconv.Atoi(envIngestInterval)
+		if err != nil {
+			return nil, err
+		}
+		cfg.FlagIngestInterval = v
+	}
+
+	if envAuditFile := os.Getenv("AUDIT_FILE"); envAuditFile != "" {
+		cfg.FlagAuditFile = envAuditFile
+	}
+
+	if envAuditFileSize := os.Getenv("AUDIT_FILE_SIZE"); envAuditFileSize != "" {
+		v, err := strconv.Atoi(envAuditFileSize)
+		if err != nil {
+			return nil, err
+		}
+		cfg.FlagAuditFileSize = v
+	}
+
+	if envAuditFileBackups := os.Getenv("AUDIT_FILE_BACKUPS"); envAuditFileBackups != "" {
+		v, err := strconv.Atoi(envAuditFileBackups)
+		if err != nil {
+			return nil, err
+		}
+		cfg.FlagAuditFileBackups = v
+	}
+
+	if envAuditURL := os.Getenv("AUDIT_URL"); envAuditURL != "" {
+		cfg.FlagAuditURL = envAuditURL
+	}
+
+	if envStatsDAddr := os.Getenv("STATSD_ADDRESS"); envStatsDAddr != "" {
+		cfg.FlagStatsDAddr = envStatsDAddr
+	}
+
+	if envStatsDInterval := os.Getenv("STATSD_INTERVAL"); envStatsDInterval != "" {
+		v, err := strconv.Atoi(envStatsDInterval)
+		if err != nil {
+			return nil, err
+		}
+		cfg.FlagStatsDInterval = v
+	}
+
+	if envGraphiteAddr := os.Getenv("GRAPHITE_ADDRESS"); envGraphiteAddr != "" {
+		cfg.FlagGraphiteAddr = envGraphiteAddr
+	}
+
+	if envGraphiteTemplates := os.Getenv("GRAPHITE_TEMPLATES"); envGraphiteTemplates != "" {
+		cfg.FlagGraphiteTemplates = envGraphiteTemplates
+	}
+
 	if envFlagRestore := os.Getenv("RESTORE"); envFlagRestore != "" {
 		v, err := strconv.ParseBool(envFlagRestore)
 		if err != nil {
@@ -132,12 +336,21 @@ func LoadConfig() (*ConfigServer, error) {
 		cfg.FlagRestore = v
 	}
 
+	if cfg.FlagIngestMode != "" && cfg.FlagIngestMode != "async" && cfg.FlagIngestMode != "group" {
+		return nil, fmt.Errorf("unknown ingest mode %q", cfg.FlagIngestMode)
+	}
+
 	// check if any config variables is empty
 	if !cfg.validate() {
 		return nil, errCfgVarEmpty
 	}
 
 	cfg.ContextTimout = timeout * time.Second
+	cfg.HistoryRetention = time.Duration(cfg.FlagHistoryRetention) * time.Second
+	cfg.RollupRetention = time.Duration(cfg.FlagRollupRetention) * time.Second
+	cfg.CacheTTL = time.Duration(cfg.FlagCacheTTL) * time.Second
+	cfg.IngestInterval = time.Duration(cfg.FlagIngestInterval) * time.Millisecond
+	cfg.StatsDInterval = time.Duration(cfg.FlagStatsDInterval) * time.Second
 	return cfg, err
 }
 
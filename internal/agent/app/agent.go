@@ -3,12 +3,14 @@ package agentcmd
 
 import (
 	"context"
+	"time"
 
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
 	config "github.com/igortoigildin/go-metrics-altering/config/agent"
 	agent "github.com/igortoigildin/go-metrics-altering/internal/agent/sendMetrics"
 	"github.com/igortoigildin/go-metrics-altering/internal/models"
 	adapter "github.com/igortoigildin/go-metrics-altering/pkg/interceptors/logging"
+	"github.com/igortoigildin/go-metrics-altering/pkg/interceptors/source"
 	pb "github.com/igortoigildin/go-metrics-altering/pkg/metrics_v1"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
@@ -22,8 +24,14 @@ const (
 	XRealIp = "X-Real-IP"
 )
 
+// LatencyObserver accumulates durations of metric sendings.
+type LatencyObserver interface {
+	ObserveLatency(cfg *config.ConfigAgent, d time.Duration)
+}
+
 // SendMetrics reads metrics from metricsChan and sends it to server.
-func SendMetrics(ctx context.Context, metricsChan <-chan models.Metrics, cfg *config.ConfigAgent) {
+// Durations of JSON sendings are reported to observer.
+func SendMetrics(ctx context.Context, metricsChan <-chan models.Metrics, cfg *config.ConfigAgent, observer LatencyObserver) {
 	logger, _ := zap.NewDevelopment()
 	defer logger.Sync()
 
@@ -52,7 +60,9 @@ func SendMetrics(ctx context.Context, metricsChan <-chan models.Metrics, cfg *co
 			if err != nil {
 				logger.Error("unexpected sending url counter metric error:", zap.Error(err))
 			}
+			start := time.Now()
 			err = agent.SendJSONCounter(int(*metric.Delta), cfg)
+			observer.ObserveLatency(cfg, time.Since(start))
 			if err != nil {
 				logger.Error("unexpected sending json counter metric error:", zap.Error(err))
 			}
@@ -62,8 +72,7 @@ func SendMetrics(ctx context.Context, metricsChan <-chan models.Metrics, cfg *co
 				Name:  "counter",
 				Value: *metric.Delta,
 			}
-			// Add metadata with "X-Real-IP" to RPC call
-			md := grpcMetadata.Pairs(XRealIp, cfg.FlagRealIP)
+			md := callMetadata(cfg)
 
 			resp, err := m.AddCounterMetric(metadata.NewOutgoingContext(ctx, md), &pb.AddCounterRequest{
 				Metric: &counterMetric,
@@ -71,8 +80,8 @@ func SendMetrics(ctx context.Context, metricsChan <-chan models.Metrics, cfg *co
 			if err != nil {
 				logger.Error("error", zap.Error(err))
 			}
-			if resp.Error != "" {
-				logger.Error(resp.Error)
+			if resp.GetError() != "" {
+				logger.Error(resp.GetError())
 			}
 
 		case config.GaugeType:
@@ -80,7 +89,9 @@ func SendMetrics(ctx context.Context, metricsChan <-chan models.Metrics, cfg *co
 			if err != nil {
 				logger.Error("unexpected sending url gauge metric error:", zap.Error(err))
 			}
+			start := time.Now()
 			err = agent.SendJSONGauge(metric.ID, cfg, *metric.Value)
+			observer.ObserveLatency(cfg, time.Since(start))
 			if err != nil {
 				logger.Error("unexpected sending json gauge metric error:", zap.Error(err))
 			}
@@ -91,17 +102,51 @@ func SendMetrics(ctx context.Context, metricsChan <-chan models.Metrics, cfg *co
 				Value: *metric.Value,
 			}
 
-			// Add metadata with "X-Real-IP" to RPC call
-			md := grpcMetadata.Pairs(XRealIp, cfg.FlagRealIP)
+			md := callMetadata(cfg)
 			resp, err := m.AddGaugeMetric(metadata.NewOutgoingContext(ctx, md), &pb.AddGaugeRequest{
 				Metric: &gaugeMetric,
 			})
 			if err != nil {
 				logger.Error("error", zap.Error(err))
 			}
-			if resp.Error != "" {
-				logger.Error(resp.Error)
+			if resp.GetError() != "" {
+				logger.Error(resp.GetError())
+			}
+
+		case config.HistogramType:
+			err := agent.SendJSONHistogram(metric.ID, cfg, metric.Histogram)
+			if err != nil {
+				logger.Error("unexpected sending json histogram metric error:", zap.Error(err))
+			}
+
+			// gRPC
+			histogramMetric := pb.HistogramMetric{
+				Name:   metric.ID,
+				Bounds: metric.Histogram.Bounds,
+				Counts: metric.Histogram.Counts,
+				Sum:    metric.Histogram.Sum,
+				Count:  metric.Histogram.Count,
+			}
+
+			md := callMetadata(cfg)
+			resp, err := m.AddHistogramMetric(metadata.NewOutgoingContext(ctx, md), &pb.AddHistogramRequest{
+				Metric: &histogramMetric,
+			})
+			if err != nil {
+				logger.Error("error", zap.Error(err))
+			}
+			if resp.GetError() != "" {
+				logger.Error(resp.GetError())
 			}
 		}
 	}
 }
+
+// callMetadata returns metadata of RPC calls: "X-Real-IP" and agent ID, if stated by config.
+func callMetadata(cfg *config.ConfigAgent) metadata.MD {
+	md := grpcMetadata.Pairs(XRealIp, cfg.FlagRealIP)
+	if cfg.FlagAgentID != "" {
+		md.Append(source.AgentIDKey, cfg.FlagAgentID)
+	}
+	return md
+}
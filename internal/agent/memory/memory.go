@@ -8,6 +8,7 @@ import (
 	"math/rand/v2"
 	"runtime"
 	"sync"
+	"time"
 
 	config "github.com/igortoigildin/go-metrics-altering/config/agent"
 	"github.com/igortoigildin/go-metrics-altering/internal/models"
@@ -17,6 +18,8 @@ import (
 	"go.uber.org/zap"
 )
 
+const sendLatency = "SendLatency"
+
 var (
 	ErrConnectionFailed = errors.New("connection failed")
 )
@@ -26,6 +29,7 @@ type MemoryStats struct {
 	GaugeMetrics  map[string]float64
 	CounterMetric int
 	RunTimeMem    *runtime.MemStats
+	Latency       *models.Histogram // distribution of metric sending latencies in seconds
 	rwm           sync.RWMutex
 }
 
@@ -38,15 +42,38 @@ func New() *MemoryStats {
 }
 
 // ReadMetrics collects runtime metrics from memory and sends it to agent via metricsChan.
+// Metrics are sent after the lock is released, as senders observe latency while metricsChan is full.
 func (m *MemoryStats) ReadMetrics(cfg *config.ConfigAgent, metricsChan chan models.Metrics) {
 	m.rwm.Lock()
+	metrics := make([]models.Metrics, 0, len(m.GaugeMetrics)+2)
 	for name, value := range m.GaugeMetrics {
-		metric := models.GaugeConstructor(value, name)
-		metricsChan <- metric
+		metrics = append(metrics, models.GaugeConstructor(value, name))
+	}
+	metrics = append(metrics, models.CounterConstructor(int64(m.CounterMetric)))
+	if m.Latency != nil && m.Latency.Count > 0 {
+		metrics = append(metrics, models.HistogramConstructor(m.Latency.Clone(), sendLatency))
+		m.Latency.Reset()
 	}
 	m.rwm.Unlock()
-	metric := models.CounterConstructor(int64(m.CounterMetric))
-	metricsChan <- metric
+
+	for _, metric := range metrics {
+		metricsChan <- metric
+	}
+}
+
+// ObserveLatency adds duration of a single metric sending to the latency histogram.
+func (m *MemoryStats) ObserveLatency(cfg *config.ConfigAgent, d time.Duration) {
+	m.rwm.Lock()
+	defer m.rwm.Unlock()
+
+	if m.Latency == nil {
+		bounds := cfg.HistogramBuckets
+		if len(bounds) == 0 {
+			bounds = models.DefaultBuckets
+		}
+		m.Latency = models.NewHistogram(bounds)
+	}
+	m.Latency.Observe(d.Seconds())
 }
 
 // UpdateCPURAMStat collects and updates in MemoryStats CPU metrics.
@@ -4,6 +4,7 @@
 package memory
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
@@ -51,3 +52,50 @@ func TestMemoryStats_ReadMetrics(t *testing.T) {
 	sm := <-ch
 	assert.NotNil(t, sm.Delta)
 }
+
+func TestMemoryStats_ObserveLatency(t *testing.T) {
+	mem := New()
+	cfg := config.ConfigAgent{HistogramBuckets: []float64{0.1, 1}}
+
+	mem.ObserveLatency(&cfg, 50*time.Millisecond)
+	mem.ObserveLatency(&cfg, 2*time.Second)
+
+	ch := make(chan models.Metrics, 2)
+	mem.ReadMetrics(&cfg, ch)
+
+	<-ch // counter
+	hm := <-ch
+	assert.Equal(t, "SendLatency", hm.ID)
+	assert.Equal(t, config.HistogramType, hm.MType)
+	assert.Equal(t, []uint64{1, 0, 1}, hm.Histogram.Counts)
+	assert.Equal(t, uint64(2), hm.Histogram.Count)
+
+	// histogram is reset after being read
+	assert.Equal(t, uint64(0), mem.Latency.Count)
+}
+
+func TestMemoryStats_ReadMetricsFullChannel(t *testing.T) {
+	mem := New()
+	for i := range 40 {
+		mem.GaugeMetrics[fmt.Sprintf("gauge%d", i)] = float64(i)
+	}
+	cfg := config.ConfigAgent{}
+
+	ch := make(chan models.Metrics, 33)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mem.ReadMetrics(&cfg, ch)
+	}()
+
+	// sender observes latency of every metric it takes from the full channel
+	for range 41 {
+		select {
+		case <-ch:
+			mem.ObserveLatency(&cfg, time.Millisecond)
+		case <-time.After(5 * time.Second):
+			t.Fatal("metrics are not read")
+		}
+	}
+	<-done
+}
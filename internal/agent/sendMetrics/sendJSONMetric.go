@@ -14,6 +14,7 @@ import (
 	"github.com/igortoigildin/go-metrics-altering/internal/models"
 	"github.com/igortoigildin/go-metrics-altering/pkg/crypt"
 	"github.com/igortoigildin/go-metrics-altering/pkg/logger"
+	"github.com/igortoigildin/go-metrics-altering/pkg/middlewares/source"
 	"go.uber.org/zap"
 )
 
@@ -37,6 +38,9 @@ func SendJSONGauge(metricName string, cfg *config.ConfigAgent, value float64) er
 
 	// Add X-Real-IP header as defined by agent config
 	req.SetHeader("X-Real-IP", cfg.FlagRealIP)
+	if cfg.FlagAgentID != "" {
+		req.SetHeader(source.AgentIDHeader, cfg.FlagAgentID)
+	}
 
 	metricsJSON, err := json.Marshal(metric)
 	if err != nil {
@@ -97,6 +101,9 @@ func SendJSONCounter(counter int, cfg *config.ConfigAgent) error {
 
 	// Add X-Real-IP header as defined by agent config
 	req.SetHeader("X-Real-IP", cfg.FlagRealIP)
+	if cfg.FlagAgentID != "" {
+		req.SetHeader(source.AgentIDHeader, cfg.FlagAgentID)
+	}
 
 	metricJSON, err := json.Marshal(metric)
 	if err != nil {
@@ -147,3 +154,72 @@ func SendJSONCounter(counter int, cfg *config.ConfigAgent) error {
 	logger.Log.Info("sent JSON counter metric:", zap.Int("conuter", counter))
 	return nil
 }
+
+// SendJSONHistogram accepts and sends histogram metrics in JSON format to predefined by config server address.
+func SendJSONHistogram(metricName string, cfg *config.ConfigAgent, histogram *models.Histogram) error {
+	agent := resty.New()
+
+	if metricName == "" || histogram == nil {
+		logger.Log.Info("metric data not complete")
+		return errors.New("metric data not complete")
+	}
+
+	metric := models.HistogramConstructor(histogram, metricName)
+	req := agent.R().SetHeader("Content-Type", "application/json")
+
+	// Add X-Real-IP header as defined by agent config
+	req.SetHeader("X-Real-IP", cfg.FlagRealIP)
+	if cfg.FlagAgentID != "" {
+		req.SetHeader(source.AgentIDHeader, cfg.FlagAgentID)
+	}
+
+	metricJSON, err := json.Marshal(metric)
+	if err != nil {
+		logger.Log.Info("marshalling json error:", zap.Error(err))
+		return err
+	}
+
+	// signing metric value with sha256 and setting header accordingly
+	if cfg.FlagHashKey != "" {
+		key := []byte(cfg.FlagHashKey)
+		h := hmac.New(sha256.New, key)
+		h.Write(metricJSON)
+		dst := h.Sum(nil)
+		req.SetHeader("HashSHA256", fmt.Sprintf("%x", dst))
+	}
+
+	if cfg.FlagRSAEncryption {
+		publicKeyPEM, err := os.ReadFile(cfg.FlagCryptoKey)
+		if err != nil {
+			logger.Log.Info("error while reading rsa public key:", zap.Error(err))
+			return err
+		}
+		// encrypting using public key
+		metricJSON, err = crypt.Encrypt(publicKeyPEM, metricJSON)
+		if err != nil {
+			logger.Log.Error("error while encrypting data")
+			return err
+		}
+	}
+
+	_, err = req.SetBody(metricJSON).Post(cfg.URL + updEndpoint)
+	if err != nil {
+		// send again n times if timeout error
+		switch {
+		case os.IsTimeout(err):
+			for _, delay := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+				time.Sleep(delay)
+				if _, err = req.Post(req.URL); err == nil {
+					break
+				}
+			}
+			return ErrConnectionFailed
+		default:
+			logger.Log.Info("unexpected sending metric error:", zap.Error(err))
+			return err
+		}
+	}
+
+	logger.Log.Info("sent JSON histogram metric:", zap.String("name", metricName), zap.Uint64("count", histogram.Count))
+	return nil
+}
@@ -11,6 +11,7 @@ import (
 
 	config "github.com/igortoigildin/go-metrics-altering/config/agent"
 	"github.com/igortoigildin/go-metrics-altering/pkg/logger"
+	"github.com/igortoigildin/go-metrics-altering/pkg/middlewares/source"
 	"go.uber.org/zap"
 )
 
@@ -35,6 +36,9 @@ func SendURLCounter(cfg *config.ConfigAgent, counter int) error {
 
 	// Add X-Real-IP header as defined by agent config
 	r.Header.Add("X-Real-IP", cfg.FlagRealIP)
+	if cfg.FlagAgentID != "" {
+		r.Header.Add(source.AgentIDHeader, cfg.FlagAgentID)
+	}
 
 	client := http.Client{}
 	_, err = client.Do(r)
@@ -78,6 +82,9 @@ func SendURLGauge(cfg *config.ConfigAgent, value float64, metricName string) err
 
 	// Add X-Real-IP header as defined by agent config
 	r.Header.Add("X-Real-IP", cfg.FlagRealIP)
+	if cfg.FlagAgentID != "" {
+		r.Header.Add(source.AgentIDHeader, cfg.FlagAgentID)
+	}
 
 	client := http.Client{}
 
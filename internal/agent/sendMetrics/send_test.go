@@ -292,3 +292,78 @@ func Test_retryURL(t *testing.T) {
 	err := retryURL(client, &r)
 	assert.NotNil(t, err)
 }
+
+func TestSendJSONHistogram(t *testing.T) {
+	type args struct {
+		metricName string
+		cfg        *config.ConfigAgent
+		histogram  *models.Histogram
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var metric models.Metrics
+
+		err := json.NewDecoder(r.Body).Decode(&metric)
+		if err != nil {
+			log.Println(err)
+		}
+		assert.Equal(t, "/update/", r.URL.String())
+		assert.Equal(t, config.HistogramType, metric.MType)
+		assert.NotNil(t, metric.Histogram)
+	}))
+	defer server.Close()
+
+	cfg := config.ConfigAgent{
+		FlagRunAddrHTTP: "localhost:8080",
+		URL:             server.URL,
+		FlagHashKey:     "123",
+	}
+
+	h := models.NewHistogram([]float64{0.1, 1})
+	h.Observe(0.5)
+
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{
+			name: "Success",
+			args: args{
+				metricName: "SendLatency",
+				cfg:        &cfg,
+				histogram:  h,
+			},
+			wantErr: false,
+		},
+		{
+			name: "Histogram not provided",
+			args: args{
+				metricName: "SendLatency",
+				cfg:        &cfg,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SendJSONHistogram(tt.args.metricName, tt.args.cfg, tt.args.histogram); (err != nil) != tt.wantErr {
+				t.Errorf("SendJSONHistogram() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSend_agentID(t *testing.T) {
+	ids := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids <- r.Header.Get("X-Agent-ID")
+	}))
+	defer server.Close()
+
+	cfg := config.ConfigAgent{URL: server.URL, FlagAgentID: "agent-1"}
+	assert.NoError(t, SendJSONCounter(1, &cfg))
+	assert.NoError(t, SendURLGauge(&cfg, 1, "Alloc"))
+	assert.Equal(t, "agent-1", <-ids)
+	assert.Equal(t, "agent-1", <-ids)
+}
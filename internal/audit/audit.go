@@ -0,0 +1,166 @@
+// Package audit notifies observers, such as a log file or an HTTP endpoint, of metric changes made by clients.
+package audit
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/igortoigildin/go-metrics-altering/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// Transports metrics are updated over.
+const (
+	TransportHTTP = "http"
+	TransportGRPC = "grpc"
+)
+
+// Actions made to metrics.
+const (
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+	ActionReset  = "reset"
+)
+
+const (
+	defaultBufferSize = 1024
+	notifyTimeout     = 5 * time.Second
+)
+
+// Event describes a successful change of metrics.
+type Event struct {
+	Timestamp time.Time `json:"ts"`
+	Action    string    `json:"action"`
+	Metrics   []string  `json:"metrics"`    // names of the updated metrics, name patterns of deleted and reset ones
+	IPAddress string    `json:"ip_address"` // client IP, empty if unknown
+	Transport string    `json:"transport"`
+}
+
+// Observer receives audit events.
+type Observer interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// ObserverFunc is an adapter to use ordinary functions as observers.
+type ObserverFunc func(ctx context.Context, event Event) error
+
+func (f ObserverFunc) Notify(ctx context.Context, event Event) error {
+	return f(ctx, event)
+}
+
+// Auditor delivers events to registered observers. Every observer gets events in background in the order
+// they are published, so that slow observers delay neither updates nor other observers.
+// Events are dropped, if the observer falls behind by more than the buffer size.
+// Nil Auditor discards events.
+type Auditor struct {
+	mu         sync.Mutex
+	sinks      []*sink
+	closed     bool
+	bufferSize int
+	wg         sync.WaitGroup
+}
+
+type sink struct {
+	observer Observer
+	events   chan Event
+}
+
+// Option configures Auditor.
+type Option func(*Auditor)
+
+// WithBufferSize sets number of events queued for each observer.
+func WithBufferSize(size int) Option {
+	return func(a *Auditor) {
+		if size > 0 {
+			a.bufferSize = size
+		}
+	}
+}
+
+// New returns auditor without observers.
+func New(opts ...Option) *Auditor {
+	a := &Auditor{bufferSize: defaultBufferSize}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Register starts delivering events to the observer.
+func (a *Auditor) Register(observer Observer) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return
+	}
+	s := &sink{observer: observer, events: make(chan Event, a.bufferSize)}
+	a.sinks = append(a.sinks, s)
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		s.run()
+	}()
+}
+
+// Enabled reports whether any observer is registered.
+func (a *Auditor) Enabled() bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return len(a.sinks) > 0
+}
+
+// Publish queues the event for every observer.
+func (a *Auditor) Publish(event Event) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, s := range a.sinks {
+		select {
+		case s.events <- event:
+		default:
+			logger.Log.Error("audit event dropped, observer falls behind", zap.Strings("metrics", event.Metrics))
+		}
+	}
+}
+
+// Close delivers queued events and stops observers, observers implementing io.Closer are closed.
+// Events published afterwards are discarded.
+func (a *Auditor) Close() {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		for _, s := range a.sinks {
+			close(s.events)
+		}
+		a.sinks = nil
+	}
+	a.mu.Unlock()
+
+	a.wg.Wait()
+}
+
+func (s *sink) run() {
+	for event := range s.events {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		if err := s.observer.Notify(ctx, event); err != nil {
+			logger.Log.Error("failed to deliver audit event", zap.Strings("metrics", event.Metrics), zap.Error(err))
+		}
+		cancel()
+	}
+	if c, ok := s.observer.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			logger.Log.Error("failed to close audit observer", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder records events it is notified of.
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+	closed bool
+	block  chan struct{} // if set, Notify waits for it to be closed
+}
+
+func (r *recorder) Notify(ctx context.Context, event Event) error {
+	if r.block != nil {
+		<-r.block
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return nil
+}
+
+func (r *recorder) names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var res []string
+	for _, e := range r.events {
+		res = append(res, e.Metrics...)
+	}
+	return res
+}
+
+func TestAuditor(t *testing.T) {
+	a := New()
+	first, second := &recorder{}, &recorder{}
+	a.Register(first)
+	a.Register(second)
+	assert.True(t, a.Enabled())
+
+	for _, name := range []string{"Alloc", "PollCount", "RandomValue"} {
+		a.Publish(Event{Timestamp: time.Now(), Metrics: []string{name}, Transport: TransportHTTP})
+	}
+	a.Close()
+
+	// queued events are delivered in order before close
+	assert.Equal(t, []string{"Alloc", "PollCount", "RandomValue"}, first.names())
+	assert.Equal(t, []string{"Alloc", "PollCount", "RandomValue"}, second.names())
+	assert.True(t, first.closed)
+
+	a.Publish(Event{Metrics: []string{"Frees"}})
+	assert.Len(t, first.names(), 3)
+}
+
+func TestAuditor_slowObserver(t *testing.T) {
+	a := New(WithBufferSize(1))
+	slow, fast := &recorder{block: make(chan struct{})}, &recorder{}
+	a.Register(slow)
+	a.Register(fast)
+
+	// slow observer takes the first event and queues the second one, the rest are dropped for it only
+	for _, name := range []string{"a", "b", "c", "d"} {
+		a.Publish(Event{Metrics: []string{name}})
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(slow.block)
+	a.Close()
+
+	assert.Equal(t, []string{"a", "b", "c", "d"}, fast.names())
+	assert.Equal(t, []string{"a", "b"}, slow.names())
+}
+
+func TestAuditor_nil(t *testing.T) {
+	var a *Auditor
+	assert.False(t, a.Enabled())
+	assert.NotPanics(t, func() { a.Publish(Event{Metrics: []string{"Alloc"}}) })
+}
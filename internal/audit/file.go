@@ -0,0 +1,143 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const (
+	defaultMaxSize = 100 << 20
+	defaultBackups = 5
+)
+
+// File appends events to a file, one JSON object per line. Once the file would grow over the maximum size,
+// it is rotated: path.1 is the previous file, path.2 the one before it, and so on. The oldest backup is removed.
+type File struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	f       *os.File
+	size    int64
+}
+
+// FileOption configures File.
+type FileOption func(*File)
+
+// WithMaxSize sets size of the file in bytes, starting from which it is rotated.
+func WithMaxSize(size int64) FileOption {
+	return func(f *File) {
+		if size > 0 {
+			f.maxSize = size
+		}
+	}
+}
+
+// WithBackups sets number of kept rotated files.
+func WithBackups(n int) FileOption {
+	return func(f *File) {
+		if n >= 0 {
+			f.backups = n
+		}
+	}
+}
+
+// NewFile opens the file for appending events.
+func NewFile(path string, opts ...FileOption) (*File, error) {
+	f := &File{path: path, maxSize: defaultMaxSize, backups: defaultBackups}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) Notify(ctx context.Context, event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.f == nil {
+		return os.ErrClosed
+	}
+	if f.size > 0 && f.size+int64(len(line)) > f.maxSize {
+		if err = f.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := f.f.Write(line)
+	f.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return f.f.Sync()
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.f == nil {
+		return nil
+	}
+	err := f.f.Close()
+	f.f = nil
+	return err
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.f, f.size = file, info.Size()
+	return nil
+}
+
+// rotate shifts backups and starts a new file. Caller must hold the lock.
+func (f *File) rotate() error {
+	if err := f.f.Close(); err != nil {
+		return err
+	}
+	f.f = nil
+
+	var err error
+	if f.backups == 0 {
+		err = os.Remove(f.path)
+	}
+	for i := f.backups; i > 0 && err == nil; i-- {
+		err = os.Rename(backupPath(f.path, i-1), backupPath(f.path, i))
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// backupPath returns path of the i-th rotated file, 0 is the current one.
+func backupPath(path string, i int) string {
+	if i == 0 {
+		return path
+	}
+	return path + "." + strconv.Itoa(i)
+}
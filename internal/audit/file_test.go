@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readEvents(t *testing.T, path string) []Event {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var res []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		res = append(res, event)
+	}
+	require.NoError(t, scanner.Err())
+	return res
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	event := Event{Timestamp: ts, Metrics: []string{"Alloc", "PollCount"}, IPAddress: "10.0.0.1", Transport: TransportHTTP}
+
+	f, err := NewFile(path)
+	require.NoError(t, err)
+	require.NoError(t, f.Notify(context.Background(), event))
+	require.NoError(t, f.Close())
+	assert.ErrorIs(t, f.Notify(context.Background(), event), os.ErrClosed)
+
+	// reopened file is appended to
+	f, err = NewFile(path)
+	require.NoError(t, err)
+	require.NoError(t, f.Notify(context.Background(), event))
+	require.NoError(t, f.Close())
+
+	assert.Equal(t, []Event{event, event}, readEvents(t, path))
+}
+
+func TestFile_rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	line, _ := json.Marshal(Event{Metrics: []string{"m0"}})
+
+	// every file fits two events
+	f, err := NewFile(path, WithMaxSize(int64(2*(len(line)+1))), WithBackups(2))
+	require.NoError(t, err)
+	defer f.Close()
+	for _, name := range []string{"m0", "m1", "m2", "m3", "m4", "m5", "m6"} {
+		require.NoError(t, f.Notify(context.Background(), Event{Metrics: []string{name}}))
+	}
+
+	names := func(path string) []string {
+		var res []string
+		for _, e := range readEvents(t, path) {
+			res = append(res, e.Metrics...)
+		}
+		return res
+	}
+	assert.Equal(t, []string{"m6"}, names(path))
+	assert.Equal(t, []string{"m4", "m5"}, names(path+".1"))
+	assert.Equal(t, []string{"m2", "m3"}, names(path+".2"))
+	assert.NoFileExists(t, path+".3")
+}
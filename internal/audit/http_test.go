@@ -0,0 +1,39 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTP(t *testing.T) {
+	var got Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	event := Event{Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Metrics: []string{"Alloc"}, IPAddress: "10.0.0.1", Transport: TransportGRPC}
+	require.NoError(t, NewHTTP(srv.URL, nil).Notify(context.Background(), event))
+	assert.Equal(t, event, got)
+}
+
+func TestHTTP_error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	err := NewHTTP(srv.URL, srv.Client()).Notify(context.Background(), Event{Metrics: []string{"Alloc"}})
+	assert.ErrorContains(t, err, "503")
+}
@@ -0,0 +1,237 @@
+// Package lineprotocol parses InfluxDB line protocol.
+package lineprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Point is a parsed line: measurement, its tags and fields recorded at the time.
+type Point struct {
+	Line        int // number of the line, starting from 1
+	Measurement string
+	Tags        map[string]string
+	// Fields values are float64, int64, uint64, string or bool
+	Fields map[string]any
+	Time   time.Time // zero if the line has no timestamp
+}
+
+// LineError describes why the line could not be parsed.
+type LineError struct {
+	Line int    `json:"line"`
+	Err  string `json:"error"`
+}
+
+func (e LineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+// ParsePrecision returns unit of timestamps stated by the precision parameter. Both InfluxDB v1 (n, u, ms, s, m, h)
+// and v2 (ns, us, ms, s) names are accepted, empty one means nanoseconds.
+func ParsePrecision(s string) (time.Duration, error) {
+	switch s {
+	case "", "n", "ns":
+		return time.Nanosecond, nil
+	case "u", "us", "µ", "µs":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	}
+	return 0, fmt.Errorf("unknown precision %q", s)
+}
+
+// Parse parses every line of data, timestamps are in units of precision. Empty lines and comments are skipped.
+// Points of valid lines are returned along with errors of invalid ones.
+func Parse(data []byte, precision time.Duration) ([]Point, []LineError) {
+	var (
+		points []Point
+		errs   []LineError
+	)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, len(data)+1)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		p, err := parseLine(line, precision)
+		if err != nil {
+			errs = append(errs, LineError{Line: n, Err: err.Error()})
+			continue
+		}
+		p.Line = n
+		points = append(points, p)
+	}
+	return points, errs
+}
+
+const (
+	measurementEscapes = ", "
+	keyEscapes         = ",= "
+)
+
+// scanner reads parts of the line.
+type scanner struct {
+	s string
+	i int
+}
+
+func (sc *scanner) done() bool {
+	return sc.i >= len(sc.s)
+}
+
+func (sc *scanner) peek() byte {
+	if sc.done() {
+		return 0
+	}
+	return sc.s[sc.i]
+}
+
+// until reads up to the first of unescaped stop bytes. Backslash followed by one of escapes stands for it,
+// other backslashes are kept.
+func (sc *scanner) until(stops string, escapes string) string {
+	var b strings.Builder
+	for ; !sc.done(); sc.i++ {
+		c := sc.s[sc.i]
+		if c == '\\' && sc.i+1 < len(sc.s) && strings.IndexByte(escapes, sc.s[sc.i+1]) >= 0 {
+			sc.i++
+			b.WriteByte(sc.s[sc.i])
+			continue
+		}
+		if strings.IndexByte(stops, c) >= 0 {
+			break
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// quoted reads string field value, the scanner is at its opening quote.
+func (sc *scanner) quoted() (string, error) {
+	var b strings.Builder
+	for sc.i++; !sc.done(); sc.i++ {
+		c := sc.s[sc.i]
+		switch {
+		case c == '\\' && sc.i+1 < len(sc.s) && (sc.s[sc.i+1] == '"' || sc.s[sc.i+1] == '\\'):
+			sc.i++
+			b.WriteByte(sc.s[sc.i])
+		case c == '"':
+			sc.i++
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", errors.New("unterminated string field value")
+}
+
+func (sc *scanner) skipSpaces() {
+	for !sc.done() && sc.s[sc.i] == ' ' {
+		sc.i++
+	}
+}
+
+func parseLine(line string, precision time.Duration) (Point, error) {
+	p := Point{Tags: map[string]string{}, Fields: map[string]any{}}
+	sc := &scanner{s: line}
+
+	p.Measurement = sc.until(", ", measurementEscapes)
+	if p.Measurement == "" {
+		return p, errors.New("missing measurement")
+	}
+	for sc.peek() == ',' {
+		sc.i++
+		key := sc.until(keyEscapes, keyEscapes)
+		if sc.peek() != '=' {
+			return p, fmt.Errorf("missing value of tag %q", key)
+		}
+		sc.i++
+		value := sc.until(", ", keyEscapes)
+		if key == "" || value == "" {
+			return p, fmt.Errorf("empty tag %q=%q", key, value)
+		}
+		p.Tags[key] = value
+	}
+
+	sc.skipSpaces()
+	for {
+		key := sc.until(keyEscapes, keyEscapes)
+		if key == "" {
+			return p, errors.New("missing field")
+		}
+		if sc.peek() != '=' {
+			return p, fmt.Errorf("missing value of field %q", key)
+		}
+		sc.i++
+
+		var (
+			value any
+			err   error
+		)
+		if sc.peek() == '"' {
+			value, err = sc.quoted()
+		} else {
+			value, err = parseFieldValue(sc.until(", ", ""))
+		}
+		if err != nil {
+			return p, fmt.Errorf("invalid value of field %q: %w", key, err)
+		}
+		p.Fields[key] = value
+
+		if sc.peek() != ',' {
+			break
+		}
+		sc.i++
+	}
+
+	sc.skipSpaces()
+	if sc.done() {
+		return p, nil
+	}
+	ts, err := strconv.ParseInt(sc.s[sc.i:], 10, 64)
+	if err != nil {
+		return p, fmt.Errorf("invalid timestamp %q", sc.s[sc.i:])
+	}
+	p.Time = time.Unix(0, ts*int64(precision))
+	return p, nil
+}
+
+// parseFieldValue parses unquoted field value: integer with suffix i, unsigned integer with suffix u,
+// boolean or float.
+func parseFieldValue(s string) (any, error) {
+	switch s {
+	case "":
+		return nil, errors.New("empty value")
+	case "t", "T", "true", "True", "TRUE":
+		return true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return false, nil
+	}
+
+	switch s[len(s)-1] {
+	case 'i':
+		return strconv.ParseInt(s[:len(s)-1], 10, 64)
+	case 'u':
+		return strconv.ParseUint(s[:len(s)-1], 10, 64)
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, fmt.Errorf("%q is not a finite number", s)
+	}
+	return v, nil
+}
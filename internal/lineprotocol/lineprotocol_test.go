@@ -0,0 +1,138 @@
+package lineprotocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		precision time.Duration
+		want      Point
+		wantErr   string
+	}{
+		{
+			name:      "Tags, fields and timestamp",
+			line:      `cpu,host=a,region=eu usage=0.5,count=3i,total=7u,up=true,msg="ok" 1700000000`,
+			precision: time.Second,
+			want: Point{
+				Measurement: "cpu",
+				Tags:        map[string]string{"host": "a", "region": "eu"},
+				Fields:      map[string]any{"usage": 0.5, "count": int64(3), "total": uint64(7), "up": true, "msg": "ok"},
+				Time:        time.Unix(1700000000, 0),
+			},
+		},
+		{
+			name:      "Without tags and timestamp",
+			line:      `load value=1`,
+			precision: time.Nanosecond,
+			want:      Point{Measurement: "load", Tags: map[string]string{}, Fields: map[string]any{"value": float64(1)}},
+		},
+		{
+			name:      "Escaped",
+			line:      `disk\ io,path=C:\data,dev=a\,b\=c bytes\ read=1i,msg="say \"hi\", bye" 1700000000000`,
+			precision: time.Millisecond,
+			want: Point{
+				Measurement: "disk io",
+				Tags:        map[string]string{"path": `C:\data`, "dev": "a,b=c"},
+				Fields:      map[string]any{"bytes read": int64(1), "msg": `say "hi", bye`},
+				Time:        time.UnixMilli(1700000000000),
+			},
+		},
+		{
+			name:    "Missing measurement",
+			line:    `,host=a value=1`,
+			wantErr: "missing measurement",
+		},
+		{
+			name:    "Missing fields",
+			line:    `cpu,host=a`,
+			wantErr: "missing field",
+		},
+		{
+			name:    "Tag without value",
+			line:    `cpu,host value=1`,
+			wantErr: `missing value of tag "host"`,
+		},
+		{
+			name:    "Invalid integer",
+			line:    `cpu count=1.5i`,
+			wantErr: `invalid value of field "count"`,
+		},
+		{
+			name:    "Not finite",
+			line:    `cpu usage=NaN`,
+			wantErr: `invalid value of field "usage"`,
+		},
+		{
+			name:    "Unterminated string",
+			line:    `cpu msg="ok`,
+			wantErr: "unterminated string",
+		},
+		{
+			name:    "Invalid timestamp",
+			line:    `cpu usage=1 yesterday`,
+			wantErr: "invalid timestamp",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points, errs := Parse([]byte(tt.line), tt.precision)
+			if tt.wantErr != "" {
+				require.Len(t, errs, 1)
+				assert.Equal(t, 1, errs[0].Line)
+				assert.Contains(t, errs[0].Err, tt.wantErr)
+				assert.Empty(t, points)
+				return
+			}
+			require.Empty(t, errs)
+			require.Len(t, points, 1)
+			tt.want.Line = 1
+			assert.Equal(t, tt.want, points[0])
+		})
+	}
+}
+
+func TestParseLines(t *testing.T) {
+	data := "# comment\n\ncpu usage=1\r\ncpu usage=\nmem used=2i\n"
+
+	points, errs := Parse([]byte(data), time.Nanosecond)
+	require.Len(t, points, 2)
+	assert.Equal(t, 3, points[0].Line)
+	assert.Equal(t, 5, points[1].Line)
+	require.Len(t, errs, 1)
+	assert.Equal(t, 4, errs[0].Line)
+	assert.Equal(t, `line 4: invalid value of field "usage": empty value`, errs[0].Error())
+}
+
+func TestParsePrecision(t *testing.T) {
+	tests := []struct {
+		precision string
+		want      time.Duration
+		wantErr   bool
+	}{
+		{precision: "", want: time.Nanosecond},
+		{precision: "ns", want: time.Nanosecond},
+		{precision: "u", want: time.Microsecond},
+		{precision: "ms", want: time.Millisecond},
+		{precision: "s", want: time.Second},
+		{precision: "h", want: time.Hour},
+		{precision: "d", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.precision, func(t *testing.T) {
+			got, err := ParsePrecision(tt.precision)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
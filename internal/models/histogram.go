@@ -0,0 +1,95 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// DefaultBuckets are upper bounds of histogram buckets in seconds suitable for request latencies.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	ErrInvalidHistogram = errors.New("invalid histogram")
+	ErrBoundsMismatch   = errors.New("histogram bucket bounds mismatch")
+)
+
+// Histogram is a distribution of observed values over buckets.
+// Counts[i] holds number of observations less than or equal to Bounds[i],
+// but greater than the previous bound; the last count is for the implicit +Inf bucket.
+type Histogram struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"`
+	Sum    float64   `json:"sum"`
+	Count  uint64    `json:"count"`
+}
+
+// NewHistogram is constructor for an empty histogram with the stated bucket bounds.
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{
+		Bounds: slices.Clone(bounds),
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// Observe adds a single value to the histogram.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.Bounds, v)
+	h.Counts[i]++
+	h.Sum += v
+	h.Count++
+}
+
+// Validate checks whether bounds are sorted and counts are consistent with them.
+func (h *Histogram) Validate() error {
+	if h == nil {
+		return fmt.Errorf("%w: histogram not provided", ErrInvalidHistogram)
+	}
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return fmt.Errorf("%w: expected %d counts, got %d", ErrInvalidHistogram, len(h.Bounds)+1, len(h.Counts))
+	}
+	for i := 1; i < len(h.Bounds); i++ {
+		if h.Bounds[i] <= h.Bounds[i-1] {
+			return fmt.Errorf("%w: bounds must be strictly increasing", ErrInvalidHistogram)
+		}
+	}
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	if total != h.Count {
+		return fmt.Errorf("%w: count %d does not match sum of bucket counts %d", ErrInvalidHistogram, h.Count, total)
+	}
+	return nil
+}
+
+// Merge adds observations of another histogram with the same bounds.
+func (h *Histogram) Merge(o *Histogram) error {
+	if !slices.Equal(h.Bounds, o.Bounds) {
+		return ErrBoundsMismatch
+	}
+	for i, c := range o.Counts {
+		h.Counts[i] += c
+	}
+	h.Sum += o.Sum
+	h.Count += o.Count
+	return nil
+}
+
+// Clone returns a deep copy of the histogram.
+func (h *Histogram) Clone() *Histogram {
+	return &Histogram{
+		Bounds: slices.Clone(h.Bounds),
+		Counts: slices.Clone(h.Counts),
+		Sum:    h.Sum,
+		Count:  h.Count,
+	}
+}
+
+// Reset drops all observations keeping the bounds.
+func (h *Histogram) Reset() {
+	clear(h.Counts)
+	h.Sum = 0
+	h.Count = 0
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram_Observe(t *testing.T) {
+	h := NewHistogram([]float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.1)
+	h.Observe(0.5)
+	h.Observe(10)
+
+	assert.Equal(t, []uint64{2, 1, 1}, h.Counts)
+	assert.Equal(t, uint64(4), h.Count)
+	assert.InDelta(t, 10.65, h.Sum, 1e-9)
+	assert.NoError(t, h.Validate())
+}
+
+func TestHistogram_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		h       *Histogram
+		wantErr bool
+	}{
+		{
+			name: "Valid",
+			h:    &Histogram{Bounds: []float64{1, 2}, Counts: []uint64{1, 0, 2}, Count: 3},
+		},
+		{
+			name: "Only +Inf bucket",
+			h:    &Histogram{Counts: []uint64{1}, Count: 1},
+		},
+		{
+			name:    "Not provided",
+			h:       nil,
+			wantErr: true,
+		},
+		{
+			name:    "Wrong number of counts",
+			h:       &Histogram{Bounds: []float64{1, 2}, Counts: []uint64{1, 0}, Count: 1},
+			wantErr: true,
+		},
+		{
+			name:    "Unsorted bounds",
+			h:       &Histogram{Bounds: []float64{2, 1}, Counts: []uint64{1, 0, 0}, Count: 1},
+			wantErr: true,
+		},
+		{
+			name:    "Count mismatch",
+			h:       &Histogram{Bounds: []float64{1}, Counts: []uint64{1, 1}, Count: 1},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.h.Validate()
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidHistogram)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestHistogram_Merge(t *testing.T) {
+	h := &Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1}
+
+	err := h.Merge(&Histogram{Bounds: []float64{1}, Counts: []uint64{1, 2}, Sum: 6, Count: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, &Histogram{Bounds: []float64{1}, Counts: []uint64{2, 2}, Sum: 6.5, Count: 4}, h)
+
+	err = h.Merge(&Histogram{Bounds: []float64{2}, Counts: []uint64{1, 0}, Count: 1})
+	assert.ErrorIs(t, err, ErrBoundsMismatch)
+	assert.Equal(t, uint64(4), h.Count)
+}
@@ -0,0 +1,256 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Labels is a set of key/value pairs, which together with metric name and type identifies a series.
+type Labels map[string]string
+
+// Validate checks whether all label names are valid.
+func (l Labels) Validate() error {
+	for k := range l {
+		if !labelNameRe.MatchString(k) {
+			return fmt.Errorf("invalid label name: %q", k)
+		}
+	}
+	return nil
+}
+
+// String returns canonical representation of labels sorted by name, e.g. {host="a",service="b"}.
+// Empty set of labels is represented by an empty string.
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(l[k]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// Equal reports whether both sets contain the same pairs.
+func (l Labels) Equal(o Labels) bool {
+	if len(l) != len(o) {
+		return false
+	}
+	for k, v := range l {
+		if ov, ok := o[k]; !ok || ov != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches reports whether labels satisfy all the matchers.
+func (l Labels) Matches(matchers []*LabelMatcher) bool {
+	for _, m := range matchers {
+		if !m.Matches(l[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Value implements driver.Valuer, labels are stored as JSON object.
+func (l Labels) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return "{}", nil
+	}
+	data, err := json.Marshal(map[string]string(l))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner for labels stored as JSON object.
+func (l *Labels) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported labels type: %T", src)
+	}
+
+	var res map[string]string
+	if err := json.Unmarshal(data, &res); err != nil {
+		return err
+	}
+	if len(res) == 0 {
+		*l = nil
+		return nil
+	}
+	*l = res
+	return nil
+}
+
+// ParseLabels parses labels in canonical form, as returned by Labels.String.
+// Surrounding braces are optional.
+func ParseLabels(s string) (Labels, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "{")
+	s = strings.TrimSuffix(s, "}")
+
+	labels := Labels{}
+	for s != "" {
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("invalid labels: %q", s)
+		}
+		name := strings.TrimSpace(s[:eq])
+
+		rest := strings.TrimSpace(s[eq+1:])
+		value, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of label %q: %w", name, err)
+		}
+		labels[name], _ = strconv.Unquote(value)
+
+		s = strings.TrimSpace(rest[len(value):])
+		if s != "" {
+			if s[0] != ',' {
+				return nil, fmt.Errorf("invalid labels: %q", s)
+			}
+			s = strings.TrimSpace(s[1:])
+		}
+	}
+
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	return labels, labels.Validate()
+}
+
+// SeriesKey returns unique key of a series with the stated name and labels.
+func SeriesKey(name string, labels Labels) string {
+	return name + labels.String()
+}
+
+// MatchType is the type of label matching.
+type MatchType string
+
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+var ErrInvalidMatcher = errors.New("invalid label matcher")
+
+// LabelMatcher filters series by value of a single label.
+// Missing label is matched as an empty value.
+type LabelMatcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+	re    *regexp.Regexp
+}
+
+// NewLabelMatcher is constructor for LabelMatcher.
+func NewLabelMatcher(t MatchType, name, value string) (*LabelMatcher, error) {
+	m := &LabelMatcher{Type: t, Name: name, Value: value}
+
+	switch t {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidMatcher, err)
+		}
+		m.re = re
+	default:
+		return nil, fmt.Errorf("%w: unknown match type %q", ErrInvalidMatcher, t)
+	}
+	return m, nil
+}
+
+// Matches reports whether label value satisfies the matcher.
+func (m *LabelMatcher) Matches(v string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return v == m.Value
+	case MatchNotEqual:
+		return v != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(v)
+	case MatchNotRegexp:
+		return !m.re.MatchString(v)
+	}
+	return false
+}
+
+// String returns matcher in form accepted by ParseLabelMatcher, e.g. host=~"a.*".
+func (m *LabelMatcher) String() string {
+	return m.Name + string(m.Type) + strconv.Quote(m.Value)
+}
+
+// MatcherStrings returns matchers in form accepted by ParseLabelMatcher.
+func MatcherStrings(matchers []*LabelMatcher) []string {
+	res := make([]string, 0, len(matchers))
+	for _, m := range matchers {
+		res = append(res, m.String())
+	}
+	return res
+}
+
+// ParseLabelMatcher parses matcher in form name=value, name!=value, name=~regexp or name!~regexp.
+// Value may optionally be quoted.
+func ParseLabelMatcher(s string) (*LabelMatcher, error) {
+	i := strings.IndexAny(s, "=!")
+	if i <= 0 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidMatcher, s)
+	}
+
+	var t MatchType
+	switch op := s[i:]; {
+	case strings.HasPrefix(op, string(MatchRegexp)):
+		t = MatchRegexp
+	case strings.HasPrefix(op, string(MatchNotRegexp)):
+		t = MatchNotRegexp
+	case strings.HasPrefix(op, string(MatchNotEqual)):
+		t = MatchNotEqual
+	case strings.HasPrefix(op, string(MatchEqual)):
+		t = MatchEqual
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidMatcher, s)
+	}
+
+	name := strings.TrimSpace(s[:i])
+	value := strings.TrimSpace(s[i+len(t):])
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+	return NewLabelMatcher(t, name, value)
+}
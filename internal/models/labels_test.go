@@ -0,0 +1,166 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabels_String(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels Labels
+		want   string
+	}{
+		{
+			name:   "Empty",
+			labels: nil,
+			want:   "",
+		},
+		{
+			name:   "Sorted",
+			labels: Labels{"service": "api", "host": "a"},
+			want:   `{host="a",service="api"}`,
+		},
+		{
+			name:   "Escaped",
+			labels: Labels{"path": `a"b,c`},
+			want:   `{path="a\"b,c"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.labels.String())
+
+			parsed, err := ParseLabels(tt.want)
+			require.NoError(t, err)
+			assert.True(t, tt.labels.Equal(parsed))
+		})
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Labels
+		wantErr bool
+	}{
+		{
+			name:  "Without braces",
+			input: `host="a", service="api"`,
+			want:  Labels{"host": "a", "service": "api"},
+		},
+		{
+			name:    "Unquoted value",
+			input:   `host=a`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid name",
+			input:   `{1host="a"}`,
+			wantErr: true,
+		},
+		{
+			name:    "Missing comma",
+			input:   `{host="a" service="api"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLabels(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLabels_Matches(t *testing.T) {
+	labels := Labels{"host": "a", "service": "api"}
+
+	tests := []struct {
+		name     string
+		matchers []string
+		want     bool
+	}{
+		{
+			name:     "Equal",
+			matchers: []string{"host=a"},
+			want:     true,
+		},
+		{
+			name:     "Quoted value",
+			matchers: []string{`host="a"`, "service!=db"},
+			want:     true,
+		},
+		{
+			name:     "Regexp",
+			matchers: []string{"service=~a.*"},
+			want:     true,
+		},
+		{
+			name:     "Regexp is anchored",
+			matchers: []string{"service=~p"},
+			want:     false,
+		},
+		{
+			name:     "Not regexp",
+			matchers: []string{"service!~api"},
+			want:     false,
+		},
+		{
+			name:     "Missing label",
+			matchers: []string{"env="},
+			want:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matchers := make([]*LabelMatcher, 0, len(tt.matchers))
+			for _, s := range tt.matchers {
+				m, err := ParseLabelMatcher(s)
+				require.NoError(t, err)
+				matchers = append(matchers, m)
+			}
+			assert.Equal(t, tt.want, labels.Matches(matchers))
+		})
+	}
+}
+
+func TestParseLabelMatcher_Error(t *testing.T) {
+	for _, s := range []string{"host", "=a", "host!", "host=~("} {
+		_, err := ParseLabelMatcher(s)
+		assert.ErrorIs(t, err, ErrInvalidMatcher, s)
+	}
+}
+
+func TestLabels_Scan(t *testing.T) {
+	var l Labels
+	require.NoError(t, l.Scan([]byte(`{"host":"a"}`)))
+	assert.Equal(t, Labels{"host": "a"}, l)
+
+	require.NoError(t, l.Scan("{}"))
+	assert.Nil(t, l)
+
+	v, err := Labels(nil).Value()
+	require.NoError(t, err)
+	assert.Equal(t, "{}", v)
+}
+
+func TestLabelMatcher_String(t *testing.T) {
+	strs := []string{`host="a"`, `host!=""`, `host=~"a.*"`, `host!~"b|c"`}
+	var matchers []*LabelMatcher
+	for _, s := range strs {
+		m, err := ParseLabelMatcher(s)
+		require.NoError(t, err)
+		assert.Equal(t, s, m.String())
+		matchers = append(matchers, m)
+	}
+	assert.Equal(t, strs, MatcherStrings(matchers))
+}
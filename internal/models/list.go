@@ -0,0 +1,97 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	config "github.com/igortoigildin/go-metrics-altering/config/agent"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListFilter selects series returned by listing. Zero value selects all series at once.
+type ListFilter struct {
+	Type     string          // metric type, all types if empty
+	Prefix   string          // prefix of metric names
+	Regexp   *regexp.Regexp  // regular expression metric names must match
+	Matchers []*LabelMatcher // label matchers
+	Cursor   string          // cursor returned with the previous page, the first page if empty
+	Limit    int             // maximum number of series per page, no limit if zero
+}
+
+// Validate checks metric type, limit and cursor of the filter.
+func (f ListFilter) Validate() error {
+	if f.Type != "" && f.Type != config.GaugeType && f.Type != config.CountType && f.Type != config.HistogramType {
+		return fmt.Errorf("unsupported metric type %q", f.Type)
+	}
+	if f.Limit < 0 {
+		return fmt.Errorf("invalid limit %d", f.Limit)
+	}
+	_, err := decodeCursor(f.Cursor)
+	return err
+}
+
+// Match reports whether the metric is selected by type, name and labels.
+// The cursor is not taken into account, see Page.
+func (f ListFilter) Match(m Metrics) bool {
+	if f.Type != "" && f.Type != m.MType {
+		return false
+	}
+	if !strings.HasPrefix(m.ID, f.Prefix) {
+		return false
+	}
+	if f.Regexp != nil && !f.Regexp.MatchString(m.ID) {
+		return false
+	}
+	return m.Labels.Matches(f.Matchers)
+}
+
+// Page sorts metrics by type and series key and returns the page following the cursor of the filter.
+// The next cursor is empty when there are no more pages.
+func (f ListFilter) Page(metrics []Metrics) ([]Metrics, string, error) {
+	after, err := decodeCursor(f.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return listKey(metrics[i]) < listKey(metrics[j])
+	})
+	start := 0
+	if after != "" {
+		start = sort.Search(len(metrics), func(i int) bool {
+			return listKey(metrics[i]) > after
+		})
+	}
+	metrics = metrics[start:]
+
+	if f.Limit == 0 || len(metrics) <= f.Limit {
+		return metrics, "", nil
+	}
+	metrics = metrics[:f.Limit]
+	return metrics, encodeCursor(listKey(metrics[f.Limit-1])), nil
+}
+
+// listKey orders series by type first, so that gauge and counter of the same name are distinct.
+func listKey(m Metrics) string {
+	return m.MType + "\x00" + SeriesKey(m.ID, m.Labels)
+}
+
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.Contains(string(key), "\x00") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidCursor, cursor)
+	}
+	return string(key), nil
+}
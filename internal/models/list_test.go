@@ -0,0 +1,79 @@
+package models
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListFilter_Match(t *testing.T) {
+	matcher, err := NewLabelMatcher(MatchEqual, "host", "a")
+	require.NoError(t, err)
+	metric := Metrics{ID: "http_requests", MType: "counter", Labels: Labels{"host": "a"}}
+
+	tests := []struct {
+		name   string
+		filter ListFilter
+		want   bool
+	}{
+		{name: "Zero filter", filter: ListFilter{}, want: true},
+		{name: "Type", filter: ListFilter{Type: "counter"}, want: true},
+		{name: "Other type", filter: ListFilter{Type: "gauge"}, want: false},
+		{name: "Prefix", filter: ListFilter{Prefix: "http_"}, want: true},
+		{name: "Other prefix", filter: ListFilter{Prefix: "requests"}, want: false},
+		{name: "Regexp", filter: ListFilter{Regexp: regexp.MustCompile("^http_.+s$")}, want: true},
+		{name: "Other regexp", filter: ListFilter{Regexp: regexp.MustCompile("^cpu")}, want: false},
+		{name: "Matchers", filter: ListFilter{Matchers: []*LabelMatcher{matcher}}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.Match(metric))
+		})
+	}
+}
+
+func TestListFilter_Page(t *testing.T) {
+	metrics := func() []Metrics {
+		return []Metrics{
+			{ID: "requests", MType: "gauge"},
+			{ID: "requests", MType: "counter", Labels: Labels{"host": "b"}},
+			{ID: "alloc", MType: "gauge"},
+			{ID: "requests", MType: "counter", Labels: Labels{"host": "a"}},
+		}
+	}
+
+	// gauge and counter of the same name are distinct series
+	all, next, err := ListFilter{}.Page(metrics())
+	require.NoError(t, err)
+	assert.Empty(t, next)
+	require.Len(t, all, 4)
+
+	var pages [][]Metrics
+	filter := ListFilter{Limit: 3}
+	for {
+		page, next, err := filter.Page(metrics())
+		require.NoError(t, err)
+		pages = append(pages, page)
+		if next == "" {
+			break
+		}
+		filter.Cursor = next
+	}
+	require.Len(t, pages, 2)
+	assert.Equal(t, all[:3], pages[0])
+	assert.Equal(t, all[3:], pages[1])
+	assert.Equal(t, Labels{"host": "a"}, pages[0][0].Labels)
+	assert.Equal(t, "alloc", pages[0][2].ID)
+
+	_, _, err = ListFilter{Cursor: "!"}.Page(metrics())
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestListFilter_Validate(t *testing.T) {
+	assert.NoError(t, ListFilter{Type: "histogram", Limit: 10}.Validate())
+	assert.Error(t, ListFilter{Type: "summary"}.Validate())
+	assert.Error(t, ListFilter{Limit: -1}.Validate())
+	assert.ErrorIs(t, ListFilter{Cursor: "cmVxdWVzdHM"}.Validate(), ErrInvalidCursor)
+}
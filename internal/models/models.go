@@ -2,13 +2,29 @@
 
 package models
 
-import config "github.com/igortoigildin/go-metrics-altering/config/agent"
+import (
+	"errors"
+	"fmt"
+
+	config "github.com/igortoigildin/go-metrics-altering/config/agent"
+)
+
+var (
+	// ErrTypeConflict is returned when metric type is unsupported or does not match type of the value.
+	ErrTypeConflict = errors.New("metric type conflict")
+	// ErrInvalidValue is returned when value is missing or cannot be applied to the stored series,
+	// e.g. histogram with different bucket bounds.
+	ErrInvalidValue = errors.New("invalid metric value")
+)
 
 type Metrics struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	ID     string   `json:"id"`               // имя метрики
+	MType  string   `json:"type"`             // параметр, принимающий значение gauge или counter
+	Delta  *int64   `json:"delta,omitempty"`  // значение метрики в случае передачи counter
+	Value  *float64 `json:"value,omitempty"`  // значение метрики в случае передачи gauge
+	Labels Labels   `json:"labels,omitempty"` // метки, вместе с именем и типом идентифицирующие серию
+
+	Histogram *Histogram `json:"histogram,omitempty"` // значение метрики в случае передачи histogram
 }
 
 // CounterConstructor constructor for counter metric model.
@@ -28,3 +44,84 @@ func GaugeConstructor(value float64, name string) Metrics {
 		Value: &value,
 	}
 }
+
+// HistogramConstructor constructor for histogram metric model.
+func HistogramConstructor(histogram *Histogram, name string) Metrics {
+	return Metrics{
+		ID:        name,
+		MType:     config.HistogramType,
+		Histogram: histogram,
+	}
+}
+
+// NewMetric converts value passed to Update to metric of the stated type. Value is copied,
+// so that the metric is not changed along with the value of the caller.
+func NewMetric(metricType string, metricName string, labels Labels, metricValue any) (Metrics, error) {
+	metric := Metrics{ID: metricName, MType: metricType, Labels: labels}
+
+	switch metricType {
+	case config.GaugeType:
+		switch v := metricValue.(type) {
+		case float64:
+			metric.Value = &v
+		case *float64:
+			metric.Value = v
+		}
+		if metric.Value == nil {
+			return metric, valueError(metricType, metricName, metricValue)
+		}
+	case config.CountType:
+		switch v := metricValue.(type) {
+		case int64:
+			metric.Delta = &v
+		case *int64:
+			metric.Delta = v
+		}
+		if metric.Delta == nil {
+			return metric, valueError(metricType, metricName, metricValue)
+		}
+	case config.HistogramType:
+		v, ok := metricValue.(*Histogram)
+		if !ok {
+			return metric, valueError(metricType, metricName, metricValue)
+		}
+		if v == nil {
+			return metric, fmt.Errorf("%w: histogram %q not provided", ErrInvalidValue, metricName)
+		}
+		metric.Histogram = v
+	default:
+		return metric, fmt.Errorf("%w: unsupported metric type %q", ErrTypeConflict, metricType)
+	}
+	return metric.Clone(), nil
+}
+
+// valueError returns ErrInvalidValue for missing value and ErrTypeConflict for value of another type.
+func valueError(metricType string, metricName string, metricValue any) error {
+	switch v := metricValue.(type) {
+	case *float64:
+		if v == nil && metricType == config.GaugeType {
+			return fmt.Errorf("%w: value of gauge %q not provided", ErrInvalidValue, metricName)
+		}
+	case *int64:
+		if v == nil && metricType == config.CountType {
+			return fmt.Errorf("%w: delta of counter %q not provided", ErrInvalidValue, metricName)
+		}
+	}
+	return fmt.Errorf("%w: %T is not a value of %s %q", ErrTypeConflict, metricValue, metricType, metricName)
+}
+
+// Clone returns copy of the metric, which values may be changed without affecting the original.
+func (m Metrics) Clone() Metrics {
+	if m.Value != nil {
+		value := *m.Value
+		m.Value = &value
+	}
+	if m.Delta != nil {
+		delta := *m.Delta
+		m.Delta = &delta
+	}
+	if m.Histogram != nil {
+		m.Histogram = m.Histogram.Clone()
+	}
+	return m
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"testing"
+
+	config "github.com/igortoigildin/go-metrics-altering/config/agent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewMetric(t *testing.T) {
+	value, delta := 1.5, int64(2)
+	tests := []struct {
+		name       string
+		metricType string
+		value      any
+		want       Metrics
+		wantErr    error
+	}{
+		{name: "Gauge", metricType: config.GaugeType, value: value, want: Metrics{ID: "m", MType: config.GaugeType, Value: &value}},
+		{name: "Gauge pointer", metricType: config.GaugeType, value: &value, want: Metrics{ID: "m", MType: config.GaugeType, Value: &value}},
+		{name: "Counter", metricType: config.CountType, value: delta, want: Metrics{ID: "m", MType: config.CountType, Delta: &delta}},
+		{name: "Counter pointer", metricType: config.CountType, value: &delta, want: Metrics{ID: "m", MType: config.CountType, Delta: &delta}},
+		{name: "Histogram", metricType: config.HistogramType, value: NewHistogram([]float64{1}),
+			want: Metrics{ID: "m", MType: config.HistogramType, Histogram: NewHistogram([]float64{1})}},
+		{name: "Missing gauge", metricType: config.GaugeType, value: (*float64)(nil), wantErr: ErrInvalidValue},
+		{name: "Missing counter", metricType: config.CountType, value: (*int64)(nil), wantErr: ErrInvalidValue},
+		{name: "Missing histogram", metricType: config.HistogramType, value: (*Histogram)(nil), wantErr: ErrInvalidValue},
+		{name: "Counter as gauge", metricType: config.GaugeType, value: delta, wantErr: ErrTypeConflict},
+		{name: "Gauge as histogram", metricType: config.HistogramType, value: value, wantErr: ErrTypeConflict},
+		{name: "Unknown type", metricType: "summary", value: value, wantErr: ErrTypeConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewMetric(tt.metricType, "m", nil, tt.value)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMetrics_Clone(t *testing.T) {
+	value, delta := 1.5, int64(2)
+	h := NewHistogram([]float64{1})
+	m := Metrics{ID: "m", Value: &value, Delta: &delta, Histogram: h}
+
+	c := m.Clone()
+	assert.Equal(t, m, c)
+
+	*c.Value, *c.Delta = 3, 4
+	c.Histogram.Observe(0.5)
+	assert.Equal(t, 1.5, value)
+	assert.Equal(t, int64(2), delta)
+	assert.Equal(t, uint64(0), h.Count)
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"path"
+)
+
+var ErrInvalidPattern = errors.New("invalid metric name pattern")
+
+// ValidatePattern checks whether pattern is a valid glob, e.g. cpu_* or http_requests_?xx.
+// A plain metric name is a valid pattern matching only itself.
+func ValidatePattern(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("%w: pattern not provided", ErrInvalidPattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidPattern, pattern)
+	}
+	return nil
+}
+
+// MatchName reports whether metric name matches the glob pattern.
+func MatchName(pattern, name string) bool {
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchName(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{pattern: "Alloc", name: "Alloc", want: true},
+		{pattern: "Alloc", name: "HeapAlloc", want: false},
+		{pattern: "Heap*", name: "HeapAlloc", want: true},
+		{pattern: "*Alloc", name: "HeapAlloc", want: true},
+		{pattern: "Num?C", name: "NumGC", want: true},
+		{pattern: "[A-C]*", name: "Frees", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+"/"+tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, MatchName(tt.pattern, tt.name))
+		})
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	assert.NoError(t, ValidatePattern("cpu_*"))
+	assert.ErrorIs(t, ValidatePattern(""), ErrInvalidPattern)
+	assert.ErrorIs(t, ValidatePattern("cpu_[a"), ErrInvalidPattern)
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Sample is a single timestamped value of a metric.
+// Counter samples hold the accumulated value after the update.
+type Sample struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// History is a range of samples of a single metric.
+type History struct {
+	ID      string   `json:"id"`
+	MType   string   `json:"type"`
+	Labels  Labels   `json:"labels,omitempty"`
+	Samples []Sample `json:"samples"`
+}
+
+// Downsample keeps the last sample of every step-long bucket starting at from.
+// Samples must be sorted by timestamp. Zero step returns samples unchanged.
+func Downsample(samples []Sample, from time.Time, step time.Duration) []Sample {
+	if step <= 0 || len(samples) == 0 {
+		return samples
+	}
+
+	res := make([]Sample, 0, len(samples))
+	for _, s := range samples {
+		bucket := from.Add(s.Timestamp.Sub(from) / step * step)
+		if n := len(res); n > 0 && res[n-1].Timestamp.Equal(bucket) {
+			res[n-1].Value = s.Value
+			continue
+		}
+		res = append(res, Sample{Timestamp: bucket, Value: s.Value})
+	}
+	return res
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownsample(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	samples := []Sample{
+		{Timestamp: from.Add(1 * time.Second), Value: 1},
+		{Timestamp: from.Add(5 * time.Second), Value: 2},
+		{Timestamp: from.Add(12 * time.Second), Value: 3},
+		{Timestamp: from.Add(31 * time.Second), Value: 4},
+	}
+
+	tests := []struct {
+		name string
+		step time.Duration
+		want []Sample
+	}{
+		{
+			name: "No step",
+			step: 0,
+			want: samples,
+		},
+		{
+			name: "Ten seconds",
+			step: 10 * time.Second,
+			want: []Sample{
+				{Timestamp: from, Value: 2},
+				{Timestamp: from.Add(10 * time.Second), Value: 3},
+				{Timestamp: from.Add(30 * time.Second), Value: 4},
+			},
+		},
+		{
+			name: "One minute",
+			step: time.Minute,
+			want: []Sample{
+				{Timestamp: from, Value: 4},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Downsample(samples, from, tt.step))
+		})
+	}
+}
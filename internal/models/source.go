@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// SourceTotal is the sum of increments of a counter sent by a single source, such as agent ID or its IP address.
+type SourceTotal struct {
+	Source    string    `json:"source"`
+	Delta     int64     `json:"delta"`
+	UpdatedAt time.Time `json:"updated_at"` // time of the last increment
+}
@@ -0,0 +1,162 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/pkg/logger"
+	pb "github.com/igortoigildin/go-metrics-altering/pkg/metrics_v1"
+	"go.uber.org/zap"
+)
+
+const (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 10 * time.Second
+)
+
+var (
+	errPromoted   = errors.New("node is promoted")
+	errOutOfOrder = errors.New("replication entry out of order")
+)
+
+// Follow applies changes streamed from the primary until the node is promoted or ctx is done.
+// Broken streams are reopened from the last applied change.
+func (n *Node) Follow(ctx context.Context, client pb.ReplicationClient) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	n.mu.Lock()
+	if n.role != RoleFollower {
+		n.mu.Unlock()
+		return ErrPrimary
+	}
+	n.stop = cancel
+	n.mu.Unlock()
+
+	backoff := minBackoff
+	for {
+		progressed, err := n.replicate(ctx, client)
+		if ctx.Err() != nil {
+			return nil
+		}
+		logger.Log.Info("replication stream broken", zap.Error(err))
+
+		if progressed {
+			backoff = minBackoff
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// replicate applies changes of a single stream. It reports whether any of them were applied.
+func (n *Node) replicate(ctx context.Context, client pb.ReplicationClient) (bool, error) {
+	n.mu.Lock()
+	req := &pb.ReplicateRequest{Epoch: n.epoch, AfterSeq: n.log.Last()}
+	n.mu.Unlock()
+
+	stream, err := client.Replicate(ctx, req)
+	if err != nil {
+		return false, err
+	}
+
+	var progressed, copying bool
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			return progressed, err
+		}
+		entry, err := fromProto(msg)
+		if err != nil {
+			return progressed, err
+		}
+
+		if entry.Op == OpSnapshot {
+			err = n.applySnapshot(ctx, entry, !copying)
+			copying = !entry.Last
+		} else {
+			err = n.apply(ctx, entry)
+		}
+		if err != nil {
+			return progressed, err
+		}
+		progressed = true
+	}
+}
+
+// applySnapshot applies chunk of the full copy. Series of the follower are deleted before the first chunk.
+// The copy is resumed from only after the last chunk is applied.
+func (n *Node) applySnapshot(ctx context.Context, entry Entry, first bool) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.role != RoleFollower {
+		return errPromoted
+	}
+	if first {
+		if _, err := n.Storage.Delete(ctx, "", "*"); err != nil {
+			return err
+		}
+		n.epoch = ""
+		n.log.Reset(0)
+	}
+	if len(entry.Metrics) > 0 {
+		if err := n.Storage.UpdateBatch(ctx, entry.Metrics); err != nil {
+			return err
+		}
+	}
+	if entry.Last {
+		n.epoch = entry.Epoch
+		n.log.Reset(entry.Seq)
+	}
+	return nil
+}
+
+// apply applies change of the primary and logs it, so that followers of this node get it too.
+func (n *Node) apply(ctx context.Context, entry Entry) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.role != RoleFollower {
+		return errPromoted
+	}
+	if last := n.log.Last(); n.epoch == "" || entry.Seq != last+1 {
+		return fmt.Errorf("%w: got %d after %d", errOutOfOrder, entry.Seq, last)
+	}
+
+	var err error
+	switch entry.Op {
+	case OpUpdate:
+		err = n.Storage.UpdateBatch(ctx, entry.Metrics)
+	case OpDelete, OpReset:
+		matchers := make([]*models.LabelMatcher, 0, len(entry.Matchers))
+		for _, v := range entry.Matchers {
+			matcher, err := models.ParseLabelMatcher(v)
+			if err != nil {
+				return err
+			}
+			matchers = append(matchers, matcher)
+		}
+		if entry.Op == OpDelete {
+			_, err = n.Storage.Delete(ctx, entry.Type, entry.Pattern, matchers...)
+		} else {
+			_, err = n.Storage.Reset(ctx, entry.Type, entry.Pattern, matchers...)
+		}
+	default:
+		err = fmt.Errorf("unknown replication op %q", entry.Op)
+	}
+	if err != nil {
+		return err
+	}
+
+	n.epoch = entry.Epoch
+	n.log.Append(entry)
+	return nil
+}
@@ -0,0 +1,110 @@
+// Package replication streams changes applied to storage of the primary server to its followers.
+// Every change gets a sequence number, so that a follower catching up after a disconnect resumes
+// from the last applied one, and gets a full copy only when the primary has no longer kept it.
+package replication
+
+import (
+	"sync"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+)
+
+// Op is the kind of change.
+type Op string
+
+const (
+	OpUpdate   Op = "update"
+	OpDelete   Op = "delete"
+	OpReset    Op = "reset"
+	OpSnapshot Op = "snapshot" // chunk of the full copy of the storage
+)
+
+// Entry is a change applied to the storage.
+type Entry struct {
+	Epoch    string
+	Seq      uint64
+	Op       Op
+	Metrics  []models.Metrics // updated series or chunk of the snapshot
+	Type     string           // metric type of delete and reset
+	Pattern  string           // glob pattern of delete and reset
+	Matchers []string         // label matchers of delete and reset
+	Last     bool             // set for the last chunk of the snapshot
+}
+
+// Log keeps the latest entries in memory. Entries are appended in order of sequence numbers,
+// the oldest are dropped once the log exceeds its size.
+type Log struct {
+	mu      sync.Mutex
+	entries []Entry
+	size    int
+	last    uint64        // sequence number of the last appended entry
+	changed chan struct{} // closed and replaced on every append
+}
+
+// NewLog returns empty log keeping up to size entries.
+func NewLog(size int) *Log {
+	if size <= 0 {
+		size = 1
+	}
+	return &Log{size: size, changed: make(chan struct{})}
+}
+
+// Append adds the entry following the last one and returns its sequence number.
+func (l *Log) Append(e Entry) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.last++
+	e.Seq = l.last
+	l.entries = append(l.entries, e)
+	// entries are copied once in size appends, not on every append
+	if len(l.entries) >= 2*l.size {
+		l.entries = append([]Entry(nil), l.entries[len(l.entries)-l.size:]...)
+	}
+
+	close(l.changed)
+	l.changed = make(chan struct{})
+	return l.last
+}
+
+// Reset drops all entries and makes seq the last sequence number, e.g. after the full copy is applied.
+func (l *Log) Reset(seq uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = nil
+	l.last = seq
+}
+
+// Last returns sequence number of the last appended entry, 0 if none.
+func (l *Log) Last() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.last
+}
+
+// Covers reports whether all entries following the stated sequence number are kept.
+func (l *Log) Covers(after uint64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.covers(after)
+}
+
+func (l *Log) covers(after uint64) bool {
+	return after <= l.last && l.last-after <= uint64(len(l.entries))
+}
+
+// Since returns entries following the stated sequence number and channel closed when the next entry is appended.
+// ok is false if some of the entries were dropped.
+func (l *Log) Since(after uint64) (entries []Entry, changed <-chan struct{}, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.covers(after) {
+		return nil, nil, false
+	}
+	n := int(l.last - after)
+	return append([]Entry(nil), l.entries[len(l.entries)-n:]...), l.changed, true
+}
@@ -0,0 +1,45 @@
+package replication
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLog(t *testing.T) {
+	l := NewLog(2)
+	assert.True(t, l.Covers(0))
+
+	for i := 1; i <= 3; i++ {
+		assert.Equal(t, uint64(i), l.Append(Entry{Op: OpUpdate}))
+	}
+	assert.Equal(t, uint64(3), l.Last())
+
+	entries, changed, ok := l.Since(1)
+	require.True(t, ok)
+	require.Len(t, entries, 2)
+	assert.Equal(t, uint64(2), entries[0].Seq)
+	assert.Equal(t, uint64(3), entries[1].Seq)
+
+	l.Append(Entry{Op: OpDelete})
+	select {
+	case <-changed:
+	default:
+		t.Fatal("append not notified")
+	}
+
+	// the oldest are dropped
+	assert.False(t, l.Covers(0))
+	assert.True(t, l.Covers(2))
+	_, _, ok = l.Since(1)
+	assert.False(t, ok)
+	// ahead of the log
+	assert.False(t, l.Covers(5))
+
+	l.Reset(10)
+	assert.Equal(t, uint64(10), l.Last())
+	assert.True(t, l.Covers(10))
+	assert.False(t, l.Covers(9))
+	assert.Equal(t, uint64(11), l.Append(Entry{Op: OpUpdate}))
+}
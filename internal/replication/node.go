@@ -0,0 +1,296 @@
+package replication
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+)
+
+const (
+	defaultLogSize = 100000
+	chunkSize      = 1000 // series per snapshot entry
+)
+
+var (
+	// ErrLogTruncated is returned to a follower which fell behind so far, that entries it needs were dropped.
+	ErrLogTruncated = errors.New("replication log truncated")
+	// ErrPrimary is returned on promotion of the node, which is already primary.
+	ErrPrimary = errors.New("node is already primary")
+)
+
+// Role of the node.
+type Role string
+
+const (
+	RolePrimary  Role = "primary"
+	RoleFollower Role = "follower"
+)
+
+// Status describes replication state of the node.
+type Status struct {
+	Role    Role   `json:"role"`
+	Primary string `json:"primary,omitempty"` // address of the followed primary
+	Epoch   string `json:"epoch"`
+	Seq     uint64 `json:"seq"` // sequence number of the last applied change
+}
+
+// Node is storage, which changes are replicated. Primary applies writes and logs them,
+// follower rejects writes with storage.ErrReadOnly and applies changes streamed from the primary.
+// Reads are served by both.
+//
+// Epoch is changed on promotion, so that followers, which got changes the promoted node has not,
+// get a full copy instead of resuming. Changes logged before promotion keep the previous epoch.
+type Node struct {
+	storage.Storage
+
+	// mu serializes writes, so that they are logged in the order they are applied
+	mu        sync.Mutex
+	log       *Log
+	role      Role
+	primary   string
+	epoch     string
+	forkEpoch string // epoch before the last promotion
+	forkSeq   uint64 // sequence number of the last change before the last promotion
+	stop      context.CancelFunc
+}
+
+// Option configures Node.
+type Option func(*Node)
+
+// WithLogSize sets how many of the latest changes are kept for followers to resume from.
+func WithLogSize(size int) Option {
+	return func(n *Node) {
+		if size > 0 {
+			n.log = NewLog(size)
+		}
+	}
+}
+
+// WithPrimary makes the node follower of the primary at the stated address. See Follow.
+func WithPrimary(addr string) Option {
+	return func(n *Node) {
+		n.role = RoleFollower
+		n.primary = addr
+	}
+}
+
+// New returns primary node replicating changes of the storage.
+func New(s storage.Storage, opts ...Option) *Node {
+	n := &Node{
+		Storage: s,
+		log:     NewLog(defaultLogSize),
+		role:    RolePrimary,
+		epoch:   newEpoch(),
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	return n
+}
+
+// newEpoch returns random epoch ID.
+func newEpoch() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Unwrap returns the replicated storage.
+func (n *Node) Unwrap() storage.Storage {
+	return n.Storage
+}
+
+// Status returns role of the node and the last applied change.
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return Status{Role: n.role, Primary: n.primary, Epoch: n.epoch, Seq: n.log.Last()}
+}
+
+// Promote makes follower primary: it stops following and starts accepting writes.
+func (n *Node) Promote() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.role == RolePrimary {
+		return ErrPrimary
+	}
+	if n.stop != nil {
+		n.stop()
+	}
+	n.forkEpoch, n.forkSeq = n.epoch, n.log.Last()
+	n.epoch = newEpoch()
+	n.role = RolePrimary
+	n.primary = ""
+	return nil
+}
+
+// CheckWritable returns storage.ErrReadOnly if the node is follower, so that storages queueing writes
+// in front of it reject them at once.
+func (n *Node) CheckWritable() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.role != RolePrimary {
+		return fmt.Errorf("%w: follower of %s", storage.ErrReadOnly, n.primary)
+	}
+	return nil
+}
+
+// write applies change of the primary and logs it. Followers reject it.
+func (n *Node) write(apply func() (Entry, error)) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.role != RolePrimary {
+		return fmt.Errorf("%w: follower of %s", storage.ErrReadOnly, n.primary)
+	}
+	entry, err := apply()
+	if err != nil {
+		return err
+	}
+	if entry.Op != "" {
+		entry.Epoch = n.epoch
+		n.log.Append(entry)
+	}
+	return nil
+}
+
+func (n *Node) Update(ctx context.Context, metricType string, metricName string, labels models.Labels, metricValue any) error {
+	return n.write(func() (Entry, error) {
+		if err := n.Storage.Update(ctx, metricType, metricName, labels, metricValue); err != nil {
+			return Entry{}, err
+		}
+		metric, err := models.NewMetric(metricType, metricName, labels, metricValue)
+		if err != nil {
+			return Entry{}, err
+		}
+		return Entry{Op: OpUpdate, Metrics: []models.Metrics{metric}}, nil
+	})
+}
+
+func (n *Node) UpdateBatch(ctx context.Context, metrics []models.Metrics) error {
+	return n.write(func() (Entry, error) {
+		if err := n.Storage.UpdateBatch(ctx, metrics); err != nil {
+			return Entry{}, err
+		}
+		return Entry{Op: OpUpdate, Metrics: append([]models.Metrics(nil), metrics...)}, nil
+	})
+}
+
+func (n *Node) Delete(ctx context.Context, metricType string, pattern string, matchers ...*models.LabelMatcher) (int, error) {
+	var deleted int
+	err := n.write(func() (entry Entry, err error) {
+		deleted, err = n.Storage.Delete(ctx, metricType, pattern, matchers...)
+		if err != nil || deleted == 0 {
+			return Entry{}, err
+		}
+		return Entry{Op: OpDelete, Type: metricType, Pattern: pattern, Matchers: models.MatcherStrings(matchers)}, nil
+	})
+	return deleted, err
+}
+
+func (n *Node) Reset(ctx context.Context, metricType string, pattern string, matchers ...*models.LabelMatcher) (int, error) {
+	var reset int
+	err := n.write(func() (entry Entry, err error) {
+		reset, err = n.Storage.Reset(ctx, metricType, pattern, matchers...)
+		if err != nil || reset == 0 {
+			return Entry{}, err
+		}
+		return Entry{Op: OpReset, Type: metricType, Pattern: pattern, Matchers: models.MatcherStrings(matchers)}, nil
+	})
+	return reset, err
+}
+
+// Stream sends changes following the stated one to a follower until ctx is done. If the node cannot resume
+// from the stated change, full copy of the storage is sent first.
+func (n *Node) Stream(ctx context.Context, epoch string, after uint64, send func(Entry) error) error {
+	n.mu.Lock()
+	resume := n.canResume(epoch, after)
+	var (
+		snapshot []models.Metrics
+		err      error
+	)
+	if !resume {
+		// taken under the lock, so that it has every change up to the last logged one
+		snapshot, err = n.listAll(ctx)
+		epoch, after = n.epoch, n.log.Last()
+	}
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if !resume {
+		for i := 0; i == 0 || i < len(snapshot); i += chunkSize {
+			chunk := snapshot[i:min(i+chunkSize, len(snapshot))]
+			last := i+chunkSize >= len(snapshot)
+			if err = send(Entry{Epoch: epoch, Seq: after, Op: OpSnapshot, Metrics: chunk, Last: last}); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		entries, changed, ok := n.log.Since(after)
+		if !ok {
+			return fmt.Errorf("%w: change %d not kept", ErrLogTruncated, after+1)
+		}
+		for _, entry := range entries {
+			if err = send(entry); err != nil {
+				return err
+			}
+			after = entry.Seq
+		}
+		if len(entries) > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// canResume reports whether all changes following the stated one are kept and are the same the follower would
+// get from the node it replicated from. Caller must hold the lock.
+func (n *Node) canResume(epoch string, after uint64) bool {
+	switch {
+	case epoch == "":
+		return false
+	case epoch == n.epoch:
+		return n.log.Covers(after)
+	case epoch == n.forkEpoch:
+		return after <= n.forkSeq && n.log.Covers(after)
+	}
+	return false
+}
+
+// listAll returns all series of the storage.
+func (n *Node) listAll(ctx context.Context) ([]models.Metrics, error) {
+	var res []models.Metrics
+	filter := models.ListFilter{Limit: chunkSize}
+	for {
+		page, next, err := n.Storage.List(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, page...)
+		if next == "" {
+			return res, nil
+		}
+		filter.Cursor = next
+	}
+}
@@ -0,0 +1,172 @@
+package replication
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	local "github.com/igortoigildin/go-metrics-altering/internal/storage/inmemory"
+	pb "github.com/igortoigildin/go-metrics-altering/pkg/metrics_v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// collect returns the first n entries streamed by the node.
+func collect(t *testing.T, node *Node, epoch string, after uint64, n int) []Entry {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var res []Entry
+	_ = node.Stream(ctx, epoch, after, func(e Entry) error {
+		res = append(res, e)
+		if len(res) == n {
+			cancel()
+		}
+		return nil
+	})
+	require.Len(t, res, n)
+	return res
+}
+
+func list(t *testing.T, s storage.Storage) []models.Metrics {
+	metrics, _, err := s.List(context.Background(), models.ListFilter{})
+	require.NoError(t, err)
+	return metrics
+}
+
+func TestNode_Stream(t *testing.T) {
+	ctx := context.Background()
+	node := New(local.New(), WithLogSize(2))
+	for i := 0; i < 5; i++ {
+		require.NoError(t, node.Update(ctx, "counter", "requests", nil, int64(1)))
+	}
+	epoch := node.Status().Epoch
+
+	t.Run("Resume", func(t *testing.T) {
+		entries := collect(t, node, epoch, 3, 2)
+		assert.Equal(t, uint64(4), entries[0].Seq)
+		assert.Equal(t, OpUpdate, entries[0].Op)
+		assert.Equal(t, epoch, entries[1].Epoch)
+	})
+
+	t.Run("Full copy of unknown epoch", func(t *testing.T) {
+		entries := collect(t, node, "", 0, 1)
+		assert.Equal(t, OpSnapshot, entries[0].Op)
+		assert.True(t, entries[0].Last)
+		assert.Equal(t, uint64(5), entries[0].Seq)
+		assert.ElementsMatch(t, list(t, node), entries[0].Metrics)
+	})
+
+	t.Run("Full copy of dropped changes", func(t *testing.T) {
+		entries := collect(t, node, epoch, 1, 1)
+		assert.Equal(t, OpSnapshot, entries[0].Op)
+	})
+
+	t.Run("Changes after full copy", func(t *testing.T) {
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			_, _ = node.Reset(ctx, "counter", "requests")
+		}()
+		entries := collect(t, node, "", 0, 2)
+		assert.Equal(t, OpSnapshot, entries[0].Op)
+		assert.Equal(t, OpReset, entries[1].Op)
+		assert.Equal(t, entries[0].Seq+1, entries[1].Seq)
+	})
+}
+
+func TestNode_follower(t *testing.T) {
+	ctx := context.Background()
+	node := New(local.New(), WithPrimary("primary:8081"))
+
+	err := node.Update(ctx, "gauge", "Alloc", nil, 1.5)
+	assert.ErrorIs(t, err, storage.ErrReadOnly)
+	_, err = node.Delete(ctx, "", "*")
+	assert.ErrorIs(t, err, storage.ErrReadOnly)
+
+	status := node.Status()
+	assert.Equal(t, RoleFollower, status.Role)
+	assert.Equal(t, "primary:8081", status.Primary)
+
+	require.NoError(t, node.Promote())
+	assert.NoError(t, node.Update(ctx, "gauge", "Alloc", nil, 1.5))
+	assert.ErrorIs(t, node.Promote(), ErrPrimary)
+	assert.NotEqual(t, status.Epoch, node.Status().Epoch)
+}
+
+func TestReplication(t *testing.T) {
+	ctx := context.Background()
+	primary := New(local.New())
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	Register(srv, primary)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := pb.NewReplicationClient(conn)
+
+	// changes made before the follower connects are copied
+	require.NoError(t, primary.Update(ctx, "gauge", "Alloc", models.Labels{"host": "a"}, 1.5))
+	require.NoError(t, primary.Update(ctx, "counter", "requests", nil, int64(3)))
+
+	follower := New(local.New(), WithPrimary("bufnet"))
+	followCtx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, follower.Follow(followCtx, client))
+	}()
+
+	inSync := func() bool {
+		return follower.Status().Seq == primary.Status().Seq &&
+			assert.ObjectsAreEqual(list(t, primary), list(t, follower))
+	}
+
+	require.NoError(t, primary.UpdateBatch(ctx, []models.Metrics{
+		{ID: "requests", MType: "counter", Delta: ptr(int64(2))},
+		{ID: "latency", MType: "histogram", Histogram: &models.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1}},
+	}))
+	_, err = primary.Delete(ctx, "gauge", "Alloc")
+	require.NoError(t, err)
+	require.Eventually(t, inSync, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, primary.Status().Epoch, follower.Status().Epoch)
+
+	// disconnected follower resumes from the last applied change
+	stop()
+	<-done
+	require.NoError(t, primary.Update(ctx, "counter", "requests", nil, int64(5)))
+	followCtx, stop = context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		assert.NoError(t, follower.Follow(followCtx, client))
+	}()
+	require.Eventually(t, inSync, 5*time.Second, 10*time.Millisecond)
+	metric, err := follower.Get(ctx, "counter", "requests", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), *metric.Delta)
+
+	// promoted follower accepts writes, other followers of the old primary resume from it
+	epoch, seq := follower.Status().Epoch, follower.Status().Seq
+	require.NoError(t, follower.Promote())
+	require.NoError(t, follower.Update(ctx, "gauge", "Alloc", nil, 2.5))
+	assert.Equal(t, RolePrimary, follower.Status().Role)
+	assert.True(t, follower.canResume(epoch, seq-1))
+	assert.False(t, follower.canResume(epoch, seq+1))
+	assert.True(t, follower.canResume(follower.Status().Epoch, seq))
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,105 @@
+package replication
+
+import (
+	"errors"
+	"fmt"
+
+	config "github.com/igortoigildin/go-metrics-altering/config/server"
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	pb "github.com/igortoigildin/go-metrics-altering/pkg/metrics_v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type server struct {
+	pb.UnimplementedReplicationServer
+	node *Node
+}
+
+// Register registers replication service streaming changes of the node.
+func Register(gRPC *grpc.Server, node *Node) {
+	pb.RegisterReplicationServer(gRPC, &server{node: node})
+}
+
+func (s *server) Replicate(req *pb.ReplicateRequest, stream grpc.ServerStreamingServer[pb.ReplicationEntry]) error {
+	err := s.node.Stream(stream.Context(), req.Epoch, req.AfterSeq, func(entry Entry) error {
+		return stream.Send(toProto(entry))
+	})
+	switch {
+	case errors.Is(err, ErrLogTruncated):
+		return status.Error(codes.Aborted, err.Error())
+	case stream.Context().Err() != nil:
+		return status.FromContextError(stream.Context().Err()).Err()
+	case err != nil:
+		return status.Error(codes.Internal, "internal error")
+	}
+	return nil
+}
+
+func toProto(entry Entry) *pb.ReplicationEntry {
+	res := &pb.ReplicationEntry{
+		Epoch:    entry.Epoch,
+		Seq:      entry.Seq,
+		Op:       string(entry.Op),
+		Metrics:  make([]*pb.Metric, 0, len(entry.Metrics)),
+		Type:     entry.Type,
+		Pattern:  entry.Pattern,
+		Matchers: entry.Matchers,
+		Last:     entry.Last,
+	}
+	for _, metric := range entry.Metrics {
+		labels := map[string]string(metric.Labels)
+		m := &pb.Metric{}
+		switch {
+		case metric.Value != nil:
+			m.Gauge = &pb.GaugeMetric{Name: metric.ID, Value: *metric.Value, Labels: labels}
+		case metric.Delta != nil:
+			m.Counter = &pb.CounterMetric{Name: metric.ID, Value: *metric.Delta, Labels: labels}
+		case metric.Histogram != nil:
+			m.Histogram = &pb.HistogramMetric{
+				Name:   metric.ID,
+				Bounds: metric.Histogram.Bounds,
+				Counts: metric.Histogram.Counts,
+				Sum:    metric.Histogram.Sum,
+				Count:  metric.Histogram.Count,
+				Labels: labels,
+			}
+		}
+		res.Metrics = append(res.Metrics, m)
+	}
+	return res
+}
+
+func fromProto(entry *pb.ReplicationEntry) (Entry, error) {
+	res := Entry{
+		Epoch:    entry.Epoch,
+		Seq:      entry.Seq,
+		Op:       Op(entry.Op),
+		Metrics:  make([]models.Metrics, 0, len(entry.Metrics)),
+		Type:     entry.Type,
+		Pattern:  entry.Pattern,
+		Matchers: entry.Matchers,
+		Last:     entry.Last,
+	}
+	for _, m := range entry.Metrics {
+		var metric models.Metrics
+		switch {
+		case m.Gauge != nil:
+			metric = models.Metrics{ID: m.Gauge.Name, MType: config.GaugeType, Value: &m.Gauge.Value, Labels: m.Gauge.Labels}
+		case m.Counter != nil:
+			metric = models.Metrics{ID: m.Counter.Name, MType: config.CountType, Delta: &m.Counter.Value, Labels: m.Counter.Labels}
+		case m.Histogram != nil:
+			metric = models.Metrics{ID: m.Histogram.Name, MType: config.HistogramType, Labels: m.Histogram.Labels, Histogram: &models.Histogram{
+				Bounds: m.Histogram.Bounds,
+				Counts: m.Histogram.Counts,
+				Sum:    m.Histogram.Sum,
+				Count:  m.Histogram.Count,
+			}}
+		default:
+			return Entry{}, fmt.Errorf("replication entry %d: metric without value", entry.Seq)
+		}
+		res.Metrics = append(res.Metrics, metric)
+	}
+	return res, nil
+}
@@ -0,0 +1,200 @@
+// Package graphite provides TCP listener of Graphite plaintext protocol writing values into storage as gauges.
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	config "github.com/igortoigildin/go-metrics-altering/config/server"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	"github.com/igortoigildin/go-metrics-altering/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const maxLineSize = 64 << 10
+
+// Server accepts lines of form "<path> <value> <timestamp>" over TCP. Paths are turned into metric names and
+// labels by templates, values are written as gauges as soon as they are received. Timestamps are validated,
+// but, like with other protocols, values are recorded at the time they are received.
+type Server struct {
+	addr      string
+	templates Templates
+	storage   storage.Storage
+
+	listener net.Listener
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// Option configures Server.
+type Option func(*Server)
+
+// WithTemplates sets templates applied to paths, paths are metric names as they are without them.
+func WithTemplates(templates Templates) Option {
+	return func(s *Server) {
+		s.templates = templates
+	}
+}
+
+// New returns server listening at the address and writing values into the storage.
+func New(addr string, st storage.Storage, opts ...Option) *Server {
+	s := &Server{
+		addr:    addr,
+		storage: st,
+		conns:   map[net.Conn]struct{}{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Start starts listening in background.
+func (s *Server) Start() error {
+	const op = "graphite.Start"
+
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	s.listener = l
+
+	logger.Log.Info("graphite server is running:", zap.String("addr", l.Addr().String()))
+
+	s.wg.Add(1)
+	go s.serve()
+	return nil
+}
+
+// Addr returns address the server listens at.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Shutdown stops listening and waits for lines being written until ctx is done, then closes connections.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.listener.Close()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	// connections are closed for reads, so that lines already received are written
+	s.mu.Lock()
+	for conn := range s.conns {
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.CloseRead()
+		} else {
+			conn.Close()
+		}
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Log.Error("failed to accept graphite connection", zap.Error(err))
+			}
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn writes values of lines received until the connection is closed.
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	sc := bufio.NewScanner(conn)
+	sc.Buffer(make([]byte, 4096), maxLineSize)
+	for sc.Scan() {
+		if err := s.handleLine(context.Background(), sc.Text()); err != nil {
+			logger.Log.Info("graphite line not written", zap.String("line", sc.Text()), zap.Error(err))
+		}
+	}
+}
+
+func (s *Server) handleLine(ctx context.Context, line string) error {
+	path, value, err := parseLine(line)
+	if err != nil || path == "" {
+		return err
+	}
+	name, labels, err := s.templates.Apply(path)
+	if err != nil {
+		return err
+	}
+	return s.storage.Update(ctx, config.GaugeType, name, labels, value)
+}
+
+// parseLine returns path and value of the line, empty path is returned for blank line. Timestamp may be omitted
+// or be -1, which means the time the line is received.
+func parseLine(line string) (string, float64, error) {
+	fields := strings.Fields(line)
+	switch len(fields) {
+	case 0:
+		return "", 0, nil
+	case 2, 3:
+	default:
+		return "", 0, fmt.Errorf("expected path, value and timestamp, got %d fields", len(fields))
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return "", 0, fmt.Errorf("invalid value %q", fields[1])
+	}
+	if len(fields) == 3 {
+		if ts, err := strconv.ParseFloat(fields[2], 64); err != nil || math.IsNaN(ts) || ts < 0 && ts != -1 {
+			return "", 0, fmt.Errorf("invalid timestamp %q", fields[2])
+		}
+	}
+	return fields[0], value, nil
+}
@@ -0,0 +1,73 @@
+package graphite
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	local "github.com/igortoigildin/go-metrics-altering/internal/storage/inmemory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseLine(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		wantPath  string
+		wantValue float64
+		wantErr   bool
+	}{
+		{name: "With timestamp", line: "servers.web01.load 0.5 1700000000", wantPath: "servers.web01.load", wantValue: 0.5},
+		{name: "Current time", line: "servers.web01.load 1 -1", wantPath: "servers.web01.load", wantValue: 1},
+		{name: "Without timestamp", line: "servers.web01.load 2", wantPath: "servers.web01.load", wantValue: 2},
+		{name: "Blank", line: "  "},
+		{name: "Missing value", line: "servers.web01.load", wantErr: true},
+		{name: "Invalid value", line: "servers.web01.load high 1700000000", wantErr: true},
+		{name: "Not finite value", line: "servers.web01.load nan 1700000000", wantErr: true},
+		{name: "Invalid timestamp", line: "servers.web01.load 1 -5", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, value, err := parseLine(tt.line)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantPath, path)
+			assert.Equal(t, tt.wantValue, value)
+		})
+	}
+}
+
+func TestServer(t *testing.T) {
+	templates, err := ParseTemplates("servers.* .host.measurement*")
+	require.NoError(t, err)
+
+	st := local.New()
+	s := New("127.0.0.1:0", st, WithTemplates(templates))
+	require.NoError(t, s.Start())
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("servers.web01.cpu.load 0.5 1700000000\ninvalid\ncron.backup.duration 12 -1\n"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		_, err := st.Get(context.Background(), "gauge", "cron.backup.duration", nil)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	metric, err := st.Get(context.Background(), "gauge", "cpu.load", models.Labels{"host": "web01"})
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, *metric.Value)
+
+	// open connection does not prevent shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+}
@@ -0,0 +1,159 @@
+package graphite
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+)
+
+const (
+	partMeasurement       = "measurement"
+	partGreedyMeasurement = "measurement*"
+)
+
+// Template turns dotted Graphite path into metric name and labels. It is stated as
+//
+//	[filter] template [label=value,...]
+//
+// Filter is a path pattern, where * stands for any single element, the template applies to matching paths only.
+// Template states meaning of path elements: "measurement" elements make up metric name, "measurement*" takes
+// the rest of the path into it, empty element is skipped and any other element is label of that name.
+// Elements beyond the template are dropped. Labels stated after the template are added to every metric.
+type Template struct {
+	filter []string
+	parts  []string
+	labels models.Labels
+}
+
+// ParseTemplate parses template definition.
+func ParseTemplate(s string) (*Template, error) {
+	fields := strings.Fields(s)
+	t := &Template{labels: models.Labels{}}
+	switch len(fields) {
+	case 1:
+		t.parts = strings.Split(fields[0], ".")
+	case 2:
+		if strings.Contains(fields[1], "=") {
+			t.parts = strings.Split(fields[0], ".")
+			if err := t.parseLabels(fields[1]); err != nil {
+				return nil, err
+			}
+		} else {
+			t.filter, t.parts = strings.Split(fields[0], "."), strings.Split(fields[1], ".")
+		}
+	case 3:
+		t.filter, t.parts = strings.Split(fields[0], "."), strings.Split(fields[1], ".")
+		if err := t.parseLabels(fields[2]); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid template %q", s)
+	}
+
+	var measurement bool
+	for i, part := range t.parts {
+		switch part {
+		case partMeasurement:
+			measurement = true
+		case partGreedyMeasurement:
+			if i != len(t.parts)-1 {
+				return nil, fmt.Errorf("invalid template %q: %s must be the last element", s, partGreedyMeasurement)
+			}
+			measurement = true
+		case "":
+		default:
+			if err := (models.Labels{part: ""}).Validate(); err != nil {
+				return nil, fmt.Errorf("invalid template %q: %w", s, err)
+			}
+		}
+	}
+	if !measurement {
+		return nil, fmt.Errorf("invalid template %q: no %s element", s, partMeasurement)
+	}
+	return t, nil
+}
+
+func (t *Template) parseLabels(s string) error {
+	for _, pair := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || name == "" || value == "" {
+			return fmt.Errorf("invalid template label %q", pair)
+		}
+		t.labels[name] = value
+	}
+	return t.labels.Validate()
+}
+
+// Match reports whether the template applies to the path.
+func (t *Template) Match(elements []string) bool {
+	if len(t.filter) > len(elements) {
+		return false
+	}
+	for i, f := range t.filter {
+		if f != "*" && f != elements[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply returns metric name and labels of the path elements.
+func (t *Template) Apply(elements []string) (string, models.Labels, error) {
+	var name []string
+	labels := maps.Clone(t.labels)
+
+	for i, part := range t.parts {
+		if i >= len(elements) {
+			break
+		}
+		switch part {
+		case partMeasurement:
+			name = append(name, elements[i])
+		case partGreedyMeasurement:
+			name = append(name, elements[i:]...)
+		case "":
+		default:
+			if elements[i] != "" {
+				labels[part] = elements[i]
+			}
+		}
+	}
+	if len(name) == 0 {
+		return "", nil, errors.New("no measurement elements in path")
+	}
+	return strings.Join(name, "."), labels, nil
+}
+
+// Templates is ordered list of templates, the first matching one applies. Paths not matching any
+// are metric names as they are.
+type Templates []*Template
+
+// ParseTemplates parses templates separated by semicolons.
+func ParseTemplates(s string) (Templates, error) {
+	var res Templates
+	for _, def := range strings.Split(s, ";") {
+		if strings.TrimSpace(def) == "" {
+			continue
+		}
+		t, err := ParseTemplate(def)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, t)
+	}
+	return res, nil
+}
+
+// Apply returns metric name and labels of the path.
+func (ts Templates) Apply(path string) (string, models.Labels, error) {
+	elements := strings.Split(path, ".")
+	for _, t := range ts {
+		if t.Match(elements) {
+			return t.Apply(elements)
+		}
+	}
+	return path, nil, nil
+}
@@ -0,0 +1,94 @@
+package graphite
+
+import (
+	"testing"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplates_Apply(t *testing.T) {
+	templates, err := ParseTemplates(
+		"servers.* .host.measurement* dc=eu;" +
+			"stats.*.*.* ..region.measurement.measurement;" +
+			"app.* .service..measurement",
+	)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		path       string
+		wantName   string
+		wantLabels models.Labels
+		wantErr    bool
+	}{
+		{
+			name:       "Greedy measurement",
+			path:       "servers.web01.cpu.load.1m",
+			wantName:   "cpu.load.1m",
+			wantLabels: models.Labels{"host": "web01", "dc": "eu"},
+		},
+		{
+			name:       "Measurement elements",
+			path:       "stats.app.eu.requests.count",
+			wantName:   "requests.count",
+			wantLabels: models.Labels{"region": "eu"},
+		},
+		{
+			name:       "Elements beyond template dropped",
+			path:       "app.billing.prod.latency.p99",
+			wantName:   "latency",
+			wantLabels: models.Labels{"service": "billing"},
+		},
+		{
+			name:    "Path shorter than template",
+			path:    "app.billing",
+			wantErr: true,
+		},
+		{
+			name:     "No matching template",
+			path:     "cron.backup.duration",
+			wantName: "cron.backup.duration",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, labels, err := templates.Apply(tt.path)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantName, name)
+			assert.Equal(t, tt.wantLabels, labels)
+		})
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		def     string
+		wantErr bool
+	}{
+		{name: "Template only", def: "host.measurement*"},
+		{name: "Template with labels", def: "host.measurement* dc=eu,env=prod"},
+		{name: "Filter and template", def: "servers.* .host.measurement"},
+		{name: "No measurement", def: "host.service", wantErr: true},
+		{name: "Greedy measurement not last", def: "measurement*.host", wantErr: true},
+		{name: "Invalid label name", def: "1host.measurement", wantErr: true},
+		{name: "Invalid extra label", def: "host.measurement dc", wantErr: true},
+		{name: "Too many fields", def: "a.* .host.measurement dc=eu extra", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTemplate(tt.def)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package grpcapp
 
 import (
 	config "github.com/igortoigildin/go-metrics-altering/config/server"
+	"github.com/igortoigildin/go-metrics-altering/internal/audit"
 	grpcapp "github.com/igortoigildin/go-metrics-altering/internal/server/grpc/app/grpc"
 	"github.com/igortoigildin/go-metrics-altering/internal/storage"
 )
@@ -10,9 +11,9 @@ type App struct {
 	GRPCServer *grpcapp.App
 }
 
-func New(config *config.ConfigServer, storage storage.Storage) *App {
+func New(config *config.ConfigServer, storage storage.Storage, auditor *audit.Auditor) *App {
 
-	grpcApp := grpcapp.New(config, storage)
+	grpcApp := grpcapp.New(config, storage, auditor)
 
 	return &App{
 		GRPCServer: grpcApp,
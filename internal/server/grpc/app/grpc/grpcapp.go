@@ -1,6 +1,7 @@
 package grpcapp
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -9,9 +10,15 @@ import (
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/realip"
 	config "github.com/igortoigildin/go-metrics-altering/config/server"
+	"github.com/igortoigildin/go-metrics-altering/internal/audit"
+	"github.com/igortoigildin/go-metrics-altering/internal/replication"
 	server "github.com/igortoigildin/go-metrics-altering/internal/server/grpc/rpcserver"
+	metricstorage "github.com/igortoigildin/go-metrics-altering/internal/storage"
+	"github.com/igortoigildin/go-metrics-altering/pkg/interceptors/auth"
 	adapter "github.com/igortoigildin/go-metrics-altering/pkg/interceptors/logging"
+	"github.com/igortoigildin/go-metrics-altering/pkg/interceptors/source"
 	"github.com/igortoigildin/go-metrics-altering/pkg/logger"
+	pb "github.com/igortoigildin/go-metrics-altering/pkg/metrics_v1"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 )
@@ -24,6 +31,7 @@ type App struct {
 func New(
 	config *config.ConfigServer,
 	storage server.Storage,
+	auditor *audit.Auditor,
 ) *App {
 
 	logger, _ := zap.NewDevelopment()
@@ -53,9 +61,23 @@ func New(
 	gRPCServer := grpc.NewServer(grpc.ChainUnaryInterceptor(
 		logging.UnaryServerInterceptor(adapter.InterceptorLogger(logger), opts1...),
 		realip.UnaryServerInterceptorOpts(opts2...),
+		source.UnaryServerInterceptor(),
+		// destructive methods are allowed for trusted subnet only
+		auth.UnaryServerInterceptor(config.FlagTrustedSubnet, pb.Metrics_DeleteMetrics_FullMethodName, pb.Metrics_ResetMetrics_FullMethodName),
+	), grpc.ChainStreamInterceptor(
+		logging.StreamServerInterceptor(adapter.InterceptorLogger(logger), opts1...),
+		realip.StreamServerInterceptorOpts(opts2...),
+		// followers are allowed from trusted subnet only
+		auth.StreamServerInterceptor(config.FlagTrustedSubnet, pb.Replication_Replicate_FullMethodName),
 	))
 
-	server.Register(gRPCServer, storage)
+	server.Register(gRPCServer, storage, auditor)
+	// the node may be wrapped, e.g. by ingestion queue
+	if s, ok := storage.(metricstorage.Storage); ok {
+		if node, ok := metricstorage.As[*replication.Node](s); ok {
+			replication.Register(gRPCServer, node)
+		}
+	}
 
 	return &App{
 		GRPCServer: gRPCServer,
@@ -86,3 +108,19 @@ func (a *App) Run() error {
 	}
 	return nil
 }
+
+// Stop stops accepting connections and waits for pending requests. Streams still open when ctx is done,
+// such as ones of followers, are closed.
+func (a *App) Stop(ctx context.Context) {
+	stopped := make(chan struct{})
+	go func() {
+		a.GRPCServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		a.GRPCServer.Stop()
+	}
+}
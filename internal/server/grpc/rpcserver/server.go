@@ -2,8 +2,14 @@ package metricsgrpc
 
 import (
 	"context"
+	"errors"
+	"regexp"
+	"time"
 
+	"github.com/igortoigildin/go-metrics-altering/internal/audit"
 	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	"github.com/igortoigildin/go-metrics-altering/pkg/interceptors/source"
 	metrics "github.com/igortoigildin/go-metrics-altering/pkg/metrics_v1"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -11,39 +17,240 @@ import (
 )
 
 const (
-	gauge   = "gauge"
-	counter = "counter"
+	gauge     = "gauge"
+	counter   = "counter"
+	histogram = "histogram"
 )
 
 //go:generate go run github.com/vektra/mockery/v2@v2.45.0 --name=Storage
 type Storage interface {
-	Update(ctx context.Context, metricType string, metricName string, metricValue any) error
-	Get(ctx context.Context, metricType string, metricName string) (models.Metrics, error)
-	GetAll(ctx context.Context) (map[string]any, error)
+	Update(ctx context.Context, metricType string, metricName string, labels models.Labels, metricValue any) error
+	Get(ctx context.Context, metricType string, metricName string, labels models.Labels) (models.Metrics, error)
+	List(ctx context.Context, filter models.ListFilter) ([]models.Metrics, string, error)
+	Delete(ctx context.Context, metricType string, pattern string, matchers ...*models.LabelMatcher) (int, error)
+	Reset(ctx context.Context, metricType string, pattern string, matchers ...*models.LabelMatcher) (int, error)
 	Ping(ctx context.Context) error
 }
 
 type ServerAPI struct {
 	metrics.UnimplementedMetricsServer
 	Storage Storage
+	// Auditor is notified of updated, deleted and reset metrics, nil one discards them.
+	Auditor *audit.Auditor
 }
 
-func Register(gRPC *grpc.Server, storage Storage) {
-	metrics.RegisterMetricsServer(gRPC, &ServerAPI{Storage: storage})
+func Register(gRPC *grpc.Server, storage Storage, auditor *audit.Auditor) {
+	metrics.RegisterMetricsServer(gRPC, &ServerAPI{Storage: storage, Auditor: auditor})
 }
 
 func (s *ServerAPI) AddGaugeMetric(ctx context.Context, req *metrics.AddGaugeRequest) (*metrics.AddGaugeResponse, error) {
-	err := s.Storage.Update(ctx, gauge, req.Metric.Name, req.Metric.Value)
+	labels := models.Labels(req.Metric.Labels)
+	if err := labels.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	err := s.Storage.Update(ctx, gauge, req.Metric.Name, labels, req.Metric.Value)
 	if err != nil {
-		return nil, status.Error(codes.Internal, "internal error")
+		return nil, statusError(err)
 	}
-	return nil, nil
+	if s.Auditor.Enabled() {
+		s.publish(ctx, audit.ActionUpdate, req.Metric.Name)
+	}
+	return &metrics.AddGaugeResponse{}, nil
 }
 
 func (s *ServerAPI) AddCounterMetric(ctx context.Context, req *metrics.AddCounterRequest) (*metrics.AddCounterResponse, error) {
-	err := s.Storage.Update(ctx, counter, req.Metric.Name, req.Metric.Value)
+	labels := models.Labels(req.Metric.Labels)
+	if err := labels.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	err := s.Storage.Update(ctx, counter, req.Metric.Name, labels, req.Metric.Value)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if s.Auditor.Enabled() {
+		s.publish(ctx, audit.ActionUpdate, req.Metric.Name)
+	}
+	return &metrics.AddCounterResponse{}, nil
+}
+
+func (s *ServerAPI) AddHistogramMetric(ctx context.Context, req *metrics.AddHistogramRequest) (*metrics.AddHistogramResponse, error) {
+	labels := models.Labels(req.Metric.Labels)
+	if err := labels.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	h := &models.Histogram{
+		Bounds: req.Metric.Bounds,
+		Counts: req.Metric.Counts,
+		Sum:    req.Metric.Sum,
+		Count:  req.Metric.Count,
+	}
+	if err := h.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	err := s.Storage.Update(ctx, histogram, req.Metric.Name, labels, h)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if s.Auditor.Enabled() {
+		s.publish(ctx, audit.ActionUpdate, req.Metric.Name)
+	}
+	return &metrics.AddHistogramResponse{}, nil
+}
+
+func (s *ServerAPI) GetMetric(ctx context.Context, req *metrics.GetMetricRequest) (*metrics.GetMetricResponse, error) {
+	labels := models.Labels(req.Labels)
+	if err := labels.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	metric, err := s.Storage.Get(ctx, req.Type, req.Name, labels)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	metric.ID, metric.Labels = req.Name, labels
+	m := toMetric(metric)
+	return &metrics.GetMetricResponse{Gauge: m.Gauge, Counter: m.Counter, Histogram: m.Histogram}, nil
+}
+
+func (s *ServerAPI) ListMetrics(ctx context.Context, req *metrics.ListMetricsRequest) (*metrics.ListMetricsResponse, error) {
+	filter := models.ListFilter{
+		Type:   req.Type,
+		Prefix: req.Prefix,
+		Cursor: req.Cursor,
+		Limit:  int(req.Limit),
+	}
+	for _, v := range req.Matchers {
+		matcher, err := models.ParseLabelMatcher(v)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		filter.Matchers = append(filter.Matchers, matcher)
+	}
+	if req.Regex != "" {
+		re, err := regexp.Compile(req.Regex)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		filter.Regexp = re
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	list, next, err := s.Storage.List(ctx, filter)
 	if err != nil {
-		return nil, status.Error(codes.Internal, "internal error")
+		return nil, statusError(err)
+	}
+
+	resp := &metrics.ListMetricsResponse{Metrics: make([]*metrics.Metric, 0, len(list)), NextCursor: next}
+	for _, metric := range list {
+		resp.Metrics = append(resp.Metrics, toMetric(metric))
+	}
+	return resp, nil
+}
+
+func (s *ServerAPI) DeleteMetrics(ctx context.Context, req *metrics.DeleteMetricsRequest) (*metrics.DeleteMetricsResponse, error) {
+	matchers, err := parseSeriesRequest(req.Type, req.Pattern, req.Matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := s.Storage.Delete(ctx, req.Type, req.Pattern, matchers...)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if n > 0 && s.Auditor.Enabled() {
+		s.publish(ctx, audit.ActionDelete, req.Pattern)
+	}
+	return &metrics.DeleteMetricsResponse{Deleted: int64(n)}, nil
+}
+
+func (s *ServerAPI) ResetMetrics(ctx context.Context, req *metrics.ResetMetricsRequest) (*metrics.ResetMetricsResponse, error) {
+	matchers, err := parseSeriesRequest(req.Type, req.Pattern, req.Matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := s.Storage.Reset(ctx, req.Type, req.Pattern, matchers...)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if n > 0 && s.Auditor.Enabled() {
+		s.publish(ctx, audit.ActionReset, req.Pattern)
+	}
+	return &metrics.ResetMetricsResponse{ResetCount: int64(n)}, nil
+}
+
+// publish notifies auditor of the action made to metrics by the call.
+func (s *ServerAPI) publish(ctx context.Context, action string, names ...string) {
+	s.Auditor.Publish(audit.Event{
+		Timestamp: time.Now(),
+		Action:    action,
+		Metrics:   names,
+		IPAddress: source.IP(ctx),
+		Transport: audit.TransportGRPC,
+	})
+}
+
+// parseSeriesRequest validates metric type and name pattern and parses label matchers of delete and reset requests.
+func parseSeriesRequest(metricType string, pattern string, rawMatchers []string) ([]*models.LabelMatcher, error) {
+	if metricType != "" && metricType != gauge && metricType != counter && metricType != histogram {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported metric type: %q", metricType)
+	}
+	if err := models.ValidatePattern(pattern); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	matchers := make([]*models.LabelMatcher, 0, len(rawMatchers))
+	for _, v := range rawMatchers {
+		matcher, err := models.ParseLabelMatcher(v)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, nil
+}
+
+// statusError maps storage errors to gRPC status codes. Details of internal errors are not exposed.
+func statusError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, storage.ErrInvalidValue), errors.Is(err, storage.ErrTypeConflict):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, storage.ErrReadOnly):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, storage.ErrOverloaded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	default:
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+// toMetric converts the stored series to its protobuf representation.
+func toMetric(metric models.Metrics) *metrics.Metric {
+	res := &metrics.Metric{}
+	labels := map[string]string(metric.Labels)
+	switch {
+	case metric.Value != nil:
+		res.Gauge = &metrics.GaugeMetric{Name: metric.ID, Value: *metric.Value, Labels: labels}
+	case metric.Delta != nil:
+		res.Counter = &metrics.CounterMetric{Name: metric.ID, Value: *metric.Delta, Labels: labels}
+	case metric.Histogram != nil:
+		res.Histogram = &metrics.HistogramMetric{
+			Name:   metric.ID,
+			Bounds: metric.Histogram.Bounds,
+			Counts: metric.Histogram.Counts,
+			Sum:    metric.Histogram.Sum,
+			Count:  metric.Histogram.Count,
+			Labels: labels,
+		}
 	}
-	return nil, nil
+	return res
 }
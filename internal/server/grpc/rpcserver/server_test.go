@@ -5,9 +5,14 @@ import (
 	"testing"
 
 	config "github.com/igortoigildin/go-metrics-altering/config/server"
+	"github.com/igortoigildin/go-metrics-altering/internal/audit"
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
 	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	_ "github.com/igortoigildin/go-metrics-altering/internal/storage/all"
 	pb "github.com/igortoigildin/go-metrics-altering/pkg/metrics_v1"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestServerAPI_AddGaugeMetric(t *testing.T) {
@@ -26,6 +31,46 @@ func TestServerAPI_AddGaugeMetric(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestServerAPI_audit(t *testing.T) {
+	events := make(chan audit.Event, 3)
+	auditor := audit.New()
+	auditor.Register(audit.ObserverFunc(func(ctx context.Context, event audit.Event) error {
+		events <- event
+		return nil
+	}))
+
+	cfg := config.ConfigServer{}
+	st, _ := storage.New(&cfg)
+	s := ServerAPI{
+		Storage: st,
+		Auditor: auditor,
+	}
+	_, err := s.AddCounterMetric(context.Background(), &pb.AddCounterRequest{Metric: &pb.CounterMetric{Name: "PollCount", Value: 1}})
+	assert.NoError(t, err)
+	_, err = s.AddHistogramMetric(context.Background(), &pb.AddHistogramRequest{Metric: &pb.HistogramMetric{Name: "latency"}})
+	assert.Error(t, err)
+	_, err = s.ResetMetrics(context.Background(), &pb.ResetMetricsRequest{Pattern: "Poll*"})
+	assert.NoError(t, err)
+	_, err = s.DeleteMetrics(context.Background(), &pb.DeleteMetricsRequest{Pattern: "PollCount"})
+	assert.NoError(t, err)
+	// nothing deleted
+	_, err = s.DeleteMetrics(context.Background(), &pb.DeleteMetricsRequest{Pattern: "PollCount"})
+	assert.NoError(t, err)
+	auditor.Close()
+
+	assert.Len(t, events, 3)
+	event := <-events
+	assert.Equal(t, audit.ActionUpdate, event.Action)
+	assert.Equal(t, []string{"PollCount"}, event.Metrics)
+	assert.Equal(t, audit.TransportGRPC, event.Transport)
+	event = <-events
+	assert.Equal(t, audit.ActionReset, event.Action)
+	assert.Equal(t, []string{"Poll*"}, event.Metrics)
+	event = <-events
+	assert.Equal(t, audit.ActionDelete, event.Action)
+	assert.Equal(t, []string{"PollCount"}, event.Metrics)
+}
+
 func TestServerAPI_AddCounterMetric(t *testing.T) {
 	conterMetric := pb.CounterMetric{
 		Name:  "counter",
@@ -42,3 +87,132 @@ func TestServerAPI_AddCounterMetric(t *testing.T) {
 	})
 	assert.NoError(t, err)
 }
+
+func TestServerAPI_AddGaugeMetricWithLabels(t *testing.T) {
+	cfg := config.ConfigServer{}
+	st, _ := storage.New(&cfg)
+	s := ServerAPI{
+		Storage: st,
+	}
+
+	_, err := s.AddGaugeMetric(context.Background(), &pb.AddGaugeRequest{
+		Metric: &pb.GaugeMetric{Name: "requests", Value: 2, Labels: map[string]string{"host": "a"}},
+	})
+	assert.NoError(t, err)
+
+	metric, err := st.Get(context.Background(), gauge, "requests", models.Labels{"host": "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, float64(2), *metric.Value)
+
+	_, err = s.AddGaugeMetric(context.Background(), &pb.AddGaugeRequest{
+		Metric: &pb.GaugeMetric{Name: "requests", Value: 2, Labels: map[string]string{"1host": "a"}},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServerAPI_AddHistogramMetric(t *testing.T) {
+	cfg := config.ConfigServer{}
+	st, _ := storage.New(&cfg)
+	s := ServerAPI{
+		Storage: st,
+	}
+
+	_, err := s.AddHistogramMetric(context.Background(), &pb.AddHistogramRequest{
+		Metric: &pb.HistogramMetric{Name: "latency", Bounds: []float64{0.1, 1}, Counts: []uint64{1, 0, 1}, Sum: 2.05, Count: 2},
+	})
+	assert.NoError(t, err)
+
+	metric, err := st.Get(context.Background(), histogram, "latency", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), metric.Histogram.Count)
+
+	_, err = s.AddHistogramMetric(context.Background(), &pb.AddHistogramRequest{
+		Metric: &pb.HistogramMetric{Name: "latency", Bounds: []float64{5}, Counts: []uint64{1, 0}, Count: 1},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = s.AddHistogramMetric(context.Background(), &pb.AddHistogramRequest{
+		Metric: &pb.HistogramMetric{Name: "latency", Bounds: []float64{0.1, 1}, Counts: []uint64{1}, Count: 1},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServerAPI_DeleteResetMetrics(t *testing.T) {
+	cfg := config.ConfigServer{}
+	st, _ := storage.New(&cfg)
+	s := ServerAPI{
+		Storage: st,
+	}
+	_ = st.Update(context.Background(), counter, "requests", models.Labels{"host": "a"}, int64(5))
+	_ = st.Update(context.Background(), gauge, "requests", models.Labels{"host": "b"}, float64(1))
+
+	reset, err := s.ResetMetrics(context.Background(), &pb.ResetMetricsRequest{Type: counter, Pattern: "req*"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), reset.ResetCount)
+
+	deleted, err := s.DeleteMetrics(context.Background(), &pb.DeleteMetricsRequest{Pattern: "requests", Matchers: []string{`host="b"`}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted.Deleted)
+
+	_, err = s.DeleteMetrics(context.Background(), &pb.DeleteMetricsRequest{Type: "summary", Pattern: "requests"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = s.DeleteMetrics(context.Background(), &pb.DeleteMetricsRequest{Pattern: ""})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = s.ResetMetrics(context.Background(), &pb.ResetMetricsRequest{Pattern: "*", Matchers: []string{"host"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServerAPI_GetMetric(t *testing.T) {
+	cfg := config.ConfigServer{}
+	st, _ := storage.New(&cfg)
+	s := ServerAPI{
+		Storage: st,
+	}
+	_ = st.Update(context.Background(), counter, "requests", models.Labels{"host": "a"}, int64(5))
+	_ = st.Update(context.Background(), gauge, "load", nil, float64(0.5))
+
+	resp, err := s.GetMetric(context.Background(), &pb.GetMetricRequest{Type: counter, Name: "requests", Labels: map[string]string{"host": "a"}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), resp.GetCounter().GetValue())
+
+	resp, err = s.GetMetric(context.Background(), &pb.GetMetricRequest{Type: gauge, Name: "load"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0.5, resp.GetGauge().GetValue())
+
+	_, err = s.GetMetric(context.Background(), &pb.GetMetricRequest{Type: counter, Name: "requests"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = s.GetMetric(context.Background(), &pb.GetMetricRequest{Type: "summary", Name: "requests"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServerAPI_ListMetrics(t *testing.T) {
+	cfg := config.ConfigServer{}
+	st, _ := storage.New(&cfg)
+	s := ServerAPI{
+		Storage: st,
+	}
+	_ = st.Update(context.Background(), counter, "requests", models.Labels{"host": "a"}, int64(5))
+	_ = st.Update(context.Background(), gauge, "requests", models.Labels{"host": "a"}, float64(0.5))
+	_ = st.Update(context.Background(), gauge, "load", nil, float64(1))
+
+	resp, err := s.ListMetrics(context.Background(), &pb.ListMetricsRequest{Regex: "^req", Matchers: []string{`host="a"`}, Limit: 1})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Metrics, 1)
+	assert.Equal(t, int64(5), resp.Metrics[0].GetCounter().GetValue())
+
+	resp, err = s.ListMetrics(context.Background(), &pb.ListMetricsRequest{Regex: "^req", Limit: 1, Cursor: resp.NextCursor})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Metrics, 1)
+	assert.Equal(t, 0.5, resp.Metrics[0].GetGauge().GetValue())
+	assert.Equal(t, map[string]string{"host": "a"}, resp.Metrics[0].GetGauge().GetLabels())
+	assert.Empty(t, resp.NextCursor)
+
+	_, err = s.ListMetrics(context.Background(), &pb.ListMetricsRequest{Regex: "["})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = s.ListMetrics(context.Background(), &pb.ListMetricsRequest{Cursor: "!"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"bufio"
+	"io"
+	"maps"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	config "github.com/igortoigildin/go-metrics-altering/config/server"
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// Content types of the Prometheus exposition formats.
+const (
+	textContentType        = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// family is series of the same type sharing the sanitised name.
+type family struct {
+	name   string
+	mType  string
+	series []models.Metrics
+}
+
+// writeExposition renders metrics in the Prometheus text format or, if openMetrics is set, in the OpenMetrics one.
+// Metrics are grouped into families by sanitised name. Series, which name clashes with a family of another type,
+// are skipped, as a family cannot have two types. So are series, which become duplicates of others once
+// their names are sanitised.
+func writeExposition(w io.Writer, metrics []models.Metrics, openMetrics bool) error {
+	bw := bufio.NewWriter(w)
+
+	for _, f := range families(metrics, openMetrics) {
+		bw.WriteString("# TYPE " + f.name + " " + f.mType + "\n")
+		for _, metric := range f.series {
+			switch {
+			case metric.Value != nil:
+				writeSample(bw, f.name, metric.Labels, "", "", formatFloat(*metric.Value))
+			case metric.Delta != nil:
+				name := f.name
+				if openMetrics {
+					name += "_total"
+				}
+				writeSample(bw, name, metric.Labels, "", "", strconv.FormatInt(*metric.Delta, 10))
+			case metric.Histogram != nil:
+				writeHistogram(bw, f.name, metric.Labels, metric.Histogram)
+			}
+		}
+	}
+	if openMetrics {
+		bw.WriteString("# EOF\n")
+	}
+	return bw.Flush()
+}
+
+// families groups metrics by sanitised name in order of the names.
+func families(metrics []models.Metrics, openMetrics bool) []*family {
+	byName := make(map[string]*family)
+	seen := make(map[string]bool)
+	for _, metric := range metrics {
+		name := sanitizeName(metric.ID)
+		if openMetrics && metric.MType == config.CountType {
+			// counter family is named without the suffix of its samples
+			name = strings.TrimSuffix(name, "_total")
+		}
+		if name == "" {
+			continue
+		}
+
+		f, ok := byName[name]
+		if !ok {
+			f = &family{name: name, mType: metric.MType}
+			byName[name] = f
+		}
+		if f.mType != metric.MType {
+			logger.Log.Info("series skipped from exposition, name is taken by another type",
+				zap.String("name", metric.ID), zap.String("type", metric.MType))
+			continue
+		}
+
+		labels := metric.Labels
+		if metric.MType == config.HistogramType {
+			// le label is replaced by bounds of the buckets
+			labels = maps.Clone(labels)
+			delete(labels, "le")
+		}
+		key := models.SeriesKey(name, labels)
+		if seen[key] {
+			logger.Log.Info("series skipped from exposition, sanitised name duplicates another series",
+				zap.String("name", metric.ID), zap.String("type", metric.MType))
+			continue
+		}
+		seen[key] = true
+		f.series = append(f.series, metric)
+	}
+
+	res := make([]*family, 0, len(byName))
+	for _, f := range byName {
+		res = append(res, f)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].name < res[j].name })
+	return res
+}
+
+// writeHistogram writes cumulative buckets, sum and count of the histogram. Label le of the series is
+// replaced by bounds of the buckets.
+func writeHistogram(w *bufio.Writer, name string, labels models.Labels, h *models.Histogram) {
+	if _, ok := labels["le"]; ok {
+		labels = maps.Clone(labels)
+		delete(labels, "le")
+	}
+
+	var cumulative uint64
+	for i, c := range h.Counts {
+		cumulative += c
+		le := "+Inf"
+		if i < len(h.Bounds) {
+			le = formatFloat(h.Bounds[i])
+		}
+		writeSample(w, name+"_bucket", labels, "le", le, strconv.FormatUint(cumulative, 10))
+	}
+	writeSample(w, name+"_sum", labels, "", "", formatFloat(h.Sum))
+	writeSample(w, name+"_count", labels, "", "", strconv.FormatUint(h.Count, 10))
+}
+
+// writeSample writes a line of the sample with labels sorted by name, extra label is written last.
+func writeSample(w *bufio.Writer, name string, labels models.Labels, extraName, extraValue string, value string) {
+	w.WriteString(name)
+
+	keys := make([]string, 0, len(labels)+1)
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if extraName != "" {
+		keys = append(keys, extraName)
+	}
+
+	if len(keys) > 0 {
+		w.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			v := labels[k]
+			if k == extraName {
+				v = extraValue
+			}
+			w.WriteString(k + `="` + escapeLabelValue(v) + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteString(" " + value + "\n")
+}
+
+// sanitizeName replaces characters not allowed in metric names with underscores.
+// Names starting with a digit get an underscore prepended.
+func sanitizeName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueReplacer.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
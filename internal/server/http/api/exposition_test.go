@@ -0,0 +1,96 @@
+package api
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeExposition(t *testing.T) {
+	gauge := func(v float64) *float64 { return &v }
+	counter := func(v int64) *int64 { return &v }
+	metrics := []models.Metrics{
+		{ID: "requests_total", MType: "counter", Delta: counter(7), Labels: models.Labels{"path": "/update", "code": "200"}},
+		{ID: "heap.alloc", MType: "gauge", Value: gauge(1.5)},
+		// the same series once sanitised
+		{ID: "heap-alloc", MType: "gauge", Value: gauge(2)},
+		{ID: "2xx", MType: "gauge", Value: gauge(math.Inf(1)), Labels: models.Labels{"msg": "a \"b\"\nc\\"}},
+		{ID: "latency", MType: "histogram", Labels: models.Labels{"le": "x"}, Histogram: &models.Histogram{
+			Bounds: []float64{0.1, 1}, Counts: []uint64{2, 1, 1}, Sum: 3.25, Count: 4,
+		}},
+		// the same series once le label is replaced
+		{ID: "latency", MType: "histogram", Histogram: &models.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 1, Count: 1}},
+		// clashes with the histogram family
+		{ID: "latency", MType: "gauge", Value: gauge(1)},
+	}
+
+	tests := []struct {
+		name        string
+		openMetrics bool
+		want        string
+	}{
+		{
+			name: "Text",
+			want: `# TYPE _2xx gauge
+_2xx{msg="a \"b\"\nc\\"} +Inf
+# TYPE heap_alloc gauge
+heap_alloc 1.5
+# TYPE latency histogram
+latency_bucket{le="0.1"} 2
+latency_bucket{le="1"} 3
+latency_bucket{le="+Inf"} 4
+latency_sum 3.25
+latency_count 4
+# TYPE requests_total counter
+requests_total{code="200",path="/update"} 7
+`,
+		},
+		{
+			name:        "OpenMetrics",
+			openMetrics: true,
+			want: `# TYPE _2xx gauge
+_2xx{msg="a \"b\"\nc\\"} +Inf
+# TYPE heap_alloc gauge
+heap_alloc 1.5
+# TYPE latency histogram
+latency_bucket{le="0.1"} 2
+latency_bucket{le="1"} 3
+latency_bucket{le="+Inf"} 4
+latency_sum 3.25
+latency_count 4
+# TYPE requests counter
+requests_total{code="200",path="/update"} 7
+# EOF
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, writeExposition(&buf, metrics, tt.openMetrics))
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func Test_sanitizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "Alloc", want: "Alloc"},
+		{name: "http:requests_total", want: "http:requests_total"},
+		{name: "go.mem-stats/heap", want: "go_mem_stats_heap"},
+		{name: "9lives", want: "_9lives"},
+		{name: "тест", want: "____"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, sanitizeName(tt.name))
+		})
+	}
+}
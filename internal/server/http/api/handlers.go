@@ -2,36 +2,68 @@ package api
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	_ "net/http/pprof" // подключаем пакет pprof
 
 	config "github.com/igortoigildin/go-metrics-altering/config/server"
+	"github.com/igortoigildin/go-metrics-altering/internal/audit"
 	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/replication"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage/cache"
 	"github.com/igortoigildin/go-metrics-altering/pkg/crypt"
 	"github.com/igortoigildin/go-metrics-altering/pkg/logger"
+	"github.com/igortoigildin/go-metrics-altering/pkg/middlewares/source"
 	processjson "github.com/igortoigildin/go-metrics-altering/pkg/processJSON"
 	"go.uber.org/zap"
 )
 
 const (
 	path = "keys/private.pem"
+
+	defaultHistoryWindow = time.Hour
+	listPageSize         = 1000 // series listed at once to render all of them
 )
 
 //go:generate go run github.com/vektra/mockery/v2@v2.45.0 --name=Storage
 type Storage interface {
-	Update(ctx context.Context, metricType string, metricName string, metricValue any) error
-	Get(ctx context.Context, metricType string, metricName string) (models.Metrics, error)
-	GetAll(ctx context.Context) (map[string]any, error)
+	Update(ctx context.Context, metricType string, metricName string, labels models.Labels, metricValue any) error
+	UpdateBatch(ctx context.Context, metrics []models.Metrics) error
+	Get(ctx context.Context, metricType string, metricName string, labels models.Labels) (models.Metrics, error)
+	List(ctx context.Context, filter models.ListFilter) ([]models.Metrics, string, error)
+	History(ctx context.Context, metricType string, metricName string, labels models.Labels, from, to time.Time, step time.Duration) ([]models.Sample, error)
+	Delete(ctx context.Context, metricType string, pattern string, matchers ...*models.LabelMatcher) (int, error)
+	Reset(ctx context.Context, metricType string, pattern string, matchers ...*models.LabelMatcher) (int, error)
 	Ping(ctx context.Context) error
 }
 
+// Attributor is implemented by storages recording sources of counter increments.
+type Attributor interface {
+	CounterSources(ctx context.Context, metricName string, labels models.Labels) ([]models.SourceTotal, error)
+}
+
+// CacheStater is implemented by storages caching metric values.
+type CacheStater interface {
+	CacheStats() cache.Stats
+}
+
+// Replicator is implemented by storages replicated between primary and follower servers.
+type Replicator interface {
+	Status() replication.Status
+	Promote() error
+}
+
 func ping(Storage Storage) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -49,7 +81,7 @@ func ping(Storage Storage) http.HandlerFunc {
 	})
 }
 
-func updates(Storage Storage) http.HandlerFunc {
+func updates(Storage Storage, auditor *audit.Auditor) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
@@ -67,35 +99,51 @@ func updates(Storage Storage) http.HandlerFunc {
 			return
 		}
 
-		// iterating through []Metrics and adding it to db one by one
+		// validating the whole batch before saving it, so it is either saved entirely or rejected
 		for _, metric := range metrics {
-			if metric.MType != config.GaugeType && metric.MType != config.CountType {
+			if metric.MType != config.GaugeType && metric.MType != config.CountType && metric.MType != config.HistogramType {
 				logger.Log.Info("usupported request type", zap.String("type", metric.MType))
 				w.WriteHeader(http.StatusUnprocessableEntity)
 				return
 			}
-			switch metric.MType {
-			case config.GaugeType:
-				err := Storage.Update(ctx, metric.MType, metric.ID, metric.Value)
-				if err != nil {
-					logger.Log.Info("error while updating value", zap.Error(err))
-					w.WriteHeader(http.StatusInternalServerError)
-					return
-				}
-			case config.CountType:
-				err := Storage.Update(ctx, metric.MType, metric.ID, metric.Delta)
-				if err != nil {
-					logger.Log.Info("error while updating value", zap.Error(err))
-					w.WriteHeader(http.StatusInternalServerError)
+			if err := metric.Labels.Validate(); err != nil {
+				logger.Log.Info("invalid labels", zap.Error(err))
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			switch {
+			case metric.MType == config.GaugeType && metric.Value == nil,
+				metric.MType == config.CountType && metric.Delta == nil:
+				logger.Log.Info("metric value not provided", zap.String("name", metric.ID))
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			case metric.MType == config.HistogramType:
+				if err := metric.Histogram.Validate(); err != nil {
+					logger.Log.Info("invalid histogram", zap.Error(err))
+					w.WriteHeader(http.StatusBadRequest)
 					return
 				}
 			}
 		}
+
+		err = Storage.UpdateBatch(ctx, metrics)
+		if err != nil {
+			logger.Log.Info("error while updating batch, no metrics saved", zap.Error(err))
+			w.WriteHeader(errorStatus(err))
+			return
+		}
+		if auditor.Enabled() {
+			names := make([]string, 0, len(metrics))
+			for _, metric := range metrics {
+				names = append(names, metric.ID)
+			}
+			publish(auditor, r, audit.ActionUpdate, names...)
+		}
 		w.Header().Set("Content-Type", "application/json")
 	})
 }
 
-func updateMetric(Storage Storage) http.HandlerFunc {
+func updateMetric(Storage Storage, auditor *audit.Auditor) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		ctx := r.Context()
@@ -134,19 +182,37 @@ func updateMetric(Storage Storage) http.HandlerFunc {
 			logger.Log.Error("error: ", zap.Error(err))
 		}
 
+		if err := req.Labels.Validate(); err != nil {
+			logger.Log.Info("invalid labels", zap.Error(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
 		switch req.MType {
 		case config.GaugeType:
-			err := Storage.Update(ctx, req.MType, req.ID, req.Value)
+			err := Storage.Update(ctx, req.MType, req.ID, req.Labels, req.Value)
 			if err != nil {
 				logger.Log.Info("error while updating value", zap.Error(err))
-				w.WriteHeader(http.StatusInternalServerError)
+				w.WriteHeader(errorStatus(err))
 				return
 			}
 		case config.CountType:
-			err := Storage.Update(ctx, req.MType, req.ID, req.Delta)
+			err := Storage.Update(ctx, req.MType, req.ID, req.Labels, req.Delta)
 			if err != nil {
 				logger.Log.Info("error while updating value", zap.Error(err))
-				w.WriteHeader(http.StatusInternalServerError)
+				w.WriteHeader(errorStatus(err))
+				return
+			}
+		case config.HistogramType:
+			if err := req.Histogram.Validate(); err != nil {
+				logger.Log.Info("invalid histogram", zap.Error(err))
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			err := Storage.Update(ctx, req.MType, req.ID, req.Labels, req.Histogram)
+			if err != nil {
+				logger.Log.Info("error while updating value", zap.Error(err))
+				w.WriteHeader(errorStatus(err))
 				return
 			}
 		default:
@@ -155,11 +221,17 @@ func updateMetric(Storage Storage) http.HandlerFunc {
 			return
 		}
 
+		if auditor.Enabled() {
+			publish(auditor, r, audit.ActionUpdate, req.ID)
+		}
+
 		resp := models.Metrics{
-			ID:    req.ID,
-			MType: req.MType,
-			Value: req.Value,
-			Delta: req.Delta,
+			ID:        req.ID,
+			MType:     req.MType,
+			Value:     req.Value,
+			Delta:     req.Delta,
+			Labels:    req.Labels,
+			Histogram: req.Histogram,
 		}
 		err = processjson.WriteJSON(w, http.StatusOK, resp, nil)
 		if err != nil {
@@ -169,21 +241,111 @@ func updateMetric(Storage Storage) http.HandlerFunc {
 	})
 }
 
+// listResponse is a page of series returned by GET /.
+type listResponse struct {
+	Metrics []models.Metrics `json:"metrics"`
+	Next    string           `json:"next,omitempty"` // cursor of the next page
+}
+
+// pageParams are query parameters of GET /, which select response of listResponse form.
+var pageParams = []string{"type", "prefix", "regex", "cursor", "limit"}
+
+// getAllmetrics responds with values of all series satisfying match parameters by series key. If any of
+// pageParams is stated, it responds with a page of series instead.
 func getAllmetrics(Storage Storage) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter, err := parseListFilter(query)
+		if err != nil {
+			logger.Log.Info("invalid list filter", zap.Error(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		paged := slices.ContainsFunc(pageParams, query.Has)
+		var resp any
+		if paged {
+			var page listResponse
+			page.Metrics, page.Next, err = Storage.List(r.Context(), filter)
+			if page.Metrics == nil {
+				page.Metrics = []models.Metrics{}
+			}
+			resp = page
+		} else {
+			var metrics []models.Metrics
+			metrics, err = listAll(r.Context(), Storage, filter)
+			resp = seriesValues(metrics)
+		}
+		if err != nil {
+			logger.Log.Info("error while listing metrics", zap.Error(err))
+			w.WriteHeader(errorStatus(err))
+			return
+		}
+
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		w.Header().Add("Content-Encoding", "gzip")
-		metrics, err := Storage.GetAll(r.Context())
+		err = processjson.WriteJSON(w, http.StatusOK, resp, nil)
 		if err != nil {
-			logger.Log.Info("error", zap.Error(err))
-			w.WriteHeader(http.StatusInternalServerError)
+			logger.Log.Info("error encoding response", zap.Error(err))
 			return
 		}
-		err = processjson.WriteJSON(w, http.StatusOK, metrics, nil)
+	})
+}
+
+// listAll returns all series satisfying the filter, listing them page by page.
+func listAll(ctx context.Context, Storage Storage, filter models.ListFilter) ([]models.Metrics, error) {
+	var metrics []models.Metrics
+	filter.Limit = listPageSize
+	for {
+		page, next, err := Storage.List(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, page...)
+		if next == "" {
+			return metrics, nil
+		}
+		filter.Cursor = next
+	}
+}
+
+// seriesValues returns values of the series by series key.
+func seriesValues(metrics []models.Metrics) map[string]any {
+	res := make(map[string]any, len(metrics))
+	for _, metric := range metrics {
+		key := models.SeriesKey(metric.ID, metric.Labels)
+		switch {
+		case metric.Value != nil:
+			res[key] = *metric.Value
+		case metric.Delta != nil:
+			res[key] = *metric.Delta
+		case metric.Histogram != nil:
+			res[key] = metric.Histogram
+		}
+	}
+	return res
+}
+
+// exposition renders all stored series in the Prometheus text format, or in the OpenMetrics one
+// if the client accepts it.
+func exposition(Storage Storage) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metrics, err := listAll(r.Context(), Storage, models.ListFilter{})
 		if err != nil {
-			logger.Log.Info("error encoding response", zap.Error(err))
+			logger.Log.Info("error while listing metrics", zap.Error(err))
+			w.WriteHeader(errorStatus(err))
 			return
 		}
+
+		openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+		if openMetrics {
+			w.Header().Set("Content-Type", openMetricsContentType)
+		} else {
+			w.Header().Set("Content-Type", textContentType)
+		}
+		if err := writeExposition(w, metrics, openMetrics); err != nil {
+			logger.Log.Info("error writing exposition", zap.Error(err))
+		}
 	})
 }
 
@@ -205,48 +367,26 @@ func getMetric(Storage Storage) http.HandlerFunc {
 			return
 		}
 
-		if req.MType != config.GaugeType && req.MType != config.CountType {
+		if req.MType != config.GaugeType && req.MType != config.CountType && req.MType != config.HistogramType {
 			logger.Log.Info("usupported request type", zap.String("type", req.MType))
 			w.WriteHeader(http.StatusUnprocessableEntity)
 			return
 		}
 
-		resp := models.Metrics{
-			ID:    req.ID,
-			MType: req.MType,
+		res, err := Storage.Get(ctx, req.MType, req.ID, req.Labels)
+		if err != nil {
+			logger.Log.Info("error while obtaining metric", zap.Error(err))
+			w.WriteHeader(errorStatus(err))
+			return
 		}
 
-		switch req.MType {
-		case config.GaugeType:
-			res, err := Storage.Get(ctx, req.MType, req.ID)
-			if err != nil {
-				switch {
-				case errors.Is(err, sql.ErrNoRows):
-					logger.Log.Info("error while obtaining metric", zap.Error(err))
-					w.WriteHeader(http.StatusNotFound)
-					return
-				default:
-					logger.Log.Info("error while obtaining metric", zap.Error(err))
-					w.WriteHeader(http.StatusInternalServerError)
-					return
-				}
-			}
-			resp.Value = res.Value
-		case config.CountType:
-			res, err := Storage.Get(ctx, req.MType, req.ID)
-			if err != nil {
-				switch {
-				case errors.Is(err, sql.ErrNoRows):
-					logger.Log.Info("error while obtaining metric", zap.Error(err))
-					w.WriteHeader(http.StatusNotFound)
-					return
-				default:
-					logger.Log.Info("error while obtaining metric", zap.Error(err))
-					w.WriteHeader(http.StatusInternalServerError)
-					return
-				}
-			}
-			resp.Delta = res.Delta
+		resp := models.Metrics{
+			ID:        req.ID,
+			MType:     req.MType,
+			Labels:    req.Labels,
+			Value:     res.Value,
+			Delta:     res.Delta,
+			Histogram: res.Histogram,
 		}
 
 		w.Header().Add("Content-Encoding", "gzip")
@@ -258,7 +398,7 @@ func getMetric(Storage Storage) http.HandlerFunc {
 	})
 }
 
-func updatePathHandler(LocalStorage Storage) http.HandlerFunc {
+func updatePathHandler(LocalStorage Storage, auditor *audit.Auditor) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		metricType := r.PathValue("metricType")
 		metricName := r.PathValue("metricName")
@@ -273,10 +413,10 @@ func updatePathHandler(LocalStorage Storage) http.HandlerFunc {
 				return
 			}
 
-			err = LocalStorage.Update(context.TODO(), config.GaugeType, metricName, metricValueConverted)
+			err = LocalStorage.Update(context.TODO(), config.GaugeType, metricName, nil, metricValueConverted)
 			if err != nil {
 				logger.Log.Error("error while updating metric", zap.Error(err))
-				w.WriteHeader(http.StatusInternalServerError)
+				w.WriteHeader(errorStatus(err))
 				return
 			}
 
@@ -288,20 +428,34 @@ func updatePathHandler(LocalStorage Storage) http.HandlerFunc {
 				return
 			}
 
-			err = LocalStorage.Update(context.TODO(), config.CountType, metricName, metricValueConverted)
+			err = LocalStorage.Update(context.TODO(), config.CountType, metricName, nil, metricValueConverted)
 			if err != nil {
 				logger.Log.Error("error while updating metric", zap.Error(err))
-				w.WriteHeader(http.StatusInternalServerError)
+				w.WriteHeader(errorStatus(err))
 				return
 			}
 		default:
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
+		if auditor.Enabled() {
+			publish(auditor, r, audit.ActionUpdate, metricName)
+		}
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	})
 }
 
+// publish notifies auditor of the action made to metrics by the request.
+func publish(auditor *audit.Auditor, r *http.Request, action string, names ...string) {
+	auditor.Publish(audit.Event{
+		Timestamp: time.Now(),
+		Action:    action,
+		Metrics:   names,
+		IPAddress: source.IP(r),
+		Transport: audit.TransportHTTP,
+	})
+}
+
 func valuePathHandler(LocalStorage Storage) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -310,19 +464,19 @@ func valuePathHandler(LocalStorage Storage) http.HandlerFunc {
 
 		switch metricType {
 		case config.GaugeType:
-			metric, err := LocalStorage.Get(context.TODO(), config.GaugeType, metricName)
+			metric, err := LocalStorage.Get(context.TODO(), config.GaugeType, metricName, nil)
 			if err != nil {
-				logger.Log.Error("error while loading metric", zap.Error(err))
-				w.WriteHeader(http.StatusInternalServerError)
+				logger.Log.Info("error while loading metric", zap.Error(err))
+				w.WriteHeader(errorStatus(err))
 				return
 			}
 
 			w.Write([]byte(strconv.FormatFloat(*metric.Value, 'f', -1, 64)))
 		case config.CountType:
-			metric, err := LocalStorage.Get(context.TODO(), config.CountType, config.PollCount)
+			metric, err := LocalStorage.Get(context.TODO(), config.CountType, metricName, nil)
 			if err != nil {
-				logger.Log.Error("error while loading metric", zap.Error(err))
-				w.WriteHeader(http.StatusInternalServerError)
+				logger.Log.Info("error while loading metric", zap.Error(err))
+				w.WriteHeader(errorStatus(err))
 				return
 			}
 
@@ -334,3 +488,356 @@ func valuePathHandler(LocalStorage Storage) http.HandlerFunc {
 		}
 	})
 }
+
+func history(Storage Storage) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metricType := r.PathValue("metricType")
+		metricName := r.PathValue("metricName")
+
+		if metricType != config.GaugeType && metricType != config.CountType {
+			logger.Log.Info("usupported request type", zap.String("type", metricType))
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+
+		query := r.URL.Query()
+
+		to := time.Now()
+		if v := query.Get("to"); v != "" {
+			t, err := parseTime(v)
+			if err != nil {
+				logger.Log.Info("error parsing to parameter", zap.Error(err))
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			to = t
+		}
+
+		from := to.Add(-defaultHistoryWindow)
+		if v := query.Get("from"); v != "" {
+			t, err := parseTime(v)
+			if err != nil {
+				logger.Log.Info("error parsing from parameter", zap.Error(err))
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			from = t
+		}
+
+		labels, err := models.ParseLabels(query.Get("labels"))
+		if err != nil {
+			logger.Log.Info("error parsing labels parameter", zap.Error(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var step time.Duration
+		if v := query.Get("step"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				logger.Log.Info("error parsing step parameter", zap.String("step", v))
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			step = d
+		}
+
+		if from.After(to) {
+			logger.Log.Info("from is after to", zap.Time("from", from), zap.Time("to", to))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		samples, err := Storage.History(r.Context(), metricType, metricName, labels, from, to, step)
+		if err != nil {
+			logger.Log.Info("error while obtaining metric history", zap.Error(err))
+			w.WriteHeader(errorStatus(err))
+			return
+		}
+
+		resp := models.History{
+			ID:      metricName,
+			MType:   metricType,
+			Labels:  labels,
+			Samples: samples,
+		}
+		err = processjson.WriteJSON(w, http.StatusOK, resp, nil)
+		if err != nil {
+			logger.Log.Info("error encoding response", zap.Error(err))
+			return
+		}
+	})
+}
+
+// counterSources responds with increments of the counter summed up by source, the largest first.
+func counterSources(Storage Storage) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attributor, ok := storage.As[Attributor](Storage)
+		if !ok {
+			logger.Log.Info("storage does not attribute counter increments")
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+
+		labels, err := models.ParseLabels(r.URL.Query().Get("labels"))
+		if err != nil {
+			logger.Log.Info("error parsing labels parameter", zap.Error(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		sources, err := attributor.CounterSources(r.Context(), r.PathValue("metricName"), labels)
+		if err != nil {
+			logger.Log.Info("error while obtaining counter sources", zap.Error(err))
+			w.WriteHeader(errorStatus(err))
+			return
+		}
+		err = processjson.WriteJSON(w, http.StatusOK, sources, nil)
+		if err != nil {
+			logger.Log.Info("error encoding response", zap.Error(err))
+			return
+		}
+	})
+}
+
+// cacheStats responds with counters of cache hits and misses.
+func cacheStats(Storage Storage) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stater, ok := storage.As[CacheStater](Storage)
+		if !ok {
+			logger.Log.Info("storage is not cached")
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+
+		err := processjson.WriteJSON(w, http.StatusOK, stater.CacheStats(), nil)
+		if err != nil {
+			logger.Log.Info("error encoding response", zap.Error(err))
+			return
+		}
+	})
+}
+
+// replicationStatus responds with role of the server and the last applied change.
+func replicationStatus(Storage Storage) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		replicator, ok := storage.As[Replicator](Storage)
+		if !ok {
+			logger.Log.Info("storage is not replicated")
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+
+		err := processjson.WriteJSON(w, http.StatusOK, replicator.Status(), nil)
+		if err != nil {
+			logger.Log.Info("error encoding response", zap.Error(err))
+			return
+		}
+	})
+}
+
+// promote makes follower server primary. Responds 409 if the server is already primary.
+func promote(Storage Storage) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		replicator, ok := storage.As[Replicator](Storage)
+		if !ok {
+			logger.Log.Info("storage is not replicated")
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+
+		if err := replicator.Promote(); err != nil {
+			logger.Log.Info("error while promoting server", zap.Error(err))
+			if errors.Is(err, replication.ErrPrimary) {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		logger.Log.Info("server promoted to primary")
+
+		err := processjson.WriteJSON(w, http.StatusOK, replicator.Status(), nil)
+		if err != nil {
+			logger.Log.Info("error encoding response", zap.Error(err))
+			return
+		}
+	})
+}
+
+// affectedResponse reports number of series affected by delete or reset requests.
+type affectedResponse struct {
+	Affected int `json:"affected"`
+}
+
+// seriesOperation is either Storage.Delete or Storage.Reset.
+type seriesOperation func(ctx context.Context, metricType string, pattern string, matchers ...*models.LabelMatcher) (int, error)
+
+// deleteMetric deletes series of the metric, metricName may be a glob pattern.
+func deleteMetric(Storage Storage, auditor *audit.Auditor) http.HandlerFunc {
+	return pathSeriesHandler(Storage.Delete, audit.ActionDelete, auditor)
+}
+
+// resetMetric sets series of the metric to zero, metricName may be a glob pattern.
+func resetMetric(Storage Storage, auditor *audit.Auditor) http.HandlerFunc {
+	return pathSeriesHandler(Storage.Reset, audit.ActionReset, auditor)
+}
+
+// pathSeriesHandler applies op to series of the type and name stated in path, optionally filtered by match parameters.
+// Responds 404 if no series matched. The action is published to the auditor.
+func pathSeriesHandler(op seriesOperation, action string, auditor *audit.Auditor) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metricType := r.PathValue("metricType")
+		metricName := r.PathValue("metricName")
+
+		if metricType != config.GaugeType && metricType != config.CountType && metricType != config.HistogramType {
+			logger.Log.Info("usupported request type", zap.String("type", metricType))
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := models.ValidatePattern(metricName); err != nil {
+			logger.Log.Info("invalid metric name", zap.Error(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		matchers, err := parseMatchers(r.URL.Query())
+		if err != nil {
+			logger.Log.Info("invalid label matcher", zap.Error(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		n, err := op(r.Context(), metricType, metricName, matchers...)
+		if err != nil {
+			logger.Log.Info("error while processing metric", zap.Error(err))
+			w.WriteHeader(errorStatus(err))
+			return
+		}
+		if n == 0 {
+			logger.Log.Info("metric not found", zap.String("name", metricName))
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if auditor.Enabled() {
+			publish(auditor, r, action, metricName)
+		}
+
+		err = processjson.WriteJSON(w, http.StatusOK, affectedResponse{Affected: n}, nil)
+		if err != nil {
+			logger.Log.Info("error encoding response", zap.Error(err))
+			return
+		}
+	})
+}
+
+// deleteMetrics deletes series in bulk. Query parameters are pattern (required glob of metric names),
+// type (all types if omitted) and repeated label matchers match.
+func deleteMetrics(Storage Storage, auditor *audit.Auditor) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		metricType := query.Get("type")
+		if metricType != "" && metricType != config.GaugeType && metricType != config.CountType && metricType != config.HistogramType {
+			logger.Log.Info("usupported request type", zap.String("type", metricType))
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+
+		pattern := query.Get("pattern")
+		if err := models.ValidatePattern(pattern); err != nil {
+			logger.Log.Info("invalid pattern parameter", zap.Error(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		matchers, err := parseMatchers(query)
+		if err != nil {
+			logger.Log.Info("invalid label matcher", zap.Error(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		n, err := Storage.Delete(r.Context(), metricType, pattern, matchers...)
+		if err != nil {
+			logger.Log.Info("error while deleting metrics", zap.Error(err))
+			w.WriteHeader(errorStatus(err))
+			return
+		}
+		if n > 0 && auditor.Enabled() {
+			publish(auditor, r, audit.ActionDelete, pattern)
+		}
+
+		err = processjson.WriteJSON(w, http.StatusOK, affectedResponse{Affected: n}, nil)
+		if err != nil {
+			logger.Log.Info("error encoding response", zap.Error(err))
+			return
+		}
+	})
+}
+
+// parseMatchers parses repeated match query parameters.
+func parseMatchers(query url.Values) ([]*models.LabelMatcher, error) {
+	matchers := make([]*models.LabelMatcher, 0, len(query["match"]))
+	for _, v := range query["match"] {
+		matcher, err := models.ParseLabelMatcher(v)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, nil
+}
+
+// parseListFilter parses type, prefix, regex, match, cursor and limit query parameters.
+func parseListFilter(query url.Values) (models.ListFilter, error) {
+	matchers, err := parseMatchers(query)
+	if err != nil {
+		return models.ListFilter{}, err
+	}
+
+	filter := models.ListFilter{
+		Type:     query.Get("type"),
+		Prefix:   query.Get("prefix"),
+		Matchers: matchers,
+		Cursor:   query.Get("cursor"),
+	}
+	if v := query.Get("regex"); v != "" {
+		if filter.Regexp, err = regexp.Compile(v); err != nil {
+			return models.ListFilter{}, err
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil {
+			return models.ListFilter{}, err
+		}
+	}
+	return filter, filter.Validate()
+}
+
+// parseTime accepts either RFC3339 time or unix timestamp in seconds.
+func parseTime(v string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// errorStatus maps storage errors to HTTP status codes.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrInvalidValue):
+		return http.StatusBadRequest
+	case errors.Is(err, storage.ErrTypeConflict):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, storage.ErrReadOnly):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, storage.ErrOverloaded):
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -3,19 +3,25 @@ package api
 import (
 	"bytes"
 	"context"
-	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	_ "net/http/pprof"
 	"os"
+	"strconv"
 	"testing"
+	"time"
 
 	config "github.com/igortoigildin/go-metrics-altering/config/server"
+	"github.com/igortoigildin/go-metrics-altering/internal/audit"
 	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/replication"
 	"github.com/igortoigildin/go-metrics-altering/internal/server/http/api/mocks"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage/cache"
 	"github.com/igortoigildin/go-metrics-altering/pkg/crypt"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -114,14 +120,11 @@ func Test_updates(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			repo := mocks.NewStorage(t)
 
-			if tt.respError == "" && tt.mockError == nil {
-				repo.On("Update", mock.Anything, tt.metric[0].MType, tt.metric[0].ID, tt.metric[0].Value).Return(nil).Times(1)
-				repo.On("Update", mock.Anything, tt.metric[4].MType, tt.metric[4].ID, tt.metric[4].Delta).Return(nil).Times(1)
-			}
-
-			if tt.mockError != nil {
-				repo.On("Update", mock.Anything, tt.metric[3].MType, tt.metric[3].ID, tt.metric[3].Value).Return(tt.mockError).Maybe()
-				repo.On("Update", mock.Anything, tt.metric[4].MType, tt.metric[4].ID, tt.metric[4].Delta).Return(tt.mockError).Maybe()
+			// the whole batch is passed to storage at once
+			if tt.respError == "" && tt.method == http.MethodPost {
+				repo.On("UpdateBatch", mock.Anything, mock.MatchedBy(func(batch []models.Metrics) bool {
+					return len(batch) == len(tt.inputIndex)
+				})).Return(tt.mockError).Once()
 			}
 
 			var metrics []metric
@@ -130,7 +133,7 @@ func Test_updates(t *testing.T) {
 			}
 			js, _ := json.Marshal(metrics)
 
-			handler := updates(repo)
+			handler := updates(repo, nil)
 			req, err := http.NewRequest(tt.method, "/updates/", bytes.NewReader([]byte(js)))
 			require.NoError(t, err)
 
@@ -232,18 +235,18 @@ func Test_updateMetric(t *testing.T) {
 			repo := mocks.NewStorage(t)
 
 			if tt.respError == "" && tt.mockError == nil {
-				repo.On("Update", mock.Anything, tt.metric.MType, tt.metric.ID, tt.metric.Value).Return(nil).Maybe()
-				repo.On("Update", mock.Anything, tt.metric.MType, tt.metric.ID, tt.metric.Delta).Return(nil).Maybe()
+				repo.On("Update", mock.Anything, tt.metric.MType, tt.metric.ID, models.Labels(nil), tt.metric.Value).Return(nil).Maybe()
+				repo.On("Update", mock.Anything, tt.metric.MType, tt.metric.ID, models.Labels(nil), tt.metric.Delta).Return(nil).Maybe()
 			}
 
 			if tt.respError != "" {
-				repo.On("Update", mock.Anything, tt.metric.MType, tt.metric.ID, tt.metric.Value).Return(tt.respError).Maybe()
-				repo.On("Update", mock.Anything, tt.metric.MType, tt.metric.ID, tt.metric.Delta).Return(tt.respError).Maybe()
+				repo.On("Update", mock.Anything, tt.metric.MType, tt.metric.ID, models.Labels(nil), tt.metric.Value).Return(tt.respError).Maybe()
+				repo.On("Update", mock.Anything, tt.metric.MType, tt.metric.ID, models.Labels(nil), tt.metric.Delta).Return(tt.respError).Maybe()
 			}
 
 			if tt.mockError != nil {
-				repo.On("Update", mock.Anything, tt.metric.MType, tt.metric.ID, tt.metric.Value).Return(tt.mockError).Maybe()
-				repo.On("Update", mock.Anything, tt.metric.MType, tt.metric.ID, tt.metric.Delta).Return(tt.mockError).Maybe()
+				repo.On("Update", mock.Anything, tt.metric.MType, tt.metric.ID, models.Labels(nil), tt.metric.Value).Return(tt.mockError).Maybe()
+				repo.On("Update", mock.Anything, tt.metric.MType, tt.metric.ID, models.Labels(nil), tt.metric.Delta).Return(tt.mockError).Maybe()
 			}
 
 			// preparing temp config
@@ -266,7 +269,7 @@ func Test_updateMetric(t *testing.T) {
 				log.Println(err)
 			}
 
-			handler := updateMetric(repo)
+			handler := updateMetric(repo, nil)
 
 			req, err := http.NewRequest(tt.method, "/update/", bytes.NewReader([]byte(res)))
 			require.NoError(t, err)
@@ -387,7 +390,7 @@ func Test_getMetric(t *testing.T) {
 				MType: "counter",
 			},
 			respStatusCode: http.StatusNotFound,
-			mockError:      sql.ErrNoRows,
+			mockError:      storage.ErrNotFound,
 			method:         http.MethodGet,
 		},
 		{
@@ -397,7 +400,7 @@ func Test_getMetric(t *testing.T) {
 				MType: "gauge",
 			},
 			respStatusCode: http.StatusNotFound,
-			mockError:      sql.ErrNoRows,
+			mockError:      storage.ErrNotFound,
 			method:         http.MethodGet,
 		},
 	}
@@ -407,17 +410,17 @@ func Test_getMetric(t *testing.T) {
 			repo := mocks.NewStorage(t)
 
 			if tt.respError == "" && tt.mockError == nil {
-				repo.On("Get", mock.Anything, tt.req.MType, tt.req.ID).Return(tt.response, nil).Maybe()
-				repo.On("Get", mock.Anything, tt.req.MType, tt.req.ID).Return(tt.response, nil).Maybe()
+				repo.On("Get", mock.Anything, tt.req.MType, tt.req.ID, models.Labels(nil)).Return(tt.response, nil).Maybe()
+				repo.On("Get", mock.Anything, tt.req.MType, tt.req.ID, models.Labels(nil)).Return(tt.response, nil).Maybe()
 			}
 
 			if tt.respError != "" {
-				repo.On("Get", mock.Anything, tt.req.MType, tt.req.ID).Return(tt.respError, nil).Maybe()
-				repo.On("Get", mock.Anything, tt.req.MType, tt.req.ID).Return(models.Metrics{}, tt.mockError).Maybe()
+				repo.On("Get", mock.Anything, tt.req.MType, tt.req.ID, models.Labels(nil)).Return(tt.respError, nil).Maybe()
+				repo.On("Get", mock.Anything, tt.req.MType, tt.req.ID, models.Labels(nil)).Return(models.Metrics{}, tt.mockError).Maybe()
 			}
 
 			if tt.mockError != nil {
-				repo.On("Get", mock.Anything, tt.req.MType, tt.req.ID).Return(models.Metrics{}, tt.mockError).Maybe()
+				repo.On("Get", mock.Anything, tt.req.MType, tt.req.ID, models.Labels(nil)).Return(models.Metrics{}, tt.mockError).Maybe()
 			}
 
 			js, _ := json.Marshal(tt.req)
@@ -547,6 +550,26 @@ func Test_valuePathHandler(t *testing.T) {
 			mockError:      errors.New("unexpected error"),
 			method:         http.MethodGet,
 		},
+		{
+			name: "Counter not found",
+			mod: mod{
+				ID:    "requests",
+				MType: "counter",
+			},
+			respStatusCode: http.StatusNotFound,
+			mockError:      storage.ErrNotFound,
+			method:         http.MethodGet,
+		},
+		{
+			name: "Gauge not found",
+			mod: mod{
+				ID:    "Alloc",
+				MType: "gauge",
+			},
+			respStatusCode: http.StatusNotFound,
+			mockError:      storage.ErrNotFound,
+			method:         http.MethodGet,
+		},
 	}
 
 	for _, tt := range tests {
@@ -554,11 +577,11 @@ func Test_valuePathHandler(t *testing.T) {
 			repo := mocks.NewStorage(t)
 
 			if tt.respError == "" && tt.mockError == nil {
-				repo.On("Get", mock.Anything, tt.mod.MType, tt.mod.ID).Return(tt.response, nil).Once()
+				repo.On("Get", mock.Anything, tt.mod.MType, tt.mod.ID, models.Labels(nil)).Return(tt.response, nil).Once()
 			}
 
 			if tt.mockError != nil {
-				repo.On("Get", mock.Anything, tt.mod.MType, tt.mod.ID).Return(models.Metrics{}, tt.mockError).Maybe()
+				repo.On("Get", mock.Anything, tt.mod.MType, tt.mod.ID, models.Labels(nil)).Return(models.Metrics{}, tt.mockError).Maybe()
 			}
 
 			handler := valuePathHandler(repo)
@@ -578,6 +601,100 @@ func Test_valuePathHandler(t *testing.T) {
 	}
 }
 
+func Test_errorStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "Not found", err: fmt.Errorf("%w: no rows", storage.ErrNotFound), want: http.StatusNotFound},
+		{name: "Invalid value", err: fmt.Errorf("%w: %w", storage.ErrInvalidValue, models.ErrBoundsMismatch), want: http.StatusBadRequest},
+		{name: "Type conflict", err: storage.ErrTypeConflict, want: http.StatusUnprocessableEntity},
+		{name: "Read-only", err: fmt.Errorf("%w: follower", storage.ErrReadOnly), want: http.StatusServiceUnavailable},
+		{name: "Overloaded", err: fmt.Errorf("%w: queue is full", storage.ErrOverloaded), want: http.StatusTooManyRequests},
+		{name: "Other", err: errors.New("connection refused"), want: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, errorStatus(tt.err))
+		})
+	}
+}
+
+func Test_updatesAudit(t *testing.T) {
+	events := make(chan audit.Event, 2)
+	auditor := audit.New()
+	auditor.Register(audit.ObserverFunc(func(ctx context.Context, event audit.Event) error {
+		events <- event
+		return nil
+	}))
+
+	repo := mocks.NewStorage(t)
+	repo.On("UpdateBatch", mock.Anything, mock.Anything).Return(nil).Once()
+	repo.On("UpdateBatch", mock.Anything, mock.Anything).Return(storage.ErrReadOnly).Once()
+
+	body := `[{"id":"Alloc","type":"gauge","value":1.5},{"id":"PollCount","type":"counter","delta":2}]`
+	for _, status := range []int{http.StatusOK, http.StatusServiceUnavailable} {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader([]byte(body)))
+		req.Header.Set("X-Real-IP", "10.0.0.1")
+		rr := httptest.NewRecorder()
+		updates(repo, auditor).ServeHTTP(rr, req)
+		require.Equal(t, status, rr.Code)
+	}
+	auditor.Close()
+
+	// failed update is not audited
+	require.Len(t, events, 1)
+	event := <-events
+	require.Equal(t, audit.ActionUpdate, event.Action)
+	require.Equal(t, []string{"Alloc", "PollCount"}, event.Metrics)
+	require.Equal(t, "10.0.0.1", event.IPAddress)
+	require.Equal(t, audit.TransportHTTP, event.Transport)
+	require.WithinDuration(t, time.Now(), event.Timestamp, time.Minute)
+}
+
+func Test_deleteResetAudit(t *testing.T) {
+	events := make(chan audit.Event, 3)
+	auditor := audit.New()
+	auditor.Register(audit.ObserverFunc(func(ctx context.Context, event audit.Event) error {
+		events <- event
+		return nil
+	}))
+
+	repo := mocks.NewStorage(t)
+	repo.On("Reset", mock.Anything, "counter", "requests").Return(1, nil).Once()
+	repo.On("Delete", mock.Anything, "counter", "requests").Return(1, nil).Once()
+	repo.On("Delete", mock.Anything, "", "Heap*").Return(2, nil).Once()
+	repo.On("Delete", mock.Anything, "", "Stack*").Return(0, nil).Once()
+
+	for _, h := range []http.HandlerFunc{resetMetric(repo, auditor), deleteMetric(repo, auditor)} {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.SetPathValue("metricType", "counter")
+		req.SetPathValue("metricName", "requests")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+	}
+	// deleting nothing is not audited
+	for _, query := range []string{"?pattern=Heap*", "?pattern=Stack*"} {
+		rr := httptest.NewRecorder()
+		deleteMetrics(repo, auditor).ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/values/"+query, nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+	}
+	auditor.Close()
+
+	require.Len(t, events, 3)
+	for _, want := range []audit.Event{
+		{Action: audit.ActionReset, Metrics: []string{"requests"}},
+		{Action: audit.ActionDelete, Metrics: []string{"requests"}},
+		{Action: audit.ActionDelete, Metrics: []string{"Heap*"}},
+	} {
+		event := <-events
+		require.Equal(t, want.Action, event.Action)
+		require.Equal(t, want.Metrics, event.Metrics)
+	}
+}
+
 func Test_updatePathHandler(t *testing.T) {
 	type mod struct {
 		ID     string `json:"id"`
@@ -710,15 +827,15 @@ func Test_updatePathHandler(t *testing.T) {
 			repo := mocks.NewStorage(t)
 
 			if tt.respError == "" && tt.mockError == nil {
-				repo.On("Update", mock.Anything, tt.mod.MType, tt.mod.ID, tt.mod.Delta).Return(nil).Maybe()
-				repo.On("Update", mock.Anything, tt.mod.MType, tt.mod.ID, tt.mod.Value).Return(nil).Maybe()
+				repo.On("Update", mock.Anything, tt.mod.MType, tt.mod.ID, models.Labels(nil), tt.mod.Delta).Return(nil).Maybe()
+				repo.On("Update", mock.Anything, tt.mod.MType, tt.mod.ID, models.Labels(nil), tt.mod.Value).Return(nil).Maybe()
 			}
 
 			if tt.mockError != nil {
-				repo.On("Update", mock.Anything, tt.mod.MType, tt.mod.ID, mock.Anything).Return(tt.mockError).Maybe()
+				repo.On("Update", mock.Anything, tt.mod.MType, tt.mod.ID, models.Labels(nil), mock.Anything).Return(tt.mockError).Maybe()
 			}
 
-			handler := updatePathHandler(repo)
+			handler := updatePathHandler(repo, nil)
 			mux := &http.ServeMux{}
 			mux.HandleFunc("/update", handler)
 
@@ -762,11 +879,11 @@ func Test_getAllmetrics(t *testing.T) {
 			repo := mocks.NewStorage(t)
 
 			if tt.respError == "" && tt.mockError == nil {
-				repo.On("GetAll", mock.Anything).Return(nil, nil).Maybe()
+				repo.On("List", mock.Anything, mock.Anything).Return(nil, "", nil).Maybe()
 			}
 
 			if tt.mockError != nil {
-				repo.On("GetAll", mock.Anything).Return(nil, tt.mockError).Maybe()
+				repo.On("List", mock.Anything, mock.Anything).Return(nil, "", tt.mockError).Maybe()
 			}
 
 			handler := getAllmetrics(repo)
@@ -779,3 +896,678 @@ func Test_getAllmetrics(t *testing.T) {
 		})
 	}
 }
+
+func Test_history(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+	samples := []models.Sample{
+		{Timestamp: from.Add(time.Minute), Value: 1},
+		{Timestamp: from.Add(2 * time.Minute), Value: 2},
+	}
+
+	tests := []struct {
+		name           string
+		metricType     string
+		metricName     string
+		query          string
+		step           time.Duration
+		mockError      error
+		callStorage    bool
+		respStatusCode int
+	}{
+		{
+			name:           "Success",
+			metricType:     "gauge",
+			metricName:     "HeapAlloc",
+			query:          "?from=" + from.Format(time.RFC3339) + "&to=" + to.Format(time.RFC3339) + "&step=30s",
+			step:           30 * time.Second,
+			callStorage:    true,
+			respStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Success with unix timestamps",
+			metricType:     "counter",
+			metricName:     "PollCount",
+			query:          "?from=" + strconv.FormatInt(from.Unix(), 10) + "&to=" + strconv.FormatInt(to.Unix(), 10),
+			callStorage:    true,
+			respStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Unsupported metric type",
+			metricType:     "wrong_type",
+			metricName:     "HeapAlloc",
+			respStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Incorrect step",
+			metricType:     "gauge",
+			metricName:     "HeapAlloc",
+			query:          "?step=abc",
+			respStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "From after to",
+			metricType:     "gauge",
+			metricName:     "HeapAlloc",
+			query:          "?from=" + to.Format(time.RFC3339) + "&to=" + from.Format(time.RFC3339),
+			respStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Storage error",
+			metricType:     "gauge",
+			metricName:     "HeapAlloc",
+			query:          "?from=" + from.Format(time.RFC3339) + "&to=" + to.Format(time.RFC3339),
+			mockError:      errors.New("unexpected error"),
+			callStorage:    true,
+			respStatusCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := mocks.NewStorage(t)
+
+			if tt.callStorage {
+				repo.On("History", mock.Anything, tt.metricType, tt.metricName, models.Labels(nil),
+					mock.MatchedBy(from.Equal), mock.MatchedBy(to.Equal), tt.step).Return(samples, tt.mockError).Once()
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/history"+tt.query, nil)
+			req.SetPathValue("metricType", tt.metricType)
+			req.SetPathValue("metricName", tt.metricName)
+
+			rr := httptest.NewRecorder()
+			history(repo).ServeHTTP(rr, req)
+			require.Equal(t, tt.respStatusCode, rr.Code)
+
+			if tt.respStatusCode == http.StatusOK {
+				var resp models.History
+				require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+				require.Equal(t, tt.metricName, resp.ID)
+				require.Len(t, resp.Samples, len(samples))
+			}
+		})
+	}
+}
+
+func Test_updatesWithLabels(t *testing.T) {
+	gaugeValue := float64(1.5)
+
+	tests := []struct {
+		name           string
+		metrics        []models.Metrics
+		callStorage    bool
+		respStatusCode int
+	}{
+		{
+			name: "Success",
+			metrics: []models.Metrics{
+				{ID: "requests", MType: "gauge", Value: &gaugeValue, Labels: models.Labels{"host": "a", "service": "api"}},
+			},
+			callStorage:    true,
+			respStatusCode: http.StatusOK,
+		},
+		{
+			name: "Invalid label name",
+			metrics: []models.Metrics{
+				{ID: "requests", MType: "gauge", Value: &gaugeValue, Labels: models.Labels{"1host": "a"}},
+			},
+			respStatusCode: http.StatusBadRequest,
+		},
+		{
+			name: "Batch rejected entirely",
+			metrics: []models.Metrics{
+				{ID: "requests", MType: "gauge", Value: &gaugeValue, Labels: models.Labels{"host": "a"}},
+				{ID: "errors", MType: "counter"},
+			},
+			respStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := mocks.NewStorage(t)
+
+			if tt.callStorage {
+				repo.On("UpdateBatch", mock.Anything, tt.metrics).Return(nil).Once()
+			}
+
+			js, _ := json.Marshal(tt.metrics)
+			req, err := http.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(js))
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			updates(repo, nil).ServeHTTP(rr, req)
+			require.Equal(t, tt.respStatusCode, rr.Code)
+		})
+	}
+}
+
+func Test_exposition(t *testing.T) {
+	value := float64(1.5)
+	delta := int64(3)
+
+	repo := mocks.NewStorage(t)
+	// all pages are rendered
+	repo.On("List", mock.Anything, models.ListFilter{Limit: listPageSize}).
+		Return([]models.Metrics{{ID: "Alloc", MType: "gauge", Value: &value}}, "next", nil)
+	repo.On("List", mock.Anything, models.ListFilter{Limit: listPageSize, Cursor: "next"}).
+		Return([]models.Metrics{{ID: "PollCount", MType: "counter", Delta: &delta}}, "", nil)
+
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		want        string
+	}{
+		{
+			name:        "Text",
+			contentType: textContentType,
+			want:        "# TYPE Alloc gauge\nAlloc 1.5\n# TYPE PollCount counter\nPollCount 3\n",
+		},
+		{
+			name:        "OpenMetrics",
+			accept:      "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5",
+			contentType: openMetricsContentType,
+			want:        "# TYPE Alloc gauge\nAlloc 1.5\n# TYPE PollCount counter\nPollCount_total 3\n# EOF\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			req.Header.Set("Accept", tt.accept)
+			rr := httptest.NewRecorder()
+			exposition(repo).ServeHTTP(rr, req)
+
+			require.Equal(t, http.StatusOK, rr.Code)
+			require.Equal(t, tt.contentType, rr.Header().Get("Content-Type"))
+			require.Equal(t, tt.want, rr.Body.String())
+		})
+	}
+
+	t.Run("Storage error", func(t *testing.T) {
+		repo := mocks.NewStorage(t)
+		repo.On("List", mock.Anything, mock.Anything).Return(nil, "", errors.New("connection refused"))
+
+		rr := httptest.NewRecorder()
+		exposition(repo).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+	})
+}
+
+func Test_getAllmetricsWithFilter(t *testing.T) {
+	value := float64(1)
+	page := []models.Metrics{{ID: "requests", MType: "gauge", Value: &value, Labels: models.Labels{"host": "a"}}}
+
+	tests := []struct {
+		name           string
+		query          string
+		want           func(filter models.ListFilter) bool
+		respStatusCode int
+	}{
+		{
+			name:  "Matchers",
+			query: "?match=host%3Da&match=service%3D~api.*&limit=5",
+			want: func(filter models.ListFilter) bool {
+				return len(filter.Matchers) == 2 && filter.Limit == 5
+			},
+			respStatusCode: http.StatusOK,
+		},
+		{
+			name:  "Type, prefix, regex and page",
+			query: "?type=gauge&prefix=req&regex=%5Ereq.%2Bs%24&limit=10&cursor=Z2F1Z2UAcmVxdWVzdHM",
+			want: func(filter models.ListFilter) bool {
+				return filter.Type == "gauge" && filter.Prefix == "req" && filter.Regexp.String() == "^req.+s$" &&
+					filter.Limit == 10 && filter.Cursor == "Z2F1Z2UAcmVxdWVzdHM"
+			},
+			respStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Invalid matcher",
+			query:          "?match=host",
+			respStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid regex",
+			query:          "?regex=%5B",
+			respStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid limit",
+			query:          "?limit=-1",
+			respStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid cursor",
+			query:          "?cursor=%21",
+			respStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Unsupported type",
+			query:          "?type=summary",
+			respStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := mocks.NewStorage(t)
+
+			if tt.want != nil {
+				repo.On("List", mock.Anything, mock.MatchedBy(tt.want)).Return(page, "next-cursor", nil).Once()
+			}
+
+			req, err := http.NewRequest(http.MethodGet, "/"+tt.query, nil)
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			getAllmetrics(repo).ServeHTTP(rr, req)
+			require.Equal(t, tt.respStatusCode, rr.Code)
+
+			if tt.want != nil {
+				var resp listResponse
+				require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+				require.Equal(t, page, resp.Metrics)
+				require.Equal(t, "next-cursor", resp.Next)
+			}
+		})
+	}
+}
+
+func Test_getAllmetricsValues(t *testing.T) {
+	value := float64(1.5)
+	delta := int64(2)
+	histogram := &models.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1}
+
+	repo := mocks.NewStorage(t)
+	// all pages are listed
+	repo.On("List", mock.Anything, mock.MatchedBy(func(filter models.ListFilter) bool {
+		return len(filter.Matchers) == 1 && filter.Limit == listPageSize && filter.Cursor == ""
+	})).Return([]models.Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &value},
+		{ID: "requests", MType: "counter", Delta: &delta, Labels: models.Labels{"host": "a"}},
+	}, "next-cursor", nil).Once()
+	repo.On("List", mock.Anything, mock.MatchedBy(func(filter models.ListFilter) bool {
+		return filter.Cursor == "next-cursor"
+	})).Return([]models.Metrics{{ID: "latency", MType: "histogram", Histogram: histogram}}, "", nil).Once()
+
+	req, err := http.NewRequest(http.MethodGet, "/?match=host%3Da", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	getAllmetrics(repo).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.JSONEq(t, `{
+		"Alloc": 1.5,
+		"requests{host=\"a\"}": 2,
+		"latency": {"bounds": [1], "counts": [1, 0], "sum": 0.5, "count": 1}
+	}`, rr.Body.String())
+}
+
+func Test_updatesHistogram(t *testing.T) {
+	histogram := &models.Histogram{Bounds: []float64{0.1, 1}, Counts: []uint64{1, 0, 1}, Sum: 2.05, Count: 2}
+
+	tests := []struct {
+		name           string
+		metric         models.Metrics
+		mockError      error
+		callStorage    bool
+		respStatusCode int
+	}{
+		{
+			name:           "Success",
+			metric:         models.Metrics{ID: "latency", MType: "histogram", Histogram: histogram},
+			callStorage:    true,
+			respStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Bounds mismatch",
+			metric:         models.Metrics{ID: "latency", MType: "histogram", Histogram: histogram},
+			mockError:      fmt.Errorf("%w: %w", storage.ErrInvalidValue, models.ErrBoundsMismatch),
+			callStorage:    true,
+			respStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid histogram",
+			metric:         models.Metrics{ID: "latency", MType: "histogram", Histogram: &models.Histogram{Bounds: []float64{1}, Counts: []uint64{1}}},
+			respStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Histogram not provided",
+			metric:         models.Metrics{ID: "latency", MType: "histogram"},
+			respStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := mocks.NewStorage(t)
+
+			if tt.callStorage {
+				repo.On("UpdateBatch", mock.Anything, []models.Metrics{tt.metric}).Return(tt.mockError).Once()
+			}
+
+			js, _ := json.Marshal([]models.Metrics{tt.metric})
+			req, err := http.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(js))
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			updates(repo, nil).ServeHTTP(rr, req)
+			require.Equal(t, tt.respStatusCode, rr.Code)
+		})
+	}
+}
+
+func Test_deleteMetric(t *testing.T) {
+	tests := []struct {
+		name           string
+		metricType     string
+		metricName     string
+		query          string
+		affected       int
+		mockError      error
+		callStorage    bool
+		respStatusCode int
+	}{
+		{
+			name:           "Success",
+			metricType:     "gauge",
+			metricName:     "Heap*",
+			query:          "?match=host%3Da",
+			affected:       2,
+			callStorage:    true,
+			respStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Not found",
+			metricType:     "counter",
+			metricName:     "requests",
+			callStorage:    true,
+			respStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "Storage error",
+			metricType:     "counter",
+			metricName:     "requests",
+			mockError:      errors.New("unexpected error"),
+			callStorage:    true,
+			respStatusCode: http.StatusInternalServerError,
+		},
+		{
+			name:           "Unsupported metric type",
+			metricType:     "summary",
+			metricName:     "requests",
+			respStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Invalid pattern",
+			metricType:     "gauge",
+			metricName:     "Heap[",
+			respStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid matcher",
+			metricType:     "gauge",
+			metricName:     "Heap*",
+			query:          "?match=host",
+			respStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := mocks.NewStorage(t)
+
+			if tt.callStorage {
+				args := []interface{}{mock.Anything, tt.metricType, tt.metricName}
+				if tt.query != "" {
+					args = append(args, mock.AnythingOfType("*models.LabelMatcher"))
+				}
+				repo.On("Delete", args...).Return(tt.affected, tt.mockError).Once()
+			}
+
+			req := httptest.NewRequest(http.MethodDelete, "/value/"+tt.query, nil)
+			req.SetPathValue("metricType", tt.metricType)
+			req.SetPathValue("metricName", tt.metricName)
+
+			rr := httptest.NewRecorder()
+			deleteMetric(repo, nil).ServeHTTP(rr, req)
+			require.Equal(t, tt.respStatusCode, rr.Code)
+
+			if tt.respStatusCode == http.StatusOK {
+				require.JSONEq(t, `{"affected":2}`, rr.Body.String())
+			}
+		})
+	}
+}
+
+func Test_resetMetric(t *testing.T) {
+	repo := mocks.NewStorage(t)
+	repo.On("Reset", mock.Anything, "counter", "requests").Return(1, nil).Once()
+
+	req := httptest.NewRequest(http.MethodPost, "/reset/", nil)
+	req.SetPathValue("metricType", "counter")
+	req.SetPathValue("metricName", "requests")
+
+	rr := httptest.NewRecorder()
+	resetMetric(repo, nil).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.JSONEq(t, `{"affected":1}`, rr.Body.String())
+}
+
+func Test_deleteMetrics(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		metricType     string
+		callStorage    bool
+		respStatusCode int
+	}{
+		{
+			name:           "All types",
+			query:          "?pattern=Heap*",
+			callStorage:    true,
+			respStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Stated type",
+			query:          "?pattern=Heap*&type=gauge",
+			metricType:     "gauge",
+			callStorage:    true,
+			respStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Pattern not provided",
+			query:          "?type=gauge",
+			respStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Unsupported metric type",
+			query:          "?pattern=Heap*&type=summary",
+			respStatusCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := mocks.NewStorage(t)
+
+			if tt.callStorage {
+				repo.On("Delete", mock.Anything, tt.metricType, "Heap*").Return(0, nil).Once()
+			}
+
+			req := httptest.NewRequest(http.MethodDelete, "/values/"+tt.query, nil)
+			rr := httptest.NewRecorder()
+			deleteMetrics(repo, nil).ServeHTTP(rr, req)
+			require.Equal(t, tt.respStatusCode, rr.Code)
+		})
+	}
+}
+
+// attributingStorage is a storage recording sources of counter increments.
+type attributingStorage struct {
+	*mocks.Storage
+	sources map[string][]models.SourceTotal
+}
+
+func (s attributingStorage) CounterSources(ctx context.Context, metricName string, labels models.Labels) ([]models.SourceTotal, error) {
+	sources, ok := s.sources[models.SeriesKey(metricName, labels)]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return sources, nil
+}
+
+func Test_counterSources(t *testing.T) {
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sources := []models.SourceTotal{{Source: "10.0.0.2", Delta: 900, UpdatedAt: ts}, {Source: "agent-1", Delta: 100, UpdatedAt: ts}}
+	repo := attributingStorage{
+		Storage: mocks.NewStorage(t),
+		sources: map[string][]models.SourceTotal{`PollCount{host="a"}`: sources},
+	}
+
+	tests := []struct {
+		name           string
+		storage        Storage
+		metricName     string
+		query          string
+		respStatusCode int
+	}{
+		{
+			name:           "Success",
+			storage:        repo,
+			metricName:     "PollCount",
+			query:          "?labels=host%3D%22a%22",
+			respStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Not found",
+			storage:        repo,
+			metricName:     "PollCount",
+			respStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "Invalid labels",
+			storage:        repo,
+			metricName:     "PollCount",
+			query:          "?labels=1host%3D%22a%22",
+			respStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Storage behind cache",
+			storage:        cache.New(repo),
+			metricName:     "PollCount",
+			query:          "?labels=host%3D%22a%22",
+			respStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Not supported by storage",
+			storage:        mocks.NewStorage(t),
+			metricName:     "PollCount",
+			respStatusCode: http.StatusNotImplemented,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/sources/counter/"+tt.metricName+tt.query, nil)
+			require.NoError(t, err)
+			req.SetPathValue("metricName", tt.metricName)
+
+			rr := httptest.NewRecorder()
+			counterSources(tt.storage).ServeHTTP(rr, req)
+			require.Equal(t, tt.respStatusCode, rr.Code)
+
+			if tt.respStatusCode == http.StatusOK {
+				var got []models.SourceTotal
+				require.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+				require.Equal(t, sources, got)
+			}
+		})
+	}
+}
+
+func Test_promote(t *testing.T) {
+	follower := replication.New(mocks.NewStorage(t), replication.WithPrimary("primary:8081"))
+
+	tests := []struct {
+		name           string
+		storage        Storage
+		respStatusCode int
+		wantRole       replication.Role
+	}{
+		{
+			name:           "Follower",
+			storage:        follower,
+			respStatusCode: http.StatusOK,
+			wantRole:       replication.RolePrimary,
+		},
+		{
+			name:           "Already primary",
+			storage:        follower,
+			respStatusCode: http.StatusConflict,
+		},
+		{
+			name:           "Not supported by storage",
+			storage:        mocks.NewStorage(t),
+			respStatusCode: http.StatusNotImplemented,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "/replication/promote", nil)
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			promote(tt.storage).ServeHTTP(rr, req)
+			require.Equal(t, tt.respStatusCode, rr.Code)
+
+			if tt.respStatusCode == http.StatusOK {
+				var got replication.Status
+				require.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+				require.Equal(t, tt.wantRole, got.Role)
+			}
+		})
+	}
+}
+
+func Test_replicationStatus(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/replication", nil)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	replicationStatus(replication.New(mocks.NewStorage(t), replication.WithPrimary("primary:8081"))).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var got replication.Status
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+	require.Equal(t, replication.RoleFollower, got.Role)
+	require.Equal(t, "primary:8081", got.Primary)
+
+	rr = httptest.NewRecorder()
+	replicationStatus(mocks.NewStorage(t)).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNotImplemented, rr.Code)
+}
+
+func Test_cacheStats(t *testing.T) {
+	repo := mocks.NewStorage(t)
+	repo.On("Get", mock.Anything, "gauge", "Alloc", models.Labels(nil)).Return(models.Metrics{ID: "Alloc", MType: "gauge", Value: new(float64)}, nil).Once()
+	cached := cache.New(repo)
+	for i := 0; i < 3; i++ {
+		_, err := cached.Get(context.Background(), "gauge", "Alloc", nil)
+		require.NoError(t, err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/cache", nil)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	cacheStats(replication.New(cached)).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var got cache.Stats
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+	require.Equal(t, cache.Stats{Hits: 2, Misses: 1, Size: 1}, got)
+
+	rr = httptest.NewRecorder()
+	cacheStats(repo).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNotImplemented, rr.Code)
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+
+	config "github.com/igortoigildin/go-metrics-altering/config/server"
+	"github.com/igortoigildin/go-metrics-altering/internal/audit"
+	"github.com/igortoigildin/go-metrics-altering/internal/lineprotocol"
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/pkg/logger"
+	processjson "github.com/igortoigildin/go-metrics-altering/pkg/processJSON"
+	"go.uber.org/zap"
+)
+
+// maxLineProtocolSize limits size of line protocol request, decompressed if it is sent gzipped.
+const maxLineProtocolSize = 32 << 20
+
+// lineProtocolErrors is the response to line protocol request with invalid lines.
+type lineProtocolErrors struct {
+	Errors []lineprotocol.LineError `json:"errors"`
+}
+
+// writeLineProtocol stores points sent in InfluxDB line protocol. Every field is a metric named
+// <measurement>_<field> labelled with tags of the point: integer fields are counter deltas, float fields
+// are gauge values, string and boolean fields are skipped. Timestamps are validated, but, like with other
+// protocols, values are recorded at the time they are received. Like /updates/, the request is either saved
+// entirely or rejected with errors of every invalid line.
+func writeLineProtocol(Storage Storage, auditor *audit.Auditor) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		precision, err := lineprotocol.ParsePrecision(r.URL.Query().Get("precision"))
+		if err != nil {
+			logger.Log.Info("error parsing precision parameter", zap.Error(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxLineProtocolSize))
+		if err != nil {
+			logger.Log.Info("cannot read request body", zap.Error(err))
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		points, errs := lineprotocol.Parse(body, precision)
+		metrics, errs := pointMetrics(points, errs)
+		if len(errs) > 0 {
+			slices.SortStableFunc(errs, func(a, b lineprotocol.LineError) int { return a.Line - b.Line })
+			logger.Log.Info("invalid lines in line protocol request", zap.Int("count", len(errs)))
+			if err = processjson.WriteJSON(w, http.StatusBadRequest, lineProtocolErrors{Errors: errs}, nil); err != nil {
+				logger.Log.Info("error encoding response", zap.Error(err))
+			}
+			return
+		}
+		if len(metrics) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if err = Storage.UpdateBatch(r.Context(), metrics); err != nil {
+			logger.Log.Info("error while updating batch, no metrics saved", zap.Error(err))
+			w.WriteHeader(errorStatus(err))
+			return
+		}
+		if auditor.Enabled() {
+			names := make([]string, 0, len(metrics))
+			for _, metric := range metrics {
+				names = append(names, metric.ID)
+			}
+			publish(auditor, r, audit.ActionUpdate, names...)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// pointMetrics maps fields of the points onto metrics in order of the points and field names.
+// Errors of points, which cannot be mapped, are added to errs.
+func pointMetrics(points []lineprotocol.Point, errs []lineprotocol.LineError) ([]models.Metrics, []lineprotocol.LineError) {
+	var metrics []models.Metrics
+	for _, p := range points {
+		labels := models.Labels(p.Tags)
+		if err := labels.Validate(); err != nil {
+			errs = append(errs, lineprotocol.LineError{Line: p.Line, Err: err.Error()})
+			continue
+		}
+
+		for _, field := range slices.Sorted(maps.Keys(p.Fields)) {
+			metric := models.Metrics{ID: p.Measurement + "_" + field, Labels: labels}
+			switch v := p.Fields[field].(type) {
+			case float64:
+				metric.MType, metric.Value = config.GaugeType, &v
+			case int64:
+				metric.MType, metric.Delta = config.CountType, &v
+			case uint64:
+				if v > 1<<63-1 {
+					errs = append(errs, lineprotocol.LineError{Line: p.Line, Err: fmt.Sprintf("value of field %q overflows counter", field)})
+					continue
+				}
+				delta := int64(v)
+				metric.MType, metric.Delta = config.CountType, &delta
+			default:
+				continue
+			}
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics, errs
+}
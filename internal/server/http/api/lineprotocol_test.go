@@ -0,0 +1,114 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/server/http/api/mocks"
+	"github.com/igortoigildin/go-metrics-altering/pkg/middlewares/compress"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeLineProtocol(t *testing.T) {
+	usage := 0.5
+	count := int64(3)
+	labels := models.Labels{"host": "a"}
+
+	tests := []struct {
+		name           string
+		url            string
+		body           string
+		want           []models.Metrics
+		storageErr     error
+		respStatusCode int
+		respBody       string
+	}{
+		{
+			name: "Fields",
+			url:  "/write?precision=s",
+			body: "cpu,host=a usage=0.5,count=3i,msg=\"ok\" 1700000000\n",
+			want: []models.Metrics{
+				{ID: "cpu_count", MType: "counter", Delta: &count, Labels: labels},
+				{ID: "cpu_usage", MType: "gauge", Value: &usage, Labels: labels},
+			},
+			respStatusCode: http.StatusNoContent,
+		},
+		{
+			name:           "Only skipped fields",
+			url:            "/write",
+			body:           "cpu up=true\n",
+			respStatusCode: http.StatusNoContent,
+		},
+		{
+			name:           "Invalid lines",
+			url:            "/write",
+			body:           "cpu,1host=a usage=1\ncpu usage=1\ncpu usage=x\ncpu count=18446744073709551615u\n",
+			respStatusCode: http.StatusBadRequest,
+			respBody: `{"errors":[` +
+				`{"line":1,"error":"invalid label name: \"1host\""},` +
+				`{"line":3,"error":"invalid value of field \"usage\": strconv.ParseFloat: parsing \"x\": invalid syntax"},` +
+				`{"line":4,"error":"value of field \"count\" overflows counter"}]}`,
+		},
+		{
+			name:           "Unknown precision",
+			url:            "/write?precision=d",
+			body:           "cpu usage=1\n",
+			respStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Too large",
+			url:            "/write",
+			body:           "cpu usage=1\n" + strings.Repeat(" ", maxLineProtocolSize),
+			respStatusCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:           "Storage error",
+			url:            "/write",
+			body:           "cpu usage=0.5\n",
+			want:           []models.Metrics{{ID: "cpu_usage", MType: "gauge", Value: &usage, Labels: models.Labels{}}},
+			storageErr:     errors.New("connection refused"),
+			respStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := mocks.NewStorage(t)
+			if tt.want != nil {
+				repo.On("UpdateBatch", mock.Anything, tt.want).Return(tt.storageErr)
+			}
+
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, tt.url, strings.NewReader(tt.body))
+			writeLineProtocol(repo, nil).ServeHTTP(rr, req)
+
+			require.Equal(t, tt.respStatusCode, rr.Code)
+			if tt.respBody != "" {
+				require.JSONEq(t, tt.respBody, rr.Body.String())
+			}
+		})
+	}
+
+	t.Run("Gzip", func(t *testing.T) {
+		repo := mocks.NewStorage(t)
+		repo.On("UpdateBatch", mock.Anything, []models.Metrics{{ID: "cpu_usage", MType: "gauge", Value: &usage, Labels: labels}}).Return(nil)
+
+		var body bytes.Buffer
+		zw := gzip.NewWriter(&body)
+		_, err := zw.Write([]byte("cpu,host=a usage=0.5\n"))
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/write", &body)
+		req.Header.Set("Content-Encoding", "gzip")
+		compress.GzipMiddleware(writeLineProtocol(repo, nil)).ServeHTTP(rr, req)
+		require.Equal(t, http.StatusNoContent, rr.Code)
+	})
+}
@@ -7,6 +7,8 @@ import (
 
 	models "github.com/igortoigildin/go-metrics-altering/internal/models"
 	mock "github.com/stretchr/testify/mock"
+
+	time "time"
 )
 
 // Storage is an autogenerated mock type for the Storage type
@@ -14,9 +16,44 @@ type Storage struct {
 	mock.Mock
 }
 
-// Get provides a mock function with given fields: ctx, metricType, metricName
-func (_m *Storage) Get(ctx context.Context, metricType string, metricName string) (models.Metrics, error) {
-	ret := _m.Called(ctx, metricType, metricName)
+// Delete provides a mock function with given fields: ctx, metricType, pattern, matchers
+func (_m *Storage) Delete(ctx context.Context, metricType string, pattern string, matchers ...*models.LabelMatcher) (int, error) {
+	_va := make([]interface{}, len(matchers))
+	for _i := range matchers {
+		_va[_i] = matchers[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, metricType, pattern)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Delete")
+	}
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, ...*models.LabelMatcher) (int, error)); ok {
+		return rf(ctx, metricType, pattern, matchers...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, ...*models.LabelMatcher) int); ok {
+		r0 = rf(ctx, metricType, pattern, matchers...)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, ...*models.LabelMatcher) error); ok {
+		r1 = rf(ctx, metricType, pattern, matchers...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Get provides a mock function with given fields: ctx, metricType, metricName, labels
+func (_m *Storage) Get(ctx context.Context, metricType string, metricName string, labels models.Labels) (models.Metrics, error) {
+	ret := _m.Called(ctx, metricType, metricName, labels)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Get")
@@ -24,17 +61,17 @@ func (_m *Storage) Get(ctx context.Context, metricType string, metricName string
 
 	var r0 models.Metrics
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, string) (models.Metrics, error)); ok {
-		return rf(ctx, metricType, metricName)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, models.Labels) (models.Metrics, error)); ok {
+		return rf(ctx, metricType, metricName, labels)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, string, string) models.Metrics); ok {
-		r0 = rf(ctx, metricType, metricName)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, models.Labels) models.Metrics); ok {
+		r0 = rf(ctx, metricType, metricName, labels)
 	} else {
 		r0 = ret.Get(0).(models.Metrics)
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
-		r1 = rf(ctx, metricType, metricName)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, models.Labels) error); ok {
+		r1 = rf(ctx, metricType, metricName, labels)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -42,29 +79,29 @@ func (_m *Storage) Get(ctx context.Context, metricType string, metricName string
 	return r0, r1
 }
 
-// GetAll provides a mock function with given fields: ctx
-func (_m *Storage) GetAll(ctx context.Context) (map[string]interface{}, error) {
-	ret := _m.Called(ctx)
+// History provides a mock function with given fields: ctx, metricType, metricName, labels, from, to, step
+func (_m *Storage) History(ctx context.Context, metricType string, metricName string, labels models.Labels, from time.Time, to time.Time, step time.Duration) ([]models.Sample, error) {
+	ret := _m.Called(ctx, metricType, metricName, labels, from, to, step)
 
 	if len(ret) == 0 {
-		panic("no return value specified for GetAll")
+		panic("no return value specified for History")
 	}
 
-	var r0 map[string]interface{}
+	var r0 []models.Sample
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context) (map[string]interface{}, error)); ok {
-		return rf(ctx)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, models.Labels, time.Time, time.Time, time.Duration) ([]models.Sample, error)); ok {
+		return rf(ctx, metricType, metricName, labels, from, to, step)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context) map[string]interface{}); ok {
-		r0 = rf(ctx)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, models.Labels, time.Time, time.Time, time.Duration) []models.Sample); ok {
+		r0 = rf(ctx, metricType, metricName, labels, from, to, step)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(map[string]interface{})
+			r0 = ret.Get(0).([]models.Sample)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
-		r1 = rf(ctx)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, models.Labels, time.Time, time.Time, time.Duration) error); ok {
+		r1 = rf(ctx, metricType, metricName, labels, from, to, step)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -72,6 +109,43 @@ func (_m *Storage) GetAll(ctx context.Context) (map[string]interface{}, error) {
 	return r0, r1
 }
 
+// List provides a mock function with given fields: ctx, filter
+func (_m *Storage) List(ctx context.Context, filter models.ListFilter) ([]models.Metrics, string, error) {
+	ret := _m.Called(ctx, filter)
+
+	if len(ret) == 0 {
+		panic("no return value specified for List")
+	}
+
+	var r0 []models.Metrics
+	var r1 string
+	var r2 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.ListFilter) ([]models.Metrics, string, error)); ok {
+		return rf(ctx, filter)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, models.ListFilter) []models.Metrics); ok {
+		r0 = rf(ctx, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.Metrics)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, models.ListFilter) string); ok {
+		r1 = rf(ctx, filter)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	if rf, ok := ret.Get(2).(func(context.Context, models.ListFilter) error); ok {
+		r2 = rf(ctx, filter)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
 // Ping provides a mock function with given fields: ctx
 func (_m *Storage) Ping(ctx context.Context) error {
 	ret := _m.Called(ctx)
@@ -90,17 +164,70 @@ func (_m *Storage) Ping(ctx context.Context) error {
 	return r0
 }
 
-// Update provides a mock function with given fields: ctx, metricType, metricName, metricValue
-func (_m *Storage) Update(ctx context.Context, metricType string, metricName string, metricValue interface{}) error {
-	ret := _m.Called(ctx, metricType, metricName, metricValue)
+// Reset provides a mock function with given fields: ctx, metricType, pattern, matchers
+func (_m *Storage) Reset(ctx context.Context, metricType string, pattern string, matchers ...*models.LabelMatcher) (int, error) {
+	_va := make([]interface{}, len(matchers))
+	for _i := range matchers {
+		_va[_i] = matchers[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, metricType, pattern)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Reset")
+	}
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, ...*models.LabelMatcher) (int, error)); ok {
+		return rf(ctx, metricType, pattern, matchers...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, ...*models.LabelMatcher) int); ok {
+		r0 = rf(ctx, metricType, pattern, matchers...)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, ...*models.LabelMatcher) error); ok {
+		r1 = rf(ctx, metricType, pattern, matchers...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Update provides a mock function with given fields: ctx, metricType, metricName, labels, metricValue
+func (_m *Storage) Update(ctx context.Context, metricType string, metricName string, labels models.Labels, metricValue interface{}) error {
+	ret := _m.Called(ctx, metricType, metricName, labels, metricValue)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Update")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, string, interface{}) error); ok {
-		r0 = rf(ctx, metricType, metricName, metricValue)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, models.Labels, interface{}) error); ok {
+		r0 = rf(ctx, metricType, metricName, labels, metricValue)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateBatch provides a mock function with given fields: ctx, metrics
+func (_m *Storage) UpdateBatch(ctx context.Context, metrics []models.Metrics) error {
+	ret := _m.Called(ctx, metrics)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateBatch")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, []models.Metrics) error); ok {
+		r0 = rf(ctx, metrics)
 	} else {
 		r0 = ret.Error(0)
 	}
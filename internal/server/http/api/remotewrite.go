@@ -0,0 +1,210 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	config "github.com/igortoigildin/go-metrics-altering/config/server"
+	"github.com/igortoigildin/go-metrics-altering/internal/audit"
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	"github.com/igortoigildin/go-metrics-altering/pkg/logger"
+	"github.com/igortoigildin/go-metrics-altering/pkg/prompb"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxWriteRequestSize limits decompressed size of remote write request, the body read is limited
+// to the largest encoding of that size.
+const maxWriteRequestSize = 32 << 20
+
+// flusher is implemented by storages writing changes in background, such as ingestion queue.
+type flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// writeSeries is series of remote write request mapped onto the metric.
+type writeSeries struct {
+	name    string
+	mType   string
+	labels  models.Labels
+	samples []*prompb.Sample
+}
+
+// remoteWrite stores samples sent by Prometheus remote write. Series are counters if the name ends with _total
+// or metadata of the request says so, all other series are gauges. Prometheus counters are cumulative,
+// so they are stored with deltas bringing the stored value to the sent one. Requests with counters are written
+// one at a time, after changes queued in background are flushed, so that deltas are taken from the values
+// actually stored.
+func remoteWrite(Storage Storage, auditor *audit.Auditor) http.HandlerFunc {
+	var mu sync.Mutex
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := readWriteRequest(w, r)
+		if err != nil {
+			logger.Log.Info("cannot decode remote write request", zap.Error(err))
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// validating the whole request before saving it, so that rejected request is not retried partly saved
+		series, err := writeRequestSeries(req)
+		if err != nil {
+			logger.Log.Info("invalid remote write request", zap.Error(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if hasCounters(series) {
+			mu.Lock()
+			defer mu.Unlock()
+			if f, ok := storage.As[flusher](Storage); ok {
+				if err = f.Flush(r.Context()); err != nil {
+					logger.Log.Info("error while flushing queued changes", zap.Error(err))
+					w.WriteHeader(errorStatus(err))
+					return
+				}
+			}
+		}
+
+		names := make([]string, 0, len(series))
+		for _, s := range series {
+			if err = writeSamples(r.Context(), Storage, s); err != nil {
+				logger.Log.Info("error while saving remote write samples", zap.String("name", s.name), zap.Error(err))
+				w.WriteHeader(errorStatus(err))
+				return
+			}
+			names = append(names, s.name)
+		}
+		if auditor.Enabled() && len(names) > 0 {
+			publish(auditor, r, audit.ActionUpdate, names...)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func readWriteRequest(w http.ResponseWriter, r *http.Request) (*prompb.WriteRequest, error) {
+	compressed, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(snappy.MaxEncodedLen(maxWriteRequestSize))))
+	if err != nil {
+		return nil, err
+	}
+	n, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxWriteRequestSize {
+		return nil, fmt.Errorf("request of %d bytes exceeds %d bytes", n, maxWriteRequestSize)
+	}
+	b, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, err
+	}
+
+	var req prompb.WriteRequest
+	if err = proto.Unmarshal(b, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// writeRequestSeries maps time series of the request onto metrics. Samples are sorted by timestamp,
+// NaN values, which Prometheus uses as staleness markers, and infinite counter values are dropped.
+func writeRequestSeries(req *prompb.WriteRequest) ([]writeSeries, error) {
+	counters := make(map[string]bool)
+	for _, md := range req.GetMetadata() {
+		if md.GetType() == prompb.MetricMetadata_COUNTER {
+			counters[md.GetMetricFamilyName()] = true
+		}
+	}
+
+	res := make([]writeSeries, 0, len(req.GetTimeseries()))
+	for _, ts := range req.GetTimeseries() {
+		s := writeSeries{labels: models.Labels{}}
+		for _, l := range ts.GetLabels() {
+			if l.GetName() == "__name__" {
+				s.name = l.GetValue()
+				continue
+			}
+			s.labels[l.GetName()] = l.GetValue()
+		}
+		if s.name == "" {
+			return nil, errors.New("series without __name__ label")
+		}
+		if err := s.labels.Validate(); err != nil {
+			return nil, err
+		}
+
+		s.mType = config.GaugeType
+		if counters[s.name] || strings.HasSuffix(s.name, "_total") {
+			s.mType = config.CountType
+		}
+		for _, sample := range ts.GetSamples() {
+			v := sample.GetValue()
+			if math.IsNaN(v) || s.mType == config.CountType && math.IsInf(v, 0) {
+				continue
+			}
+			s.samples = append(s.samples, sample)
+		}
+		if len(s.samples) == 0 {
+			continue
+		}
+		sort.SliceStable(s.samples, func(i, j int) bool { return s.samples[i].GetTimestamp() < s.samples[j].GetTimestamp() })
+		res = append(res, s)
+	}
+	return res, nil
+}
+
+func hasCounters(series []writeSeries) bool {
+	for _, s := range series {
+		if s.mType == config.CountType {
+			return true
+		}
+	}
+	return false
+}
+
+// writeSamples updates the metric with every sample of the series.
+func writeSamples(ctx context.Context, Storage Storage, s writeSeries) error {
+	if s.mType == config.GaugeType {
+		for _, sample := range s.samples {
+			if err := Storage.Update(ctx, s.mType, s.name, s.labels, sample.GetValue()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var stored int64
+	metric, err := Storage.Get(ctx, s.mType, s.name, s.labels)
+	found := err == nil
+	switch {
+	case found && metric.Delta != nil:
+		stored = *metric.Delta
+	case !found && !errors.Is(err, storage.ErrNotFound):
+		return err
+	}
+	for _, sample := range s.samples {
+		// counters are stored as integers, fractional part is rounded
+		value := int64(math.Round(sample.GetValue()))
+		if value == stored && found {
+			continue
+		}
+		if err = Storage.Update(ctx, s.mType, s.name, s.labels, value-stored); err != nil {
+			return err
+		}
+		stored, found = value, true
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/server/http/api/mocks"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage/ingest"
+	local "github.com/igortoigildin/go-metrics-altering/internal/storage/inmemory"
+	"github.com/igortoigildin/go-metrics-altering/pkg/prompb"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func writeRequestBody(t *testing.T, req *prompb.WriteRequest) *bytes.Reader {
+	b, err := proto.Marshal(req)
+	require.NoError(t, err)
+	return bytes.NewReader(snappy.Encode(nil, b))
+}
+
+func series(name string, labels map[string]string, samples ...*prompb.Sample) *prompb.TimeSeries {
+	ts := &prompb.TimeSeries{Labels: []*prompb.Label{{Name: "__name__", Value: name}}, Samples: samples}
+	for k, v := range labels {
+		ts.Labels = append(ts.Labels, &prompb.Label{Name: k, Value: v})
+	}
+	return ts
+}
+
+func Test_remoteWrite(t *testing.T) {
+	labels := models.Labels{"job": "node"}
+	stored := int64(10)
+
+	repo := mocks.NewStorage(t)
+	// samples of the gauge are written in order of timestamps, the stale marker is dropped
+	repo.On("Update", mock.Anything, "gauge", "load", labels, 0.5).Return(nil).Once()
+	repo.On("Update", mock.Anything, "gauge", "load", labels, 0.7).Return(nil).Once()
+	// counters are brought to the sent value
+	repo.On("Get", mock.Anything, "counter", "requests_total", labels).
+		Return(models.Metrics{ID: "requests_total", MType: "counter", Delta: &stored}, nil)
+	repo.On("Update", mock.Anything, "counter", "requests_total", labels, int64(5)).Return(nil).Once()
+	repo.On("Update", mock.Anything, "counter", "requests_total", labels, int64(-13)).Return(nil).Once()
+	// metadata marks counter without the suffix, the new one is created with zero value
+	repo.On("Get", mock.Anything, "counter", "errors", models.Labels{}).Return(models.Metrics{}, storage.ErrNotFound)
+	repo.On("Update", mock.Anything, "counter", "errors", models.Labels{}, int64(0)).Return(nil).Once()
+
+	body := writeRequestBody(t, &prompb.WriteRequest{
+		Timeseries: []*prompb.TimeSeries{
+			series("load", labels, &prompb.Sample{Value: 0.7, Timestamp: 2000}, &prompb.Sample{Value: 0.5, Timestamp: 1000},
+				&prompb.Sample{Value: math.NaN(), Timestamp: 3000}),
+			// the same value is not written again, the lower one means reset
+			series("requests_total", labels, &prompb.Sample{Value: 15, Timestamp: 1000},
+				&prompb.Sample{Value: 15, Timestamp: 2000}, &prompb.Sample{Value: 2, Timestamp: 3000}),
+			series("errors", nil, &prompb.Sample{Value: 0, Timestamp: 1000}),
+		},
+		Metadata: []*prompb.MetricMetadata{{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "errors"}},
+	})
+
+	rr := httptest.NewRecorder()
+	remoteWrite(repo, nil).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/write", body))
+	require.Equal(t, http.StatusNoContent, rr.Code)
+}
+
+func Test_remoteWriteQueued(t *testing.T) {
+	ctx := context.Background()
+	st := local.New()
+	require.NoError(t, st.Update(ctx, "counter", "requests_total", nil, int64(10)))
+	queue := ingest.New(st, ingest.WithInterval(time.Hour))
+	t.Cleanup(func() { queue.Close() })
+
+	// deltas of the earlier request are still queued when the later one reads the counter
+	handler := remoteWrite(queue, nil)
+	for _, v := range []float64{15, 20} {
+		body := writeRequestBody(t, &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{
+			series("requests_total", nil, &prompb.Sample{Value: v, Timestamp: 1000}),
+		}})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/write", body))
+		require.Equal(t, http.StatusNoContent, rr.Code)
+	}
+
+	require.NoError(t, queue.Flush(ctx))
+	metric, err := st.Get(ctx, "counter", "requests_total", models.Labels{})
+	require.NoError(t, err)
+	require.Equal(t, int64(20), *metric.Delta)
+}
+
+func Test_remoteWriteErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           func(t *testing.T) *bytes.Reader
+		storage        func(t *testing.T) *mocks.Storage // storage expecting no calls if nil
+		respStatusCode int
+	}{
+		{
+			name: "Not compressed",
+			body: func(t *testing.T) *bytes.Reader {
+				return bytes.NewReader([]byte("load 0.5"))
+			},
+			respStatusCode: http.StatusBadRequest,
+		},
+		{
+			name: "Too large",
+			body: func(t *testing.T) *bytes.Reader {
+				return bytes.NewReader(make([]byte, snappy.MaxEncodedLen(maxWriteRequestSize)+1))
+			},
+			respStatusCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "Series without name",
+			body: func(t *testing.T) *bytes.Reader {
+				return writeRequestBody(t, &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{
+					{Labels: []*prompb.Label{{Name: "job", Value: "node"}}, Samples: []*prompb.Sample{{Value: 1}}},
+				}})
+			},
+			respStatusCode: http.StatusBadRequest,
+		},
+		{
+			name: "Invalid label",
+			body: func(t *testing.T) *bytes.Reader {
+				return writeRequestBody(t, &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{
+					series("load", nil, &prompb.Sample{Value: 1}),
+					series("load", map[string]string{"1job": "node"}, &prompb.Sample{Value: 1}),
+				}})
+			},
+			respStatusCode: http.StatusBadRequest,
+		},
+		{
+			name: "Type conflict",
+			body: func(t *testing.T) *bytes.Reader {
+				return writeRequestBody(t, &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{
+					series("load", nil, &prompb.Sample{Value: 1}),
+				}})
+			},
+			storage: func(t *testing.T) *mocks.Storage {
+				repo := mocks.NewStorage(t)
+				repo.On("Update", mock.Anything, "gauge", "load", models.Labels{}, float64(1)).Return(storage.ErrTypeConflict)
+				return repo
+			},
+			respStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "Storage error",
+			body: func(t *testing.T) *bytes.Reader {
+				return writeRequestBody(t, &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{
+					series("requests_total", nil, &prompb.Sample{Value: 1}),
+				}})
+			},
+			storage: func(t *testing.T) *mocks.Storage {
+				repo := mocks.NewStorage(t)
+				repo.On("Get", mock.Anything, "counter", "requests_total", models.Labels{}).
+					Return(models.Metrics{}, errors.New("connection refused"))
+				return repo
+			},
+			respStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/write", tt.body(t))
+			repo := mocks.NewStorage(t)
+			if tt.storage != nil {
+				repo = tt.storage(t)
+			}
+			remoteWrite(repo, nil).ServeHTTP(rr, req)
+			require.Equal(t, tt.respStatusCode, rr.Code)
+		})
+	}
+}
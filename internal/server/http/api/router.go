@@ -6,26 +6,40 @@ import (
 	"text/template"
 
 	config "github.com/igortoigildin/go-metrics-altering/config/server"
+	"github.com/igortoigildin/go-metrics-altering/internal/audit"
 	"github.com/igortoigildin/go-metrics-altering/pkg/middlewares/auth"
 	"github.com/igortoigildin/go-metrics-altering/pkg/middlewares/compress"
 	"github.com/igortoigildin/go-metrics-altering/pkg/middlewares/logging"
+	"github.com/igortoigildin/go-metrics-altering/pkg/middlewares/source"
 	"github.com/igortoigildin/go-metrics-altering/pkg/middlewares/timeout"
 	"github.com/igortoigildin/go-metrics-altering/templates"
 )
 
 var t *template.Template
 
-func Router(ctx context.Context, cfg *config.ConfigServer, storage Storage) *http.ServeMux {
+// Router returns handlers of the API. Updates, deletes and resets are published to the auditor, nil one discards them.
+func Router(ctx context.Context, cfg *config.ConfigServer, storage Storage, auditor *audit.Auditor) *http.ServeMux {
 	t = templates.ParseTemplate()
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /value/{metricType}/{metricName}", logging.WithLogging(compress.GzipMiddleware((auth.Auth(http.HandlerFunc(valuePathHandler(storage)), cfg)))))
-	mux.HandleFunc("POST /update/{metricType}/{metricName}/{metricValue}", logging.WithLogging(compress.GzipMiddleware(auth.Auth(http.HandlerFunc(updatePathHandler(storage)), cfg))))
+	mux.HandleFunc("POST /update/{metricType}/{metricName}/{metricValue}", logging.WithLogging(compress.GzipMiddleware(auth.Auth(source.WithSource(updatePathHandler(storage, auditor)), cfg))))
 	mux.HandleFunc("GET /ping", timeout.Timeout(cfg.ContextTimout, logging.WithLogging(compress.GzipMiddleware(auth.Auth(http.HandlerFunc(ping(storage)), cfg)))))
 	mux.HandleFunc("GET /", timeout.Timeout(cfg.ContextTimout, logging.WithLogging(compress.GzipMiddleware(auth.Auth(http.HandlerFunc(getAllmetrics(storage)), cfg)))))
-	mux.HandleFunc("POST /updates/", timeout.Timeout(cfg.ContextTimout, logging.WithLogging(compress.GzipMiddleware(auth.Auth(http.HandlerFunc(updates(storage)), cfg)))))
+	mux.HandleFunc("GET /metrics", timeout.Timeout(cfg.ContextTimout, logging.WithLogging(compress.GzipMiddleware(auth.Auth(http.HandlerFunc(exposition(storage)), cfg)))))
+	mux.HandleFunc("POST /updates/", timeout.Timeout(cfg.ContextTimout, logging.WithLogging(compress.GzipMiddleware(auth.Auth(source.WithSource(updates(storage, auditor)), cfg)))))
+	mux.HandleFunc("POST /api/v1/write", timeout.Timeout(cfg.ContextTimout, logging.WithLogging(compress.GzipMiddleware(auth.Auth(source.WithSource(remoteWrite(storage, auditor)), cfg)))))
+	mux.HandleFunc("POST /write", timeout.Timeout(cfg.ContextTimout, logging.WithLogging(compress.GzipMiddleware(auth.Auth(source.WithSource(writeLineProtocol(storage, auditor)), cfg)))))
 	mux.HandleFunc("POST /value/", timeout.Timeout(cfg.ContextTimout, logging.WithLogging(compress.GzipMiddleware(auth.Auth(http.HandlerFunc(getMetric(storage)), cfg)))))
-	mux.HandleFunc("POST /update/", timeout.Timeout(cfg.ContextTimout, logging.WithLogging(compress.GzipMiddleware(auth.Auth(http.HandlerFunc(updateMetric(storage)), cfg)))))
+	mux.HandleFunc("GET /history/{metricType}/{metricName}", timeout.Timeout(cfg.ContextTimout, logging.WithLogging(compress.GzipMiddleware(auth.Auth(http.HandlerFunc(history(storage)), cfg)))))
+	mux.HandleFunc("GET /sources/counter/{metricName}", timeout.Timeout(cfg.ContextTimout, logging.WithLogging(compress.GzipMiddleware(auth.Auth(http.HandlerFunc(counterSources(storage)), cfg)))))
+	mux.HandleFunc("GET /cache", timeout.Timeout(cfg.ContextTimout, logging.WithLogging(compress.GzipMiddleware(auth.Auth(http.HandlerFunc(cacheStats(storage)), cfg)))))
+	mux.HandleFunc("GET /replication", timeout.Timeout(cfg.ContextTimout, logging.WithLogging(compress.GzipMiddleware(auth.Auth(http.HandlerFunc(replicationStatus(storage)), cfg)))))
+	mux.HandleFunc("POST /replication/promote", timeout.Timeout(cfg.ContextTimout, logging.WithLogging(compress.GzipMiddleware(auth.Auth(http.HandlerFunc(promote(storage)), cfg)))))
+	mux.HandleFunc("DELETE /value/{metricType}/{metricName}", timeout.Timeout(cfg.ContextTimout, logging.WithLogging(compress.GzipMiddleware(auth.Auth(http.HandlerFunc(deleteMetric(storage, auditor)), cfg)))))
+	mux.HandleFunc("POST /reset/{metricType}/{metricName}", timeout.Timeout(cfg.ContextTimout, logging.WithLogging(compress.GzipMiddleware(auth.Auth(http.HandlerFunc(resetMetric(storage, auditor)), cfg)))))
+	mux.HandleFunc("DELETE /values/", timeout.Timeout(cfg.ContextTimout, logging.WithLogging(compress.GzipMiddleware(auth.Auth(http.HandlerFunc(deleteMetrics(storage, auditor)), cfg)))))
+	mux.HandleFunc("POST /update/", timeout.Timeout(cfg.ContextTimout, logging.WithLogging(compress.GzipMiddleware(auth.Auth(source.WithSource(updateMetric(storage, auditor)), cfg)))))
 
 	return mux
 }
@@ -0,0 +1,123 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	config "github.com/igortoigildin/go-metrics-altering/config/server"
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+)
+
+// gauge is the value of gauge received over the flush interval.
+type gauge struct {
+	value float64
+	// set is false if the gauge was only changed relatively, then value is the change of the stored one
+	set bool
+}
+
+// aggregator accumulates samples received over the flush interval.
+type aggregator struct {
+	mu       sync.Mutex
+	counters map[string]float64
+	gauges   map[string]*gauge
+	timers   map[string]*models.Histogram
+}
+
+func newAggregator() *aggregator {
+	return &aggregator{
+		counters: map[string]float64{},
+		gauges:   map[string]*gauge{},
+		timers:   map[string]*models.Histogram{},
+	}
+}
+
+// add accounts the sample. Counter increments and timer observations are scaled up by the sample rate.
+func (a *aggregator) add(s sample) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch s.kind {
+	case typeCounter:
+		a.counters[s.name] += s.value / s.rate
+	case typeGauge:
+		g, ok := a.gauges[s.name]
+		if !ok {
+			g = &gauge{}
+			a.gauges[s.name] = g
+		}
+		if s.relative {
+			g.value += s.value
+		} else {
+			g.value, g.set = s.value, true
+		}
+	case typeTimer:
+		h, ok := a.timers[s.name]
+		if !ok {
+			h = models.NewHistogram(models.DefaultBuckets)
+			a.timers[s.name] = h
+		}
+		// timers are in milliseconds, buckets are in seconds
+		v, n := s.value/1000, uint64(max(1, math.Round(1/s.rate)))
+		h.Counts[sort.SearchFloat64s(h.Bounds, v)] += n
+		h.Sum += v * float64(n)
+		h.Count += n
+	}
+}
+
+// take returns metrics accumulated since the previous call. Counters are written in whole increments,
+// the rest of the sum is carried to the next call, so that increments smaller than one, e.g. scaled
+// by sample rate, add up. Gauges changed only relatively are applied to values read from the storage,
+// ones which could not be read are dropped and their errors are returned.
+func (a *aggregator) take(ctx context.Context, s storage.Storage) ([]models.Metrics, error) {
+	a.mu.Lock()
+	counters, gauges, timers := a.counters, a.gauges, a.timers
+	a.counters, a.gauges, a.timers = map[string]float64{}, map[string]*gauge{}, map[string]*models.Histogram{}
+
+	deltas := make(map[string]int64, len(counters))
+	for name, sum := range counters {
+		delta := math.Round(sum)
+		if rest := sum - delta; rest != 0 {
+			a.counters[name] = rest
+		}
+		if delta != 0 {
+			deltas[name] = int64(delta)
+		}
+	}
+	a.mu.Unlock()
+
+	var errs []error
+	metrics := make([]models.Metrics, 0, len(deltas)+len(gauges)+len(timers))
+	for name, delta := range deltas {
+		metrics = append(metrics, models.Metrics{ID: name, MType: config.CountType, Delta: &delta})
+	}
+	for name, g := range gauges {
+		value := g.value
+		if !g.set {
+			stored, err := s.Get(ctx, config.GaugeType, name, nil)
+			switch {
+			case err == nil && stored.Value != nil:
+				value += *stored.Value
+			case err != nil && !errors.Is(err, storage.ErrNotFound):
+				errs = append(errs, fmt.Errorf("could not read gauge %q: %w", name, err))
+				continue
+			}
+		}
+		metrics = append(metrics, models.Metrics{ID: name, MType: config.GaugeType, Value: &value})
+	}
+	for name, h := range timers {
+		metrics = append(metrics, models.Metrics{ID: name, MType: config.HistogramType, Histogram: h})
+	}
+
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].ID != metrics[j].ID {
+			return metrics[i].ID < metrics[j].ID
+		}
+		return metrics[i].MType < metrics[j].MType
+	})
+	return metrics, errors.Join(errs...)
+}
@@ -0,0 +1,62 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Types of StatsD metrics.
+const (
+	typeCounter = "c"
+	typeGauge   = "g"
+	typeTimer   = "ms"
+)
+
+// sample is a parsed line of StatsD protocol.
+type sample struct {
+	name  string
+	kind  string
+	value float64
+	// relative is set for gauge changed by the value rather than set to it
+	relative bool
+	rate     float64 // share of the values sent by the client
+}
+
+// parseLine parses line of form <name>:<value>|<type>[|@<rate>]. Gauge value with explicit sign is relative.
+func parseLine(line string) (sample, error) {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return sample{}, errors.New("missing metric name")
+	}
+
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 || len(parts) > 3 {
+		return sample{}, fmt.Errorf("invalid metric %q", line)
+	}
+	s := sample{name: name, kind: parts[1], rate: 1}
+	if s.kind != typeCounter && s.kind != typeGauge && s.kind != typeTimer {
+		return sample{}, fmt.Errorf("unsupported type %q of %q", s.kind, name)
+	}
+
+	v, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return sample{}, fmt.Errorf("invalid value %q of %q", parts[0], name)
+	}
+	s.value = v
+	s.relative = s.kind == typeGauge && (parts[0][0] == '+' || parts[0][0] == '-')
+
+	if len(parts) == 3 {
+		rate, ok := strings.CutPrefix(parts[2], "@")
+		if !ok {
+			return sample{}, fmt.Errorf("invalid sample rate %q of %q", parts[2], name)
+		}
+		s.rate, err = strconv.ParseFloat(rate, 64)
+		if err != nil || s.rate <= 0 || s.rate > 1 {
+			return sample{}, fmt.Errorf("invalid sample rate %q of %q", parts[2], name)
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,43 @@
+package statsd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    sample
+		wantErr bool
+	}{
+		{name: "Counter", line: "app.requests:3|c", want: sample{name: "app.requests", kind: "c", value: 3, rate: 1}},
+		{name: "Sampled counter", line: "app.requests:1|c|@0.1", want: sample{name: "app.requests", kind: "c", value: 1, rate: 0.1}},
+		{name: "Negative counter", line: "app.requests:-2|c", want: sample{name: "app.requests", kind: "c", value: -2, rate: 1}},
+		{name: "Gauge", line: "app.load:0.5|g", want: sample{name: "app.load", kind: "g", value: 0.5, rate: 1}},
+		{name: "Gauge increment", line: "app.load:+2|g", want: sample{name: "app.load", kind: "g", value: 2, relative: true, rate: 1}},
+		{name: "Gauge decrement", line: "app.load:-2|g", want: sample{name: "app.load", kind: "g", value: -2, relative: true, rate: 1}},
+		{name: "Timer", line: "app.latency:320|ms|@0.5", want: sample{name: "app.latency", kind: "ms", value: 320, rate: 0.5}},
+		{name: "Missing name", line: ":1|c", wantErr: true},
+		{name: "Missing type", line: "app.requests:1", wantErr: true},
+		{name: "Unsupported type", line: "app.users:1|s", wantErr: true},
+		{name: "Invalid value", line: "app.requests:one|c", wantErr: true},
+		{name: "Not finite value", line: "app.load:+Inf|g", wantErr: true},
+		{name: "Invalid rate", line: "app.requests:1|c|0.1", wantErr: true},
+		{name: "Rate out of range", line: "app.requests:1|c|@2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLine(tt.line)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,227 @@
+// Package statsd provides listener of StatsD protocol writing aggregated metrics into storage.
+package statsd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	"github.com/igortoigildin/go-metrics-altering/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultInterval = 10 * time.Second
+	maxPacketSize   = 65535
+)
+
+// Server listens to StatsD metrics over UDP and TCP on the same port. Counters, gauges and timers received
+// over the flush interval are aggregated and written into the storage at its end: counters as sums
+// of increments, gauges as the last values and timers as histograms of durations in seconds.
+type Server struct {
+	addr     string
+	interval time.Duration
+	storage  storage.Storage
+	agg      *aggregator
+
+	udp   net.PacketConn
+	tcp   net.Listener
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// Option configures Server.
+type Option func(*Server)
+
+// WithInterval sets how often aggregated metrics are written into the storage.
+func WithInterval(interval time.Duration) Option {
+	return func(s *Server) {
+		if interval > 0 {
+			s.interval = interval
+		}
+	}
+}
+
+// New returns server listening at the address and writing metrics into the storage.
+func New(addr string, st storage.Storage, opts ...Option) *Server {
+	s := &Server{
+		addr:     addr,
+		interval: defaultInterval,
+		storage:  st,
+		agg:      newAggregator(),
+		conns:    map[net.Conn]struct{}{},
+		stop:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Start starts listening and flushing in background.
+func (s *Server) Start() error {
+	const op = "statsd.Start"
+
+	udp, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	// TCP listens on the port UDP got, so that it is the same if the address has no port
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	s.udp, s.tcp = udp, tcp
+
+	logger.Log.Info("statsd server is running:", zap.String("addr", udp.LocalAddr().String()))
+
+	s.wg.Add(3)
+	go s.serveUDP()
+	go s.serveTCP()
+	go s.flushLoop()
+	return nil
+}
+
+// Addr returns address the server listens at.
+func (s *Server) Addr() net.Addr {
+	return s.udp.LocalAddr()
+}
+
+// Shutdown stops listening, closes connections and writes metrics aggregated so far.
+func (s *Server) Shutdown(ctx context.Context) error {
+	close(s.stop)
+	s.udp.Close()
+	s.tcp.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+
+	return s.Flush(ctx)
+}
+
+// Flush writes metrics aggregated since the previous flush into the storage.
+func (s *Server) Flush(ctx context.Context) error {
+	metrics, err := s.agg.take(ctx, s.storage)
+	errs := []error{err}
+	for _, metric := range metrics {
+		var value any
+		switch {
+		case metric.Delta != nil:
+			value = *metric.Delta
+		case metric.Value != nil:
+			value = *metric.Value
+		default:
+			value = metric.Histogram
+		}
+		// metrics are written one by one, so that the one rejected by the storage does not affect others
+		if err := s.storage.Update(ctx, metric.MType, metric.ID, nil, value); err != nil {
+			errs = append(errs, fmt.Errorf("could not write %s %q: %w", metric.MType, metric.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Server) flushLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.Flush(context.Background()); err != nil {
+				logger.Log.Error("failed to write statsd metrics", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Log.Error("failed to read statsd packet", zap.Error(err))
+			}
+			return
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			s.handleLine(line)
+		}
+	}
+}
+
+func (s *Server) serveTCP() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Log.Error("failed to accept statsd connection", zap.Error(err))
+			}
+			return
+		}
+
+		s.mu.Lock()
+		select {
+		case <-s.stop:
+			s.mu.Unlock()
+			conn.Close()
+			return
+		default:
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn reads newline separated metrics until the connection is closed.
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	sc := bufio.NewScanner(conn)
+	sc.Buffer(make([]byte, 4096), maxPacketSize)
+	for sc.Scan() {
+		s.handleLine(sc.Text())
+	}
+}
+
+func (s *Server) handleLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	sample, err := parseLine(line)
+	if err != nil {
+		logger.Log.Info("invalid statsd metric", zap.Error(err))
+		return
+	}
+	s.agg.add(sample)
+}
@@ -0,0 +1,134 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	local "github.com/igortoigildin/go-metrics-altering/internal/storage/inmemory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get(t *testing.T, s storage.Storage, metricType string, metricName string) models.Metrics {
+	metric, err := s.Get(context.Background(), metricType, metricName, nil)
+	require.NoError(t, err)
+	return metric
+}
+
+func TestServer_Flush(t *testing.T) {
+	ctx := context.Background()
+	st := local.New()
+	require.NoError(t, st.Update(ctx, "gauge", "app.load", nil, float64(5)))
+	require.NoError(t, st.Update(ctx, "counter", "app.requests", nil, int64(10)))
+	s := New(":0", st)
+
+	for _, line := range []string{
+		"app.requests:2|c",
+		"app.requests:1|c|@0.5",
+		// relative changes of the stored gauge
+		"app.load:+2|g",
+		"app.load:-0.5|g",
+		// the last value is kept, relative changes after it are applied to it
+		"app.temp:20|g",
+		"app.temp:25|g",
+		"app.temp:+1|g",
+		"app.new:+3|g",
+		"app.latency:20|ms",
+		"app.latency:300|ms|@0.5",
+	} {
+		s.handleLine(line)
+	}
+	require.NoError(t, s.Flush(ctx))
+
+	assert.Equal(t, int64(14), *get(t, st, "counter", "app.requests").Delta)
+	assert.Equal(t, 6.5, *get(t, st, "gauge", "app.load").Value)
+	assert.Equal(t, float64(26), *get(t, st, "gauge", "app.temp").Value)
+	assert.Equal(t, float64(3), *get(t, st, "gauge", "app.new").Value)
+
+	h := get(t, st, "histogram", "app.latency").Histogram
+	require.NotNil(t, h)
+	assert.Equal(t, uint64(3), h.Count)
+	assert.InDelta(t, 0.62, h.Sum, 1e-9)
+	assert.Equal(t, uint64(1), h.Counts[2]) // 0.025
+	assert.Equal(t, uint64(2), h.Counts[6]) // 0.5
+
+	// aggregates are written once
+	require.NoError(t, s.Flush(ctx))
+	assert.Equal(t, int64(14), *get(t, st, "counter", "app.requests").Delta)
+}
+
+// rejectingStorage rejects updates of the metric.
+type rejectingStorage struct {
+	storage.Storage
+	name string
+}
+
+func (s *rejectingStorage) Update(ctx context.Context, metricType string, metricName string, labels models.Labels, metricValue any) error {
+	if metricName == s.name {
+		return storage.ErrTypeConflict
+	}
+	return s.Storage.Update(ctx, metricType, metricName, labels, metricValue)
+}
+
+func TestServer_FlushFraction(t *testing.T) {
+	ctx := context.Background()
+	st := local.New()
+	s := New(":0", st)
+
+	// increments below one are carried over flushes until they add up
+	for range 3 {
+		s.handleLine("app.requests:0.4|c")
+		require.NoError(t, s.Flush(ctx))
+	}
+	assert.Equal(t, int64(1), *get(t, st, "counter", "app.requests").Delta)
+
+	for range 2 {
+		s.handleLine("app.requests:0.4|c")
+		require.NoError(t, s.Flush(ctx))
+	}
+	assert.Equal(t, int64(2), *get(t, st, "counter", "app.requests").Delta)
+}
+
+func TestServer_rejectedMetric(t *testing.T) {
+	st := &rejectingStorage{Storage: local.New(), name: "app.latency"}
+	s := New(":0", st)
+
+	s.handleLine("app.latency:1|ms")
+	s.handleLine("app.requests:1|c")
+	require.ErrorIs(t, s.Flush(context.Background()), storage.ErrTypeConflict)
+	// rejected metric does not prevent writing others
+	assert.Equal(t, int64(1), *get(t, st, "counter", "app.requests").Delta)
+}
+
+func TestServer_listen(t *testing.T) {
+	st := local.New()
+	s := New("127.0.0.1:0", st, WithInterval(time.Hour))
+	require.NoError(t, s.Start())
+
+	udp, err := net.Dial("udp", s.Addr().String())
+	require.NoError(t, err)
+	defer udp.Close()
+	_, err = udp.Write([]byte("app.requests:1|c\napp.load:1.5|g\ninvalid\n"))
+	require.NoError(t, err)
+
+	tcp, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer tcp.Close()
+	_, err = tcp.Write([]byte("app.requests:2|c\n"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		s.agg.mu.Lock()
+		defer s.agg.mu.Unlock()
+		return s.agg.counters["app.requests"] == 3 && s.agg.gauges["app.load"] != nil
+	}, time.Second, 10*time.Millisecond)
+
+	// metrics aggregated so far are written on shutdown
+	require.NoError(t, s.Shutdown(context.Background()))
+	assert.Equal(t, int64(3), *get(t, st, "counter", "app.requests").Delta)
+	assert.Equal(t, 1.5, *get(t, st, "gauge", "app.load").Value)
+}
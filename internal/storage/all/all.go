@@ -0,0 +1,8 @@
+// Package all registers all storage drivers, it is imported for side effects only.
+package all
+
+import (
+	_ "github.com/igortoigildin/go-metrics-altering/internal/storage/boltdb"
+	_ "github.com/igortoigildin/go-metrics-altering/internal/storage/inmemory"
+	_ "github.com/igortoigildin/go-metrics-altering/internal/storage/postgres"
+)
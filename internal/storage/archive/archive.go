@@ -0,0 +1,91 @@
+// Package archive moves metrics between storage backends. Archive is JSON lines,
+// one series per line in the models.Metrics format, which snapshots of in-memory storage are made of.
+package archive
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+)
+
+const (
+	pageSize  = 1000 // series listed at once while exporting
+	batchSize = 500  // series updated at once while importing
+)
+
+// Export writes all series of the storage to w and returns their number. History is not exported.
+func Export(ctx context.Context, s storage.Storage, w io.Writer) (int, error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	var n int
+	filter := models.ListFilter{Limit: pageSize}
+	for {
+		page, next, err := s.List(ctx, filter)
+		if err != nil {
+			return n, fmt.Errorf("could not list metrics: %w", err)
+		}
+		for _, metric := range page {
+			if err = enc.Encode(metric); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if next == "" {
+			break
+		}
+		filter.Cursor = next
+	}
+	return n, bw.Flush()
+}
+
+// Import loads series read from r into the storage and returns their number. Gauges are overwritten,
+// counters and histograms are added to the existing values, so importing into empty storage restores them as is.
+func Import(ctx context.Context, s storage.Storage, r io.Reader) (int, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	var n int
+	batch := make([]models.Metrics, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := s.UpdateBatch(ctx, batch); err != nil {
+			return fmt.Errorf("could not import series %d-%d: %w", n-len(batch)+1, n, err)
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		var metric models.Metrics
+		err := dec.Decode(&metric)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return n - len(batch), fmt.Errorf("could not decode series %d: %w", n+1, err)
+		}
+		if metric.ID == "" || metric.MType == "" {
+			return n - len(batch), fmt.Errorf("series %d: name and type are required", n+1)
+		}
+
+		batch = append(batch, metric)
+		n++
+		if len(batch) == batchSize {
+			if err = flush(); err != nil {
+				return n - len(batch), err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return n - len(batch), err
+	}
+	return n, nil
+}
@@ -0,0 +1,98 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage/boltdb"
+	local "github.com/igortoigildin/go-metrics-altering/internal/storage/inmemory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func list(t *testing.T, s storage.Storage) []models.Metrics {
+	metrics, _, err := s.List(context.Background(), models.ListFilter{})
+	require.NoError(t, err)
+	return metrics
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	src := local.New()
+	require.NoError(t, src.Update(ctx, "gauge", "Alloc", models.Labels{"host": "a"}, 1.5))
+	require.NoError(t, src.Update(ctx, "counter", "requests", nil, int64(7)))
+	require.NoError(t, src.Update(ctx, "histogram", "latency", nil, &models.Histogram{
+		Bounds: []float64{0.1, 1}, Counts: []uint64{1, 0, 2}, Sum: 4.05, Count: 3,
+	}))
+
+	var buf bytes.Buffer
+	n, err := Export(ctx, src, &buf)
+	require.NoError(t, err)
+	// PollCount is created by in-memory storage
+	assert.Equal(t, 4, n)
+	assert.Equal(t, 4, strings.Count(buf.String(), "\n"))
+	assert.Contains(t, buf.String(), `{"id":"Alloc","type":"gauge","value":1.5,"labels":{"host":"a"}}`)
+
+	dst, err := boltdb.New(filepath.Join(t.TempDir(), "metrics.db"))
+	require.NoError(t, err)
+	n, err = Import(ctx, dst, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, list(t, src), list(t, dst))
+}
+
+func TestImport_batches(t *testing.T) {
+	var buf bytes.Buffer
+	for i := 0; i < batchSize*2+1; i++ {
+		buf.WriteString(`{"id":"requests","type":"counter","delta":1}` + "\n")
+	}
+
+	s := local.New()
+	n, err := Import(context.Background(), s, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, batchSize*2+1, n)
+
+	metric, err := s.Get(context.Background(), "counter", "requests", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(batchSize*2+1), *metric.Delta)
+}
+
+func TestImport_invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive string
+		want    int
+		wantErr string
+	}{
+		{
+			name:    "Malformed line",
+			archive: `{"id":"Alloc","type":"gauge","value":1}` + "\n" + `{"id":` + "\n",
+			want:    0,
+			wantErr: "could not decode series 2",
+		},
+		{
+			name:    "Missing type",
+			archive: `{"id":"Alloc","value":1}`,
+			want:    0,
+			wantErr: "series 1: name and type are required",
+		},
+		{
+			name:    "Rejected by storage",
+			archive: `{"id":"Alloc","type":"gauge"}`,
+			want:    0,
+			wantErr: "could not import series 1-1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := Import(context.Background(), local.New(), strings.NewReader(tt.archive))
+			assert.ErrorContains(t, err, tt.wantErr)
+			assert.Equal(t, tt.want, n)
+		})
+	}
+}
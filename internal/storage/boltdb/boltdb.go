@@ -0,0 +1,429 @@
+// Package boltdb implements Storage interface on top of embedded single-file bbolt database.
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	"github.com/igortoigildin/go-metrics-altering/pkg/logger"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+const (
+	GaugeType     = "gauge"
+	CountType     = "counter"
+	HistogramType = "histogram"
+
+	defaultRetention = time.Hour
+	openTimeout      = time.Second
+)
+
+// bucket describes where series of a metric type and their history are stored.
+// Series are kept by series key, history of every series is a nested bucket of samples bucket.
+type bucket struct {
+	metricType string
+	name       []byte
+	samples    []byte // nil if history is not recorded
+}
+
+var buckets = []bucket{
+	{metricType: GaugeType, name: []byte("gauges"), samples: []byte("gauge_samples")},
+	{metricType: CountType, name: []byte("counters"), samples: []byte("counter_samples")},
+	{metricType: HistogramType, name: []byte("histograms")},
+}
+
+type BoltStorage struct {
+	db        *bolt.DB
+	retention time.Duration
+}
+
+// Option configures BoltStorage.
+type Option func(*BoltStorage)
+
+// WithRetention sets how long metric samples are kept in history.
+func WithRetention(retention time.Duration) Option {
+	return func(b *BoltStorage) {
+		if retention > 0 {
+			b.retention = retention
+		}
+	}
+}
+
+// New opens the database file, creating it if needed. Every change is committed to the file
+// before it is acknowledged, so no periodic dumps are required.
+func New(path string, opts ...Option) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(b.name); err != nil {
+				return err
+			}
+			if b.samples == nil {
+				continue
+			}
+			if _, err := tx.CreateBucketIfNotExists(b.samples); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &BoltStorage{db: db, retention: defaultRetention}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
+}
+
+// Close releases the database file.
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltStorage) Ping(ctx context.Context) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(buckets[0].name) == nil {
+			return errors.New("database is not initialized")
+		}
+		return nil
+	})
+}
+
+func (s *BoltStorage) Update(ctx context.Context, metricType string, metricName string, labels models.Labels, metricValue any) error {
+	metric, err := models.NewMetric(metricType, metricName, labels, metricValue)
+	if err != nil {
+		logger.Log.Info("error while updating metric", zap.Error(err))
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return s.apply(tx, metric, time.Now())
+	})
+}
+
+// UpdateBatch applies all the metrics in a single transaction, so either all metrics are applied or none of them.
+func (s *BoltStorage) UpdateBatch(ctx context.Context, metrics []models.Metrics) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		now := time.Now()
+		for _, metric := range metrics {
+			if err := s.apply(tx, metric, now); err != nil {
+				logger.Log.Info("batch rejected", zap.Error(err))
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// apply stores the metric and records it to history.
+func (s *BoltStorage) apply(tx *bolt.Tx, metric models.Metrics, ts time.Time) error {
+	b, err := bucketOf(metric.MType)
+	if err != nil {
+		return err
+	}
+	key := []byte(models.SeriesKey(metric.ID, metric.Labels))
+	stored := tx.Bucket(b.name)
+
+	current, err := decodeMetric(stored.Get(key))
+	if err != nil {
+		return err
+	}
+
+	switch metric.MType {
+	case GaugeType:
+		if metric.Value == nil {
+			return fmt.Errorf("%w: value of gauge %q not provided", storage.ErrInvalidValue, metric.ID)
+		}
+		value := *metric.Value
+		current.Value = &value
+	case CountType:
+		if metric.Delta == nil {
+			return fmt.Errorf("%w: delta of counter %q not provided", storage.ErrInvalidValue, metric.ID)
+		}
+		var delta int64
+		if current.Delta != nil {
+			delta = *current.Delta
+		}
+		delta += *metric.Delta
+		current.Delta = &delta
+	case HistogramType:
+		if err = metric.Histogram.Validate(); err != nil {
+			return fmt.Errorf("%w: %w", storage.ErrInvalidValue, err)
+		}
+		if current.Histogram == nil {
+			current.Histogram = metric.Histogram.Clone()
+		} else if err = current.Histogram.Merge(metric.Histogram); err != nil {
+			return fmt.Errorf("%w: %w: %q", storage.ErrInvalidValue, err, metric.ID)
+		}
+	}
+	current.ID, current.MType, current.Labels = metric.ID, metric.MType, metric.Labels
+
+	if err = putMetric(stored, key, current); err != nil {
+		return err
+	}
+	return s.record(tx, b, key, current, ts)
+}
+
+// record appends current value of the series to its history and drops samples older than retention.
+func (s *BoltStorage) record(tx *bolt.Tx, b bucket, key []byte, metric models.Metrics, ts time.Time) error {
+	if b.samples == nil {
+		return nil
+	}
+	history, err := tx.Bucket(b.samples).CreateBucketIfNotExists(key)
+	if err != nil {
+		return err
+	}
+
+	var value float64
+	if metric.Value != nil {
+		value = *metric.Value
+	}
+	if metric.Delta != nil {
+		value = float64(*metric.Delta)
+	}
+	seq, err := history.NextSequence()
+	if err != nil {
+		return err
+	}
+	if err = history.Put(sampleKey(ts, seq), float64Bytes(value)); err != nil {
+		return err
+	}
+
+	// samples are sorted by time, so expired ones are at the beginning
+	cutoff := sampleKey(ts.Add(-s.retention), 0)
+	var expired [][]byte
+	c := history.Cursor()
+	for k, _ := c.First(); k != nil && bytes.Compare(k, cutoff) < 0; k, _ = c.Next() {
+		expired = append(expired, k)
+	}
+	for _, k := range expired {
+		if err = history.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// History returns samples of the metric recorded between from and to, downsampled by step.
+func (s *BoltStorage) History(ctx context.Context, metricType string, metricName string, labels models.Labels, from, to time.Time, step time.Duration) ([]models.Sample, error) {
+	b, err := bucketOf(metricType)
+	if err != nil {
+		return nil, err
+	}
+	if b.samples == nil {
+		return nil, fmt.Errorf("%w: history of %q is not recorded", storage.ErrTypeConflict, metricType)
+	}
+
+	res := make([]models.Sample, 0)
+	err = s.db.View(func(tx *bolt.Tx) error {
+		history := tx.Bucket(b.samples).Bucket([]byte(models.SeriesKey(metricName, labels)))
+		if history == nil {
+			return nil
+		}
+
+		c := history.Cursor()
+		for k, v := c.Seek(sampleKey(from, 0)); k != nil; k, v = c.Next() {
+			ts := sampleTime(k)
+			if ts.After(to) {
+				break
+			}
+			res = append(res, models.Sample{Timestamp: ts, Value: math.Float64frombits(binary.BigEndian.Uint64(v))})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return models.Downsample(res, from, step), nil
+}
+
+func (s *BoltStorage) Get(ctx context.Context, metricType string, metricName string, labels models.Labels) (models.Metrics, error) {
+	b, err := bucketOf(metricType)
+	if err != nil {
+		return models.Metrics{}, err
+	}
+
+	var metric models.Metrics
+	err = s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(b.name).Get([]byte(models.SeriesKey(metricName, labels)))
+		if data == nil {
+			return storage.ErrNotFound
+		}
+		metric, err = decodeMetric(data)
+		return err
+	})
+	return metric, err
+}
+
+// List returns page of series selected by the filter, sorted by type and series key, and cursor of the next page.
+func (s *BoltStorage) List(ctx context.Context, filter models.ListFilter) ([]models.Metrics, string, error) {
+	var metrics []models.Metrics
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, b := range buckets {
+			if filter.Type != "" && filter.Type != b.metricType {
+				continue
+			}
+			err := tx.Bucket(b.name).ForEach(func(k, v []byte) error {
+				metric, err := decodeMetric(v)
+				if err != nil {
+					return err
+				}
+				if filter.Match(metric) {
+					metrics = append(metrics, metric)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	page, next, err := filter.Page(metrics)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %w", storage.ErrInvalidValue, err)
+	}
+	return page, next, nil
+}
+
+// Delete removes series of the stated type, which names match the glob pattern and labels satisfy the matchers,
+// together with their history. Empty metric type stands for all types. Returns number of deleted series.
+func (s *BoltStorage) Delete(ctx context.Context, metricType string, pattern string, matchers ...*models.LabelMatcher) (int, error) {
+	return s.forEachSeries(metricType, pattern, matchers, func(tx *bolt.Tx, b bucket, key []byte, metric models.Metrics) error {
+		if err := tx.Bucket(b.name).Delete(key); err != nil {
+			return err
+		}
+		if b.samples == nil || tx.Bucket(b.samples).Bucket(key) == nil {
+			return nil
+		}
+		return tx.Bucket(b.samples).DeleteBucket(key)
+	})
+}
+
+// Reset sets to zero series of the stated type, which names match the glob pattern and labels satisfy the matchers.
+// Histograms keep their bounds. Empty metric type stands for all types. Returns number of reset series.
+func (s *BoltStorage) Reset(ctx context.Context, metricType string, pattern string, matchers ...*models.LabelMatcher) (int, error) {
+	now := time.Now()
+	return s.forEachSeries(metricType, pattern, matchers, func(tx *bolt.Tx, b bucket, key []byte, metric models.Metrics) error {
+		switch {
+		case metric.Value != nil:
+			*metric.Value = 0
+		case metric.Delta != nil:
+			*metric.Delta = 0
+		case metric.Histogram != nil:
+			metric.Histogram.Reset()
+		}
+		if err := putMetric(tx.Bucket(b.name), key, metric); err != nil {
+			return err
+		}
+		return s.record(tx, b, key, metric, now)
+	})
+}
+
+// forEachSeries calls fn in a single transaction for every matching series.
+func (s *BoltStorage) forEachSeries(metricType string, pattern string, matchers []*models.LabelMatcher,
+	fn func(tx *bolt.Tx, b bucket, key []byte, metric models.Metrics) error) (int, error) {
+	var n int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, b := range buckets {
+			if metricType != "" && metricType != b.metricType {
+				continue
+			}
+
+			// bucket must not be modified while it is iterated
+			matched := make(map[string]models.Metrics)
+			err := tx.Bucket(b.name).ForEach(func(k, v []byte) error {
+				metric, err := decodeMetric(v)
+				if err != nil {
+					return err
+				}
+				if models.MatchName(pattern, metric.ID) && metric.Labels.Matches(matchers) {
+					matched[string(k)] = metric
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for key, metric := range matched {
+				if err = fn(tx, b, []byte(key), metric); err != nil {
+					logger.Log.Info("error while processing series", zap.String("name", metric.ID), zap.Error(err))
+					return err
+				}
+			}
+			n += len(matched)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func bucketOf(metricType string) (bucket, error) {
+	for _, b := range buckets {
+		if b.metricType == metricType {
+			return b, nil
+		}
+	}
+	return bucket{}, fmt.Errorf("%w: unsupported metric type %q", storage.ErrTypeConflict, metricType)
+}
+
+// decodeMetric parses stored series, nil data stands for a new series.
+func decodeMetric(data []byte) (models.Metrics, error) {
+	var metric models.Metrics
+	if data == nil {
+		return metric, nil
+	}
+	err := json.Unmarshal(data, &metric)
+	return metric, err
+}
+
+func putMetric(b *bolt.Bucket, key []byte, metric models.Metrics) error {
+	data, err := json.Marshal(metric)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, data)
+}
+
+// sampleKey orders samples by time, seq distinguishes samples recorded at the same time.
+func sampleKey(ts time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(ts.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+func sampleTime(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key)))
+}
+
+func float64Bytes(v float64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, math.Float64bits(v))
+	return data
+}
@@ -0,0 +1,218 @@
+package boltdb
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newStorage(t *testing.T) (*BoltStorage, string) {
+	path := filepath.Join(t.TempDir(), "metrics.db")
+	s, err := New(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s, path
+}
+
+func TestBoltStorage_Update(t *testing.T) {
+	s, _ := newStorage(t)
+	ctx := context.TODO()
+	gauge, value, delta := float64(1.5), float64(2), int64(3)
+
+	tests := []struct {
+		name       string
+		metricType string
+		metricName string
+		labels     models.Labels
+		value      any
+		want       models.Metrics
+		wantErr    error
+	}{
+		{name: "Gauge", metricType: GaugeType, metricName: "Alloc", value: float64(2), want: models.Metrics{ID: "Alloc", MType: GaugeType, Value: &value}},
+		{name: "Gauge pointer", metricType: GaugeType, metricName: "Alloc", value: &gauge, want: models.Metrics{ID: "Alloc", MType: GaugeType, Value: &gauge}},
+		{name: "Counter", metricType: CountType, metricName: "requests", labels: models.Labels{"host": "a"}, value: int64(3),
+			want: models.Metrics{ID: "requests", MType: CountType, Delta: &delta, Labels: models.Labels{"host": "a"}}},
+		{name: "Invalid value", metricType: GaugeType, metricName: "Alloc", value: "abc", wantErr: storage.ErrTypeConflict},
+		{name: "Value not provided", metricType: CountType, metricName: "requests", value: (*int64)(nil), wantErr: storage.ErrInvalidValue},
+		{name: "Unsupported type", metricType: "summary", metricName: "Alloc", value: float64(1), wantErr: storage.ErrTypeConflict},
+		{name: "Invalid histogram", metricType: HistogramType, metricName: "latency", value: &models.Histogram{Bounds: []float64{1}}, wantErr: storage.ErrInvalidValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.Update(ctx, tt.metricType, tt.metricName, tt.labels, tt.value)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			got, err := s.Get(ctx, tt.metricType, tt.metricName, tt.labels)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := s.Get(ctx, CountType, "requests", nil)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = s.Get(ctx, "summary", "requests", nil)
+	assert.ErrorIs(t, err, storage.ErrTypeConflict)
+}
+
+func TestBoltStorage_Histogram(t *testing.T) {
+	s, _ := newStorage(t)
+	ctx := context.TODO()
+
+	require.NoError(t, s.Update(ctx, HistogramType, "latency", nil, &models.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1}))
+	require.NoError(t, s.Update(ctx, HistogramType, "latency", nil, &models.Histogram{Bounds: []float64{1}, Counts: []uint64{0, 1}, Sum: 2, Count: 1}))
+	err := s.Update(ctx, HistogramType, "latency", nil, &models.Histogram{Bounds: []float64{2}, Counts: []uint64{0, 1}, Sum: 2, Count: 1})
+	assert.ErrorIs(t, err, models.ErrBoundsMismatch)
+
+	got, err := s.Get(ctx, HistogramType, "latency", nil)
+	require.NoError(t, err)
+	assert.Equal(t, &models.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 1}, Sum: 2.5, Count: 2}, got.Histogram)
+}
+
+func TestBoltStorage_UpdateBatch(t *testing.T) {
+	s, _ := newStorage(t)
+	ctx := context.TODO()
+	gauge, delta := float64(1.5), int64(2)
+
+	err := s.UpdateBatch(ctx, []models.Metrics{
+		{ID: "requests", MType: CountType, Delta: &delta},
+		{ID: "requests", MType: CountType, Delta: &delta},
+		{ID: "Alloc", MType: GaugeType, Value: &gauge},
+	})
+	require.NoError(t, err)
+
+	// batch with an invalid metric is rejected entirely
+	err = s.UpdateBatch(ctx, []models.Metrics{
+		{ID: "requests", MType: CountType, Delta: &delta},
+		{ID: "Alloc", MType: GaugeType},
+	})
+	assert.Error(t, err)
+
+	got, _, err := s.List(ctx, models.ListFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"requests": int64(4), "Alloc": float64(1.5)}, values(got))
+}
+
+func TestBoltStorage_List(t *testing.T) {
+	s, _ := newStorage(t)
+	ctx := context.TODO()
+
+	_ = s.Update(ctx, GaugeType, "Alloc", models.Labels{"host": "a"}, float64(1))
+	_ = s.Update(ctx, GaugeType, "Alloc", models.Labels{"host": "b"}, float64(2))
+	_ = s.Update(ctx, CountType, "requests", models.Labels{"host": "a"}, int64(3))
+	_ = s.Update(ctx, GaugeType, "requests", models.Labels{"host": "a"}, float64(4))
+
+	matcher, err := models.NewLabelMatcher(models.MatchEqual, "host", "a")
+	require.NoError(t, err)
+	got, next, err := s.List(ctx, models.ListFilter{Type: GaugeType, Matchers: []*models.LabelMatcher{matcher}})
+	require.NoError(t, err)
+	assert.Empty(t, next)
+	assert.Equal(t, map[string]any{`Alloc{host="a"}`: float64(1), `requests{host="a"}`: float64(4)}, values(got))
+
+	// counter and gauge of the same name are both listed
+	got, next, err = s.List(ctx, models.ListFilter{Prefix: "req", Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, CountType, got[0].MType)
+	got, next, err = s.List(ctx, models.ListFilter{Prefix: "req", Limit: 1, Cursor: next})
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, GaugeType, got[0].MType)
+	assert.Empty(t, next)
+
+	_, _, err = s.List(ctx, models.ListFilter{Cursor: "!"})
+	assert.ErrorIs(t, err, storage.ErrInvalidValue)
+}
+
+// values returns values of the listed series by series key.
+func values(metrics []models.Metrics) map[string]any {
+	res := make(map[string]any, len(metrics))
+	for _, m := range metrics {
+		key := models.SeriesKey(m.ID, m.Labels)
+		switch {
+		case m.Value != nil:
+			res[key] = *m.Value
+		case m.Delta != nil:
+			res[key] = *m.Delta
+		case m.Histogram != nil:
+			res[key] = m.Histogram
+		}
+	}
+	return res
+}
+
+func TestBoltStorage_History(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.db")
+	s, err := New(path, WithRetention(time.Hour))
+	require.NoError(t, err)
+	defer s.Close()
+	ctx := context.TODO()
+
+	from := time.Now()
+	_ = s.Update(ctx, CountType, "requests", nil, int64(1))
+	_ = s.Update(ctx, CountType, "requests", nil, int64(2))
+	to := time.Now()
+	_ = s.Update(ctx, CountType, "requests", nil, int64(3))
+
+	samples, err := s.History(ctx, CountType, "requests", nil, from, to, 0)
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, float64(1), samples[0].Value)
+	assert.Equal(t, float64(3), samples[1].Value)
+
+	_, err = s.History(ctx, HistogramType, "latency", nil, from, to, 0)
+	assert.Error(t, err)
+}
+
+func TestBoltStorage_DeleteReset(t *testing.T) {
+	s, _ := newStorage(t)
+	ctx := context.TODO()
+
+	_ = s.Update(ctx, GaugeType, "HeapAlloc", nil, float64(1))
+	_ = s.Update(ctx, GaugeType, "HeapSys", nil, float64(2))
+	_ = s.Update(ctx, CountType, "HeapObjects", nil, int64(3))
+	_ = s.Update(ctx, GaugeType, "Alloc", nil, float64(4))
+
+	n, err := s.Reset(ctx, CountType, "Heap*")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	n, err = s.Delete(ctx, GaugeType, "Heap*")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	got, _, err := s.List(ctx, models.ListFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"HeapObjects": int64(0), "Alloc": float64(4)}, values(got))
+
+	samples, err := s.History(ctx, GaugeType, "HeapAlloc", nil, time.Time{}, time.Now(), 0)
+	require.NoError(t, err)
+	assert.Empty(t, samples)
+}
+
+func TestBoltStorage_reopen(t *testing.T) {
+	s, path := newStorage(t)
+	ctx := context.TODO()
+
+	_ = s.Update(ctx, CountType, "requests", nil, int64(3))
+	require.NoError(t, s.Close())
+
+	s, err := New(path)
+	require.NoError(t, err)
+	require.NoError(t, s.Ping(ctx))
+	_ = s.Update(ctx, CountType, "requests", nil, int64(4))
+
+	got, err := s.Get(ctx, CountType, "requests", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), *got.Delta)
+	require.NoError(t, s.Close())
+}
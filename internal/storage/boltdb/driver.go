@@ -0,0 +1,35 @@
+package boltdb
+
+import (
+	"errors"
+	"net/url"
+
+	config "github.com/igortoigildin/go-metrics-altering/config/server"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+)
+
+func init() {
+	storage.Register("bolt", open)
+}
+
+// open opens the database file stated in bolt:///var/lib/metrics.db URL. Options: retention (duration).
+func open(u *url.URL, cfg *config.ConfigServer) (storage.Storage, error) {
+	opts := storage.Options(u.Query())
+	retention, err := opts.Duration("retention", cfg.HistoryRetention)
+	if err != nil {
+		return nil, err
+	}
+	if err = opts.Unknown(); err != nil {
+		return nil, err
+	}
+
+	path := storage.Path(u)
+	if path == "" {
+		return nil, errors.New("path of the database file not provided")
+	}
+	s, err := New(path, WithRetention(retention))
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,201 @@
+// Package cache provides read-through cache of metric values in front of any storage.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+)
+
+const (
+	defaultSize = 10000
+	defaultTTL  = 5 * time.Second
+)
+
+// Stats are counters of cache lookups.
+type Stats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Size   int    `json:"size"` // number of cached series
+}
+
+type entry struct {
+	key     string
+	metric  models.Metrics
+	expires time.Time
+}
+
+// Cache keeps values of recently read series, the least recently used are evicted once the cache is full.
+// Writes go to the storage and invalidate cached values: updated series are evicted, delete and reset
+// evict all series, as they may match any of them. Listing and history are not cached.
+type Cache struct {
+	storage.Storage
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // the most recently used at front
+	size    int
+	ttl     time.Duration
+	// gen is incremented on every invalidation, values read before it are not cached
+	gen uint64
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+	now    func() time.Time
+}
+
+// Option configures Cache.
+type Option func(*Cache)
+
+// WithSize sets maximum number of cached series.
+func WithSize(size int) Option {
+	return func(c *Cache) {
+		if size > 0 {
+			c.size = size
+		}
+	}
+}
+
+// WithTTL sets how long values are cached, so that changes made bypassing the cache are seen eventually.
+func WithTTL(ttl time.Duration) Option {
+	return func(c *Cache) {
+		if ttl > 0 {
+			c.ttl = ttl
+		}
+	}
+}
+
+// New returns cache in front of the storage.
+func New(s storage.Storage, opts ...Option) *Cache {
+	c := &Cache{
+		Storage: s,
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+		size:    defaultSize,
+		ttl:     defaultTTL,
+		now:     time.Now,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Unwrap returns the cached storage.
+func (c *Cache) Unwrap() storage.Storage {
+	return c.Storage
+}
+
+// CacheStats returns counters of cache lookups.
+func (c *Cache) CacheStats() Stats {
+	c.mu.Lock()
+	size := c.order.Len()
+	c.mu.Unlock()
+
+	return Stats{Hits: c.hits.Load(), Misses: c.misses.Load(), Size: size}
+}
+
+func (c *Cache) Get(ctx context.Context, metricType string, metricName string, labels models.Labels) (models.Metrics, error) {
+	key := cacheKey(metricType, metricName, labels)
+
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*entry)
+		if c.now().Before(e.expires) {
+			c.order.MoveToFront(el)
+			metric := e.metric.Clone()
+			c.mu.Unlock()
+
+			c.hits.Add(1)
+			return metric, nil
+		}
+		c.remove(el)
+	}
+	gen := c.gen
+	c.mu.Unlock()
+
+	c.misses.Add(1)
+	metric, err := c.Storage.Get(ctx, metricType, metricName, labels)
+	if err != nil {
+		return metric, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// the value may be stale if the series was changed while it was read
+	if gen == c.gen {
+		c.add(key, metric.Clone())
+	}
+	return metric, nil
+}
+
+func (c *Cache) Update(ctx context.Context, metricType string, metricName string, labels models.Labels, metricValue any) error {
+	defer c.invalidate(cacheKey(metricType, metricName, labels))
+	return c.Storage.Update(ctx, metricType, metricName, labels, metricValue)
+}
+
+func (c *Cache) UpdateBatch(ctx context.Context, metrics []models.Metrics) error {
+	keys := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		keys = append(keys, cacheKey(metric.MType, metric.ID, metric.Labels))
+	}
+	defer c.invalidate(keys...)
+	return c.Storage.UpdateBatch(ctx, metrics)
+}
+
+func (c *Cache) Delete(ctx context.Context, metricType string, pattern string, matchers ...*models.LabelMatcher) (int, error) {
+	defer c.invalidateAll()
+	return c.Storage.Delete(ctx, metricType, pattern, matchers...)
+}
+
+func (c *Cache) Reset(ctx context.Context, metricType string, pattern string, matchers ...*models.LabelMatcher) (int, error) {
+	defer c.invalidateAll()
+	return c.Storage.Reset(ctx, metricType, pattern, matchers...)
+}
+
+// add caches the metric and evicts the least recently used one if the cache is full. Caller must hold the lock.
+func (c *Cache) add(key string, metric models.Metrics) {
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.entries[key] = c.order.PushFront(&entry{key: key, metric: metric, expires: c.now().Add(c.ttl)})
+	if c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove evicts the entry. Caller must hold the lock.
+func (c *Cache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*entry).key)
+}
+
+func (c *Cache) invalidate(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for _, key := range keys {
+		if el, ok := c.entries[key]; ok {
+			c.remove(el)
+		}
+	}
+}
+
+func (c *Cache) invalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	clear(c.entries)
+	c.order.Init()
+}
+
+func cacheKey(metricType string, metricName string, labels models.Labels) string {
+	return metricType + "\x00" + models.SeriesKey(metricName, labels)
+}
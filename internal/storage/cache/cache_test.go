@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	local "github.com/igortoigildin/go-metrics-altering/internal/storage/inmemory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStorage counts reads reaching the storage.
+type countingStorage struct {
+	storage.Storage
+	gets int
+}
+
+func (s *countingStorage) Get(ctx context.Context, metricType string, metricName string, labels models.Labels) (models.Metrics, error) {
+	s.gets++
+	return s.Storage.Get(ctx, metricType, metricName, labels)
+}
+
+func newCache(t *testing.T, opts ...Option) (*Cache, *countingStorage) {
+	s := &countingStorage{Storage: local.New()}
+	require.NoError(t, s.Update(context.Background(), "gauge", "Alloc", nil, 1.5))
+	require.NoError(t, s.Update(context.Background(), "gauge", "Alloc", models.Labels{"host": "a"}, 2.5))
+	return New(s, opts...), s
+}
+
+func value(t *testing.T, c *Cache, labels models.Labels) float64 {
+	metric, err := c.Get(context.Background(), "gauge", "Alloc", labels)
+	require.NoError(t, err)
+	return *metric.Value
+}
+
+func TestCache_Get(t *testing.T) {
+	c, s := newCache(t)
+
+	assert.Equal(t, 1.5, value(t, c, nil))
+	assert.Equal(t, 1.5, value(t, c, nil))
+	assert.Equal(t, 2.5, value(t, c, models.Labels{"host": "a"}))
+	assert.Equal(t, 2, s.gets)
+	assert.Equal(t, Stats{Hits: 1, Misses: 2, Size: 2}, c.CacheStats())
+
+	// cached value is not changed by callers
+	metric, err := c.Get(context.Background(), "gauge", "Alloc", nil)
+	require.NoError(t, err)
+	*metric.Value = 10
+	assert.Equal(t, 1.5, value(t, c, nil))
+
+	// errors are not cached
+	_, err = c.Get(context.Background(), "counter", "Alloc", nil)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = c.Get(context.Background(), "counter", "Alloc", nil)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.Equal(t, 4, s.gets)
+}
+
+func TestCache_TTL(t *testing.T) {
+	c, s := newCache(t, WithTTL(time.Second))
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	value(t, c, nil)
+	now = now.Add(999 * time.Millisecond)
+	value(t, c, nil)
+	assert.Equal(t, 1, s.gets)
+
+	now = now.Add(time.Millisecond)
+	value(t, c, nil)
+	assert.Equal(t, 2, s.gets)
+}
+
+func TestCache_eviction(t *testing.T) {
+	c, s := newCache(t, WithSize(1))
+
+	value(t, c, nil)
+	value(t, c, models.Labels{"host": "a"})
+	value(t, c, nil)
+	assert.Equal(t, 3, s.gets)
+	assert.Equal(t, 1, c.CacheStats().Size)
+}
+
+func TestCache_invalidation(t *testing.T) {
+	ctx := context.Background()
+	c, s := newCache(t)
+	value(t, c, nil)
+	value(t, c, models.Labels{"host": "a"})
+
+	require.NoError(t, c.Update(ctx, "gauge", "Alloc", nil, 3.5))
+	assert.Equal(t, 3.5, value(t, c, nil))
+	assert.Equal(t, 2.5, value(t, c, models.Labels{"host": "a"}))
+	assert.Equal(t, 3, s.gets)
+
+	require.NoError(t, c.UpdateBatch(ctx, []models.Metrics{{ID: "Alloc", MType: "gauge", Value: ptr(4.5), Labels: models.Labels{"host": "a"}}}))
+	assert.Equal(t, 4.5, value(t, c, models.Labels{"host": "a"}))
+	assert.Equal(t, 4, s.gets)
+
+	_, err := c.Reset(ctx, "gauge", "All*")
+	require.NoError(t, err)
+	assert.Equal(t, 0, c.CacheStats().Size)
+	assert.Equal(t, float64(0), value(t, c, nil))
+
+	_, err = c.Delete(ctx, "gauge", "Alloc")
+	require.NoError(t, err)
+	_, err = c.Get(ctx, "gauge", "Alloc", nil)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestCache_Unwrap(t *testing.T) {
+	c, s := newCache(t)
+	got, ok := storage.As[*countingStorage](c)
+	require.True(t, ok)
+	assert.Same(t, s, got)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
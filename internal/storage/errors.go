@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"errors"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+)
+
+// Errors returned by every storage driver, callers check them with errors.Is.
+var (
+	// ErrNotFound is returned when the requested series is not stored.
+	ErrNotFound = errors.New("metric not found")
+	// ErrTypeConflict is returned when metric type is unsupported or does not match type of the value.
+	// It is the error of models.NewMetric.
+	ErrTypeConflict = models.ErrTypeConflict
+	// ErrInvalidValue is returned when value is missing or cannot be applied to the stored series,
+	// e.g. histogram with different bucket bounds.
+	ErrInvalidValue = models.ErrInvalidValue
+	// ErrReadOnly is returned on writes to follower of the primary server, which metrics are replicated from.
+	ErrReadOnly = errors.New("storage is read-only")
+	// ErrOverloaded is returned when writes come faster than storage can take them and should be retried later.
+	ErrOverloaded = errors.New("storage is overloaded")
+)
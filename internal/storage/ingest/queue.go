@@ -0,0 +1,492 @@
+// Package ingest provides write-behind queue between handlers and storage. Updates are coalesced
+// in memory and written in bulk once per flush window: the last gauge value wins, counter deltas
+// and histograms of the same series are summed up.
+//
+// Durability depends on the mode:
+//   - ModeAsync acknowledges updates once they are queued. Updates of the current window are lost if
+//     the server crashes, they are flushed on graceful shutdown. Windows failed to be written because
+//     the storage is unavailable are queued again, so that nothing is lost while the server is running.
+//   - ModeGroup acknowledges updates once the window they are in is written, so they are as durable
+//     as with the storage itself. Failure to write the window is returned to every update in it.
+//     Update cancelled before its window is written is withdrawn from it, so that it can be retried:
+//     its counter deltas and histogram observations are not written. Its gauge values may still be
+//     written, which is harmless as the retry writes them again. Update cancelled while its window is
+//     being written waits for the result.
+//
+// In both modes reads see updates only after they are written, i.e. with up to one flush interval delay.
+// Updates are rejected with storage.ErrOverloaded once the queue holds capacity series.
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	config "github.com/igortoigildin/go-metrics-altering/config/server"
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	"github.com/igortoigildin/go-metrics-altering/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// Mode states when updates are acknowledged.
+type Mode string
+
+const (
+	ModeAsync Mode = "async" // once queued
+	ModeGroup Mode = "group" // once written
+)
+
+const (
+	defaultCapacity  = 100000
+	defaultInterval  = time.Second
+	defaultBatchSize = 1000
+)
+
+var errClosed = errors.New("ingestion queue closed")
+
+// item is the queued series. Source is kept, so that storages attributing counter increments record it.
+// Gauges are queued once for all sources, with the source of the last value.
+type item struct {
+	source string
+	metric models.Metrics
+}
+
+// window is a set of updates written together.
+type window struct {
+	items map[string]*item // by itemKey
+	done  chan struct{}    // closed once the window is written
+	err   error
+}
+
+func newWindow() *window {
+	return &window{items: map[string]*item{}, done: make(chan struct{})}
+}
+
+// Queue is storage, which updates are queued and written in bulk by a background goroutine.
+type Queue struct {
+	storage.Storage
+
+	mode      Mode
+	capacity  int
+	batchSize int
+	interval  time.Duration
+
+	mu      sync.Mutex
+	pending *window
+	closed  bool
+
+	// flushMu serializes flushes, so that windows are written in order
+	flushMu sync.Mutex
+	kick    chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// Option configures Queue.
+type Option func(*Queue)
+
+// WithMode sets when updates are acknowledged.
+func WithMode(mode Mode) Option {
+	return func(q *Queue) {
+		q.mode = mode
+	}
+}
+
+// WithCapacity sets maximum number of queued series.
+func WithCapacity(capacity int) Option {
+	return func(q *Queue) {
+		if capacity > 0 {
+			q.capacity = capacity
+		}
+	}
+}
+
+// WithInterval sets how often queued updates are written.
+func WithInterval(interval time.Duration) Option {
+	return func(q *Queue) {
+		if interval > 0 {
+			q.interval = interval
+		}
+	}
+}
+
+// WithBatchSize sets maximum number of series written at once. The window is written before the interval elapses
+// once it has that many series.
+func WithBatchSize(size int) Option {
+	return func(q *Queue) {
+		if size > 0 {
+			q.batchSize = size
+		}
+	}
+}
+
+// New returns queue in front of the storage and starts writing queued updates. Close must be called to write
+// the remaining ones.
+func New(s storage.Storage, opts ...Option) *Queue {
+	q := &Queue{
+		Storage:   s,
+		mode:      ModeAsync,
+		capacity:  defaultCapacity,
+		batchSize: defaultBatchSize,
+		interval:  defaultInterval,
+		pending:   newWindow(),
+		kick:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	go q.run()
+	return q
+}
+
+// Unwrap returns the storage updates are written to.
+func (q *Queue) Unwrap() storage.Storage {
+	return q.Storage
+}
+
+// Close writes the queued updates and stops the queue. Later updates are rejected.
+func (q *Queue) Close() error {
+	q.once.Do(func() {
+		q.mu.Lock()
+		q.closed = true
+		q.mu.Unlock()
+
+		close(q.stop)
+	})
+	<-q.stopped
+	return nil
+}
+
+func (q *Queue) run() {
+	defer close(q.stopped)
+
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-q.kick:
+		case <-q.stop:
+			if err := q.Flush(context.Background()); err != nil {
+				logger.Log.Error("queued metrics lost on shutdown", zap.Error(err))
+			}
+			return
+		}
+		if err := q.Flush(context.Background()); err != nil {
+			logger.Log.Info("error while writing queued metrics", zap.Error(err))
+		}
+	}
+}
+
+func (q *Queue) Update(ctx context.Context, metricType string, metricName string, labels models.Labels, metricValue any) error {
+	metric, err := models.NewMetric(metricType, metricName, labels, metricValue)
+	if err != nil {
+		return err
+	}
+	if err = checkMetric(metric); err != nil {
+		return err
+	}
+	return q.enqueue(ctx, []models.Metrics{metric})
+}
+
+func (q *Queue) UpdateBatch(ctx context.Context, metrics []models.Metrics) error {
+	for _, metric := range metrics {
+		if err := checkMetric(metric); err != nil {
+			return err
+		}
+	}
+	return q.enqueue(ctx, metrics)
+}
+
+// Delete writes the queued updates first, so that they are deleted too.
+func (q *Queue) Delete(ctx context.Context, metricType string, pattern string, matchers ...*models.LabelMatcher) (int, error) {
+	if err := q.Flush(ctx); err != nil {
+		return 0, fmt.Errorf("could not write queued metrics: %w", err)
+	}
+	return q.Storage.Delete(ctx, metricType, pattern, matchers...)
+}
+
+// Reset writes the queued updates first, so that they are reset too.
+func (q *Queue) Reset(ctx context.Context, metricType string, pattern string, matchers ...*models.LabelMatcher) (int, error) {
+	if err := q.Flush(ctx); err != nil {
+		return 0, fmt.Errorf("could not write queued metrics: %w", err)
+	}
+	return q.Storage.Reset(ctx, metricType, pattern, matchers...)
+}
+
+// enqueue merges the metrics into the pending window. In group mode it waits for the window to be written.
+func (q *Queue) enqueue(ctx context.Context, metrics []models.Metrics) error {
+	source := storage.SourceFromContext(ctx)
+
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return fmt.Errorf("%w: %w", storage.ErrOverloaded, errClosed)
+	}
+	// updates acknowledged in async mode must not be rejected later
+	if c, ok := storage.As[writeChecker](q.Storage); ok {
+		if err := c.CheckWritable(); err != nil {
+			q.mu.Unlock()
+			return err
+		}
+	}
+	w := q.pending
+	if err := q.admit(w, source, metrics); err != nil {
+		q.mu.Unlock()
+		return err
+	}
+	for _, metric := range metrics {
+		// merge cannot fail as the metrics are admitted
+		_ = merge(w.items, source, metric, true)
+	}
+	full := len(w.items) >= q.batchSize
+	q.mu.Unlock()
+
+	if full {
+		select {
+		case q.kick <- struct{}{}:
+		default:
+		}
+	}
+	if q.mode != ModeGroup {
+		return nil
+	}
+
+	select {
+	case <-w.done:
+		return w.err
+	case <-ctx.Done():
+	}
+	if q.withdraw(w, source, metrics) {
+		return ctx.Err()
+	}
+	<-w.done
+	return w.err
+}
+
+// withdraw removes counter deltas and histogram observations of the metrics from the window, unless it is
+// being written already. Reports whether they were removed.
+func (q *Queue) withdraw(w *window, source string, metrics []models.Metrics) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.pending != w {
+		return false
+	}
+	for _, metric := range metrics {
+		key := itemKey(source, metric)
+		queued, ok := w.items[key]
+		if !ok {
+			continue
+		}
+		m := &queued.metric
+		switch {
+		case metric.Delta != nil:
+			*m.Delta -= *metric.Delta
+			if *m.Delta == 0 {
+				delete(w.items, key)
+			}
+		case metric.Histogram != nil:
+			for i, c := range metric.Histogram.Counts {
+				m.Histogram.Counts[i] -= c
+			}
+			m.Histogram.Sum -= metric.Histogram.Sum
+			m.Histogram.Count -= metric.Histogram.Count
+			if m.Histogram.Count == 0 {
+				delete(w.items, key)
+			}
+		}
+	}
+	return true
+}
+
+// admit checks that the metrics fit into the window and histograms have the same bounds as the queued ones.
+// Caller must hold the lock.
+func (q *Queue) admit(w *window, source string, metrics []models.Metrics) error {
+	added := map[string]bool{}
+	bounds := map[string][]float64{}
+	for _, metric := range metrics {
+		key := itemKey(source, metric)
+		queued, ok := w.items[key]
+		if !ok {
+			added[key] = true
+		}
+		if metric.Histogram == nil {
+			continue
+		}
+
+		b, ok := bounds[key]
+		if !ok && queued != nil {
+			b, ok = queued.metric.Histogram.Bounds, true
+		}
+		if !ok {
+			bounds[key] = metric.Histogram.Bounds
+			continue
+		}
+		if !slices.Equal(b, metric.Histogram.Bounds) {
+			return fmt.Errorf("%w: %s %q: %w", storage.ErrInvalidValue, metric.MType, metric.ID, models.ErrBoundsMismatch)
+		}
+	}
+
+	if len(w.items)+len(added) > q.capacity {
+		return fmt.Errorf("%w: %d series queued", storage.ErrOverloaded, len(w.items))
+	}
+	return nil
+}
+
+// Flush writes the queued updates.
+func (q *Queue) Flush(ctx context.Context) error {
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+
+	q.mu.Lock()
+	w := q.pending
+	q.pending = newWindow()
+	q.mu.Unlock()
+
+	failed, err := q.write(ctx, w)
+	w.err = err
+	close(w.done)
+
+	// updates of async mode are acknowledged, so they are kept until written
+	if len(failed) > 0 && q.mode == ModeAsync {
+		q.mu.Lock()
+		for _, it := range failed {
+			if mergeErr := merge(q.pending.items, it.source, it.metric, false); mergeErr != nil {
+				logger.Log.Error("queued metric dropped", zap.String("name", it.metric.ID), zap.Error(mergeErr))
+			}
+		}
+		q.mu.Unlock()
+	}
+	return err
+}
+
+// write writes the window in batches by source. Metrics rejected by the storage are dropped, as writing them again
+// will not help. Returns metrics which were not written because of other errors.
+func (q *Queue) write(ctx context.Context, w *window) ([]*item, error) {
+	bySource := map[string][]models.Metrics{}
+	for _, it := range w.items {
+		bySource[it.source] = append(bySource[it.source], it.metric)
+	}
+
+	var (
+		failed   []*item
+		firstErr error
+	)
+	fail := func(source string, metrics []models.Metrics, err error) {
+		for _, metric := range metrics {
+			failed = append(failed, &item{source: source, metric: metric})
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for source, metrics := range bySource {
+		sctx := storage.WithSource(ctx, source)
+		for i := 0; i < len(metrics); i += q.batchSize {
+			batch := metrics[i:min(i+q.batchSize, len(metrics))]
+			err := q.Storage.UpdateBatch(sctx, batch)
+			switch {
+			case err == nil:
+			case rejected(err):
+				// find out which of the metrics are invalid
+				for _, metric := range batch {
+					err = q.Storage.UpdateBatch(sctx, []models.Metrics{metric})
+					if rejected(err) {
+						logger.Log.Info("queued metric rejected", zap.String("name", metric.ID), zap.Error(err))
+						if firstErr == nil {
+							firstErr = err
+						}
+					} else if err != nil {
+						fail(source, []models.Metrics{metric}, err)
+					}
+				}
+			default:
+				fail(source, batch, err)
+			}
+		}
+	}
+	return failed, firstErr
+}
+
+// rejected reports whether the storage rejected the metrics as invalid or not writable.
+func rejected(err error) bool {
+	return errors.Is(err, storage.ErrInvalidValue) || errors.Is(err, storage.ErrTypeConflict) ||
+		errors.Is(err, storage.ErrReadOnly)
+}
+
+// writeChecker is implemented by storages, which may reject all writes, such as replication follower.
+type writeChecker interface {
+	CheckWritable() error
+}
+
+// itemKey returns key of the queued series. Counters and histograms are queued by source, gauges are not,
+// so that the last value wins whichever source it came from.
+func itemKey(source string, metric models.Metrics) string {
+	if metric.MType == config.GaugeType {
+		source = ""
+	}
+	return source + "\x00" + metric.MType + "\x00" + models.SeriesKey(metric.ID, metric.Labels)
+}
+
+// merge adds the metric to the items. Queued gauge value is replaced only if the metric is newer,
+// e.g. it is not replaced by value of the window failed to be written.
+func merge(items map[string]*item, source string, metric models.Metrics, newer bool) error {
+	key := itemKey(source, metric)
+	queued, ok := items[key]
+	if !ok {
+		items[key] = &item{source: source, metric: metric.Clone()}
+		return nil
+	}
+
+	m := &queued.metric
+	switch {
+	case metric.Value != nil:
+		if newer {
+			value := *metric.Value
+			m.Value = &value
+			queued.source = source
+		}
+	case metric.Delta != nil:
+		*m.Delta += *metric.Delta
+	case metric.Histogram != nil:
+		return m.Histogram.Merge(metric.Histogram)
+	}
+	return nil
+}
+
+// checkMetric verifies that the metric has value of its type.
+func checkMetric(metric models.Metrics) error {
+	var ok bool
+	switch metric.MType {
+	case config.GaugeType:
+		ok = metric.Value != nil
+	case config.CountType:
+		ok = metric.Delta != nil
+	case config.HistogramType:
+		ok = metric.Histogram != nil
+	default:
+		return fmt.Errorf("%w: unsupported metric type %q", storage.ErrTypeConflict, metric.MType)
+	}
+
+	switch {
+	case !ok && (metric.Value != nil || metric.Delta != nil || metric.Histogram != nil):
+		return fmt.Errorf("%w: %s %q has value of another type", storage.ErrTypeConflict, metric.MType, metric.ID)
+	case !ok:
+		return fmt.Errorf("%w: value of %s %q not provided", storage.ErrInvalidValue, metric.MType, metric.ID)
+	case metric.Histogram != nil:
+		if err := metric.Histogram.Validate(); err != nil {
+			return fmt.Errorf("%w: %s %q: %w", storage.ErrInvalidValue, metric.MType, metric.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	local "github.com/igortoigildin/go-metrics-altering/internal/storage/inmemory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingStorage records batches written to in-memory storage, fail decides whether the batch fails.
+type recordingStorage struct {
+	storage.Storage
+	mu      sync.Mutex
+	batches map[string][][]models.Metrics // by source
+	fail    func(batch []models.Metrics) error
+}
+
+func newRecordingStorage() *recordingStorage {
+	return &recordingStorage{Storage: local.New(), batches: map[string][][]models.Metrics{}}
+}
+
+func (s *recordingStorage) UpdateBatch(ctx context.Context, metrics []models.Metrics) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fail != nil {
+		if err := s.fail(metrics); err != nil {
+			return err
+		}
+	}
+	source := storage.SourceFromContext(ctx)
+	s.batches[source] = append(s.batches[source], metrics)
+	return s.Storage.UpdateBatch(ctx, metrics)
+}
+
+func get(t *testing.T, s storage.Storage, metricType string, metricName string) models.Metrics {
+	metric, err := s.Get(context.Background(), metricType, metricName, nil)
+	require.NoError(t, err)
+	return metric
+}
+
+// newQueue returns queue which is flushed by the test only.
+func newQueue(t *testing.T, s storage.Storage, opts ...Option) *Queue {
+	q := New(s, append([]Option{WithInterval(time.Hour)}, opts...)...)
+	t.Cleanup(func() { q.Close() })
+	return q
+}
+
+func TestQueue_coalescing(t *testing.T) {
+	ctx := context.Background()
+	s := newRecordingStorage()
+	q := newQueue(t, s)
+
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, q.Update(ctx, "gauge", "Alloc", nil, float64(i)))
+		require.NoError(t, q.Update(ctx, "counter", "requests", nil, int64(i)))
+	}
+	require.NoError(t, q.UpdateBatch(ctx, []models.Metrics{
+		{ID: "latency", MType: "histogram", Histogram: &models.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1}},
+		{ID: "latency", MType: "histogram", Histogram: &models.Histogram{Bounds: []float64{1}, Counts: []uint64{0, 1}, Sum: 2, Count: 1}},
+	}))
+	// nothing is written until flush
+	_, err := q.Get(ctx, "gauge", "Alloc", nil)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	require.NoError(t, q.Flush(ctx))
+	require.Len(t, s.batches[storage.UnknownSource], 1)
+	assert.Len(t, s.batches[storage.UnknownSource][0], 3)
+	assert.Equal(t, 3.0, *get(t, q, "gauge", "Alloc").Value)
+	assert.Equal(t, int64(6), *get(t, q, "counter", "requests").Delta)
+	assert.Equal(t, &models.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 1}, Sum: 2.5, Count: 2}, get(t, q, "histogram", "latency").Histogram)
+}
+
+func TestQueue_sources(t *testing.T) {
+	s := newRecordingStorage()
+	q := newQueue(t, s)
+
+	require.NoError(t, q.Update(storage.WithSource(context.Background(), "agent-1"), "counter", "requests", nil, int64(1)))
+	require.NoError(t, q.Update(storage.WithSource(context.Background(), "agent-2"), "counter", "requests", nil, int64(2)))
+	require.NoError(t, q.Flush(context.Background()))
+
+	assert.Len(t, s.batches["agent-1"], 1)
+	assert.Len(t, s.batches["agent-2"], 1)
+	assert.Equal(t, int64(3), *get(t, q, "counter", "requests").Delta)
+}
+
+func TestQueue_invalid(t *testing.T) {
+	ctx := context.Background()
+	q := newQueue(t, newRecordingStorage())
+
+	assert.ErrorIs(t, q.Update(ctx, "gauge", "Alloc", nil, int64(1)), storage.ErrTypeConflict)
+	assert.ErrorIs(t, q.Update(ctx, "summary", "Alloc", nil, 1.0), storage.ErrTypeConflict)
+	assert.ErrorIs(t, q.UpdateBatch(ctx, []models.Metrics{{ID: "Alloc", MType: "gauge"}}), storage.ErrInvalidValue)
+	assert.ErrorIs(t, q.Update(ctx, "histogram", "latency", nil, &models.Histogram{Bounds: []float64{1}, Counts: []uint64{1}}), storage.ErrInvalidValue)
+
+	require.NoError(t, q.Update(ctx, "histogram", "latency", nil, &models.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Count: 1}))
+	err := q.Update(ctx, "histogram", "latency", nil, &models.Histogram{Bounds: []float64{2}, Counts: []uint64{1, 0}, Count: 1})
+	assert.ErrorIs(t, err, storage.ErrInvalidValue)
+	assert.ErrorIs(t, err, models.ErrBoundsMismatch)
+}
+
+func TestQueue_overloaded(t *testing.T) {
+	ctx := context.Background()
+	q := newQueue(t, newRecordingStorage(), WithCapacity(2))
+
+	require.NoError(t, q.Update(ctx, "gauge", "Alloc", nil, 1.0))
+	require.NoError(t, q.Update(ctx, "gauge", "Sys", nil, 1.0))
+	// queued series are coalesced
+	require.NoError(t, q.Update(ctx, "gauge", "Alloc", nil, 2.0))
+	assert.ErrorIs(t, q.Update(ctx, "gauge", "Frees", nil, 1.0), storage.ErrOverloaded)
+
+	require.NoError(t, q.Flush(ctx))
+	assert.NoError(t, q.Update(ctx, "gauge", "Frees", nil, 1.0))
+}
+
+func TestQueue_failedWrite(t *testing.T) {
+	ctx := context.Background()
+	s := newRecordingStorage()
+	q := newQueue(t, s)
+
+	s.fail = func([]models.Metrics) error { return errors.New("connection refused") }
+	require.NoError(t, q.Update(ctx, "gauge", "Alloc", nil, 1.0))
+	require.NoError(t, q.Update(ctx, "counter", "requests", nil, int64(1)))
+	assert.Error(t, q.Flush(ctx))
+
+	// updates of the failed window are kept, newer gauge value wins
+	s.fail = nil
+	require.NoError(t, q.Update(ctx, "gauge", "Alloc", nil, 2.0))
+	require.NoError(t, q.Update(ctx, "counter", "requests", nil, int64(2)))
+	require.NoError(t, q.Flush(ctx))
+	assert.Equal(t, 2.0, *get(t, q, "gauge", "Alloc").Value)
+	assert.Equal(t, int64(3), *get(t, q, "counter", "requests").Delta)
+}
+
+func TestQueue_rejected(t *testing.T) {
+	ctx := context.Background()
+	s := newRecordingStorage()
+	s.fail = func(batch []models.Metrics) error {
+		for _, metric := range batch {
+			if metric.ID == "bad" {
+				return storage.ErrInvalidValue
+			}
+		}
+		return nil
+	}
+	q := newQueue(t, s)
+
+	require.NoError(t, q.Update(ctx, "gauge", "Alloc", nil, 1.0))
+	require.NoError(t, q.Update(ctx, "gauge", "bad", nil, 1.0))
+	assert.ErrorIs(t, q.Flush(ctx), storage.ErrInvalidValue)
+
+	// the rest of the window is written, rejected metric is not queued again
+	assert.Equal(t, 1.0, *get(t, q, "gauge", "Alloc").Value)
+	require.NoError(t, q.Flush(ctx))
+	_, err := q.Get(ctx, "gauge", "bad", nil)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestQueue_groupMode(t *testing.T) {
+	ctx := context.Background()
+	s := newRecordingStorage()
+	q := New(s, WithMode(ModeGroup), WithInterval(10*time.Millisecond))
+	defer q.Close()
+
+	// acknowledged once written
+	require.NoError(t, q.Update(ctx, "gauge", "Alloc", nil, 1.0))
+	assert.Equal(t, 1.0, *get(t, q, "gauge", "Alloc").Value)
+
+	s.fail = func([]models.Metrics) error { return errors.New("connection refused") }
+	assert.Error(t, q.Update(ctx, "gauge", "Alloc", nil, 2.0))
+	s.fail = nil
+	require.NoError(t, q.Flush(ctx))
+	// failed updates are reported, not written later
+	assert.Equal(t, 1.0, *get(t, q, "gauge", "Alloc").Value)
+}
+
+func TestQueue_Close(t *testing.T) {
+	ctx := context.Background()
+	q := New(newRecordingStorage(), WithInterval(time.Hour))
+
+	require.NoError(t, q.Update(ctx, "counter", "requests", nil, int64(1)))
+	require.NoError(t, q.Close())
+	assert.Equal(t, int64(1), *get(t, q, "counter", "requests").Delta)
+	assert.ErrorIs(t, q.Update(ctx, "counter", "requests", nil, int64(1)), storage.ErrOverloaded)
+}
+
+func TestQueue_Delete(t *testing.T) {
+	ctx := context.Background()
+	q := newQueue(t, newRecordingStorage())
+
+	require.NoError(t, q.Update(ctx, "gauge", "Alloc", nil, 1.0))
+	n, err := q.Delete(ctx, "gauge", "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.NoError(t, q.Flush(ctx))
+	_, err = q.Get(ctx, "gauge", "Alloc", nil)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestQueue_gaugeSources(t *testing.T) {
+	s := newRecordingStorage()
+	q := newQueue(t, s)
+
+	for i, source := range []string{"a", "b", "a", "c"} {
+		ctx := storage.WithSource(context.Background(), source)
+		require.NoError(t, q.Update(ctx, "gauge", "Alloc", nil, float64(i)))
+	}
+	require.NoError(t, q.Flush(context.Background()))
+	// the last value wins, it is written once with its source
+	assert.Equal(t, 3.0, *get(t, q, "gauge", "Alloc").Value)
+	assert.Len(t, s.batches["c"], 1)
+	assert.Empty(t, s.batches["a"])
+}
+
+func TestQueue_groupModeCancelled(t *testing.T) {
+	s := newRecordingStorage()
+	q := newQueue(t, s, WithMode(ModeGroup))
+
+	written := make(chan error)
+	go func() {
+		written <- q.Update(context.Background(), "counter", "requests", nil, int64(1))
+	}()
+	require.Eventually(t, func() bool {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return len(q.pending.items) == 1
+	}, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// the cancelled update is withdrawn, so that its retry is not counted twice
+	assert.ErrorIs(t, q.Update(ctx, "counter", "requests", nil, int64(2)), context.Canceled)
+	assert.ErrorIs(t, q.Update(ctx, "counter", "errors", nil, int64(2)), context.Canceled)
+
+	require.NoError(t, q.Flush(context.Background()))
+	require.NoError(t, <-written)
+	assert.Equal(t, int64(1), *get(t, q, "counter", "requests").Delta)
+	_, err := q.Get(context.Background(), "counter", "errors", nil)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+// readOnlyStorage rejects writes like replication follower does.
+type readOnlyStorage struct {
+	storage.Storage
+}
+
+func (s readOnlyStorage) CheckWritable() error {
+	return storage.ErrReadOnly
+}
+
+func TestQueue_readOnly(t *testing.T) {
+	q := newQueue(t, readOnlyStorage{Storage: local.New()})
+
+	// rejected at once rather than acknowledged and dropped
+	assert.ErrorIs(t, q.Update(context.Background(), "gauge", "Alloc", nil, 1.0), storage.ErrReadOnly)
+}
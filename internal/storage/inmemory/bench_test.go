@@ -0,0 +1,84 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	config "github.com/igortoigildin/go-metrics-altering/config/agent"
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+)
+
+// Agents update their own series and a shared counter concurrently, as agents do with PollCount.
+// Run with -cpu to vary parallelism, e.g. go test -bench . -cpu 1,8,64.
+func BenchmarkLocalStorage_Update(b *testing.B) {
+	for _, agents := range []int{10, 100, 500} {
+		b.Run(fmt.Sprintf("agents=%d", agents), func(b *testing.B) {
+			m := New()
+			b.SetParallelism(agents)
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			var id int64
+			b.RunParallel(func(pb *testing.PB) {
+				labels := models.Labels{"agent": strconv.FormatInt(atomic.AddInt64(&id, 1), 10)}
+				for i := 0; pb.Next(); i++ {
+					if i%10 == 0 {
+						_ = m.Update(context.TODO(), config.CountType, pollCount, nil, int64(1))
+						continue
+					}
+					_ = m.Update(context.TODO(), config.GaugeType, "Alloc", labels, float64(i))
+				}
+			})
+		})
+	}
+}
+
+// Agents send their metrics in batches, as agents do over /updates/.
+func BenchmarkLocalStorage_UpdateBatch(b *testing.B) {
+	for _, agents := range []int{10, 100, 500} {
+		b.Run(fmt.Sprintf("agents=%d", agents), func(b *testing.B) {
+			m := New()
+			b.SetParallelism(agents)
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			var id int64
+			b.RunParallel(func(pb *testing.PB) {
+				labels := models.Labels{"agent": strconv.FormatInt(atomic.AddInt64(&id, 1), 10)}
+				batch := make([]models.Metrics, 0, 30)
+				for i := 0; i < cap(batch); i++ {
+					value := float64(i)
+					batch = append(batch, models.Metrics{ID: "gauge_" + strconv.Itoa(i), MType: config.GaugeType, Value: &value, Labels: labels})
+				}
+				for pb.Next() {
+					_ = m.UpdateBatch(context.TODO(), batch)
+				}
+			})
+		})
+	}
+}
+
+// Readers of single series run alongside writers.
+func BenchmarkLocalStorage_GetUpdate(b *testing.B) {
+	m := New()
+	for i := 0; i < 1000; i++ {
+		_ = m.Update(context.TODO(), config.GaugeType, "gauge_"+strconv.Itoa(i), nil, float64(i))
+	}
+	b.SetParallelism(100)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			name := "gauge_" + strconv.Itoa(i%1000)
+			if i%4 == 0 {
+				_ = m.Update(context.TODO(), config.GaugeType, name, nil, float64(i))
+				continue
+			}
+			_, _ = m.Get(context.TODO(), config.GaugeType, name, nil)
+		}
+	})
+}
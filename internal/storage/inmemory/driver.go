@@ -0,0 +1,114 @@
+package local
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"time"
+
+	configSrv "github.com/igortoigildin/go-metrics-altering/config/server"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+)
+
+// walSuffix is appended to path of the backup file to get path of write-ahead log.
+const walSuffix = ".wal"
+
+func init() {
+	storage.Register("memory", open)
+	storage.Register("file", open)
+}
+
+// open opens in-memory storage. With file:// URL metrics are backed up to the stated file.
+// Options: retention (duration), and for file:// only interval (seconds, 0 saves on every change,
+// negative saves on close only), restore (bool) and generations (number of kept backups).
+// Read-only config loads the file and its write-ahead log without writing them.
+func open(u *url.URL, cfg *configSrv.ConfigServer) (storage.Storage, error) {
+	opts := storage.Options(u.Query())
+	retention, err := opts.Duration("retention", cfg.HistoryRetention)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "memory" {
+		if err = opts.Unknown(); err != nil {
+			return nil, err
+		}
+		return New(WithRetention(retention)), nil
+	}
+
+	interval, err := opts.Int("interval", cfg.FlagStoreInterval)
+	if err != nil {
+		return nil, err
+	}
+	restore, err := opts.Bool("restore", cfg.FlagRestore)
+	if err != nil {
+		return nil, err
+	}
+	generations, err := opts.Int("generations", cfg.FlagStoreGenerations)
+	if err != nil {
+		return nil, err
+	}
+	if err = opts.Unknown(); err != nil {
+		return nil, err
+	}
+
+	path := storage.Path(u)
+	if path == "" {
+		return nil, errors.New("path of the backup file not provided")
+	}
+
+	memory := New(WithRetention(retention), WithGenerations(generations))
+	if cfg.ReadOnly {
+		if err = memory.loadFile(path); err != nil {
+			return nil, err
+		}
+		return memory, nil
+	}
+	if err = memory.openFile(path, interval, restore); err != nil {
+		return nil, err
+	}
+	return memory, nil
+}
+
+// openFile restores metrics from the backup file and write-ahead log, if needed, and starts backing up to the file.
+func (m *LocalStorage) openFile(path string, interval int, restore bool) error {
+	walPath := path + walSuffix
+
+	if restore {
+		if err := m.loadFile(path); err != nil {
+			return err
+		}
+	}
+
+	if err := m.OpenWAL(walPath); err != nil {
+		return err
+	}
+	// the log is truncated only when snapshot has all its records
+	if err := m.Checkpoint(path); err != nil {
+		return err
+	}
+	switch {
+	case interval == 0:
+		// saving on every change
+		return m.SaveAllMetricsToFile(0, path, path)
+	case interval < 0:
+		// changes are kept in the log until Close
+		m.rm.Lock()
+		m.file = path
+		m.rm.Unlock()
+	default:
+		m.startSaving(time.Duration(interval)*time.Second, path)
+	}
+	return nil
+}
+
+// loadFile restores metrics from the backup file and replays changes made after it from write-ahead log.
+// Neither of the files is changed, missing ones are skipped.
+func (m *LocalStorage) loadFile(path string) error {
+	err := m.LoadMetricsFromFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// changes made after the last snapshot
+	return m.ReplayWAL(path + walSuffix)
+}
@@ -0,0 +1,100 @@
+package local
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	config "github.com/igortoigildin/go-metrics-altering/config/agent"
+	configSrv "github.com/igortoigildin/go-metrics-altering/config/server"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_open(t *testing.T) {
+	cfg := &configSrv.ConfigServer{FlagStoreInterval: 300, FlagStoreGenerations: 3, HistoryRetention: time.Hour}
+
+	s, err := storage.Open("memory://?retention=1m", cfg)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, s.(*LocalStorage).retention)
+
+	_, err = storage.Open("memory://?interval=1", cfg)
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	s, err = storage.Open("file://"+path+"?interval=0&generations=2", cfg)
+	require.NoError(t, err)
+	memory := s.(*LocalStorage)
+	assert.Equal(t, 2, memory.generations)
+	assert.Equal(t, time.Hour, memory.retention)
+	require.NoError(t, s.Update(context.TODO(), config.CountType, "requests", nil, int64(3)))
+
+	// saved synchronously, so restored without waiting for the interval
+	s, err = storage.Open("file://"+path+"?restore=true&interval=0", cfg)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), counterOf(s.(*LocalStorage), "requests"))
+
+	_, err = storage.Open("file://", cfg)
+	assert.Error(t, err)
+}
+
+func Test_open_readOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	cfg := &configSrv.ConfigServer{FlagStoreInterval: 300, FlagStoreGenerations: 3, HistoryRetention: time.Hour}
+
+	s, err := storage.Open("file://"+path, cfg)
+	require.NoError(t, err)
+	// change is only in the log until the next checkpoint
+	require.NoError(t, s.Update(context.TODO(), config.CountType, "requests", nil, int64(3)))
+
+	snapshot, err := os.ReadFile(path)
+	require.NoError(t, err)
+	log, err := os.ReadFile(path + walSuffix)
+	require.NoError(t, err)
+	require.NotEmpty(t, log)
+
+	cfg.ReadOnly = true
+	ro, err := storage.Open("file://"+path, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), counterOf(ro.(*LocalStorage), "requests"))
+	require.NoError(t, ro.(*LocalStorage).Close())
+
+	got, _ := os.ReadFile(path)
+	assert.Equal(t, snapshot, got)
+	got, _ = os.ReadFile(path + walSuffix)
+	assert.Equal(t, log, got)
+	assert.NoFileExists(t, generationPath(path, 2))
+}
+
+func Test_open_saveOnClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	cfg := &configSrv.ConfigServer{FlagStoreInterval: 0, FlagStoreGenerations: 3, FlagRestore: true, HistoryRetention: time.Hour}
+
+	s, err := storage.Open("file://"+path, cfg)
+	require.NoError(t, err)
+	require.NoError(t, s.Update(context.TODO(), config.CountType, "requests", nil, int64(1)))
+	require.NoError(t, s.(*LocalStorage).Close())
+
+	cfg.FlagStoreInterval = -1
+	s, err = storage.Open("file://"+path, cfg)
+	require.NoError(t, err)
+	before, err := os.ReadFile(path)
+	require.NoError(t, err)
+	for range 5 {
+		require.NoError(t, s.Update(context.TODO(), config.CountType, "requests", nil, int64(1)))
+	}
+	// changes are logged, but snapshot is not saved until close
+	got, _ := os.ReadFile(path)
+	assert.Equal(t, before, got)
+	require.NoError(t, s.(*LocalStorage).Close())
+
+	l := New()
+	require.NoError(t, l.LoadMetricsFromFile(path))
+	assert.Equal(t, int64(6), counterOf(l, "requests"))
+	got, err = os.ReadFile(generationPath(path, 1))
+	require.NoError(t, err)
+	assert.Equal(t, before, got)
+}
@@ -3,157 +3,559 @@ package local
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"os"
+	"fmt"
+	"slices"
 	"sync"
+	"time"
 
 	config "github.com/igortoigildin/go-metrics-altering/config/agent"
 	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
 	"github.com/igortoigildin/go-metrics-altering/pkg/logger"
-	processmap "github.com/igortoigildin/go-metrics-altering/pkg/processMap"
 	"go.uber.org/zap"
 )
 
-const pollCount = "PollCount"
+const (
+	pollCount        = "PollCount"
+	defaultRetention = time.Hour
+)
 
+// LocalStorage keeps series in shards locked independently, so that updates of different series do not contend.
+// Changes of single series hold rm shared and lock their shards, while changes of the whole storage,
+// such as deletion by pattern or checkpoint, hold rm exclusively and need no shard locks.
 type LocalStorage struct {
-	rm       sync.RWMutex
-	Gauge    map[string]float64
-	Counter  map[string]int64
-	strategy MetricAlgo
+	rm          sync.RWMutex
+	shards      [shardCount]*shard
+	retention   time.Duration
+	walMu       sync.Mutex // orders records of the log, taken under shard locks
+	wal         *wal       // nil if write-ahead log is disabled
+	seq         uint64     // sequence number of the last change
+	generations int        // number of kept snapshot generations
+	syncFile    string     // snapshot file saved on every change, empty if saved periodically
+	file        string     // snapshot file saved on Close, empty if metrics are not backed up
+
+	stop context.CancelFunc // stops periodic saving
+	done chan struct{}      // closed once periodic saving is stopped
 }
 
-func New() *LocalStorage {
-	return &LocalStorage{
-		Counter: map[string]int64{pollCount: 0},
-		Gauge:   map[string]float64{},
+// Option configures LocalStorage.
+type Option func(*LocalStorage)
+
+// WithGenerations sets how many generations of the snapshot file are kept.
+func WithGenerations(generations int) Option {
+	return func(m *LocalStorage) {
+		if generations > 0 {
+			m.generations = generations
+		}
 	}
 }
 
-func (m *LocalStorage) setMetricAlgo(metricType string) {
-	if metricType == config.CountType {
-		count := counterRepo{
-			Counter: m.Counter,
-		}
-		m.strategy = &count
-	} else {
-		gauge := gaugeRepo{
-			Gauge: m.Gauge,
+// WithRetention sets how long metric samples are kept in history.
+func WithRetention(retention time.Duration) Option {
+	return func(m *LocalStorage) {
+		if retention > 0 {
+			m.retention = retention
 		}
-		m.strategy = &gauge
 	}
 }
 
-func (m *LocalStorage) Update(ctx context.Context, metricType string, metricName string, metricValue any) error {
-	m.setMetricAlgo(metricType)
+func New(opts ...Option) *LocalStorage {
+	m := &LocalStorage{
+		retention:   defaultRetention,
+		generations: defaultGenerations,
+	}
+	for i := range m.shards {
+		m.shards[i] = newShard()
+	}
+	m.shardOf(pollCount).counter.Counter[pollCount] = 0
 
-	m.rm.Lock()
-	defer m.rm.Unlock()
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// shardOf returns shard of the series with the stated key.
+func (m *LocalStorage) shardOf(key string) *shard {
+	return m.shards[shardIndex(key)]
+}
+
+// lockShards locks shards of the series in index order, so that concurrent batches never deadlock,
+// and returns function releasing the locks. When snapshot is saved on every change, the whole storage
+// is locked instead, as the snapshot must not see changes in progress.
+func (m *LocalStorage) lockShards(keys ...string) (unlock func()) {
+	m.rm.RLock()
+	if m.syncFile != "" {
+		m.rm.RUnlock()
+		m.rm.Lock()
+		return m.rm.Unlock
+	}
+
+	var locked [shardCount]bool
+	for _, key := range keys {
+		locked[shardIndex(key)] = true
+	}
+	for i, ok := range locked {
+		if ok {
+			m.shards[i].mu.Lock()
+		}
+	}
+	return func() {
+		for i, ok := range locked {
+			if ok {
+				m.shards[i].mu.Unlock()
+			}
+		}
+		m.rm.RUnlock()
+	}
+}
 
-	err := m.strategy.Update(metricType, metricName, metricValue)
+func (m *LocalStorage) Update(ctx context.Context, metricType string, metricName string, labels models.Labels, metricValue any) error {
+	metric, err := models.NewMetric(metricType, metricName, labels, metricValue)
 	if err != nil {
 		logger.Log.Error("error while updating metric", zap.Error(err))
 		return err
 	}
+
+	unlock := m.lockShards(models.SeriesKey(metricName, labels))
+	defer unlock()
+
+	// validated and logged before it is applied, so that change failed to be logged is not seen
+	batch := []models.Metrics{metric}
+	if err = m.validateBatch(batch); err != nil {
+		logger.Log.Info("error while updating metric", zap.Error(err))
+		return err
+	}
+	if err = m.appendWAL(walRecord{Op: opUpdate, Metrics: batch}); err != nil {
+		return err
+	}
+	if err = m.apply(metric, time.Now()); err != nil {
+		logger.Log.Error("error while updating metric", zap.Error(err))
+		return err
+	}
+	return m.syncSnapshot()
+}
+
+// UpdateBatch applies all the metrics with shards of all their series locked. The batch is validated
+// before any change, so either all metrics are applied or none of them.
+func (m *LocalStorage) UpdateBatch(ctx context.Context, metrics []models.Metrics) error {
+	keys := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		keys = append(keys, models.SeriesKey(metric.ID, metric.Labels))
+	}
+	unlock := m.lockShards(keys...)
+	defer unlock()
+
+	if err := m.validateBatch(metrics); err != nil {
+		logger.Log.Info("batch rejected", zap.Error(err))
+		return err
+	}
+	if err := m.appendWAL(walRecord{Op: opUpdate, Metrics: metrics}); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, metric := range metrics {
+		if err := m.apply(metric, now); err != nil {
+			// unreachable as the batch is validated, but never ignore it
+			logger.Log.Error("error while updating metric", zap.Error(err))
+			return err
+		}
+	}
+	return m.syncSnapshot()
+}
+
+// apply stores the metric and records it to history. Caller must hold the write lock of the shard.
+func (m *LocalStorage) apply(metric models.Metrics, ts time.Time) error {
+	var value any
+	switch metric.MType {
+	case config.GaugeType:
+		value = *metric.Value
+	case config.CountType:
+		value = *metric.Delta
+	case config.HistogramType:
+		value = metric.Histogram
+	}
+
+	key := models.SeriesKey(metric.ID, metric.Labels)
+	s := m.shardOf(key)
+	if err := s.strategy(metric.MType).Update(metric.MType, key, value); err != nil {
+		return err
+	}
+	s.setLabels(key, metric.Labels)
+	s.record(metric.MType, key, ts, m.retention)
 	return nil
 }
 
-func (m *LocalStorage) Get(ctx context.Context, metricType string, metricName string) (models.Metrics, error) {
-	m.setMetricAlgo(metricType)
+// validateBatch checks whether every metric of the batch can be applied. Caller must hold locks of the shards.
+func (m *LocalStorage) validateBatch(metrics []models.Metrics) error {
+	// bounds of histograms, which are stored or will be stored by the batch
+	bounds := make(map[string][]float64)
+	for _, metric := range metrics {
+		switch metric.MType {
+		case config.GaugeType:
+			if metric.Value == nil {
+				return fmt.Errorf("%w: value of gauge %q not provided", storage.ErrInvalidValue, metric.ID)
+			}
+		case config.CountType:
+			if metric.Delta == nil {
+				return fmt.Errorf("%w: delta of counter %q not provided", storage.ErrInvalidValue, metric.ID)
+			}
+		case config.HistogramType:
+			if err := metric.Histogram.Validate(); err != nil {
+				return fmt.Errorf("%w: %w", storage.ErrInvalidValue, err)
+			}
+			key := models.SeriesKey(metric.ID, metric.Labels)
+			b, ok := bounds[key]
+			if !ok {
+				if stored, exists := m.shardOf(key).histogram.Histogram[key]; exists {
+					b, ok = stored.Bounds, true
+				}
+			}
+			if ok && !slices.Equal(b, metric.Histogram.Bounds) {
+				return fmt.Errorf("%w: %w: %q", storage.ErrInvalidValue, models.ErrBoundsMismatch, metric.ID)
+			}
+			bounds[key] = metric.Histogram.Bounds
+		default:
+			return fmt.Errorf("%w: unsupported metric type %q", storage.ErrTypeConflict, metric.MType)
+		}
+	}
+	return nil
+}
+
+// History returns samples of the metric recorded between from and to, downsampled by step.
+func (m *LocalStorage) History(ctx context.Context, metricType string, metricName string, labels models.Labels, from, to time.Time, step time.Duration) ([]models.Sample, error) {
+	key := models.SeriesKey(metricName, labels)
+	s := m.shardOf(key)
 
 	m.rm.RLock()
 	defer m.rm.RUnlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var samples []models.Sample
+	switch metricType {
+	case config.GaugeType:
+		samples = s.gaugeHistory[key]
+	case config.CountType:
+		samples = s.counterHistory[key]
+	default:
+		return nil, fmt.Errorf("%w: history of %q is not recorded", storage.ErrTypeConflict, metricType)
+	}
+
+	res := make([]models.Sample, 0, len(samples))
+	for _, sample := range samples {
+		if sample.Timestamp.Before(from) || sample.Timestamp.After(to) {
+			continue
+		}
+		res = append(res, sample)
+	}
+	return models.Downsample(res, from, step), nil
+}
 
-	metric, err := m.strategy.Get(metricType, metricName)
+func (m *LocalStorage) Get(ctx context.Context, metricType string, metricName string, labels models.Labels) (models.Metrics, error) {
+	if metricType != config.GaugeType && metricType != config.CountType && metricType != config.HistogramType {
+		return models.Metrics{}, fmt.Errorf("%w: unsupported metric type %q", storage.ErrTypeConflict, metricType)
+	}
+	key := models.SeriesKey(metricName, labels)
+	s := m.shardOf(key)
+
+	m.rm.RLock()
+	defer m.rm.RUnlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	metric, err := s.strategy(metricType).Get(metricType, key)
 	if err != nil {
-		logger.Log.Error("error while getting metric", zap.Error(err))
+		logger.Log.Info("error while getting metric", zap.Error(err))
 		return models.Metrics{}, err
 	}
+	metric.ID = metricName
+	metric.Labels = labels
 	return metric, nil
 }
 
-func (m *LocalStorage) GetAll(ctx context.Context) (map[string]any, error) {
+// List returns page of series selected by the filter, sorted by type and series key, and cursor of the next page.
+// Shards are read one by one, so the page is not a snapshot of the whole storage.
+func (m *LocalStorage) List(ctx context.Context, filter models.ListFilter) ([]models.Metrics, string, error) {
+	m.rm.RLock()
+	var res []models.Metrics
+	for _, s := range m.shards {
+		s.mu.RLock()
+		for _, metric := range s.metrics() {
+			if filter.Match(metric) {
+				res = append(res, metric)
+			}
+		}
+		s.mu.RUnlock()
+	}
+	m.rm.RUnlock()
+
+	page, next, err := filter.Page(res)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %w", storage.ErrInvalidValue, err)
+	}
+	return page, next, nil
+}
+
+// Delete removes series of the stated type, which names match the glob pattern and labels satisfy the matchers,
+// together with their history. Empty metric type stands for all types. Returns number of deleted series.
+func (m *LocalStorage) Delete(ctx context.Context, metricType string, pattern string, matchers ...*models.LabelMatcher) (int, error) {
 	m.rm.Lock()
 	defer m.rm.Unlock()
 
-	res := processmap.ConvertToSingleMap(m.Gauge, m.Counter)
+	n := m.deleteSeries(metricType, pattern, matchers)
+	if n == 0 {
+		return 0, nil
+	}
+	return n, m.log(walRecord{Op: opDelete, Type: metricType, Pattern: pattern, Matchers: models.MatcherStrings(matchers)})
+}
+
+// deleteSeries deletes matching series. Caller must hold the exclusive lock.
+func (m *LocalStorage) deleteSeries(metricType string, pattern string, matchers []*models.LabelMatcher) int {
+	var n int
+	for _, s := range m.shards {
+		if metricType == "" || metricType == config.GaugeType {
+			for key := range s.gauge.Gauge {
+				if s.matchSeries(key, pattern, matchers) {
+					delete(s.gauge.Gauge, key)
+					delete(s.gaugeHistory, key)
+					n++
+				}
+			}
+		}
+		if metricType == "" || metricType == config.CountType {
+			for key := range s.counter.Counter {
+				if s.matchSeries(key, pattern, matchers) {
+					delete(s.counter.Counter, key)
+					delete(s.counterHistory, key)
+					n++
+				}
+			}
+		}
+		if metricType == "" || metricType == config.HistogramType {
+			for key := range s.histogram.Histogram {
+				if s.matchSeries(key, pattern, matchers) {
+					delete(s.histogram.Histogram, key)
+					n++
+				}
+			}
+		}
+		// labels are kept while the series key is used by any metric type
+		s.dropUnusedLabels()
+	}
+	return n
+}
+
+// Reset sets to zero series of the stated type, which names match the glob pattern and labels satisfy the matchers.
+// Histograms keep their bounds. Empty metric type stands for all types. Returns number of reset series.
+func (m *LocalStorage) Reset(ctx context.Context, metricType string, pattern string, matchers ...*models.LabelMatcher) (int, error) {
+	m.rm.Lock()
+	defer m.rm.Unlock()
 
-	return res, nil
+	n := m.resetSeries(metricType, pattern, matchers, time.Now())
+	if n == 0 {
+		return 0, nil
+	}
+	return n, m.log(walRecord{Op: opReset, Type: metricType, Pattern: pattern, Matchers: models.MatcherStrings(matchers)})
 }
 
-// LoadMetricsFromFile loads metrics from the stated file.
+// resetSeries sets matching series to zero. Caller must hold the exclusive lock.
+func (m *LocalStorage) resetSeries(metricType string, pattern string, matchers []*models.LabelMatcher, now time.Time) int {
+	var n int
+	for _, s := range m.shards {
+		if metricType == "" || metricType == config.GaugeType {
+			for key := range s.gauge.Gauge {
+				if s.matchSeries(key, pattern, matchers) {
+					s.gauge.Gauge[key] = 0
+					s.record(config.GaugeType, key, now, m.retention)
+					n++
+				}
+			}
+		}
+		if metricType == "" || metricType == config.CountType {
+			for key := range s.counter.Counter {
+				if s.matchSeries(key, pattern, matchers) {
+					s.counter.Counter[key] = 0
+					s.record(config.CountType, key, now, m.retention)
+					n++
+				}
+			}
+		}
+		if metricType == "" || metricType == config.HistogramType {
+			for key, h := range s.histogram.Histogram {
+				if s.matchSeries(key, pattern, matchers) {
+					h.Reset()
+					n++
+				}
+			}
+		}
+	}
+	return n
+}
+
+// metrics returns all stored series. Caller must hold the exclusive lock.
+func (m *LocalStorage) metrics() []models.Metrics {
+	var res []models.Metrics
+	for _, s := range m.shards {
+		res = append(res, s.metrics()...)
+	}
+	return res
+}
+
+// LoadMetricsFromFile loads metrics from the newest valid generation of the stated snapshot file.
 func (m *LocalStorage) LoadMetricsFromFile(fname string) error {
-	data, err := os.ReadFile(fname)
+	snap, err := loadSnapshot(fname, m.generations)
 	if err != nil {
 		return err
 	}
-	var metricSlice []models.Metrics
-	if err := json.Unmarshal(data, &metricSlice); err != nil {
-		return err
-	}
 
 	m.rm.Lock()
 	defer m.rm.Unlock()
 
-	for _, v := range metricSlice {
+	for _, v := range snap.Metrics {
+		if err := validSnapshotMetric(v); err != nil {
+			logger.Log.Info("skipping metric from snapshot", zap.String("name", v.ID), zap.Error(err))
+			continue
+		}
+		key := models.SeriesKey(v.ID, v.Labels)
+		s := m.shardOf(key)
 		if v.MType == "gauge" {
-			m.Gauge[v.ID] = *v.Value
+			s.gauge.Gauge[key] = *v.Value
 		} else if v.MType == "counter" {
-			m.Counter[v.ID] = *v.Delta
+			s.counter.Counter[key] = *v.Delta
+		} else if v.MType == "histogram" {
+			s.histogram.Histogram[key] = v.Histogram
 		}
+		s.setLabels(key, v.Labels)
 	}
+	m.seq = snap.Seq
 	return nil
 }
 
-func (m *LocalStorage) Ping(ctx context.Context) error {
-	if m.Gauge == nil {
-		logger.Log.Info("gauge local storage not initialized")
-		return errors.New("gauge local storage not initialized")
+// validSnapshotMetric checks that the snapshot entry holds the value of its type,
+// so that loaded series can be read and updated later.
+func validSnapshotMetric(v models.Metrics) error {
+	switch v.MType {
+	case "gauge":
+		if v.Value == nil {
+			return errors.New("gauge value not provided")
+		}
+	case "counter":
+		if v.Delta == nil {
+			return errors.New("counter value not provided")
+		}
+	case "histogram":
+		return v.Histogram.Validate()
 	}
+	return nil
+}
 
-	if m.Counter == nil {
-		logger.Log.Info("counter local storage not initialized")
-		return errors.New("counter local storage not initialized")
+func (m *LocalStorage) Ping(ctx context.Context) error {
+	if m.shards[0] == nil {
+		logger.Log.Info("local storage not initialized")
+		return errors.New("local storage not initialized")
 	}
 	return nil
 }
 
-// SaveAllMetricsToFile periodically saves metrics from local storage to provided file.
+// SaveAllMetricsToFile periodically checkpoints metrics from local storage to provided file until the storage is closed.
+// Zero interval makes saving synchronous: metrics are saved once and then on every change.
 func (m *LocalStorage) SaveAllMetricsToFile(FlagStoreInterval int, FlagStorePath string, fname string) error {
-	//pauseDuration := time.Duration(FlagStoreInterval) * time.Second
-	for {
-		//time.Sleep(pauseDuration)
-		metrics, _ := m.GetAll(context.Background())
-		slice := []models.Metrics{}
-
-		for key, v := range metrics {
-			var metric models.Metrics
-			if val, ok := v.(float64); ok {
-				metric.ID = key
-				metric.Value = &val
-				metric.MType = config.GaugeType
-				slice = append(slice, metric)
-				continue
-			}
-			if val, ok := v.(int64); ok {
-				metric.ID = key
-				metric.Delta = &val
-				metric.MType = config.CountType
-				slice = append(slice, metric)
-			}
-		}
+	if FlagStoreInterval <= 0 {
+		m.rm.Lock()
+		defer m.rm.Unlock()
 
-		data, err := json.MarshalIndent(slice, "", "  ")
-		if err != nil {
-			logger.Log.Info("marshalling error", zap.Error(err))
+		if err := m.checkpoint(fname); err != nil {
+			logger.Log.Info("error saving metrics to the file", zap.Error(err))
 			return err
 		}
+		m.syncFile = fname
+		m.file = fname
+		return nil
+	}
+
+	<-m.startSaving(time.Duration(FlagStoreInterval)*time.Second, fname)
+	return nil
+}
+
+// startSaving starts checkpointing metrics to the file every interval and returns channel closed once saving is stopped.
+func (m *LocalStorage) startSaving(interval time.Duration, fname string) <-chan struct{} {
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	m.rm.Lock()
+	m.stop, m.done, m.file = stop, done, fname
+	m.rm.Unlock()
+
+	go m.saveLoop(ctx, interval, fname, done)
+	return done
+}
+
+// saveLoop checkpoints metrics until ctx is cancelled. Failed checkpoint is retried on the next tick,
+// while write-ahead log keeps the changes.
+func (m *LocalStorage) saveLoop(ctx context.Context, interval time.Duration, fname string, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-		err = os.WriteFile(fname, data, 0606)
-		if err != nil {
+	for {
+		if err := m.Checkpoint(fname); err != nil {
 			logger.Log.Info("error saving metrics to the file", zap.Error(err))
-			return err
 		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops periodic saving, saves metrics to the backup file for the last time and closes write-ahead log.
+// Changes made after Close are kept in memory only.
+func (m *LocalStorage) Close() error {
+	m.rm.Lock()
+	stop, done := m.stop, m.done
+	m.stop, m.done = nil, nil
+	m.rm.Unlock()
+	if stop != nil {
+		stop()
+		<-done
+	}
+
+	m.rm.Lock()
+	defer m.rm.Unlock()
+
+	var err error
+	if m.file != "" {
+		err = m.checkpoint(m.file)
+	}
+	if m.wal != nil {
+		err = errors.Join(err, m.wal.f.Close())
+	}
+	m.file, m.syncFile, m.wal = "", "", nil
+	return err
+}
+
+// Checkpoint saves all metrics to the file and truncates write-ahead log, as its records are in the file now.
+// The file is replaced atomically, so it is never left partially written.
+func (m *LocalStorage) Checkpoint(fname string) error {
+	m.rm.Lock()
+	defer m.rm.Unlock()
+
+	return m.checkpoint(fname)
+}
+
+// checkpoint is Checkpoint without locking. Caller must hold the exclusive lock.
+func (m *LocalStorage) checkpoint(fname string) error {
+	if err := saveSnapshot(fname, m.generations, snapshot{Seq: m.seq, Metrics: m.metrics()}); err != nil {
+		return err
+	}
+
+	if m.wal == nil {
+		return nil
 	}
+	return m.wal.truncate()
 }
@@ -4,67 +4,45 @@ import (
 	"context"
 	"encoding/json"
 	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	config "github.com/igortoigildin/go-metrics-altering/config/agent"
 	"github.com/igortoigildin/go-metrics-altering/internal/models"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestLocalStorage_SetStrategy(t *testing.T) {
-	type fields struct {
-		Gauge    map[string]float64
-		Counter  map[string]int64
-		strategy MetricAlgo
-	}
+func Test_shard_strategy(t *testing.T) {
+	s := newShard()
 
-	tests := []struct {
-		name       string
-		fields     fields
-		metricType string
-	}{
-		{
-			name:       "Success",
-			fields:     fields{},
-			metricType: "counter",
-		},
-		{
-			name:       "Success",
-			fields:     fields{},
-			metricType: "gauge",
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			m := &LocalStorage{
-				Gauge:    tt.fields.Gauge,
-				Counter:  tt.fields.Counter,
-				strategy: tt.fields.strategy,
-			}
-			m.setMetricAlgo(tt.metricType)
-
-			assert.True(t, m.strategy != nil)
-		})
-	}
+	_, ok := s.strategy(config.CountType).(*counterRepo)
+	assert.True(t, ok)
+	_, ok = s.strategy(config.GaugeType).(*gaugeRepo)
+	assert.True(t, ok)
+	_, ok = s.strategy(config.HistogramType).(*histogramRepo)
+	assert.True(t, ok)
 }
 
 func TestInitLocalStorage(t *testing.T) {
-	tests := []struct {
-		name      string
-		wantError bool
-	}{
-		{
-			name:      "Success",
-			wantError: false,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			a := New()
-			assert.True(t, a.Counter != nil)
-			assert.True(t, a.Gauge != nil)
-		})
+	a := New()
+	for _, s := range a.shards {
+		assert.NotNil(t, s)
 	}
+	assert.Equal(t, int64(0), counterOf(a, pollCount))
+}
+
+// counterOf returns stored value of the counter series.
+func counterOf(m *LocalStorage, key string) int64 {
+	return m.shardOf(key).counter.Counter[key]
+}
+
+// gaugeOf returns stored value of the gauge series.
+func gaugeOf(m *LocalStorage, key string) float64 {
+	return m.shardOf(key).gauge.Gauge[key]
 }
 
 func TestLocalStorage_Update(t *testing.T) {
@@ -124,17 +102,14 @@ func TestLocalStorage_Update(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		_ = m.Update(tt.args.ctx, tt.args.metricType, tt.args.metricName, tt.args.metricValue)
+		err := m.Update(tt.args.ctx, tt.args.metricType, tt.args.metricName, nil, tt.args.metricValue)
+		assert.Equal(t, tt.wantErr, err != nil, tt.name)
 
 		switch tt.args.metricType {
 		case "gauge":
-			res, _ := tt.args.metricValue.(float64)
-
-			assert.Equal(t, res, m.Gauge[tt.args.metricName])
+			assert.Equal(t, g, gaugeOf(m, tt.args.metricName))
 		case "counter":
-			res, _ := tt.args.metricValue.(int64)
-
-			assert.Equal(t, res, m.Counter[tt.args.metricName])
+			assert.Equal(t, c, counterOf(m, tt.args.metricName))
 		}
 
 	}
@@ -142,10 +117,10 @@ func TestLocalStorage_Update(t *testing.T) {
 
 func TestLocalStorage_Get(t *testing.T) {
 	m := New()
-	m.Counter["count_metric"] = int64(25)
-	m.Gauge["gauge_metric"] = float64(50)
-	m1 := m.Counter["count_metric"]
-	m2 := m.Gauge["gauge_metric"]
+	_ = m.Update(context.TODO(), config.CountType, "count_metric", nil, int64(25))
+	_ = m.Update(context.TODO(), config.GaugeType, "gauge_metric", nil, float64(50))
+	m1 := counterOf(m, "count_metric")
+	m2 := gaugeOf(m, "gauge_metric")
 
 	type args struct {
 		ctx        context.Context
@@ -201,7 +176,7 @@ func TestLocalStorage_Get(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		res, _ := m.Get(tt.args.ctx, tt.args.metricType, tt.args.metricName)
+		res, _ := m.Get(tt.args.ctx, tt.args.metricType, tt.args.metricName, nil)
 
 		switch tt.args.metricType {
 		case "gauge":
@@ -218,21 +193,40 @@ func TestLocalStorage_Get(t *testing.T) {
 	}
 }
 
-func TestLocalStorage_GetAll(t *testing.T) {
+func TestLocalStorage_List(t *testing.T) {
 	m := New()
-	m.Counter["count_metric"] = int64(25)
-	m.Gauge["gauge_metric"] = float64(50)
+	_ = m.Update(context.TODO(), config.CountType, "count_metric", nil, int64(25))
+	_ = m.Update(context.TODO(), config.GaugeType, "gauge_metric", nil, float64(50))
+	_ = m.Update(context.TODO(), config.GaugeType, "count_metric", nil, float64(75))
 
 	res := make(map[string]any)
-	res["count_metric"] = int64(25)
-	res["gauge_metric"] = float64(50)
-	res[pollCount] = 0
+	res["counter/count_metric"] = int64(25)
+	res["gauge/gauge_metric"] = float64(50)
+	res["gauge/count_metric"] = float64(75)
+	res["counter/"+pollCount] = int64(0)
 
-	output, _ := m.GetAll(context.TODO())
+	output, next, err := m.List(context.TODO(), models.ListFilter{})
+	assert.NoError(t, err)
+	assert.Empty(t, next)
+
+	// gauge and counter of the same name do not collide
+	got := make(map[string]any)
+	for _, metric := range output {
+		if metric.Delta != nil {
+			got[metric.MType+"/"+metric.ID] = *metric.Delta
+		} else {
+			got[metric.MType+"/"+metric.ID] = *metric.Value
+		}
+	}
+	assert.Equal(t, res, got)
 
-	assert.Equal(t, res["count_metric"], output["count_metric"])
-	assert.Equal(t, res["gauge_metric"], output["gauge_metric"])
-	assert.Equal(t, len(res), len(output))
+	output, next, err = m.List(context.TODO(), models.ListFilter{Prefix: "count_", Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, config.CountType, output[0].MType)
+	output, next, err = m.List(context.TODO(), models.ListFilter{Prefix: "count_", Limit: 1, Cursor: next})
+	assert.NoError(t, err)
+	assert.Equal(t, config.GaugeType, output[0].MType)
+	assert.Empty(t, next)
 }
 
 func TestLocalStorage_Ping(t *testing.T) {
@@ -248,27 +242,10 @@ func TestLocalStorage_Ping(t *testing.T) {
 func TestLocalStorage_LoadMetricsFromFile(t *testing.T) {
 	// Input data preparation: initializing storage with data.
 	m := New()
-	m.Counter["count_metric"] = int64(25)
-	m.Gauge["gauge_metric"] = float64(50)
-	metrics, _ := m.GetAll(context.Background())
+	_ = m.Update(context.TODO(), config.CountType, "count_metric", nil, int64(25))
+	_ = m.Update(context.TODO(), config.GaugeType, "gauge_metric", nil, float64(50))
 	// Input data preparation: filling slice with data from storage.
-	slice := []models.Metrics{}
-	for key, v := range metrics {
-		var metric models.Metrics
-		if val, ok := v.(float64); ok {
-			metric.ID = key
-			metric.Value = &val
-			metric.MType = config.GaugeType
-			slice = append(slice, metric)
-			continue
-		}
-		if val, ok := v.(int64); ok {
-			metric.ID = key
-			metric.Delta = &val
-			metric.MType = config.CountType
-			slice = append(slice, metric)
-		}
-	}
+	slice, _, _ := m.List(context.Background(), models.ListFilter{})
 	data, _ := json.MarshalIndent(slice, "", "  ")
 	// Input data preparation: writing data to file.
 	_ = os.WriteFile("temp", data, 0606)
@@ -276,15 +253,15 @@ func TestLocalStorage_LoadMetricsFromFile(t *testing.T) {
 	l := New()
 
 	_ = l.LoadMetricsFromFile("temp")
-	assert.Equal(t, m.Counter["count_metric"], l.Counter["count_metric"])
-	assert.Equal(t, m.Counter["gauge_metric"], l.Counter["gauge_metric"])
+	assert.Equal(t, counterOf(m, "count_metric"), counterOf(l, "count_metric"))
+	assert.Equal(t, counterOf(m, "gauge_metric"), counterOf(l, "gauge_metric"))
 }
 
 func TestLocalStorage_SaveAllMetricsToFile(t *testing.T) {
 	var fileName = "temp"
 	m := New()
-	m.Counter["count_metric"] = int64(25)
-	m.Gauge["gauge_metric"] = float64(50)
+	_ = m.Update(context.TODO(), config.CountType, "count_metric", nil, int64(25))
+	_ = m.Update(context.TODO(), config.GaugeType, "gauge_metric", nil, float64(50))
 
 	go m.SaveAllMetricsToFile(0, ".", fileName)
 
@@ -295,7 +272,228 @@ func TestLocalStorage_SaveAllMetricsToFile(t *testing.T) {
 
 	l := New()
 	_ = l.LoadMetricsFromFile(fileName)
-	assert.Equal(t, m.Counter["count_metric"], l.Counter["count_metric"])
-	assert.Equal(t, m.Counter["gauge_metric"], l.Counter["gauge_metric"])
+	assert.Equal(t, counterOf(m, "count_metric"), counterOf(l, "count_metric"))
+	assert.Equal(t, counterOf(m, "gauge_metric"), counterOf(l, "gauge_metric"))
 	_ = os.Remove(fileName)
 }
+
+func TestLocalStorage_History(t *testing.T) {
+	m := New(WithRetention(time.Minute))
+
+	_ = m.Update(context.TODO(), config.GaugeType, "gauge_metric", nil, float64(1))
+	_ = m.Update(context.TODO(), config.GaugeType, "gauge_metric", nil, float64(2))
+	_ = m.Update(context.TODO(), config.CountType, "count_metric", nil, int64(5))
+	_ = m.Update(context.TODO(), config.CountType, "count_metric", nil, int64(5))
+
+	now := time.Now()
+
+	gauges, err := m.History(context.TODO(), config.GaugeType, "gauge_metric", nil, now.Add(-time.Minute), now, 0)
+	assert.NoError(t, err)
+	assert.Len(t, gauges, 2)
+	assert.Equal(t, float64(2), gauges[1].Value)
+
+	counters, err := m.History(context.TODO(), config.CountType, "count_metric", nil, now.Add(-time.Minute), now, 0)
+	assert.NoError(t, err)
+	assert.Len(t, counters, 2)
+	assert.Equal(t, float64(10), counters[1].Value)
+
+	downsampled, err := m.History(context.TODO(), config.GaugeType, "gauge_metric", nil, now.Add(-time.Minute), now, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, downsampled, 1)
+
+	_, err = m.History(context.TODO(), "unknown_type", "gauge_metric", nil, now.Add(-time.Minute), now, 0)
+	assert.Error(t, err)
+
+	// samples older than retention are dropped on the next update
+	history := m.shardOf("gauge_metric").gaugeHistory
+	history["gauge_metric"][0].Timestamp = now.Add(-time.Hour)
+	_ = m.Update(context.TODO(), config.GaugeType, "gauge_metric", nil, float64(3))
+	assert.Len(t, history["gauge_metric"], 2)
+}
+
+func TestLocalStorage_Labels(t *testing.T) {
+	m := New()
+	hostA := models.Labels{"host": "a"}
+	hostB := models.Labels{"host": "b"}
+
+	_ = m.Update(context.TODO(), config.GaugeType, "requests", hostA, float64(1))
+	_ = m.Update(context.TODO(), config.GaugeType, "requests", hostB, float64(2))
+	_ = m.Update(context.TODO(), config.GaugeType, "requests", nil, float64(3))
+
+	metric, err := m.Get(context.TODO(), config.GaugeType, "requests", hostB)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(2), *metric.Value)
+	assert.Equal(t, hostB, metric.Labels)
+
+	matcher, _ := models.NewLabelMatcher(models.MatchEqual, "host", "a")
+	all, _, err := m.List(context.TODO(), models.ListFilter{Matchers: []*models.LabelMatcher{matcher}})
+	assert.NoError(t, err)
+	one := float64(1)
+	assert.Equal(t, []models.Metrics{{ID: "requests", MType: config.GaugeType, Value: &one, Labels: hostA}}, all)
+
+	name, labels := m.shardOf(`requests{host="a"}`).seriesName(models.SeriesKey("requests", hostA))
+	assert.Equal(t, "requests", name)
+	assert.Equal(t, hostA, labels)
+}
+
+func TestLocalStorage_Histogram(t *testing.T) {
+	m := New()
+	h := models.NewHistogram([]float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(5)
+
+	err := m.Update(context.TODO(), config.HistogramType, "latency", nil, h)
+	assert.NoError(t, err)
+	err = m.Update(context.TODO(), config.HistogramType, "latency", nil, h)
+	assert.NoError(t, err)
+
+	metric, err := m.Get(context.TODO(), config.HistogramType, "latency", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{2, 0, 2}, metric.Histogram.Counts)
+	assert.Equal(t, uint64(4), metric.Histogram.Count)
+
+	// returned histogram is a copy
+	metric.Histogram.Count = 0
+	all, _, err := m.List(context.TODO(), models.ListFilter{Type: config.HistogramType})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), all[0].Histogram.Count)
+}
+
+func TestLocalStorage_UpdateBatch(t *testing.T) {
+	m := New()
+	gauge := float64(1.5)
+	delta := int64(2)
+	h := &models.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1}
+
+	err := m.UpdateBatch(context.TODO(), []models.Metrics{
+		{ID: "alloc", MType: config.GaugeType, Value: &gauge},
+		{ID: "requests", MType: config.CountType, Delta: &delta, Labels: models.Labels{"host": "a"}},
+		{ID: "requests", MType: config.CountType, Delta: &delta, Labels: models.Labels{"host": "a"}},
+		{ID: "latency", MType: config.HistogramType, Histogram: h},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, gauge, gaugeOf(m, "alloc"))
+	assert.Equal(t, int64(4), counterOf(m, `requests{host="a"}`))
+	assert.Equal(t, uint64(1), m.shardOf("latency").histogram.Histogram["latency"].Count)
+	assert.Len(t, m.shardOf(`requests{host="a"}`).counterHistory[`requests{host="a"}`], 2)
+
+	// nothing is applied if any metric of the batch is rejected
+	tests := []struct {
+		name    string
+		metrics []models.Metrics
+		wantErr error
+	}{
+		{
+			name: "Bounds mismatch",
+			metrics: []models.Metrics{
+				{ID: "alloc", MType: config.GaugeType, Value: &gauge},
+				{ID: "latency", MType: config.HistogramType, Histogram: &models.Histogram{Bounds: []float64{2}, Counts: []uint64{1, 0}, Count: 1}},
+			},
+			wantErr: models.ErrBoundsMismatch,
+		},
+		{
+			name: "Value not provided",
+			metrics: []models.Metrics{
+				{ID: "requests", MType: config.CountType, Delta: &delta, Labels: models.Labels{"host": "a"}},
+				{ID: "alloc", MType: config.GaugeType},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.UpdateBatch(context.TODO(), tt.metrics)
+			assert.Error(t, err)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+			assert.Equal(t, int64(4), counterOf(m, `requests{host="a"}`))
+			assert.Len(t, m.shardOf("alloc").gaugeHistory["alloc"], 1)
+		})
+	}
+}
+
+func TestLocalStorage_DeleteReset(t *testing.T) {
+	m := New()
+	_ = m.Update(context.TODO(), config.GaugeType, "HeapAlloc", nil, float64(1))
+	_ = m.Update(context.TODO(), config.GaugeType, "HeapSys", models.Labels{"host": "a"}, float64(2))
+	_ = m.Update(context.TODO(), config.GaugeType, "Alloc", nil, float64(3))
+	_ = m.Update(context.TODO(), config.CountType, "requests", models.Labels{"host": "a"}, int64(5))
+	_ = m.Update(context.TODO(), config.HistogramType, "latency", nil, &models.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1})
+
+	n, err := m.Reset(context.TODO(), config.CountType, "requests")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, int64(0), counterOf(m, `requests{host="a"}`))
+
+	n, err = m.Reset(context.TODO(), "", "latency")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []uint64{0, 0}, m.shardOf("latency").histogram.Histogram["latency"].Counts)
+
+	matcher, _ := models.NewLabelMatcher(models.MatchEqual, "host", "a")
+	n, err = m.Delete(context.TODO(), config.GaugeType, "Heap*", matcher)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	heapSys := m.shardOf(`HeapSys{host="a"}`)
+	assert.NotContains(t, heapSys.gauge.Gauge, `HeapSys{host="a"}`)
+	assert.NotContains(t, heapSys.gaugeHistory, `HeapSys{host="a"}`)
+	assert.NotContains(t, heapSys.labels, `HeapSys{host="a"}`)
+	assert.Contains(t, m.shardOf("HeapAlloc").gauge.Gauge, "HeapAlloc")
+
+	// labels of the counter with the same key are kept
+	assert.Contains(t, m.shardOf(`requests{host="a"}`).labels, `requests{host="a"}`)
+
+	n, err = m.Delete(context.TODO(), "", "*")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Empty(t, m.metrics())
+	for _, s := range m.shards {
+		assert.Empty(t, s.labels)
+	}
+
+	n, err = m.Delete(context.TODO(), config.GaugeType, "Alloc")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestLocalStorage_concurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.wal")
+	m := New()
+	require.NoError(t, m.OpenWAL(path))
+
+	const agents, updates = 32, 50
+	var wg sync.WaitGroup
+	for a := 0; a < agents; a++ {
+		wg.Add(1)
+		go func(a int) {
+			defer wg.Done()
+			host := models.Labels{"host": strconv.Itoa(a % 4)}
+			delta, value := int64(1), float64(a)
+			for i := 0; i < updates; i++ {
+				assert.NoError(t, m.Update(context.TODO(), config.CountType, "requests", host, int64(1)))
+				assert.NoError(t, m.UpdateBatch(context.TODO(), []models.Metrics{
+					{ID: "requests", MType: config.CountType, Delta: &delta},
+					{ID: "load", MType: config.GaugeType, Value: &value, Labels: host},
+				}))
+				_, _ = m.Get(context.TODO(), config.CountType, "requests", host)
+				_, _, _ = m.List(context.TODO(), models.ListFilter{Prefix: "req"})
+				if i%10 == 0 {
+					_, _ = m.Reset(context.TODO(), config.GaugeType, "load")
+				}
+			}
+		}(a)
+	}
+	wg.Wait()
+
+	var total int64
+	for i := 0; i < 4; i++ {
+		total += counterOf(m, models.SeriesKey("requests", models.Labels{"host": strconv.Itoa(i)}))
+	}
+	assert.Equal(t, int64(agents*updates), total)
+	assert.Equal(t, int64(agents*updates), counterOf(m, "requests"))
+
+	// log has the changes in the order they were applied
+	l := New()
+	require.NoError(t, l.ReplayWAL(path))
+	assert.ElementsMatch(t, m.metrics(), l.metrics())
+}
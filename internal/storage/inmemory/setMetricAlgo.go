@@ -1,11 +1,14 @@
 package local
 
 import (
-	"sync"
+	"fmt"
 
 	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
 )
 
+// MetricAlgo stores series of a single metric type. Repositories are not synchronized,
+// they are guarded by the lock of the shard they belong to.
 type MetricAlgo interface {
 	Update(metricType string, metricName string, metricValue any) error
 	Get(metricType string, metricName string) (models.Metrics, error)
@@ -13,7 +16,6 @@ type MetricAlgo interface {
 
 type counterRepo struct {
 	Counter map[string]int64
-	rm      sync.Mutex
 }
 
 func (c *counterRepo) Update(metricType string, metricName string, metricValue any) error {
@@ -21,9 +23,7 @@ func (c *counterRepo) Update(metricType string, metricName string, metricValue a
 		c.Counter = make(map[string]int64)
 	}
 	v, _ := metricValue.(int64)
-	c.rm.Lock()
 	c.Counter[metricName] += v
-	c.rm.Unlock()
 
 	//logger.Log.Info("metric updated successfully", zap.Int64("counter", v))
 
@@ -33,9 +33,10 @@ func (c *counterRepo) Update(metricType string, metricName string, metricValue a
 func (c *counterRepo) Get(metricType string, metricName string) (models.Metrics, error) {
 	var metric models.Metrics
 
-	c.rm.Lock()
-	d := c.Counter[metricName]
-	c.rm.Unlock()
+	d, ok := c.Counter[metricName]
+	if !ok {
+		return metric, storage.ErrNotFound
+	}
 	metric.Delta = &d
 	metric.MType = metricType
 
@@ -44,7 +45,6 @@ func (c *counterRepo) Get(metricType string, metricName string) (models.Metrics,
 
 type gaugeRepo struct {
 	Gauge map[string]float64
-	rm    sync.Mutex
 }
 
 func (g *gaugeRepo) Update(metricType string, metricName string, metricValue any) error {
@@ -52,9 +52,7 @@ func (g *gaugeRepo) Update(metricType string, metricName string, metricValue any
 		g.Gauge = make(map[string]float64)
 	}
 	v, _ := metricValue.(float64)
-	g.rm.Lock()
 	g.Gauge[metricName] = v
-	g.rm.Unlock()
 
 	//logger.Log.Info("metric updated successfully", zap.Float64("gauge", v))
 
@@ -64,10 +62,51 @@ func (g *gaugeRepo) Update(metricType string, metricName string, metricValue any
 func (g *gaugeRepo) Get(metricType string, metricName string) (models.Metrics, error) {
 	var metric models.Metrics
 
-	g.rm.Lock()
-	v := g.Gauge[metricName]
+	v, ok := g.Gauge[metricName]
+	if !ok {
+		return metric, storage.ErrNotFound
+	}
 	metric.Value = &v
-	g.rm.Unlock()
+	metric.MType = metricType
+
+	return metric, nil
+}
+
+type histogramRepo struct {
+	Histogram map[string]*models.Histogram
+}
+
+func (h *histogramRepo) Update(metricType string, metricName string, metricValue any) error {
+	if h.Histogram == nil {
+		h.Histogram = make(map[string]*models.Histogram)
+	}
+	v, ok := metricValue.(*models.Histogram)
+	if !ok {
+		return fmt.Errorf("%w: %w", storage.ErrTypeConflict, models.ErrInvalidHistogram)
+	}
+	if err := v.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", storage.ErrInvalidValue, err)
+	}
+
+	current, ok := h.Histogram[metricName]
+	if !ok {
+		h.Histogram[metricName] = v.Clone()
+		return nil
+	}
+	if err := current.Merge(v); err != nil {
+		return fmt.Errorf("%w: %w", storage.ErrInvalidValue, err)
+	}
+	return nil
+}
+
+func (h *histogramRepo) Get(metricType string, metricName string) (models.Metrics, error) {
+	var metric models.Metrics
+
+	v, ok := h.Histogram[metricName]
+	if !ok {
+		return metric, storage.ErrNotFound
+	}
+	metric.Histogram = v.Clone()
 	metric.MType = metricType
 
 	return metric, nil
@@ -1,7 +1,6 @@
 package local
 
 import (
-	"sync"
 	"testing"
 
 	"github.com/igortoigildin/go-metrics-altering/internal/models"
@@ -11,7 +10,6 @@ import (
 func Test_counterRepo_Update(t *testing.T) {
 	type fields struct {
 		Counter map[string]int64
-		rm      sync.Mutex
 	}
 	type args struct {
 		metricType  string
@@ -62,7 +60,6 @@ func Test_counterRepo_Update(t *testing.T) {
 func Test_gaugeRepo_Update(t *testing.T) {
 	type fields struct {
 		Gauge map[string]float64
-		rm    sync.Mutex
 	}
 	type args struct {
 		metricType  string
@@ -113,7 +110,6 @@ func Test_gaugeRepo_Update(t *testing.T) {
 func Test_counterRepo_Get(t *testing.T) {
 	type fields struct {
 		Counter map[string]int64
-		rm      sync.Mutex
 	}
 	type args struct {
 		metricType string
@@ -165,7 +161,6 @@ func Test_counterRepo_Get(t *testing.T) {
 func Test_gaugeRepo_Get(t *testing.T) {
 	type fields struct {
 		Gauge map[string]float64
-		rm    sync.Mutex
 	}
 	type args struct {
 		metricType string
@@ -213,3 +208,63 @@ func Test_gaugeRepo_Get(t *testing.T) {
 		})
 	}
 }
+
+func Test_histogramRepo_Update(t *testing.T) {
+	type args struct {
+		metricName  string
+		metricValue any
+	}
+	tests := []struct {
+		name      string
+		args      args
+		wantErr   error
+		wantCount uint64
+	}{
+		{
+			name: "New histogram",
+			args: args{
+				metricName:  "new",
+				metricValue: &models.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1},
+			},
+			wantCount: 1,
+		},
+		{
+			name: "Merge into existing",
+			args: args{
+				metricName:  "existing",
+				metricValue: &models.Histogram{Bounds: []float64{1}, Counts: []uint64{0, 1}, Sum: 2, Count: 1},
+			},
+			wantCount: 3,
+		},
+		{
+			name: "Bounds mismatch",
+			args: args{
+				metricName:  "existing",
+				metricValue: &models.Histogram{Bounds: []float64{2}, Counts: []uint64{0, 1}, Sum: 2, Count: 1},
+			},
+			wantErr:   models.ErrBoundsMismatch,
+			wantCount: 2,
+		},
+		{
+			name: "Invalid histogram",
+			args: args{
+				metricName:  "existing",
+				metricValue: &models.Histogram{Bounds: []float64{1}, Counts: []uint64{0}},
+			},
+			wantErr:   models.ErrInvalidHistogram,
+			wantCount: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &histogramRepo{
+				Histogram: map[string]*models.Histogram{
+					"existing": {Bounds: []float64{1}, Counts: []uint64{1, 1}, Sum: 3, Count: 2},
+				},
+			}
+			err := h.Update("histogram", tt.args.metricName, tt.args.metricValue)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.wantCount, h.Histogram[tt.args.metricName].Count)
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package local
+
+import (
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	config "github.com/igortoigildin/go-metrics-altering/config/agent"
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+)
+
+// shardCount is the number of independently locked shards series are spread across.
+const shardCount = 64
+
+// shard holds series, which keys hash to it. Series of all metric types with the same key
+// belong to the same shard, so that they share labels.
+type shard struct {
+	mu             sync.RWMutex
+	gauge          gaugeRepo
+	counter        counterRepo
+	histogram      histogramRepo
+	gaugeHistory   map[string][]models.Sample
+	counterHistory map[string][]models.Sample
+	labels         map[string]models.Labels // labels of every series by series key
+}
+
+func newShard() *shard {
+	return &shard{
+		gauge:          gaugeRepo{Gauge: map[string]float64{}},
+		counter:        counterRepo{Counter: map[string]int64{}},
+		histogram:      histogramRepo{Histogram: map[string]*models.Histogram{}},
+		gaugeHistory:   map[string][]models.Sample{},
+		counterHistory: map[string][]models.Sample{},
+		labels:         map[string]models.Labels{},
+	}
+}
+
+// shardIndex returns index of the shard series with the stated key belong to.
+func shardIndex(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % shardCount)
+}
+
+// strategy returns repository of the metric type.
+func (s *shard) strategy(metricType string) MetricAlgo {
+	switch metricType {
+	case config.CountType:
+		return &s.counter
+	case config.HistogramType:
+		return &s.histogram
+	default:
+		return &s.gauge
+	}
+}
+
+// setLabels remembers labels of the series. Caller must hold the write lock of the shard.
+func (s *shard) setLabels(key string, labels models.Labels) {
+	if len(labels) == 0 {
+		return
+	}
+	s.labels[key] = labels
+}
+
+// seriesName returns metric name and labels of the series with the stated key.
+// Caller must hold the lock of the shard.
+func (s *shard) seriesName(key string) (string, models.Labels) {
+	labels, ok := s.labels[key]
+	if !ok {
+		return key, nil
+	}
+	return strings.TrimSuffix(key, labels.String()), labels
+}
+
+// matchSeries reports whether the series name matches the glob pattern and its labels satisfy the matchers.
+// Caller must hold the lock of the shard.
+func (s *shard) matchSeries(key string, pattern string, matchers []*models.LabelMatcher) bool {
+	name, labels := s.seriesName(key)
+	return models.MatchName(pattern, name) && labels.Matches(matchers)
+}
+
+// record appends current value of the series to its history and drops samples older than retention.
+// Caller must hold the write lock of the shard.
+func (s *shard) record(metricType string, key string, ts time.Time, retention time.Duration) {
+	var history map[string][]models.Sample
+	var sample models.Sample
+
+	switch metricType {
+	case config.GaugeType:
+		history = s.gaugeHistory
+		sample = models.Sample{Timestamp: ts, Value: s.gauge.Gauge[key]}
+	case config.CountType:
+		history = s.counterHistory
+		sample = models.Sample{Timestamp: ts, Value: float64(s.counter.Counter[key])}
+	default:
+		return
+	}
+
+	samples := append(history[key], sample)
+
+	cutoff := ts.Add(-retention)
+	i := sort.Search(len(samples), func(i int) bool {
+		return !samples[i].Timestamp.Before(cutoff)
+	})
+	history[key] = samples[i:]
+}
+
+// metrics returns all series of the shard. Caller must hold the lock of the shard.
+func (s *shard) metrics() []models.Metrics {
+	res := make([]models.Metrics, 0, len(s.gauge.Gauge)+len(s.counter.Counter)+len(s.histogram.Histogram))
+	for key, v := range s.gauge.Gauge {
+		name, labels := s.seriesName(key)
+		res = append(res, models.Metrics{ID: name, MType: config.GaugeType, Value: &v, Labels: labels})
+	}
+	for key, v := range s.counter.Counter {
+		name, labels := s.seriesName(key)
+		res = append(res, models.Metrics{ID: name, MType: config.CountType, Delta: &v, Labels: labels})
+	}
+	for key, v := range s.histogram.Histogram {
+		name, labels := s.seriesName(key)
+		res = append(res, models.Metrics{ID: name, MType: config.HistogramType, Histogram: v.Clone(), Labels: labels})
+	}
+	return res
+}
+
+// dropUnusedLabels forgets labels of series keys, which are not used by any metric type.
+// Caller must hold the write lock of the shard.
+func (s *shard) dropUnusedLabels() {
+	for key := range s.labels {
+		_, gauge := s.gauge.Gauge[key]
+		_, counter := s.counter.Counter[key]
+		_, histogram := s.histogram.Histogram[key]
+		if !gauge && !counter && !histogram {
+			delete(s.labels, key)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package local
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"strconv"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	snapshotMagic      = "metrics-snapshot"
+	snapshotVersion    = 1
+	defaultGenerations = 3
+)
+
+// ErrCorruptedSnapshot is returned when snapshot file is damaged or has unknown format.
+var ErrCorruptedSnapshot = errors.New("snapshot is corrupted")
+
+// snapshot is the content of the file metrics are saved to.
+type snapshot struct {
+	Seq     uint64           `json:"seq"` // sequence number of the last change in snapshot
+	Metrics []models.Metrics `json:"metrics"`
+}
+
+// encodeSnapshot returns snapshot file content: header line with format version and checksum of the payload,
+// followed by the payload in JSON.
+func encodeSnapshot(snap snapshot) ([]byte, error) {
+	payload, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	header := fmt.Sprintf("%s %d %08x\n", snapshotMagic, snapshotVersion, crc32.ChecksumIEEE(payload))
+	return append([]byte(header), payload...), nil
+}
+
+// decodeSnapshot parses snapshot file content and verifies its checksum.
+// Files saved before the header was introduced, either snapshot or plain list of metrics, are accepted as is.
+func decodeSnapshot(data []byte) (snapshot, error) {
+	var snap snapshot
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		var err error
+		if trimmed[0] == '[' {
+			err = json.Unmarshal(trimmed, &snap.Metrics)
+		} else {
+			err = json.Unmarshal(trimmed, &snap)
+		}
+		if err != nil {
+			return snapshot{}, fmt.Errorf("%w: %w", ErrCorruptedSnapshot, err)
+		}
+		return snap, nil
+	}
+
+	header, payload, ok := bytes.Cut(data, []byte("\n"))
+	if !ok {
+		return snapshot{}, fmt.Errorf("%w: no header", ErrCorruptedSnapshot)
+	}
+	var (
+		magic    string
+		version  int
+		checksum uint32
+	)
+	if _, err := fmt.Sscanf(string(header), "%s %d %x", &magic, &version, &checksum); err != nil || magic != snapshotMagic {
+		return snapshot{}, fmt.Errorf("%w: invalid header", ErrCorruptedSnapshot)
+	}
+	if version != snapshotVersion {
+		return snapshot{}, fmt.Errorf("%w: unsupported version %d", ErrCorruptedSnapshot, version)
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return snapshot{}, fmt.Errorf("%w: checksum mismatch", ErrCorruptedSnapshot)
+	}
+	if err := json.Unmarshal(payload, &snap); err != nil {
+		return snapshot{}, fmt.Errorf("%w: %w", ErrCorruptedSnapshot, err)
+	}
+	return snap, nil
+}
+
+// generationPath returns path of the i-th generation of the snapshot, 0 is the newest one.
+func generationPath(fname string, i int) string {
+	if i == 0 {
+		return fname
+	}
+	return fname + "." + strconv.Itoa(i)
+}
+
+// saveSnapshot writes the snapshot to fname, previous generations are shifted, so that
+// fname.1 is the previous snapshot, fname.2 the one before it, and so on. The oldest generation is dropped.
+func saveSnapshot(fname string, generations int, snap snapshot) error {
+	data, err := encodeSnapshot(snap)
+	if err != nil {
+		logger.Log.Info("marshalling error", zap.Error(err))
+		return err
+	}
+
+	tmp := fname + ".tmp"
+	if err = writeFile(tmp, data); err != nil {
+		return err
+	}
+	for i := generations - 1; i > 0; i-- {
+		err = os.Rename(generationPath(fname, i-1), generationPath(fname, i))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(tmp, fname)
+}
+
+// loadSnapshot returns the newest valid generation of the snapshot. Corrupted generations are skipped.
+func loadSnapshot(fname string, generations int) (snapshot, error) {
+	err := os.ErrNotExist
+	for i := 0; i < max(generations, 1); i++ {
+		path := generationPath(fname, i)
+		data, readErr := os.ReadFile(path)
+		if errors.Is(readErr, os.ErrNotExist) {
+			continue
+		}
+		if readErr != nil {
+			return snapshot{}, readErr
+		}
+
+		snap, decodeErr := decodeSnapshot(data)
+		if decodeErr != nil {
+			logger.Log.Info("snapshot generation skipped", zap.String("file", path), zap.Error(decodeErr))
+			err = decodeErr
+			continue
+		}
+		if i > 0 {
+			logger.Log.Info("metrics restored from older snapshot generation", zap.String("file", path))
+		}
+		return snap, nil
+	}
+	return snapshot{}, err
+}
+
+// writeFile writes data to the file and syncs it to disk.
+func writeFile(fname string, data []byte) error {
+	f, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0606)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
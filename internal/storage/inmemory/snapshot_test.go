@@ -0,0 +1,162 @@
+package local
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	config "github.com/igortoigildin/go-metrics-altering/config/agent"
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_decodeSnapshot(t *testing.T) {
+	value := float64(1.5)
+	snap := snapshot{Seq: 7, Metrics: []models.Metrics{{ID: "Alloc", MType: config.GaugeType, Value: &value}}}
+	encoded, err := encodeSnapshot(snap)
+	require.NoError(t, err)
+
+	damaged := append([]byte{}, encoded...)
+	damaged[len(damaged)-3] = '9'
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    snapshot
+		wantErr bool
+	}{
+		{name: "Current format", data: encoded, want: snap},
+		{name: "Legacy snapshot", data: []byte(`{"seq":7,"metrics":[{"id":"Alloc","type":"gauge","value":1.5}]}`), want: snap},
+		{name: "Legacy list", data: []byte(`[{"id":"Alloc","type":"gauge","value":1.5}]`), want: snapshot{Metrics: snap.Metrics}},
+		{name: "Checksum mismatch", data: damaged, wantErr: true},
+		{name: "Truncated", data: encoded[:len(encoded)/2], wantErr: true},
+		{name: "Unknown version", data: []byte("metrics-snapshot 2 00000000\n{}"), wantErr: true},
+		{name: "Garbage", data: []byte("abc"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeSnapshot(tt.data)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrCorruptedSnapshot)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_saveSnapshot_generations(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "metrics.json")
+
+	for seq := uint64(1); seq <= 5; seq++ {
+		require.NoError(t, saveSnapshot(fname, 3, snapshot{Seq: seq}))
+	}
+
+	for i, want := range []uint64{5, 4, 3} {
+		data, err := os.ReadFile(generationPath(fname, i))
+		require.NoError(t, err)
+		snap, err := decodeSnapshot(data)
+		require.NoError(t, err)
+		assert.Equal(t, want, snap.Seq)
+	}
+	assert.NoFileExists(t, generationPath(fname, 3))
+	assert.NoFileExists(t, fname+".tmp")
+}
+
+func TestLocalStorage_LoadMetricsFromFile_fallback(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "metrics.json")
+
+	m := New()
+	_ = m.Update(context.TODO(), config.CountType, "requests", nil, int64(1))
+	require.NoError(t, m.Checkpoint(fname))
+	_ = m.Update(context.TODO(), config.CountType, "requests", nil, int64(2))
+	require.NoError(t, m.Checkpoint(fname))
+
+	// crash left the newest generation damaged
+	require.NoError(t, os.WriteFile(fname, []byte("metrics-snapshot 1 0000"), 0606))
+
+	l := New()
+	require.NoError(t, l.LoadMetricsFromFile(fname))
+	assert.Equal(t, int64(1), counterOf(l, "requests"))
+	assert.Equal(t, uint64(1), l.seq)
+
+	require.NoError(t, os.WriteFile(generationPath(fname, 1), []byte("{"), 0606))
+	require.NoError(t, os.WriteFile(generationPath(fname, 2), []byte("}"), 0606))
+	assert.ErrorIs(t, New().LoadMetricsFromFile(fname), ErrCorruptedSnapshot)
+}
+
+func TestLocalStorage_SaveAllMetricsToFile_sync(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "metrics.json")
+
+	m := New(WithGenerations(2))
+	require.NoError(t, m.SaveAllMetricsToFile(0, "", fname))
+
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, m.Update(context.TODO(), config.CountType, "requests", nil, int64(1)))
+
+		l := New()
+		require.NoError(t, l.LoadMetricsFromFile(fname))
+		assert.Equal(t, int64(i), counterOf(l, "requests"))
+	}
+	assert.FileExists(t, generationPath(fname, 1))
+	assert.NoFileExists(t, generationPath(fname, 2))
+}
+
+func TestLocalStorage_LoadMetricsFromFile_invalid(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "metrics.json")
+	data := `[
+  {"id": "latency", "type": "histogram"},
+  {"id": "size", "type": "histogram", "histogram": {"bounds": [1], "counts": [1]}},
+  {"id": "temp", "type": "gauge"},
+  {"id": "requests", "type": "counter", "delta": 3}
+]`
+	require.NoError(t, os.WriteFile(fname, []byte(data), 0606))
+
+	l := New()
+	require.NoError(t, l.LoadMetricsFromFile(fname))
+	assert.Equal(t, int64(3), counterOf(l, "requests"))
+
+	_, err := l.Get(context.TODO(), config.GaugeType, "temp", nil)
+	assert.Error(t, err)
+	_, err = l.Get(context.TODO(), config.HistogramType, "size", nil)
+	assert.Error(t, err)
+
+	h := models.NewHistogram([]float64{1})
+	h.Observe(0.5)
+	assert.NoError(t, l.Update(context.TODO(), config.HistogramType, "latency", nil, h))
+}
+
+func TestLocalStorage_startSaving(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "backup")
+	fname := filepath.Join(dir, "metrics.json")
+
+	m := New()
+	_ = m.Update(context.TODO(), config.CountType, "requests", nil, int64(1))
+
+	// saving fails until the directory is created, and is retried
+	done := m.startSaving(10*time.Millisecond, fname)
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(t, os.Mkdir(dir, 0755))
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(fname)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// changes made after the last tick are saved on close
+	_ = m.Update(context.TODO(), config.CountType, "requests", nil, int64(2))
+	require.NoError(t, m.Close())
+	select {
+	case <-done:
+	default:
+		t.Fatal("saving not stopped")
+	}
+
+	l := New()
+	require.NoError(t, l.LoadMetricsFromFile(fname))
+	assert.Equal(t, int64(3), counterOf(l, "requests"))
+	assert.NoError(t, m.Close())
+}
@@ -0,0 +1,233 @@
+package local
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// Operations recorded in the write-ahead log.
+const (
+	opUpdate = "update" // Metrics holds updated metrics, applied atomically
+	opDelete = "delete"
+	opReset  = "reset"
+)
+
+// walRecord is a single line of the write-ahead log.
+type walRecord struct {
+	Seq      uint64           `json:"seq"`
+	Op       string           `json:"op"`
+	Metrics  []models.Metrics `json:"metrics,omitempty"`
+	Type     string           `json:"type,omitempty"`
+	Pattern  string           `json:"pattern,omitempty"`
+	Matchers []string         `json:"matchers,omitempty"`
+}
+
+// wal is an append-only log of storage changes, one JSON record per line.
+// Every record is synced to disk before the change is acknowledged.
+type wal struct {
+	f *os.File
+}
+
+// openWAL opens the log for appending. Torn record at the end of the log, left by a crash in the middle of a write,
+// is cut off, so that following records are readable.
+func openWAL(path string) (*wal, error) {
+	_, size, err := readWAL(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0606)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.Truncate(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err = f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &wal{f: f}, nil
+}
+
+// append writes the record and syncs the log.
+func (w *wal) append(rec walRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err = w.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+// truncate drops all records, it is called once they are saved in snapshot.
+func (w *wal) truncate() error {
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+// readWAL returns all complete records of the log and size of the log they take.
+// Reading stops at the first incomplete or corrupted record.
+func readWAL(path string) ([]walRecord, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var (
+		records []walRecord
+		size    int64
+	)
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				logger.Log.Info("incomplete record at the end of wal is skipped", zap.Int64("offset", size))
+			}
+			return records, size, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var rec walRecord
+		if err = json.Unmarshal(line, &rec); err != nil {
+			logger.Log.Info("corrupted wal record, the rest of wal is skipped", zap.Int64("offset", size), zap.Error(err))
+			return records, size, nil
+		}
+		records = append(records, rec)
+		size += int64(len(line))
+	}
+}
+
+// OpenWAL enables write-ahead log at the stated path. Every change of the storage is appended to the log
+// before it is acknowledged. Records of the existing log must be replayed by ReplayWAL beforehand,
+// they are dropped on the next Checkpoint.
+func (m *LocalStorage) OpenWAL(path string) error {
+	w, err := openWAL(path)
+	if err != nil {
+		return err
+	}
+
+	m.rm.Lock()
+	m.wal = w
+	m.rm.Unlock()
+	return nil
+}
+
+// ReplayWAL applies records of the log, which are not in the loaded snapshot yet.
+func (m *LocalStorage) ReplayWAL(path string) error {
+	records, _, err := readWAL(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	m.rm.Lock()
+	defer m.rm.Unlock()
+
+	for _, rec := range records {
+		// record is already in the snapshot
+		if rec.Seq <= m.seq {
+			continue
+		}
+		if err = m.replay(rec); err != nil {
+			logger.Log.Info("wal record skipped", zap.Uint64("seq", rec.Seq), zap.Error(err))
+		}
+		m.seq = rec.Seq
+	}
+	logger.Log.Info("wal replayed", zap.Int("records", len(records)), zap.Uint64("seq", m.seq))
+	return nil
+}
+
+// replay applies a single record of the log. Caller must hold the exclusive lock.
+func (m *LocalStorage) replay(rec walRecord) error {
+	now := time.Now()
+	switch rec.Op {
+	case opUpdate:
+		if err := m.validateBatch(rec.Metrics); err != nil {
+			return err
+		}
+		for _, metric := range rec.Metrics {
+			if err := m.apply(metric, now); err != nil {
+				return err
+			}
+		}
+	case opDelete, opReset:
+		matchers := make([]*models.LabelMatcher, 0, len(rec.Matchers))
+		for _, v := range rec.Matchers {
+			matcher, err := models.ParseLabelMatcher(v)
+			if err != nil {
+				return err
+			}
+			matchers = append(matchers, matcher)
+		}
+		if rec.Op == opDelete {
+			m.deleteSeries(rec.Type, rec.Pattern, matchers)
+		} else {
+			m.resetSeries(rec.Type, rec.Pattern, matchers, now)
+		}
+	default:
+		return fmt.Errorf("unknown wal operation: %q", rec.Op)
+	}
+	return nil
+}
+
+// log appends the applied change to write-ahead log and saves the snapshot, see appendWAL and syncSnapshot.
+func (m *LocalStorage) log(rec walRecord) error {
+	if err := m.appendWAL(rec); err != nil {
+		return err
+	}
+	return m.syncSnapshot()
+}
+
+// appendWAL appends the change to write-ahead log, if it is enabled. Caller must hold locks of the changed shards,
+// so that changes of the same series are logged in the order they are applied.
+func (m *LocalStorage) appendWAL(rec walRecord) error {
+	m.walMu.Lock()
+	defer m.walMu.Unlock()
+
+	m.seq++
+	rec.Seq = m.seq
+	if m.wal == nil {
+		return nil
+	}
+	if err := m.wal.append(rec); err != nil {
+		logger.Log.Error("error while writing wal", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// syncSnapshot saves the snapshot once the change is applied, if it is saved synchronously.
+func (m *LocalStorage) syncSnapshot() error {
+	if m.syncFile == "" {
+		return nil
+	}
+	if err := m.checkpoint(m.syncFile); err != nil {
+		logger.Log.Error("error saving metrics to the file", zap.Error(err))
+		return err
+	}
+	return nil
+}
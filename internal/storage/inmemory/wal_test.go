@@ -0,0 +1,107 @@
+package local
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	config "github.com/igortoigildin/go-metrics-altering/config/agent"
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalStorage_ReplayWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.wal")
+	gauge, delta := float64(1.5), int64(3)
+
+	m := New()
+	require.NoError(t, m.OpenWAL(path))
+	_ = m.Update(context.TODO(), config.GaugeType, "Alloc", nil, &gauge)
+	_ = m.Update(context.TODO(), config.GaugeType, "HeapAlloc", models.Labels{"host": "a"}, float64(2))
+	_ = m.Update(context.TODO(), config.CountType, "requests", models.Labels{"host": "a"}, &delta)
+	_ = m.Update(context.TODO(), config.HistogramType, "latency", nil, &models.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1})
+	_ = m.UpdateBatch(context.TODO(), []models.Metrics{
+		{ID: "requests", MType: config.CountType, Delta: &delta, Labels: models.Labels{"host": "a"}},
+		{ID: "Sys", MType: config.GaugeType, Value: &gauge},
+	})
+	matcher, _ := models.NewLabelMatcher(models.MatchEqual, "host", "a")
+	_, _ = m.Delete(context.TODO(), config.GaugeType, "Heap*", matcher)
+	_, _ = m.Reset(context.TODO(), "", "latency")
+
+	// rejected changes are not logged
+	assert.Error(t, m.Update(context.TODO(), config.GaugeType, "Alloc", nil, "abc"))
+
+	l := New()
+	require.NoError(t, l.ReplayWAL(path))
+	assert.ElementsMatch(t, m.metrics(), l.metrics())
+	assert.Equal(t, m.seq, l.seq)
+	assert.Equal(t, int64(6), counterOf(l, `requests{host="a"}`))
+}
+
+func TestLocalStorage_walFailed(t *testing.T) {
+	m := New()
+	require.NoError(t, m.OpenWAL(filepath.Join(t.TempDir(), "metrics.wal")))
+	require.NoError(t, m.wal.f.Close())
+	before := m.metrics()
+
+	// change failed to be logged is not applied
+	assert.Error(t, m.Update(context.TODO(), config.CountType, "requests", nil, int64(1)))
+	assert.Error(t, m.UpdateBatch(context.TODO(), []models.Metrics{{ID: "Sys", MType: config.GaugeType, Value: new(float64)}}))
+	assert.ElementsMatch(t, before, m.metrics())
+}
+
+func TestLocalStorage_Checkpoint(t *testing.T) {
+	dir := t.TempDir()
+	path, snap := filepath.Join(dir, "metrics.wal"), filepath.Join(dir, "metrics.json")
+
+	m := New()
+	require.NoError(t, m.OpenWAL(path))
+	_ = m.Update(context.TODO(), config.CountType, "requests", nil, int64(1))
+	_ = m.Update(context.TODO(), config.CountType, "requests", nil, int64(2))
+
+	// crash after the snapshot is saved, but before the log is truncated
+	logged, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, m.Checkpoint(snap))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Empty(t, data)
+
+	_ = m.Update(context.TODO(), config.CountType, "requests", nil, int64(4))
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, append(logged, data...), 0606))
+
+	l := New()
+	require.NoError(t, l.LoadMetricsFromFile(snap))
+	require.NoError(t, l.ReplayWAL(path))
+	// records already in the snapshot are skipped
+	assert.Equal(t, int64(7), counterOf(l, "requests"))
+}
+
+func Test_openWAL_tornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.wal")
+
+	w, err := openWAL(path)
+	require.NoError(t, err)
+	require.NoError(t, w.append(walRecord{Seq: 1, Op: opReset, Pattern: "a"}))
+
+	// crash in the middle of the write
+	_, err = w.f.WriteString(`{"seq":2,"op":"res`)
+	require.NoError(t, err)
+
+	records, _, err := readWAL(path)
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+
+	w, err = openWAL(path)
+	require.NoError(t, err)
+	require.NoError(t, w.append(walRecord{Seq: 2, Op: opReset, Pattern: "b"}))
+
+	records, _, err = readWAL(path)
+	require.NoError(t, err)
+	assert.Equal(t, []walRecord{{Seq: 1, Op: opReset, Pattern: "a"}, {Seq: 2, Op: opReset, Pattern: "b"}}, records)
+}
@@ -0,0 +1,186 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	"github.com/igortoigildin/go-metrics-altering/pkg/logger"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// UpdateBatch applies all the metrics in a single transaction with one multi-row upsert per metric type.
+// Either all metrics are applied or none of them.
+func (pg *PGStorage) UpdateBatch(ctx context.Context, metrics []models.Metrics) error {
+	gauges, counters, histograms, err := compactBatch(metrics)
+	if err != nil {
+		return err
+	}
+
+	tx, err := pg.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = upsertGauges(ctx, tx, gauges); err != nil {
+		logger.Log.Info("error while updating gauges", zap.Error(err))
+		return err
+	}
+	if err = upsertCounters(ctx, tx, counters); err != nil {
+		logger.Log.Info("error while updating counters", zap.Error(err))
+		return err
+	}
+	if err = upsertHistograms(ctx, tx, histograms); err != nil {
+		logger.Log.Info("error while updating histograms", zap.Error(err))
+		return err
+	}
+	return tx.Commit()
+}
+
+// compactBatch splits the batch by metric type and merges metrics of the same series,
+// as a single upsert statement cannot affect the same row twice.
+// The last gauge value wins, counter deltas and histograms are summed up.
+func compactBatch(metrics []models.Metrics) (gauges, counters, histograms []models.Metrics, err error) {
+	index := make(map[string]int, len(metrics))
+	for _, metric := range metrics {
+		key := metric.MType + ":" + models.SeriesKey(metric.ID, metric.Labels)
+
+		switch metric.MType {
+		case GaugeType:
+			if metric.Value == nil {
+				return nil, nil, nil, fmt.Errorf("%w: value of gauge %q not provided", storage.ErrInvalidValue, metric.ID)
+			}
+			v := *metric.Value
+			if i, ok := index[key]; ok {
+				gauges[i].Value = &v
+				continue
+			}
+			index[key] = len(gauges)
+			gauges = append(gauges, models.Metrics{ID: metric.ID, MType: metric.MType, Labels: metric.Labels, Value: &v})
+		case CountType:
+			if metric.Delta == nil {
+				return nil, nil, nil, fmt.Errorf("%w: delta of counter %q not provided", storage.ErrInvalidValue, metric.ID)
+			}
+			d := *metric.Delta
+			if i, ok := index[key]; ok {
+				*counters[i].Delta += d
+				continue
+			}
+			index[key] = len(counters)
+			counters = append(counters, models.Metrics{ID: metric.ID, MType: metric.MType, Labels: metric.Labels, Delta: &d})
+		case HistogramType:
+			if err := metric.Histogram.Validate(); err != nil {
+				return nil, nil, nil, fmt.Errorf("%w: %w", storage.ErrInvalidValue, err)
+			}
+			if i, ok := index[key]; ok {
+				if err := histograms[i].Histogram.Merge(metric.Histogram); err != nil {
+					return nil, nil, nil, fmt.Errorf("%w: %w", storage.ErrInvalidValue, err)
+				}
+				continue
+			}
+			index[key] = len(histograms)
+			histograms = append(histograms, models.Metrics{ID: metric.ID, MType: metric.MType, Labels: metric.Labels, Histogram: metric.Histogram.Clone()})
+		default:
+			return nil, nil, nil, fmt.Errorf("%w: unsupported metric type %q", storage.ErrTypeConflict, metric.MType)
+		}
+	}
+	return gauges, counters, histograms, nil
+}
+
+// placeholders returns VALUES list of rows with cols numbered parameters each, e.g. ($1, $2), ($3, $4).
+func placeholders(rows, cols int) string {
+	var b strings.Builder
+	for r := 0; r < rows; r++ {
+		if r > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteByte('(')
+		for c := 1; c <= cols; c++ {
+			if c > 1 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "$%d", r*cols+c)
+		}
+		b.WriteByte(')')
+	}
+	return b.String()
+}
+
+func upsertGauges(ctx context.Context, tx *sql.Tx, gauges []models.Metrics) error {
+	if len(gauges) == 0 {
+		return nil
+	}
+
+	args := make([]any, 0, len(gauges)*4)
+	for _, g := range gauges {
+		args = append(args, g.ID, g.MType, *g.Value, g.Labels)
+	}
+	_, err := tx.ExecContext(ctx, "WITH upd AS (INSERT INTO gauges(name, type, value, labels) VALUES "+placeholders(len(gauges), 4)+
+		" ON CONFLICT (name, labels) DO UPDATE SET value = EXCLUDED.value RETURNING name, labels, value) "+
+		"INSERT INTO gauge_samples(name, labels, value) SELECT name, labels, value FROM upd", args...)
+	return err
+}
+
+func upsertCounters(ctx context.Context, tx *sql.Tx, counters []models.Metrics) error {
+	if len(counters) == 0 {
+		return nil
+	}
+
+	args := make([]any, 0, len(counters)*4)
+	for _, c := range counters {
+		args = append(args, c.ID, c.MType, *c.Delta, c.Labels)
+	}
+	_, err := tx.ExecContext(ctx, "WITH upd AS (INSERT INTO counters(name, type, value, labels) VALUES "+placeholders(len(counters), 4)+
+		" ON CONFLICT (name, labels) DO UPDATE SET value = counters.value + EXCLUDED.value RETURNING name, labels, value) "+
+		"INSERT INTO counter_samples(name, labels, value) SELECT name, labels, value FROM upd", args...)
+	if err != nil {
+		return err
+	}
+
+	source := storage.SourceFromContext(ctx)
+	args = args[:0]
+	for _, c := range counters {
+		args = append(args, c.ID, c.Labels, source, *c.Delta)
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO counter_sources(name, labels, source, value) VALUES "+placeholders(len(counters), 4)+
+		" ON CONFLICT (name, labels, source) DO UPDATE SET value = counter_sources.value + EXCLUDED.value, updated_at = now()", args...)
+	return err
+}
+
+func upsertHistograms(ctx context.Context, tx *sql.Tx, histograms []models.Metrics) error {
+	if len(histograms) == 0 {
+		return nil
+	}
+
+	args := make([]any, 0, len(histograms)*7)
+	for _, h := range histograms {
+		counts := make([]int64, len(h.Histogram.Counts))
+		for i, c := range h.Histogram.Counts {
+			counts[i] = int64(c)
+		}
+		args = append(args, h.ID, h.MType, h.Labels, pq.Array(h.Histogram.Bounds), pq.Array(counts), h.Histogram.Sum, int64(h.Histogram.Count))
+	}
+	res, err := tx.ExecContext(ctx, "INSERT INTO histograms(name, type, labels, bounds, counts, sum, count) VALUES "+placeholders(len(histograms), 7)+
+		" ON CONFLICT (name, labels) DO UPDATE SET "+
+		"counts = ARRAY(SELECT a + b FROM unnest(histograms.counts, EXCLUDED.counts) AS t(a, b)), "+
+		"sum = histograms.sum + EXCLUDED.sum, count = histograms.count + EXCLUDED.count "+
+		"WHERE histograms.bounds = EXCLUDED.bounds", args...)
+	if err != nil {
+		return err
+	}
+
+	// conflicting rows with different bounds are skipped, so the whole batch is rolled back
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != int64(len(histograms)) {
+		return fmt.Errorf("%w: %w", storage.ErrInvalidValue, models.ErrBoundsMismatch)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// table describes where series of a metric type and their history are stored.
+type table struct {
+	metricType string
+	name       string
+	samples    string // empty if history is not recorded, rollups of samples are named after it
+	sources    string // empty if increments are not attributed to sources
+	reset      string // SET clause zeroing the series
+}
+
+var tables = []table{
+	{metricType: GaugeType, name: "gauges", samples: "gauge_samples", reset: "value = 0"},
+	{metricType: CountType, name: "counters", samples: "counter_samples", sources: "counter_sources", reset: "value = 0"},
+	{metricType: HistogramType, name: "histograms", reset: "counts = array_fill(0::bigint, ARRAY[cardinality(counts)]), sum = 0, count = 0"},
+}
+
+type series struct {
+	name   string
+	labels models.Labels
+}
+
+// Delete removes series of the stated type, which names match the glob pattern and labels satisfy the matchers,
+// together with their history. Empty metric type stands for all types. Returns number of deleted series.
+func (pg *PGStorage) Delete(ctx context.Context, metricType string, pattern string, matchers ...*models.LabelMatcher) (int, error) {
+	return pg.forEachSeries(ctx, metricType, pattern, matchers, func(tx *sql.Tx, t table, s series) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+t.name+` WHERE name = $1 AND labels = $2`, s.name, s.labels); err != nil {
+			return err
+		}
+		if err := deleteSources(ctx, tx, t, s); err != nil {
+			return err
+		}
+		if t.samples == "" {
+			return nil
+		}
+		samples := []string{t.samples}
+		for _, r := range rollups {
+			samples = append(samples, t.samples+r.suffix)
+		}
+		for _, name := range samples {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+name+` WHERE name = $1 AND labels = $2`, s.name, s.labels); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Reset sets to zero series of the stated type, which names match the glob pattern and labels satisfy the matchers.
+// Histograms keep their bounds. Empty metric type stands for all types. Returns number of reset series.
+func (pg *PGStorage) Reset(ctx context.Context, metricType string, pattern string, matchers ...*models.LabelMatcher) (int, error) {
+	return pg.forEachSeries(ctx, metricType, pattern, matchers, func(tx *sql.Tx, t table, s series) error {
+		query := `UPDATE ` + t.name + ` SET ` + t.reset + ` WHERE name = $1 AND labels = $2`
+		if t.samples != "" {
+			query = `WITH upd AS (` + query + ` RETURNING name, labels, value) ` +
+				`INSERT INTO ` + t.samples + `(name, labels, value) SELECT name, labels, value FROM upd`
+		}
+		if _, err := tx.ExecContext(ctx, query, s.name, s.labels); err != nil {
+			return err
+		}
+		// subtotals must add up to the reset value
+		return deleteSources(ctx, tx, t, s)
+	})
+}
+
+// deleteSources removes subtotals of the series by source, if the table has them.
+func deleteSources(ctx context.Context, tx *sql.Tx, t table, s series) error {
+	if t.sources == "" {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `DELETE FROM `+t.sources+` WHERE name = $1 AND labels = $2`, s.name, s.labels)
+	return err
+}
+
+// forEachSeries calls fn in a single transaction for every matching series.
+func (pg *PGStorage) forEachSeries(ctx context.Context, metricType string, pattern string, matchers []*models.LabelMatcher,
+	fn func(tx *sql.Tx, t table, s series) error) (int, error) {
+	tx, err := pg.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var n int
+	for _, t := range tables {
+		if metricType != "" && metricType != t.metricType {
+			continue
+		}
+
+		matched, err := selectSeries(ctx, tx, t, pattern, matchers)
+		if err != nil {
+			return 0, err
+		}
+		for _, s := range matched {
+			if err = fn(tx, t, s); err != nil {
+				logger.Log.Info("error while processing series", zap.String("name", s.name), zap.Error(err))
+				return 0, err
+			}
+		}
+		n += len(matched)
+	}
+	return n, tx.Commit()
+}
+
+// selectSeries returns series of the table, which names match the glob pattern and labels satisfy the matchers.
+func selectSeries(ctx context.Context, tx *sql.Tx, t table, pattern string, matchers []*models.LabelMatcher) ([]series, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT name, labels FROM `+t.name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]series, 0)
+	for rows.Next() {
+		var s series
+		if err = rows.Scan(&s.name, &s.labels); err != nil {
+			return nil, err
+		}
+		if models.MatchName(pattern, s.name) && s.labels.Matches(matchers) {
+			res = append(res, s)
+		}
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,45 @@
+package psql
+
+import (
+	"net/url"
+
+	config "github.com/igortoigildin/go-metrics-altering/config/server"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+)
+
+func init() {
+	storage.Register("postgres", open)
+	storage.Register("postgresql", open)
+}
+
+// open connects to postgres. Options: retention (duration), rollup_retention (duration), check_schema (bool), the rest of the query
+// are connection parameters passed to the database, e.g. sslmode.
+func open(u *url.URL, cfg *config.ConfigServer) (storage.Storage, error) {
+	opts := storage.Options(u.Query())
+	retention, err := opts.Duration("retention", cfg.HistoryRetention)
+	if err != nil {
+		return nil, err
+	}
+
+	rollupRetention, err := opts.Duration("rollup_retention", cfg.RollupRetention)
+	if err != nil {
+		return nil, err
+	}
+
+	checkSchema, err := opts.Bool("check_schema", cfg.FlagCheckSchema)
+	if err != nil {
+		return nil, err
+	}
+	pgOpts := []Option{WithRetention(retention), WithRollupRetention(rollupRetention)}
+	if checkSchema {
+		pgOpts = append(pgOpts, WithSchemaCheck())
+	}
+
+	dsn := *u
+	dsn.RawQuery = url.Values(opts).Encode()
+	pg, err := New(dsn.String(), pgOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return pg, nil
+}
@@ -0,0 +1,145 @@
+package psql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	partitionsAhead = 2 // number of partitions created in advance
+	partitionLayout = "2006010215"
+)
+
+// partitionedTable is table of samples partitioned by time. Samples out of ranges of its partitions
+// are kept in the default partition.
+type partitionedTable struct {
+	name   string
+	period time.Duration // time range of a partition
+	rollup bool          // kept for rollup retention
+}
+
+// partitionedTables returns tables of samples and their rollups.
+func partitionedTables() []partitionedTable {
+	var res []partitionedTable
+	for _, t := range tables {
+		if t.samples == "" {
+			continue
+		}
+		res = append(res, partitionedTable{name: t.samples, period: time.Hour})
+		for _, r := range rollups {
+			res = append(res, partitionedTable{name: t.samples + r.suffix, period: 24 * time.Hour, rollup: true})
+		}
+	}
+	return res
+}
+
+type partition struct {
+	name  string
+	start time.Time
+}
+
+func partitionName(table string, start time.Time) string {
+	return table + "_p" + start.UTC().Format(partitionLayout)
+}
+
+// maintainPartitions drops partitions of the table, which samples were all recorded before the stated time,
+// and creates partitions for now and upcoming periods.
+func (pg *PGStorage) maintainPartitions(ctx context.Context, p partitionedTable, now time.Time, before time.Time) error {
+	existing, err := pg.partitions(ctx, p.name)
+	if err != nil {
+		return err
+	}
+
+	created := make(map[string]bool, len(existing))
+	for _, part := range existing {
+		if part.start.Add(p.period).After(before) {
+			created[part.name] = true
+			continue
+		}
+		if _, err = pg.conn.ExecContext(ctx, `DROP TABLE IF EXISTS `+part.name); err != nil {
+			return fmt.Errorf("could not drop partition %s: %w", part.name, err)
+		}
+	}
+
+	start := now.Truncate(p.period)
+	for i := 0; i <= partitionsAhead; i++ {
+		from := start.Add(time.Duration(i) * p.period)
+		if created[partitionName(p.name, from)] {
+			continue
+		}
+		if err = pg.createPartition(ctx, p.name, from, from.Add(p.period)); err != nil {
+			return fmt.Errorf("could not create partition of %s: %w", p.name, err)
+		}
+	}
+	return nil
+}
+
+// partitions returns partitions of the table created by maintainPartitions.
+func (pg *PGStorage) partitions(ctx context.Context, table string) ([]partition, error) {
+	rows, err := pg.conn.QueryContext(ctx, `SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid `+
+		`WHERE i.inhparent = $1::regclass ORDER BY c.relname`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []partition
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		suffix, ok := strings.CutPrefix(name, table+"_p")
+		if !ok {
+			continue
+		}
+		start, err := time.Parse(partitionLayout, suffix)
+		if err != nil {
+			continue
+		}
+		res = append(res, partition{name: name, start: start})
+	}
+	return res, rows.Err()
+}
+
+// createPartition creates partition of the table for samples recorded between from and to. Samples of the range
+// recorded while there was no partition for it are moved from the default partition.
+func (pg *PGStorage) createPartition(ctx context.Context, table string, from, to time.Time) error {
+	name := partitionName(table, from)
+
+	tx, err := pg.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// servers sharing the database may create the same partition at once
+	if _, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, name); err != nil {
+		return err
+	}
+	var exists bool
+	if err = tx.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, name).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	if _, err = tx.ExecContext(ctx, `CREATE TABLE `+name+` (LIKE `+table+` INCLUDING DEFAULTS)`); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `WITH moved AS (DELETE FROM `+table+`_default WHERE ts >= $1 AND ts < $2 RETURNING *) `+
+		`INSERT INTO `+name+` SELECT * FROM moved`, from, to)
+	if err != nil {
+		return err
+	}
+	// bounds cannot be passed as parameters
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %s ATTACH PARTITION %s FOR VALUES FROM ('%s') TO ('%s')`,
+		table, name, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339)))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,77 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPGStorage_maintainPartitions(t *testing.T) {
+	db, mock := NewMock()
+	now := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)
+	table := partitionedTable{name: "gauge_samples", period: time.Hour}
+
+	mock.ExpectQuery(`SELECT c.relname FROM pg_inherits`).WithArgs("gauge_samples").WillReturnRows(
+		sqlmock.NewRows([]string{"relname"}).
+			AddRow("gauge_samples_default").
+			AddRow("gauge_samples_p2024010108").
+			AddRow("gauge_samples_p2024010109").
+			AddRow("gauge_samples_p2024010110"))
+	// partition holding samples recorded since 9:30 is kept
+	mock.ExpectExec(`DROP TABLE IF EXISTS gauge_samples_p2024010108`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock\(hashtext\(\$1\)\)`).WithArgs("gauge_samples_p2024010111").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT to_regclass\(\$1\) IS NOT NULL`).WithArgs("gauge_samples_p2024010111").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec(`CREATE TABLE gauge_samples_p2024010111 \(LIKE gauge_samples INCLUDING DEFAULTS\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`WITH moved AS \(DELETE FROM gauge_samples_default WHERE ts >= \$1 AND ts < \$2 RETURNING \*\) INSERT INTO gauge_samples_p2024010111`).
+		WithArgs(time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`ALTER TABLE gauge_samples ATTACH PARTITION gauge_samples_p2024010111 FOR VALUES FROM \('2024-01-01T11:00:00Z'\) TO \('2024-01-01T12:00:00Z'\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	// created by another server meanwhile
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock`).WithArgs("gauge_samples_p2024010112").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT to_regclass`).WithArgs("gauge_samples_p2024010112").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	subject := PGStorage{conn: db}
+	assert.NoError(t, subject.maintainPartitions(context.Background(), table, now, now.Add(-time.Hour)))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_createPartition(t *testing.T) {
+	db, mock := NewMock()
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT to_regclass`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec(`CREATE TABLE counter_samples_1h_p2024010100`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`WITH moved AS`).WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+
+	subject := PGStorage{conn: db}
+	err := subject.createPartition(context.Background(), "counter_samples_1h", from, from.Add(24*time.Hour))
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_Close(t *testing.T) {
+	db, mock := NewMock()
+	// maintenance started fails, as no queries are expected, the loop is stopped while waiting for the next one
+	mock.ExpectClose()
+
+	subject := PGStorage{conn: db}
+	subject.startMaintenance()
+	assert.NoError(t, subject.Close())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -3,59 +3,137 @@ package psql
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"time"
 
-	_ "github.com/golang-migrate/migrate/v4/source/file"
 	config "github.com/igortoigildin/go-metrics-altering/config/server"
 	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
 	"github.com/igortoigildin/go-metrics-altering/pkg/logger"
-	migrate "github.com/igortoigildin/go-metrics-altering/pkg/migrations"
-	_ "github.com/lib/pq"
+	"github.com/igortoigildin/go-metrics-altering/pkg/migrations"
+	"github.com/lib/pq"
 
 	"go.uber.org/zap"
 )
 
 const (
-	GaugeType = "gauge"
-	CountType = "counter"
-	PollCount = "PollCount"
+	GaugeType     = "gauge"
+	CountType     = "counter"
+	HistogramType = "histogram"
+	PollCount     = "PollCount"
+
+	defaultRetention       = time.Hour
+	defaultRollupRetention = 30 * 24 * time.Hour
+	maintenanceInterval    = time.Minute
 )
 
 type PGStorage struct {
-	conn     *sql.DB
-	strategy Strategy
+	conn            *sql.DB
+	retention       time.Duration
+	rollupRetention time.Duration
+	checkSchema     bool
+
+	stop context.CancelFunc // stops maintenance loop
+	done chan struct{}      // closed once maintenance loop is stopped
+}
+
+// Option configures PGStorage.
+type Option func(*PGStorage)
+
+// WithRetention sets how long metric samples are kept in history.
+func WithRetention(retention time.Duration) Option {
+	return func(pg *PGStorage) {
+		if retention > 0 {
+			pg.retention = retention
+		}
+	}
+}
+
+// WithRollupRetention sets how long samples aggregated into rollups are kept.
+func WithRollupRetention(retention time.Duration) Option {
+	return func(pg *PGStorage) {
+		if retention > 0 {
+			pg.rollupRetention = retention
+		}
+	}
 }
 
-func New(cfg *config.ConfigServer) (*PGStorage, error) {
-	db, err := sql.Open("pgx", cfg.FlagDBDSN)
+// WithSchemaCheck makes New fail when the database schema is behind instead of migrating it.
+func WithSchemaCheck() Option {
+	return func(pg *PGStorage) {
+		pg.checkSchema = true
+	}
+}
+
+func New(dsn string, opts ...Option) (*PGStorage, error) {
+	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := migrate.New("migrations", db); err != nil {
-		logger.Log.Fatal("could not migrate db: %s", zap.Error(err))
+	pg := &PGStorage{
+		conn:            db,
+		retention:       defaultRetention,
+		rollupRetention: defaultRollupRetention,
+	}
+	for _, opt := range opts {
+		opt(pg)
+	}
+
+	if err = pg.migrate(); err != nil {
+		db.Close()
+		return nil, err
 	}
+	pg.startMaintenance()
 
-	return &PGStorage{
-		conn: db,
-	}, nil
+	return pg, nil
 }
 
-func (pg *PGStorage) SetStrategy(metricType string) error {
-	if metricType == config.CountType {
-		count := Count{
-			conn: pg.conn,
-		}
-		pg.strategy = &count
-		return nil
+// startMaintenance starts maintenance of history tables in background, it is stopped by Close.
+func (pg *PGStorage) startMaintenance() {
+	ctx, stop := context.WithCancel(context.Background())
+	pg.stop, pg.done = stop, make(chan struct{})
+	go pg.maintenanceLoop(ctx)
+}
+
+// Close stops maintenance of history tables and closes the database.
+func (pg *PGStorage) Close() error {
+	if pg.stop != nil {
+		pg.stop()
+		<-pg.done
 	}
+	return pg.conn.Close()
+}
 
-	gauge := Gauge{
-		conn: pg.conn,
+// migrate applies pending migrations or, with schema check, verifies that there are none.
+func (pg *PGStorage) migrate() error {
+	migrator, err := migrations.New(pg.conn)
+	if err != nil {
+		return fmt.Errorf("could not init migrations: %w", err)
+	}
+	defer migrator.Close()
+	if pg.checkSchema {
+		return migrator.Check()
+	}
+	if err = migrator.Up(); err != nil {
+		return fmt.Errorf("could not migrate db: %w", err)
 	}
-	pg.strategy = &gauge
 	return nil
 }
 
+// strategyFor returns the strategy storing metrics of the type. Strategy is not kept in the storage,
+// as concurrent requests may be of different types.
+func (pg *PGStorage) strategyFor(metricType string) Strategy {
+	switch metricType {
+	case config.CountType:
+		return &Count{conn: pg.conn}
+	case config.HistogramType:
+		return &Histogram{conn: pg.conn}
+	default:
+		return &Gauge{conn: pg.conn}
+	}
+}
+
 func (pg *PGStorage) Ping(ctx context.Context) error {
 	err := pg.conn.PingContext(ctx)
 	if err != nil {
@@ -64,53 +142,217 @@ func (pg *PGStorage) Ping(ctx context.Context) error {
 	return err
 }
 
-func (pg *PGStorage) Update(ctx context.Context, metricType string, metricName string, metricValue any) error {
-	pg.SetStrategy(metricType)
-	return pg.strategy.Update(ctx, metricType, metricName, metricValue)
+func (pg *PGStorage) Update(ctx context.Context, metricType string, metricName string, labels models.Labels, metricValue any) error {
+	if err := checkValue(metricType, metricName, metricValue); err != nil {
+		return err
+	}
+	return pg.strategyFor(metricType).Update(ctx, metricType, metricName, labels, metricValue)
 }
 
-func (pg *PGStorage) Get(ctx context.Context, metricType string, metricName string) (models.Metrics, error) {
-	pg.SetStrategy(metricType)
-	return pg.strategy.Get(ctx, metricType, metricName)
+func (pg *PGStorage) Get(ctx context.Context, metricType string, metricName string, labels models.Labels) (models.Metrics, error) {
+	if err := checkType(metricType); err != nil {
+		return models.Metrics{}, err
+	}
+	return pg.strategyFor(metricType).Get(ctx, metricType, metricName, labels)
 }
 
-func (pg *PGStorage) GetAll(ctx context.Context) (map[string]any, error) {
-	metrics := make(map[string]any, 33)
-	rows, err := pg.conn.QueryContext(ctx, `SELECT name, value FROM gauges WHERE type = ?`, GaugeType)
-	if err != nil {
+// History returns samples of the metric recorded between from and to, downsampled by step.
+// Long ranges and ranges expired from raw samples are answered with rollups, see rollupFor.
+func (pg *PGStorage) History(ctx context.Context, metricType string, metricName string, labels models.Labels, from, to time.Time, step time.Duration) ([]models.Sample, error) {
+	if err := checkType(metricType); err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var name string
-		var value any
-		err = rows.Scan(&name, &value)
-		if err != nil {
-			return nil, err
+	for _, t := range tables {
+		if t.metricType != metricType || t.samples == "" {
+			continue
+		}
+		if r := pg.rollupFor(from, to, step, time.Now()); r != nil {
+			return pg.rollupHistory(ctx, *r, t, metricName, labels, from, to, step)
 		}
-		metrics[name] = value
 	}
-	err = rows.Err()
+	samples, err := pg.strategyFor(metricType).History(ctx, metricName, labels, from, to)
 	if err != nil {
 		return nil, err
 	}
-	rows, err = pg.conn.QueryContext(ctx, `SELECT name, value FROM counters WHERE type = ?`, CountType)
+	return models.Downsample(samples, from, step), nil
+}
+
+// CounterSources returns increments of the counter summed up by source, the largest first.
+func (pg *PGStorage) CounterSources(ctx context.Context, metricName string, labels models.Labels) ([]models.SourceTotal, error) {
+	rows, err := pg.conn.QueryContext(ctx, `SELECT source, value, updated_at FROM counter_sources `+
+		`WHERE name = $1 AND labels = $2 ORDER BY value DESC, source`, metricName, labels)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
+
+	var res []models.SourceTotal
 	for rows.Next() {
-		var name string
-		var value any
-		err = rows.Scan(&name, &value)
-		if err != nil {
+		var total models.SourceTotal
+		if err = rows.Scan(&total.Source, &total.Delta, &total.UpdatedAt); err != nil {
 			return nil, err
 		}
-		metrics[name] = value
+		res = append(res, total)
 	}
-	err = rows.Err()
-	if err != nil {
+	if err = rows.Err(); err != nil {
 		return nil, err
 	}
-	return metrics, nil
+	if len(res) == 0 {
+		return nil, fmt.Errorf("%w: no increments of counter %q", storage.ErrNotFound, metricName)
+	}
+	return res, nil
+}
+
+// Maintain rolls up samples, creates partitions for upcoming samples and drops samples older than retention:
+// whole partitions when possible, the rest are deleted.
+func (pg *PGStorage) Maintain(ctx context.Context, now time.Time) error {
+	if err := pg.Rollup(ctx, now); err != nil {
+		return err
+	}
+	for _, p := range partitionedTables() {
+		before := now.Add(-pg.retention)
+		if p.rollup {
+			before = now.Add(-pg.rollupRetention)
+		}
+		if err := pg.maintainPartitions(ctx, p, now, before); err != nil {
+			return err
+		}
+		if _, err := pg.conn.ExecContext(ctx, `DELETE FROM `+p.name+` WHERE ts < $1`, before); err != nil {
+			return fmt.Errorf("could not purge %s: %w", p.name, err)
+		}
+	}
+	return nil
+}
+
+// maintenanceLoop maintains history tables on start and periodically afterwards until ctx is done.
+func (pg *PGStorage) maintenanceLoop(ctx context.Context) {
+	defer close(pg.done)
+
+	ticker := time.NewTicker(maintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := pg.Maintain(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			logger.Log.Info("error while maintaining metrics history", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// List returns page of series selected by the filter, sorted by type and series key, and cursor of the next page.
+func (pg *PGStorage) List(ctx context.Context, filter models.ListFilter) ([]models.Metrics, string, error) {
+	var metrics []models.Metrics
+	if filter.Type == "" || filter.Type == GaugeType {
+		rows, err := pg.conn.QueryContext(ctx, `SELECT name, labels, value FROM gauges WHERE type = $1`, GaugeType)
+		if err != nil {
+			return nil, "", err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			metric := models.Metrics{MType: GaugeType, Value: new(float64)}
+			err = rows.Scan(&metric.ID, &metric.Labels, metric.Value)
+			if err != nil {
+				return nil, "", err
+			}
+			if filter.Match(metric) {
+				metrics = append(metrics, metric)
+			}
+		}
+		if err = rows.Err(); err != nil {
+			return nil, "", err
+		}
+	}
+	if filter.Type == "" || filter.Type == CountType {
+		rows, err := pg.conn.QueryContext(ctx, `SELECT name, labels, value FROM counters WHERE type = $1`, CountType)
+		if err != nil {
+			return nil, "", err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			metric := models.Metrics{MType: CountType, Delta: new(int64)}
+			err = rows.Scan(&metric.ID, &metric.Labels, metric.Delta)
+			if err != nil {
+				return nil, "", err
+			}
+			if filter.Match(metric) {
+				metrics = append(metrics, metric)
+			}
+		}
+		if err = rows.Err(); err != nil {
+			return nil, "", err
+		}
+	}
+	if filter.Type == "" || filter.Type == HistogramType {
+		rows, err := pg.conn.QueryContext(ctx, `SELECT name, labels, bounds, counts, sum, count FROM histograms WHERE type = $1`, HistogramType)
+		if err != nil {
+			return nil, "", err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var counts []int64
+			metric := models.Metrics{MType: HistogramType, Histogram: &models.Histogram{}}
+			h := metric.Histogram
+			err = rows.Scan(&metric.ID, &metric.Labels, pq.Array(&h.Bounds), pq.Array(&counts), &h.Sum, &h.Count)
+			if err != nil {
+				return nil, "", err
+			}
+			h.Counts = make([]uint64, len(counts))
+			for i, c := range counts {
+				h.Counts[i] = uint64(c)
+			}
+			if filter.Match(metric) {
+				metrics = append(metrics, metric)
+			}
+		}
+		if err = rows.Err(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	page, next, err := filter.Page(metrics)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %w", storage.ErrInvalidValue, err)
+	}
+	return page, next, nil
+}
+
+// checkType returns ErrTypeConflict for unsupported metric types.
+func checkType(metricType string) error {
+	if metricType != GaugeType && metricType != CountType && metricType != HistogramType {
+		return fmt.Errorf("%w: unsupported metric type %q", storage.ErrTypeConflict, metricType)
+	}
+	return nil
+}
+
+// checkValue verifies that value passed to Update suits the metric type. Histograms are validated by the strategy.
+func checkValue(metricType string, metricName string, metricValue any) error {
+	if err := checkType(metricType); err != nil {
+		return err
+	}
+
+	var ok, missing bool
+	switch v := metricValue.(type) {
+	case float64:
+		ok = metricType == GaugeType
+	case *float64:
+		ok, missing = metricType == GaugeType, v == nil
+	case int64:
+		ok = metricType == CountType
+	case *int64:
+		ok, missing = metricType == CountType, v == nil
+	case *models.Histogram:
+		ok = metricType == HistogramType
+	}
+
+	switch {
+	case !ok:
+		return fmt.Errorf("%w: %T is not a value of %s %q", storage.ErrTypeConflict, metricValue, metricType, metricName)
+	case missing:
+		return fmt.Errorf("%w: value of %s %q not provided", storage.ErrInvalidValue, metricType, metricName)
+	}
+	return nil
 }
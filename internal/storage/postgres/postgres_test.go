@@ -5,30 +5,42 @@ import (
 	"database/sql"
 	"log"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestPGStorage_GetAll(t *testing.T) {
+func TestPGStorage_List(t *testing.T) {
 	db, mock := NewMock()
 
-	mock.ExpectQuery(`SELECT name, value FROM gauges WHERE type = ?`).WithArgs("gauge").WillReturnRows(
-		sqlmock.NewRows([]string{"name", "value"}).AddRow("test_metric", 1.25))
-	mock.ExpectQuery(`SELECT name, value FROM counters WHERE type = ?`).WithArgs("counter").WillReturnRows(
-		sqlmock.NewRows([]string{"name", "value"}).AddRow("test_metric", 1.25))
+	mock.ExpectQuery(`SELECT name, labels, value FROM gauges WHERE type = \$1`).WithArgs("gauge").WillReturnRows(
+		sqlmock.NewRows([]string{"name", "labels", "value"}).AddRow("test_metric", []byte("{}"), 1.25))
+	mock.ExpectQuery(`SELECT name, labels, value FROM counters WHERE type = \$1`).WithArgs("counter").WillReturnRows(
+		sqlmock.NewRows([]string{"name", "labels", "value"}).AddRow("test_metric", []byte("{}"), 2))
+	mock.ExpectQuery(`SELECT name, labels, bounds, counts, sum, count FROM histograms WHERE type = \$1`).WithArgs("histogram").WillReturnRows(
+		sqlmock.NewRows([]string{"name", "labels", "bounds", "counts", "sum", "count"}).
+			AddRow("latency", []byte("{}"), []byte("{0.1,1}"), []byte("{1,0,1}"), 2.05, 2))
 
 	subject := PGStorage{
 		conn: db,
 	}
 
-	resp, err := subject.GetAll(context.Background())
+	resp, next, err := subject.List(context.Background(), models.ListFilter{})
 
 	assert.Nil(t, err)
-	assert.NotNil(t, resp)
-	assert.Equal(t, 1, len(resp))
+	assert.Empty(t, next)
+	assert.Equal(t, 3, len(resp))
+	// sorted by type, gauge and counter of the same name are both listed
+	assert.Equal(t, CountType, resp[0].MType)
+	assert.Equal(t, int64(2), *resp[0].Delta)
+	assert.Equal(t, GaugeType, resp[1].MType)
+	assert.Equal(t, 1.25, *resp[1].Value)
+	assert.Equal(t, &models.Histogram{Bounds: []float64{0.1, 1}, Counts: []uint64{1, 0, 1}, Sum: 2.05, Count: 2}, resp[2].Histogram)
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
 func NewMock() (*sql.DB, sqlmock.Sqlmock) {
@@ -40,16 +52,188 @@ func NewMock() (*sql.DB, sqlmock.Sqlmock) {
 	return db, mock
 }
 
-func TestPGStorage_SetStrategy(t *testing.T) {
-	pg1 := PGStorage{}
-	pg1.SetStrategy(CountType)
+func TestPGStorage_strategyFor(t *testing.T) {
+	pg := PGStorage{}
 
-	_, ok := pg1.strategy.(*Count)
+	_, ok := pg.strategyFor(CountType).(*Count)
 	assert.True(t, ok)
 
-	pg2 := PGStorage{}
-	pg2.SetStrategy(GaugeType)
+	_, ok = pg.strategyFor(GaugeType).(*Gauge)
+	assert.True(t, ok)
 
-	_, ok = pg2.strategy.(*Gauge)
+	_, ok = pg.strategyFor(HistogramType).(*Histogram)
 	assert.True(t, ok)
 }
+
+func TestPGStorage_ListWithFilter(t *testing.T) {
+	db, mock := NewMock()
+
+	// only the table of the stated type is queried
+	mock.ExpectQuery(`SELECT name, labels, value FROM gauges WHERE type = \$1`).WithArgs("gauge").WillReturnRows(
+		sqlmock.NewRows([]string{"name", "labels", "value"}).
+			AddRow("requests", []byte(`{"host":"a"}`), 1.25).
+			AddRow("requests", []byte(`{"host":"b"}`), 2.5).
+			AddRow("alloc", []byte(`{"host":"b"}`), 3.5))
+
+	subject := PGStorage{
+		conn: db,
+	}
+
+	matcher, _ := models.NewLabelMatcher(models.MatchEqual, "host", "b")
+	resp, _, err := subject.List(context.Background(), models.ListFilter{Type: GaugeType, Prefix: "req", Matchers: []*models.LabelMatcher{matcher}})
+
+	value := 2.5
+	assert.Nil(t, err)
+	assert.Equal(t, []models.Metrics{{ID: "requests", MType: GaugeType, Value: &value, Labels: models.Labels{"host": "b"}}}, resp)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_UpdateBatch(t *testing.T) {
+	gauge := float64(1.5)
+	delta := int64(2)
+	h := &models.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1}
+	metrics := []models.Metrics{
+		{ID: "alloc", MType: GaugeType, Value: &gauge},
+		{ID: "requests", MType: CountType, Delta: &delta},
+		{ID: "requests", MType: CountType, Delta: &delta},
+		{ID: "latency", MType: HistogramType, Histogram: h},
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		db, mock := NewMock()
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO gauges\(name, type, value, labels\) VALUES \(\$1, \$2, \$3, \$4\) ON CONFLICT`).
+			WithArgs("alloc", GaugeType, gauge, "{}").WillReturnResult(sqlmock.NewResult(0, 1))
+		// counters of the same series are summed up
+		mock.ExpectExec(`INSERT INTO counters\(name, type, value, labels\) VALUES \(\$1, \$2, \$3, \$4\) ON CONFLICT`).
+			WithArgs("requests", CountType, int64(4), "{}").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO counter_sources\(name, labels, source, value\) VALUES \(\$1, \$2, \$3, \$4\) ON CONFLICT`).
+			WithArgs("requests", "{}", "agent-1", int64(4)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO histograms\(name, type, labels, bounds, counts, sum, count\) VALUES \(\$1, \$2, \$3, \$4, \$5, \$6, \$7\)`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		subject := PGStorage{
+			conn: db,
+		}
+		assert.NoError(t, subject.UpdateBatch(storage.WithSource(context.Background(), "agent-1"), metrics))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Rollback on bounds mismatch", func(t *testing.T) {
+		db, mock := NewMock()
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO gauges`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO counters`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO counter_sources`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO histograms`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		subject := PGStorage{
+			conn: db,
+		}
+		assert.ErrorIs(t, subject.UpdateBatch(context.Background(), metrics), models.ErrBoundsMismatch)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Rollback on error", func(t *testing.T) {
+		db, mock := NewMock()
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO gauges`).WillReturnError(sql.ErrConnDone)
+		mock.ExpectRollback()
+
+		subject := PGStorage{
+			conn: db,
+		}
+		assert.ErrorIs(t, subject.UpdateBatch(context.Background(), metrics), sql.ErrConnDone)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_placeholders(t *testing.T) {
+	assert.Equal(t, "($1, $2), ($3, $4), ($5, $6)", placeholders(3, 2))
+}
+
+func TestPGStorage_Delete(t *testing.T) {
+	db, mock := NewMock()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT name, labels FROM gauges`).WillReturnRows(
+		sqlmock.NewRows([]string{"name", "labels"}).
+			AddRow("HeapAlloc", []byte(`{"host":"a"}`)).
+			AddRow("HeapSys", []byte(`{"host":"b"}`)).
+			AddRow("Alloc", []byte(`{"host":"a"}`)))
+	mock.ExpectExec(`DELETE FROM gauges WHERE name = \$1 AND labels = \$2`).WithArgs("HeapAlloc", `{"host":"a"}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM gauge_samples WHERE name = \$1 AND labels = \$2`).WithArgs("HeapAlloc", `{"host":"a"}`).
+		WillReturnResult(sqlmock.NewResult(0, 10))
+	mock.ExpectExec(`DELETE FROM gauge_samples_1m WHERE name = \$1 AND labels = \$2`).WithArgs("HeapAlloc", `{"host":"a"}`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM gauge_samples_1h WHERE name = \$1 AND labels = \$2`).WithArgs("HeapAlloc", `{"host":"a"}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	subject := PGStorage{
+		conn: db,
+	}
+
+	matcher, _ := models.NewLabelMatcher(models.MatchEqual, "host", "a")
+	n, err := subject.Delete(context.Background(), GaugeType, "Heap*", matcher)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_Reset(t *testing.T) {
+	db, mock := NewMock()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT name, labels FROM gauges`).WillReturnRows(sqlmock.NewRows([]string{"name", "labels"}))
+	mock.ExpectQuery(`SELECT name, labels FROM counters`).WillReturnRows(
+		sqlmock.NewRows([]string{"name", "labels"}).AddRow("requests", []byte("{}")))
+	mock.ExpectExec(`WITH upd AS \(UPDATE counters SET value = 0 WHERE name = \$1 AND labels = \$2 RETURNING name, labels, value\) INSERT INTO counter_samples`).
+		WithArgs("requests", "{}").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM counter_sources WHERE name = \$1 AND labels = \$2`).WithArgs("requests", "{}").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(`SELECT name, labels FROM histograms`).WillReturnRows(
+		sqlmock.NewRows([]string{"name", "labels"}).AddRow("requests", []byte("{}")))
+	mock.ExpectExec(`UPDATE histograms SET counts = array_fill`).WithArgs("requests", "{}").
+		WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+
+	subject := PGStorage{
+		conn: db,
+	}
+
+	_, err := subject.Reset(context.Background(), "", "requests")
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_CounterSources(t *testing.T) {
+	db, mock := NewMock()
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`SELECT source, value, updated_at FROM counter_sources WHERE name = \$1 AND labels = \$2 ORDER BY value DESC`).
+		WithArgs("PollCount", "{}").WillReturnRows(
+		sqlmock.NewRows([]string{"source", "value", "updated_at"}).
+			AddRow("10.0.0.2", 900, ts).
+			AddRow("10.0.0.1", 100, ts))
+	mock.ExpectQuery(`SELECT source, value, updated_at FROM counter_sources`).WithArgs("requests", "{}").WillReturnRows(
+		sqlmock.NewRows([]string{"source", "value", "updated_at"}))
+
+	subject := PGStorage{
+		conn: db,
+	}
+
+	got, err := subject.CounterSources(context.Background(), "PollCount", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.SourceTotal{
+		{Source: "10.0.0.2", Delta: 900, UpdatedAt: ts},
+		{Source: "10.0.0.1", Delta: 100, UpdatedAt: ts},
+	}, got)
+
+	_, err = subject.CounterSources(context.Background(), "requests", nil)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,165 @@
+package psql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+)
+
+const (
+	// rollupLookback is how far back samples are rolled up again on every run, ones recorded later are missed
+	rollupLookback = 5 * time.Minute
+	// maxPoints is number of rollup intervals in a range, starting from which the range is answered with the rollup
+	maxPoints = 1000
+)
+
+// rollup describes tables of samples aggregated over fixed intervals. Gauges keep sum and number of values,
+// counters keep the last value and the increase over the interval.
+type rollup struct {
+	resolution time.Duration
+	suffix     string // appended to the name of samples table
+}
+
+// rollups from the finest, every one is aggregated from the previous one.
+var rollups = []rollup{
+	{resolution: time.Minute, suffix: "_1m"},
+	{resolution: time.Hour, suffix: "_1h"},
+}
+
+// Rollup aggregates samples recorded since shortly before now into rollup tables. Intervals already rolled up
+// are aggregated again, so that samples recorded late are included.
+func (pg *PGStorage) Rollup(ctx context.Context, now time.Time) error {
+	for _, t := range tables {
+		if t.samples == "" {
+			continue
+		}
+		src, raw := t.samples, true
+		for _, r := range rollups {
+			dst := t.samples + r.suffix
+			start := now.Add(-rollupLookback).Truncate(r.resolution)
+			if _, err := pg.conn.ExecContext(ctx, rollupQuery(t.metricType, dst, src, raw), start, r.resolution.Seconds()); err != nil {
+				return fmt.Errorf("could not roll up %s: %w", dst, err)
+			}
+			src, raw = dst, false
+		}
+	}
+	return nil
+}
+
+// rollupQuery returns statement aggregating samples of the src table recorded since $1 into $2 seconds long
+// intervals of the dst table.
+func rollupQuery(metricType string, dst string, src string, raw bool) string {
+	const bucket = `to_timestamp(floor(extract(epoch FROM ts) / $2) * $2)`
+
+	if metricType == GaugeType {
+		values, where := `sum(sum), sum(count)`, `ts >= $1`
+		if raw {
+			values, where = `sum(value), count(value)`, `ts >= $1 AND value IS NOT NULL`
+		}
+		return `INSERT INTO ` + dst + `(name, labels, ts, sum, count) ` +
+			`SELECT name, labels, ` + bucket + ` AS bucket, ` + values + ` FROM ` + src + ` WHERE ` + where + ` ` +
+			`GROUP BY name, labels, bucket ` +
+			`ON CONFLICT (name, labels, ts) DO UPDATE SET sum = EXCLUDED.sum, count = EXCLUDED.count`
+	}
+
+	upsert := ` ON CONFLICT (name, labels, ts) DO UPDATE SET value = EXCLUDED.value, increase = EXCLUDED.increase`
+	if !raw {
+		return `INSERT INTO ` + dst + `(name, labels, ts, value, increase) ` +
+			`SELECT name, labels, ` + bucket + ` AS bucket, (array_agg(value ORDER BY ts DESC))[1], sum(increase) ` +
+			`FROM ` + src + ` WHERE ts >= $1 GROUP BY name, labels, bucket` + upsert
+	}
+	// increase is counted from the previous sample, which may be recorded before $1; the value lower than
+	// the previous one means that the counter was reset
+	return `INSERT INTO ` + dst + `(name, labels, ts, value, increase) ` +
+		`SELECT name, labels, ` + bucket + ` AS bucket, (array_agg(value ORDER BY ts DESC))[1], ` +
+		`sum(CASE WHEN value < prev THEN value ELSE value - prev END) ` +
+		`FROM (SELECT name, labels, ts, value, COALESCE(lag(value) OVER (PARTITION BY name, labels ORDER BY ts), ` +
+		`(SELECT p.value FROM ` + src + ` p WHERE p.name = s.name AND p.labels = s.labels AND p.ts < $1 AND p.value IS NOT NULL ` +
+		`ORDER BY p.ts DESC LIMIT 1), 0) AS prev ` +
+		`FROM ` + src + ` s WHERE ts >= $1 AND value IS NOT NULL) d ` +
+		`GROUP BY name, labels, bucket` + upsert
+}
+
+// rollupFor returns rollup answering range query, or nil if raw samples should be used. It is the coarsest
+// rollup, which resolution fits the step or which makes up less than maxPoints intervals of the range.
+// The finest rollup is used for ranges starting before raw samples are kept.
+func (pg *PGStorage) rollupFor(from, to time.Time, step time.Duration, now time.Time) *rollup {
+	var res *rollup
+	for i, r := range rollups {
+		if r.resolution <= step || to.Sub(from) >= maxPoints*r.resolution ||
+			i == 0 && from.Before(now.Add(-pg.retention)) {
+			res = &rollups[i]
+		}
+	}
+	return res
+}
+
+// rollupHistory returns values of the metric aggregated by the rollup between from and to, downsampled by step.
+// Gauge values are averaged, counters keep the last value of every interval.
+func (pg *PGStorage) rollupHistory(ctx context.Context, r rollup, t table, metricName string, labels models.Labels,
+	from, to time.Time, step time.Duration) ([]models.Sample, error) {
+	// the interval containing from is included
+	args := []any{metricName, labels, from.Truncate(r.resolution), to}
+	where := ` WHERE name = $1 AND labels = $2 AND ts BETWEEN $3 AND $4 ORDER BY ts`
+
+	if t.metricType != GaugeType {
+		samples, err := querySamples(ctx, pg.conn, `SELECT ts, value FROM `+t.samples+r.suffix+where, args...)
+		if err != nil {
+			return nil, err
+		}
+		return models.Downsample(samples, from, step), nil
+	}
+
+	rows, err := pg.conn.QueryContext(ctx, `SELECT ts, sum, count FROM `+t.samples+r.suffix+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var aggregates []aggregate
+	for rows.Next() {
+		var a aggregate
+		if err = rows.Scan(&a.timestamp, &a.sum, &a.count); err != nil {
+			return nil, err
+		}
+		aggregates = append(aggregates, a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return averages(aggregates, from, step), nil
+}
+
+// aggregate is sum and number of gauge values recorded over a rollup interval.
+type aggregate struct {
+	timestamp time.Time
+	sum       float64
+	count     int64
+}
+
+// averages merges aggregates into step-long buckets starting at from, like models.Downsample does with samples,
+// and returns average value of every bucket. Zero step keeps aggregates as they are.
+func averages(aggregates []aggregate, from time.Time, step time.Duration) []models.Sample {
+	merged := make([]aggregate, 0, len(aggregates))
+	for _, a := range aggregates {
+		if step > 0 {
+			a.timestamp = from.Add(a.timestamp.Sub(from) / step * step)
+		}
+		if n := len(merged); n > 0 && merged[n-1].timestamp.Equal(a.timestamp) {
+			merged[n-1].sum += a.sum
+			merged[n-1].count += a.count
+			continue
+		}
+		merged = append(merged, a)
+	}
+
+	res := make([]models.Sample, 0, len(merged))
+	for _, a := range merged {
+		if a.count > 0 {
+			res = append(res, models.Sample{Timestamp: a.timestamp, Value: a.sum / float64(a.count)})
+		}
+	}
+	return res
+}
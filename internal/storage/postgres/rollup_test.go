@@ -0,0 +1,118 @@
+package psql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPGStorage_rollupFor(t *testing.T) {
+	now := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		from time.Time
+		to   time.Time
+		step time.Duration
+		want time.Duration // resolution of the rollup, zero for raw samples
+	}{
+		{name: "Recent", from: now.Add(-30 * time.Minute), to: now, want: 0},
+		{name: "Step under minute", from: now.Add(-30 * time.Minute), to: now, step: 10 * time.Second, want: 0},
+		{name: "Step of minutes", from: now.Add(-30 * time.Minute), to: now, step: 5 * time.Minute, want: time.Minute},
+		{name: "Step of hours", from: now.Add(-30 * time.Minute), to: now, step: time.Hour, want: time.Hour},
+		{name: "Expired from raw samples", from: now.Add(-2 * time.Hour), to: now.Add(-90 * time.Minute), want: time.Minute},
+		{name: "Long range", from: now.Add(-24 * time.Hour), to: now, want: time.Minute},
+		{name: "Very long range", from: now.Add(-60 * 24 * time.Hour), to: now, want: time.Hour},
+	}
+
+	pg := PGStorage{retention: time.Hour}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := pg.rollupFor(tt.from, tt.to, tt.step, now)
+			if tt.want == 0 {
+				assert.Nil(t, got)
+				return
+			}
+			require.NotNil(t, got)
+			assert.Equal(t, tt.want, got.resolution)
+		})
+	}
+}
+
+func Test_averages(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	aggregates := []aggregate{
+		{timestamp: from, sum: 10, count: 2},
+		{timestamp: from.Add(time.Minute), sum: 30, count: 3},
+		{timestamp: from.Add(2 * time.Minute), sum: 6, count: 1},
+	}
+
+	assert.Equal(t, []models.Sample{
+		{Timestamp: from, Value: 5},
+		{Timestamp: from.Add(time.Minute), Value: 10},
+		{Timestamp: from.Add(2 * time.Minute), Value: 6},
+	}, averages(aggregates, from, 0))
+	// averaged over all values, not over averages of intervals
+	assert.Equal(t, []models.Sample{
+		{Timestamp: from, Value: 8},
+		{Timestamp: from.Add(2 * time.Minute), Value: 6},
+	}, averages(aggregates, from, 2*time.Minute))
+}
+
+func TestPGStorage_History(t *testing.T) {
+	to := time.Now()
+	from := to.Add(-3 * time.Hour).Add(30 * time.Second)
+
+	t.Run("Gauge", func(t *testing.T) {
+		db, mock := NewMock()
+		start := from.Truncate(time.Minute)
+		mock.ExpectQuery(`SELECT ts, sum, count FROM gauge_samples_1m WHERE name = \$1 AND labels = \$2 AND ts BETWEEN \$3 AND \$4 ORDER BY ts`).
+			WithArgs("Alloc", "{}", start, to).WillReturnRows(
+			sqlmock.NewRows([]string{"ts", "sum", "count"}).AddRow(start, 3.0, 2).AddRow(start.Add(time.Minute), 4.5, 3))
+
+		subject := PGStorage{conn: db, retention: time.Hour}
+		samples, err := subject.History(context.Background(), GaugeType, "Alloc", nil, from, to, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []models.Sample{{Timestamp: start, Value: 1.5}, {Timestamp: start.Add(time.Minute), Value: 1.5}}, samples)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Counter", func(t *testing.T) {
+		db, mock := NewMock()
+		start := from.Truncate(time.Hour)
+		from := start
+		mock.ExpectQuery(`SELECT ts, value FROM counter_samples_1h WHERE name = \$1 AND labels = \$2 AND ts BETWEEN \$3 AND \$4 ORDER BY ts`).
+			WithArgs("requests", "{}", start, to).WillReturnRows(
+			sqlmock.NewRows([]string{"ts", "value"}).AddRow(start, 10).AddRow(start.Add(time.Hour), 25))
+
+		subject := PGStorage{conn: db, retention: time.Hour}
+		samples, err := subject.History(context.Background(), CountType, "requests", nil, from, to, time.Hour)
+		require.NoError(t, err)
+		require.Len(t, samples, 2)
+		assert.Equal(t, 25.0, samples[1].Value)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPGStorage_Rollup(t *testing.T) {
+	db, mock := NewMock()
+	now := time.Date(2024, 1, 1, 10, 2, 30, 0, time.UTC)
+
+	// rollups are aggregated from the previous ones
+	mock.ExpectExec(`INSERT INTO gauge_samples_1m\(name, labels, ts, sum, count\) SELECT .* sum\(value\), count\(value\) FROM gauge_samples WHERE`).
+		WithArgs(time.Date(2024, 1, 1, 9, 57, 0, 0, time.UTC), 60.0).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO gauge_samples_1h\(name, labels, ts, sum, count\) SELECT .* sum\(sum\), sum\(count\) FROM gauge_samples_1m WHERE`).
+		WithArgs(time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC), 3600.0).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO counter_samples_1m\(name, labels, ts, value, increase\) SELECT .* FROM counter_samples s WHERE`).
+		WithArgs(time.Date(2024, 1, 1, 9, 57, 0, 0, time.UTC), 60.0).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO counter_samples_1h\(name, labels, ts, value, increase\) SELECT .* sum\(increase\) FROM counter_samples_1m WHERE`).
+		WithArgs(time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC), 3600.0).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	subject := PGStorage{conn: db}
+	assert.NoError(t, subject.Rollup(context.Background(), now))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
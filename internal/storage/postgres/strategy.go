@@ -4,33 +4,44 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
 	"github.com/igortoigildin/go-metrics-altering/pkg/logger"
+	"github.com/lib/pq"
 	"go.uber.org/zap"
 )
 
 type Strategy interface {
-	Update(ctx context.Context, metricType string, metricName string, metricValue any) error
-	Get(ctx context.Context, metricType string, metricName string) (models.Metrics, error)
+	Update(ctx context.Context, metricType string, metricName string, labels models.Labels, metricValue any) error
+	Get(ctx context.Context, metricType string, metricName string, labels models.Labels) (models.Metrics, error)
+	History(ctx context.Context, metricName string, labels models.Labels, from, to time.Time) ([]models.Sample, error)
 }
 
 type Count struct {
 	conn *sql.DB
 }
 
-func (c *Count) Update(ctx context.Context, metricType string, metricName string, metricValue any) error {
-	_, err := c.conn.ExecContext(ctx, `INSERT INTO counters(name, type, value) VALUES($1, $2, $3) ON CONFLICT (name) DO UPDATE SET value = counters.value + $3`, metricName, metricType, metricValue)
+func (c *Count) Update(ctx context.Context, metricType string, metricName string, labels models.Labels, metricValue any) error {
+	// increment is attributed to its source in the same statement, so subtotals always add up to the value
+	_, err := c.conn.ExecContext(ctx, `WITH upd AS (INSERT INTO counters(name, type, value, labels) VALUES($1, $2, $3, $4) `+
+		`ON CONFLICT (name, labels) DO UPDATE SET value = counters.value + $3 RETURNING name, labels, value), `+
+		`src AS (INSERT INTO counter_sources(name, labels, source, value) VALUES($1, $4, $5, $3) `+
+		`ON CONFLICT (name, labels, source) DO UPDATE SET value = counter_sources.value + $3, updated_at = now()) `+
+		`INSERT INTO counter_samples(name, labels, value) SELECT name, labels, value FROM upd`,
+		metricName, metricType, metricValue, labels, storage.SourceFromContext(ctx))
 	return err
 }
 
-func (c *Count) Get(ctx context.Context, metricType string, metricName string) (models.Metrics, error) {
+func (c *Count) Get(ctx context.Context, metricType string, metricName string, labels models.Labels) (models.Metrics, error) {
 	var metric models.Metrics
-	err := c.conn.QueryRowContext(ctx, "SELECT name, type, value FROM counters WHERE name = ?", metricName).Scan(
-		&metric.ID, &metric.MType, &metric.Delta)
+	err := c.conn.QueryRowContext(ctx, "SELECT name, type, value, labels FROM counters WHERE name = $1 AND labels = $2", metricName, labels).Scan(
+		&metric.ID, &metric.MType, &metric.Delta, &metric.Labels)
 	switch {
 	case errors.Is(err, sql.ErrNoRows):
-		return metric, err
+		return metric, fmt.Errorf("%w: %w", storage.ErrNotFound, err)
 	case err != nil:
 		logger.Log.Info("error while obtaining metrics", zap.Error(err))
 		return metric, err
@@ -42,22 +53,120 @@ type Gauge struct {
 	conn *sql.DB
 }
 
-func (g *Gauge) Update(ctx context.Context, metricType string, metricName string, metricValue any) error {
-	_, err := g.conn.ExecContext(ctx, "INSERT INTO gauges(name, type, value) VALUES($1, $2, $3)"+
-		"ON CONFLICT (name) DO UPDATE SET value = $3", metricName, metricType, metricValue)
+func (g *Gauge) Update(ctx context.Context, metricType string, metricName string, labels models.Labels, metricValue any) error {
+	_, err := g.conn.ExecContext(ctx, "WITH upd AS (INSERT INTO gauges(name, type, value, labels) VALUES($1, $2, $3, $4) "+
+		"ON CONFLICT (name, labels) DO UPDATE SET value = $3 RETURNING name, labels, value) "+
+		"INSERT INTO gauge_samples(name, labels, value) SELECT name, labels, value FROM upd", metricName, metricType, metricValue, labels)
 	return err
 }
 
-func (g *Gauge) Get(ctx context.Context, metricType string, metricName string) (models.Metrics, error) {
+func (g *Gauge) Get(ctx context.Context, metricType string, metricName string, labels models.Labels) (models.Metrics, error) {
 	var metric models.Metrics
-	err := g.conn.QueryRowContext(ctx, "SELECT name, type, value FROM gauges WHERE name = $1", metricName).Scan(
-		&metric.ID, &metric.MType, &metric.Value)
+	err := g.conn.QueryRowContext(ctx, "SELECT name, type, value, labels FROM gauges WHERE name = $1 AND labels = $2", metricName, labels).Scan(
+		&metric.ID, &metric.MType, &metric.Value, &metric.Labels)
 	switch {
 	case errors.Is(err, sql.ErrNoRows):
 		logger.Log.Info("no rows selected", zap.Error(err))
+		return metric, fmt.Errorf("%w: %w", storage.ErrNotFound, err)
+	case err != nil:
 		return metric, err
+	}
+	return metric, nil
+}
+
+func (c *Count) History(ctx context.Context, metricName string, labels models.Labels, from, to time.Time) ([]models.Sample, error) {
+	return querySamples(ctx, c.conn, `SELECT ts, value FROM counter_samples WHERE name = $1 AND labels = $2 AND ts BETWEEN $3 AND $4 ORDER BY ts`,
+		metricName, labels, from, to)
+}
+
+func (g *Gauge) History(ctx context.Context, metricName string, labels models.Labels, from, to time.Time) ([]models.Sample, error) {
+	return querySamples(ctx, g.conn, `SELECT ts, value FROM gauge_samples WHERE name = $1 AND labels = $2 AND ts BETWEEN $3 AND $4 ORDER BY ts`,
+		metricName, labels, from, to)
+}
+
+func querySamples(ctx context.Context, conn *sql.DB, query string, args ...any) ([]models.Sample, error) {
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := make([]models.Sample, 0)
+	for rows.Next() {
+		var sample models.Sample
+		if err = rows.Scan(&sample.Timestamp, &sample.Value); err != nil {
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
+}
+
+type Histogram struct {
+	conn *sql.DB
+}
+
+// Update merges observations into the stored histogram. Bucket counts are added element-wise,
+// so bounds of the stored and the new histogram must be equal.
+func (h *Histogram) Update(ctx context.Context, metricType string, metricName string, labels models.Labels, metricValue any) error {
+	v, ok := metricValue.(*models.Histogram)
+	if !ok {
+		return fmt.Errorf("%w: %w", storage.ErrTypeConflict, models.ErrInvalidHistogram)
+	}
+	if err := v.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", storage.ErrInvalidValue, err)
+	}
+
+	counts := make([]int64, len(v.Counts))
+	for i, c := range v.Counts {
+		counts[i] = int64(c)
+	}
+
+	res, err := h.conn.ExecContext(ctx, `INSERT INTO histograms(name, type, labels, bounds, counts, sum, count) VALUES($1, $2, $3, $4, $5, $6, $7) `+
+		`ON CONFLICT (name, labels) DO UPDATE SET `+
+		`counts = ARRAY(SELECT a + b FROM unnest(histograms.counts, EXCLUDED.counts) AS t(a, b)), `+
+		`sum = histograms.sum + EXCLUDED.sum, count = histograms.count + EXCLUDED.count `+
+		`WHERE histograms.bounds = EXCLUDED.bounds`,
+		metricName, metricType, labels, pq.Array(v.Bounds), pq.Array(counts), v.Sum, int64(v.Count))
+	if err != nil {
+		return err
+	}
+
+	// no rows are affected when conflicting row has different bounds
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %w", storage.ErrInvalidValue, models.ErrBoundsMismatch)
+	}
+	return nil
+}
+
+func (h *Histogram) Get(ctx context.Context, metricType string, metricName string, labels models.Labels) (models.Metrics, error) {
+	var metric models.Metrics
+	var counts []int64
+	histogram := &models.Histogram{}
+
+	err := h.conn.QueryRowContext(ctx, "SELECT name, type, labels, bounds, counts, sum, count FROM histograms WHERE name = $1 AND labels = $2",
+		metricName, labels).Scan(&metric.ID, &metric.MType, &metric.Labels, pq.Array(&histogram.Bounds), pq.Array(&counts), &histogram.Sum, &histogram.Count)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return metric, fmt.Errorf("%w: %w", storage.ErrNotFound, err)
 	case err != nil:
+		logger.Log.Info("error while obtaining metrics", zap.Error(err))
 		return metric, err
 	}
+
+	histogram.Counts = make([]uint64, len(counts))
+	for i, c := range counts {
+		histogram.Counts[i] = uint64(c)
+	}
+	metric.Histogram = histogram
 	return metric, nil
 }
+
+// History is not recorded for histograms.
+func (h *Histogram) History(ctx context.Context, metricName string, labels models.Labels, from, to time.Time) ([]models.Sample, error) {
+	return nil, errors.New("history is not supported for histograms")
+}
@@ -4,33 +4,144 @@ import (
 	"context"
 	"database/sql"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/igortoigildin/go-metrics-altering/internal/models"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestCount_Get(t *testing.T) {
 	db, mock := NewMock()
 
-	mock.ExpectQuery(`SELECT name, type, value FROM counters WHERE name = ?`).WithArgs("testmetric").WillReturnRows(
+	mock.ExpectQuery(`SELECT name, type, value, labels FROM counters WHERE name = \$1 AND labels = \$2`).WithArgs("testmetric", "{}").WillReturnRows(
 		sqlmock.NewRows([]string{"testmetric", "counter", "1"}))
 
 	c := Count{
 		conn: db,
 	}
-	_, err := c.Get(context.Background(), "counter", "testmetric")
+	_, err := c.Get(context.Background(), "counter", "testmetric", nil)
 	assert.ErrorIs(t, err, sql.ErrNoRows)
 }
 
+func TestCount_Update(t *testing.T) {
+	db, mock := NewMock()
+
+	mock.ExpectExec(`WITH upd AS \(INSERT INTO counters.+src AS \(INSERT INTO counter_sources\(name, labels, source, value\) VALUES\(\$1, \$4, \$5, \$3\)`).
+		WithArgs("PollCount", "counter", int64(5), "{}", "10.0.0.1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO counter_sources`).
+		WithArgs("PollCount", "counter", int64(1), "{}", storage.UnknownSource).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	c := Count{
+		conn: db,
+	}
+	err := c.Update(storage.WithSource(context.Background(), "10.0.0.1"), "counter", "PollCount", nil, int64(5))
+	assert.NoError(t, err)
+	err = c.Update(context.Background(), "counter", "PollCount", nil, int64(1))
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestGauge_Get(t *testing.T) {
 	db, mock := NewMock()
 
-	mock.ExpectQuery(`SELECT name, type, value FROM gauges WHERE name = ?`).WithArgs("testmetric").WillReturnRows(
+	mock.ExpectQuery(`SELECT name, type, value, labels FROM gauges WHERE name = \$1 AND labels = \$2`).WithArgs("testmetric", "{}").WillReturnRows(
 		sqlmock.NewRows([]string{"testmetric", "gauges", "1"}))
 
 	g := Gauge{
 		conn: db,
 	}
-	_, err := g.Get(context.Background(), "gauges", "testmetric")
+	_, err := g.Get(context.Background(), "gauges", "testmetric", nil)
 	assert.ErrorIs(t, err, sql.ErrNoRows)
 }
+
+func TestGauge_History(t *testing.T) {
+	db, mock := NewMock()
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+
+	mock.ExpectQuery(`SELECT ts, value FROM gauge_samples WHERE name = \$1 AND labels = \$2 AND ts BETWEEN \$3 AND \$4 ORDER BY ts`).
+		WithArgs("testmetric", `{"host":"a"}`, from, to).WillReturnRows(
+		sqlmock.NewRows([]string{"ts", "value"}).AddRow(from.Add(time.Minute), 1.5).AddRow(from.Add(2*time.Minute), 2.5))
+
+	g := Gauge{
+		conn: db,
+	}
+	samples, err := g.History(context.Background(), "testmetric", models.Labels{"host": "a"}, from, to)
+	assert.NoError(t, err)
+	assert.Len(t, samples, 2)
+	assert.Equal(t, 2.5, samples[1].Value)
+}
+
+func TestCount_History(t *testing.T) {
+	db, mock := NewMock()
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+
+	mock.ExpectQuery(`SELECT ts, value FROM counter_samples WHERE name = \$1 AND labels = \$2 AND ts BETWEEN \$3 AND \$4 ORDER BY ts`).
+		WithArgs("testmetric", "{}", from, to).WillReturnError(sql.ErrConnDone)
+
+	c := Count{
+		conn: db,
+	}
+	_, err := c.History(context.Background(), "testmetric", nil, from, to)
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+}
+
+func TestGauge_Update(t *testing.T) {
+	db, mock := NewMock()
+	value := 1.5
+
+	mock.ExpectExec(`INSERT INTO gauges\(name, type, value, labels\) VALUES\(\$1, \$2, \$3, \$4\) ON CONFLICT \(name, labels\)`).
+		WithArgs("testmetric", "gauge", &value, `{"host":"a"}`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	g := Gauge{
+		conn: db,
+	}
+	err := g.Update(context.Background(), "gauge", "testmetric", models.Labels{"host": "a"}, &value)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHistogram_Update(t *testing.T) {
+	db, mock := NewMock()
+	h := &models.Histogram{Bounds: []float64{0.1, 1}, Counts: []uint64{1, 0, 1}, Sum: 2.05, Count: 2}
+
+	mock.ExpectExec(`INSERT INTO histograms\(name, type, labels, bounds, counts, sum, count\)`).
+		WithArgs("latency", "histogram", "{}", sqlmock.AnyArg(), sqlmock.AnyArg(), 2.05, int64(2)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO histograms\(name, type, labels, bounds, counts, sum, count\)`).
+		WithArgs("latency", "histogram", "{}", sqlmock.AnyArg(), sqlmock.AnyArg(), 2.05, int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	s := Histogram{
+		conn: db,
+	}
+	err := s.Update(context.Background(), "histogram", "latency", nil, h)
+	assert.NoError(t, err)
+
+	// stored histogram has other bounds
+	err = s.Update(context.Background(), "histogram", "latency", nil, h)
+	assert.ErrorIs(t, err, models.ErrBoundsMismatch)
+
+	err = s.Update(context.Background(), "histogram", "latency", nil, &models.Histogram{Bounds: []float64{1}})
+	assert.ErrorIs(t, err, models.ErrInvalidHistogram)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHistogram_Get(t *testing.T) {
+	db, mock := NewMock()
+
+	mock.ExpectQuery(`SELECT name, type, labels, bounds, counts, sum, count FROM histograms WHERE name = \$1 AND labels = \$2`).
+		WithArgs("latency", "{}").WillReturnRows(
+		sqlmock.NewRows([]string{"name", "type", "labels", "bounds", "counts", "sum", "count"}).
+			AddRow("latency", "histogram", []byte("{}"), []byte("{0.1,1}"), []byte("{1,0,1}"), 2.05, 2))
+
+	s := Histogram{
+		conn: db,
+	}
+	metric, err := s.Get(context.Background(), "histogram", "latency", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &models.Histogram{Bounds: []float64{0.1, 1}, Counts: []uint64{1, 0, 1}, Sum: 2.05, Count: 2}, metric.Histogram)
+}
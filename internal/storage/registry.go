@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	config "github.com/igortoigildin/go-metrics-altering/config/server"
+)
+
+// ErrUnknownDriver is returned when no driver is registered for scheme of storage URL.
+var ErrUnknownDriver = errors.New("unknown storage driver")
+
+// Driver opens storage described by URL. Server config provides defaults for options missing in the URL.
+type Driver func(u *url.URL, cfg *config.ConfigServer) (Storage, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+// Register makes the driver available for URLs with the stated scheme.
+// It is meant to be called from init function of the driver package and panics if the scheme is taken.
+func Register(scheme string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	if driver == nil {
+		panic("storage: driver is nil")
+	}
+	if _, ok := drivers[scheme]; ok {
+		panic("storage: driver registered twice for scheme " + scheme)
+	}
+	drivers[scheme] = driver
+}
+
+// Drivers returns sorted schemes of registered drivers.
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	res := make([]string, 0, len(drivers))
+	for scheme := range drivers {
+		res = append(res, scheme)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Open opens storage with the driver registered for scheme of the URL.
+func Open(rawURL string, cfg *config.ConfigServer) (Storage, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage url: %w", err)
+	}
+
+	driversMu.RLock()
+	driver, ok := drivers[u.Scheme]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q, registered: %s", ErrUnknownDriver, u.Scheme, strings.Join(Drivers(), ", "))
+	}
+	return driver(u, cfg)
+}
+
+// Path returns file path stated in the URL: file:///var/lib/metrics.json is absolute
+// and file:metrics.json is relative path.
+func Path(u *url.URL) string {
+	if u.Opaque != "" {
+		return u.Opaque
+	}
+	return u.Host + u.Path
+}
+
+// Options are per-driver options passed in query of storage URL.
+type Options url.Values
+
+// Pop removes the option and returns its value, empty if not set.
+// Drivers pop their options, so that only options of the underlying database are left.
+func (o Options) Pop(name string) string {
+	v := url.Values(o).Get(name)
+	delete(o, name)
+	return v
+}
+
+// Int pops integer option, def is returned if the option is not set.
+func (o Options) Int(name string, def int) (int, error) {
+	v := o.Pop(name)
+	if v == "" {
+		return def, nil
+	}
+	res, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid option %q: %w", name, err)
+	}
+	return res, nil
+}
+
+// Bool pops boolean option, def is returned if the option is not set.
+func (o Options) Bool(name string, def bool) (bool, error) {
+	v := o.Pop(name)
+	if v == "" {
+		return def, nil
+	}
+	res, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid option %q: %w", name, err)
+	}
+	return res, nil
+}
+
+// Duration pops duration option, such as 90s or 1h, def is returned if the option is not set.
+func (o Options) Duration(name string, def time.Duration) (time.Duration, error) {
+	v := o.Pop(name)
+	if v == "" {
+		return def, nil
+	}
+	res, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid option %q: %w", name, err)
+	}
+	return res, nil
+}
+
+// Unknown returns error if any option is left after the driver popped all supported ones.
+func (o Options) Unknown() error {
+	if len(o) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(o))
+	for name := range o {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("unknown storage options: %s", strings.Join(names, ", "))
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	config "github.com/igortoigildin/go-metrics-altering/config/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen(t *testing.T) {
+	var opened *url.URL
+	Register("test", func(u *url.URL, cfg *config.ConfigServer) (Storage, error) {
+		opened = u
+		return nil, nil
+	})
+	assert.Panics(t, func() {
+		Register("test", func(u *url.URL, cfg *config.ConfigServer) (Storage, error) { return nil, nil })
+	})
+	assert.Contains(t, Drivers(), "test")
+
+	_, err := Open("test:///var/lib/metrics?retention=1h", &config.ConfigServer{})
+	require.NoError(t, err)
+	assert.Equal(t, "/var/lib/metrics", Path(opened))
+	assert.Equal(t, "1h", opened.Query().Get("retention"))
+
+	_, err = Open("unknown://", &config.ConfigServer{})
+	assert.ErrorIs(t, err, ErrUnknownDriver)
+}
+
+func TestURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.ConfigServer
+		want    string
+		wantErr bool
+	}{
+		{name: "Storage URL", cfg: config.ConfigServer{FlagStorageURL: "bolt:///tmp/m.db", FlagDBDSN: "postgres://localhost/db"}, want: "bolt:///tmp/m.db"},
+		{name: "Postgres URL", cfg: config.ConfigServer{FlagDBDSN: "postgres://localhost/db", FlagStorePath: "/tmp/m.json"}, want: "postgres://localhost/db"},
+		{name: "Postgres key/value DSN", cfg: config.ConfigServer{FlagDBDSN: "host=localhost dbname='metrics'"}, want: "postgres://?dbname=metrics&host=localhost"},
+		{name: "Quoted DSN values", cfg: config.ConfigServer{FlagDBDSN: `user = admin password='a b\'c' sslmode=disable`}, want: "postgres://?password=a+b%27c&sslmode=disable&user=admin"},
+		{name: "Unterminated quote", cfg: config.ConfigServer{FlagDBDSN: "password='a b"}, wantErr: true},
+		{name: "Missing value", cfg: config.ConfigServer{FlagDBDSN: "host=localhost metrics"}, wantErr: true},
+		{name: "Absolute file", cfg: config.ConfigServer{FlagStorePath: "/tmp/m.json"}, want: "file:///tmp/m.json"},
+		{name: "Relative file", cfg: config.ConfigServer{FlagStorePath: "m.json"}, want: "file:m.json"},
+		{name: "Memory", cfg: config.ConfigServer{}, want: "memory://"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := URL(&tt.cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+
+			u, err := url.Parse(got)
+			require.NoError(t, err)
+			if u.Scheme == "file" {
+				assert.Equal(t, tt.cfg.FlagStorePath, Path(u))
+			}
+		})
+	}
+}
+
+func TestOptions(t *testing.T) {
+	u, err := url.Parse("memory://?retention=90s&interval=0&restore=true&sslmode=disable")
+	require.NoError(t, err)
+	opts := Options(u.Query())
+
+	retention, err := opts.Duration("retention", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Second, retention)
+
+	interval, err := opts.Int("interval", 5)
+	require.NoError(t, err)
+	assert.Equal(t, 0, interval)
+
+	restore, err := opts.Bool("restore", false)
+	require.NoError(t, err)
+	assert.True(t, restore)
+
+	generations, err := opts.Int("generations", 3)
+	require.NoError(t, err)
+	assert.Equal(t, 3, generations)
+
+	assert.EqualError(t, opts.Unknown(), "unknown storage options: sslmode")
+	assert.Equal(t, "disable", opts.Pop("sslmode"))
+	assert.NoError(t, opts.Unknown())
+
+	opts = Options(url.Values{"interval": {"abc"}})
+	_, err = opts.Int("interval", 1)
+	assert.Error(t, err)
+}
+
+type backend struct{ Storage }
+
+func (backend) Backup() {}
+
+type decorator struct{ Storage }
+
+func (d decorator) Unwrap() Storage { return d.Storage }
+
+func TestAs(t *testing.T) {
+	type backuper interface{ Backup() }
+
+	b, ok := As[backuper](decorator{decorator{backend{}}})
+	assert.True(t, ok)
+	assert.Equal(t, backend{}, b)
+
+	_, ok = As[backuper](decorator{})
+	assert.False(t, ok)
+	_, ok = As[backuper](nil)
+	assert.False(t, ok)
+}
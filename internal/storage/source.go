@@ -0,0 +1,25 @@
+package storage
+
+import "context"
+
+// UnknownSource is recorded for increments which source is not stated in context.
+const UnknownSource = "unknown"
+
+type sourceKey struct{}
+
+// WithSource returns context stating source of written metrics, such as agent ID or its IP address.
+// Storages attributing counter increments record it alongside the increment.
+func WithSource(ctx context.Context, source string) context.Context {
+	if source == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// SourceFromContext returns source stated by WithSource, or UnknownSource.
+func SourceFromContext(ctx context.Context) string {
+	if source, ok := ctx.Value(sourceKey{}).(string); ok {
+		return source
+	}
+	return UnknownSource
+}
@@ -3,40 +3,129 @@ package storage
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
 
 	config "github.com/igortoigildin/go-metrics-altering/config/server"
 	"github.com/igortoigildin/go-metrics-altering/internal/models"
-	local "github.com/igortoigildin/go-metrics-altering/internal/storage/inmemory"
-	psql "github.com/igortoigildin/go-metrics-altering/internal/storage/postgres"
 )
 
 //go:generate go run github.com/vektra/mockery/v2@v2.45.0 --name=Storage
 type Storage interface {
-	Update(ctx context.Context, metricType string, metricName string, metricValue any) error
-	Get(ctx context.Context, metricType string, metricName string) (models.Metrics, error)
-	GetAll(ctx context.Context) (map[string]any, error)
+	Update(ctx context.Context, metricType string, metricName string, labels models.Labels, metricValue any) error
+	UpdateBatch(ctx context.Context, metrics []models.Metrics) error
+	Get(ctx context.Context, metricType string, metricName string, labels models.Labels) (models.Metrics, error)
+	List(ctx context.Context, filter models.ListFilter) ([]models.Metrics, string, error)
+	History(ctx context.Context, metricType string, metricName string, labels models.Labels, from, to time.Time, step time.Duration) ([]models.Sample, error)
+	Delete(ctx context.Context, metricType string, pattern string, matchers ...*models.LabelMatcher) (int, error)
+	Reset(ctx context.Context, metricType string, pattern string, matchers ...*models.LabelMatcher) (int, error)
 	Ping(ctx context.Context) error
 }
 
-func New(cfg *config.ConfigServer) (Storage, error) {
-	if cfg.FlagDBDSN != "" {
-		storage, err := psql.New(cfg)
-		if err != nil {
-			return nil, errors.New("failed to init storage")
+// As finds the first storage in the chain of decorators, starting with s, which implements T,
+// such as optional interface of the underlying backend. Decorators expose the wrapped storage with Unwrap method.
+func As[T any](s Storage) (T, bool) {
+	for s != nil {
+		if res, ok := s.(T); ok {
+			return res, true
+		}
+		u, ok := s.(interface{ Unwrap() Storage })
+		if !ok {
+			break
 		}
-		return storage, nil
+		s = u.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// New opens storage stated in the config. Drivers must be registered beforehand,
+// usually by importing internal/storage/all.
+func New(cfg *config.ConfigServer) (Storage, error) {
+	u, err := URL(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return Open(u, cfg)
+}
+
+// URL returns storage URL of the config. When it is not set, the URL is made of legacy flags:
+// database DSN stands for postgres, otherwise metrics are kept in memory and backed up to the file.
+func URL(cfg *config.ConfigServer) (string, error) {
+	switch {
+	case cfg.FlagStorageURL != "":
+		return cfg.FlagStorageURL, nil
+	case cfg.FlagDBDSN != "":
+		return dsnURL(cfg.FlagDBDSN)
+	case cfg.FlagStorePath == "":
+		return "memory://", nil
+	case filepath.IsAbs(cfg.FlagStorePath):
+		return (&url.URL{Scheme: "file", Path: cfg.FlagStorePath}).String(), nil
+	default:
+		return (&url.URL{Scheme: "file", Opaque: cfg.FlagStorePath}).String(), nil
+	}
+}
+
+// dsnURL converts key/value postgres DSN, such as "host=localhost password='a b'", to URL.
+// Values follow libpq rules: they may be single-quoted, and backslash escapes the next character.
+func dsnURL(dsn string) (string, error) {
+	if strings.Contains(dsn, "://") {
+		return dsn, nil
 	}
 
-	memory := local.New()
+	params := url.Values{}
+	s := strings.TrimLeft(dsn, " \t\n\r")
+	for s != "" {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return "", fmt.Errorf("invalid DSN: missing \"=\" after %q", s)
+		}
+		key := strings.TrimSpace(s[:eq])
+		if key == "" || strings.ContainsAny(key, " \t\n\r") {
+			return "", fmt.Errorf("invalid DSN: bad key %q", key)
+		}
 
-	if cfg.FlagRestore {
-		err := memory.LoadMetricsFromFile(cfg.FlagStorePath)
+		var (
+			value strings.Builder
+			err   error
+		)
+		s, err = dsnValue(strings.TrimLeft(s[eq+1:], " \t\n\r"), &value)
 		if err != nil {
-			return memory, err
+			return "", fmt.Errorf("invalid DSN value of %q: %w", key, err)
+		}
+		params.Set(key, value.String())
+		s = strings.TrimLeft(s, " \t\n\r")
+	}
+	return "postgres://?" + params.Encode(), nil
+}
+
+// dsnValue reads a value from the beginning of s to b and returns the rest of s.
+func dsnValue(s string, b *strings.Builder) (string, error) {
+	quoted := strings.HasPrefix(s, "'")
+	if quoted {
+		s = s[1:]
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+			if i == len(s) {
+				return "", errors.New("trailing backslash")
+			}
+			b.WriteByte(s[i])
+		case quoted && c == '\'':
+			return s[i+1:], nil
+		case !quoted && strings.IndexByte(" \t\n\r", c) >= 0:
+			return s[i:], nil
+		default:
+			b.WriteByte(c)
 		}
 	}
-	if cfg.FlagStorePath != "" {
-		go memory.SaveAllMetricsToFile(cfg.FlagStoreInterval, cfg.FlagStorePath, cfg.FlagStorePath)
+	if quoted {
+		return "", errors.New("unterminated quoted string")
 	}
-	return memory, nil
+	return "", nil
 }
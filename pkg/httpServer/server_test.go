@@ -9,13 +9,14 @@ import (
 	config "github.com/igortoigildin/go-metrics-altering/config/server"
 	server "github.com/igortoigildin/go-metrics-altering/internal/server/http/api"
 	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	_ "github.com/igortoigildin/go-metrics-altering/internal/storage/all"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestNew(t *testing.T) {
 	cfg := config.ConfigServer{}
 	storage, _ := storage.New(&cfg)
-	r := server.Router(context.Background(), &cfg, storage)
+	r := server.Router(context.Background(), &cfg, storage, nil)
 	s := New(r)
 	assert.IsType(t, Server{}, *s)
 }
@@ -0,0 +1,58 @@
+// Package auth provides gRPC interceptor checking whether client IP is in trusted subnet.
+package auth
+
+import (
+	"context"
+	"slices"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/realip"
+	"github.com/igortoigildin/go-metrics-altering/pkg/middlewares/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor rejects calls of the stated methods from clients outside of trusted subnet.
+// Client IP is taken from context, so the interceptor must be chained after realip one.
+// All calls are allowed if trusted subnet is empty.
+func UnaryServerInterceptor(trustedSubnet string, methods ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if trustedSubnet == "" || !slices.Contains(methods, info.FullMethod) {
+			return handler(ctx, req)
+		}
+		if err := checkTrusted(ctx, trustedSubnet); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming methods.
+func StreamServerInterceptor(trustedSubnet string, methods ...string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if trustedSubnet == "" || !slices.Contains(methods, info.FullMethod) {
+			return handler(srv, ss)
+		}
+		if err := checkTrusted(ss.Context(), trustedSubnet); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// checkTrusted returns PermissionDenied status if client IP is not in trusted subnet.
+func checkTrusted(ctx context.Context, trustedSubnet string) error {
+	ip, ok := realip.FromContext(ctx)
+	if !ok {
+		return status.Error(codes.PermissionDenied, "client IP not provided")
+	}
+
+	isTrusted, err := auth.IsIPInTrustedSubnet(ip.String(), trustedSubnet)
+	if err != nil {
+		return status.Error(codes.Internal, "internal error")
+	}
+	if !isTrusted {
+		return status.Error(codes.PermissionDenied, "client IP not in trusted subnet")
+	}
+	return nil
+}
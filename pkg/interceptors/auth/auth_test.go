@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/realip"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	const guarded = "/metrics.Metrics/DeleteMetrics"
+
+	// realip interceptor puts client IP into context
+	withIP := func(ip string) context.Context {
+		var ctx context.Context
+		p := &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}}
+		_, _ = realip.UnaryServerInterceptor(nil, nil)(peer.NewContext(context.Background(), p), nil, &grpc.UnaryServerInfo{},
+			func(c context.Context, req any) (any, error) {
+				ctx = c
+				return nil, nil
+			})
+		return ctx
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return "ok", nil
+	}
+
+	tests := []struct {
+		name          string
+		trustedSubnet string
+		ctx           context.Context
+		method        string
+		wantCode      codes.Code
+	}{
+		{
+			name:          "Trusted",
+			trustedSubnet: "127.0.0.0/8",
+			ctx:           withIP("127.0.0.2"),
+			method:        guarded,
+			wantCode:      codes.OK,
+		},
+		{
+			name:          "Not trusted",
+			trustedSubnet: "127.0.0.0/8",
+			ctx:           withIP("10.0.0.1"),
+			method:        guarded,
+			wantCode:      codes.PermissionDenied,
+		},
+		{
+			name:          "IP not provided",
+			trustedSubnet: "127.0.0.0/8",
+			ctx:           context.Background(),
+			method:        guarded,
+			wantCode:      codes.PermissionDenied,
+		},
+		{
+			name:          "Method not guarded",
+			trustedSubnet: "127.0.0.0/8",
+			ctx:           withIP("10.0.0.1"),
+			method:        "/metrics.Metrics/AddGaugeMetric",
+			wantCode:      codes.OK,
+		},
+		{
+			name:     "Trusted subnet not set",
+			ctx:      withIP("10.0.0.1"),
+			method:   guarded,
+			wantCode: codes.OK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			interceptor := UnaryServerInterceptor(tt.trustedSubnet, guarded)
+			_, err := interceptor(tt.ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			assert.Equal(t, tt.wantCode, status.Code(err))
+		})
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	const guarded = "/metrics.Replication/Replicate"
+
+	ctx := context.Background()
+	p := &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}}
+	_ = realip.StreamServerInterceptor(nil, nil)(nil, serverStream{ctx: peer.NewContext(ctx, p)}, &grpc.StreamServerInfo{},
+		func(srv any, ss grpc.ServerStream) error {
+			ctx = ss.Context()
+			return nil
+		})
+	handler := func(srv any, ss grpc.ServerStream) error {
+		return nil
+	}
+
+	err := StreamServerInterceptor("127.0.0.0/8", guarded)(nil, serverStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: guarded}, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	err = StreamServerInterceptor("10.0.0.0/8", guarded)(nil, serverStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: guarded}, handler)
+	assert.NoError(t, err)
+
+	err = StreamServerInterceptor("127.0.0.0/8", guarded)(nil, serverStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: "/metrics.Other/Stream"}, handler)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,38 @@
+// Package source provides gRPC interceptor stating source of written metrics in call context.
+package source
+
+import (
+	"context"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/realip"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// AgentIDKey is metadata key stating ID of the agent sending metrics.
+const AgentIDKey = "x-agent-id"
+
+// UnaryServerInterceptor puts source of the call into its context: agent ID if stated in metadata,
+// otherwise client IP. Client IP is taken from context, so the interceptor must be chained after realip one.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(storage.WithSource(ctx, Of(ctx)), req)
+	}
+}
+
+// Of returns source of the call, empty if unknown.
+func Of(ctx context.Context) string {
+	if ids := metadata.ValueFromIncomingContext(ctx, AgentIDKey); len(ids) > 0 && ids[0] != "" {
+		return ids[0]
+	}
+	return IP(ctx)
+}
+
+// IP returns client IP put into context by realip interceptor, empty if unknown.
+func IP(ctx context.Context) string {
+	if ip, ok := realip.FromContext(ctx); ok {
+		return ip.String()
+	}
+	return ""
+}
@@ -0,0 +1,60 @@
+package source
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/realip"
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	// realip interceptor puts client IP into context
+	withIP := func(ctx context.Context, ip string) context.Context {
+		p := &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}}
+		_, _ = realip.UnaryServerInterceptor(nil, nil)(peer.NewContext(ctx, p), nil, &grpc.UnaryServerInfo{},
+			func(c context.Context, req any) (any, error) {
+				ctx = c
+				return nil, nil
+			})
+		return ctx
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{
+			name: "Agent ID",
+			ctx:  withIP(metadata.NewIncomingContext(context.Background(), metadata.Pairs(AgentIDKey, "agent-1")), "10.0.0.1"),
+			want: "agent-1",
+		},
+		{
+			name: "Client IP",
+			ctx:  withIP(context.Background(), "10.0.0.1"),
+			want: "10.0.0.1",
+		},
+		{
+			name: "Unknown",
+			ctx:  context.Background(),
+			want: storage.UnknownSource,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			_, err := UnaryServerInterceptor()(tt.ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+				got = storage.SourceFromContext(ctx)
+				return nil, nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
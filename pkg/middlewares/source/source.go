@@ -0,0 +1,40 @@
+// Package source provides middleware stating source of written metrics in request context.
+package source
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+)
+
+// AgentIDHeader states ID of the agent sending metrics.
+const AgentIDHeader = "X-Agent-ID"
+
+// WithSource puts source of the request into its context: agent ID if stated,
+// otherwise X-Real-IP header or remote address of the connection.
+func WithSource(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(storage.WithSource(r.Context(), Of(r))))
+	})
+}
+
+// Of returns source of the request.
+func Of(r *http.Request) string {
+	if id := r.Header.Get(AgentIDHeader); id != "" {
+		return id
+	}
+	return IP(r)
+}
+
+// IP returns client IP stated in X-Real-IP header or remote address of the connection.
+func IP(r *http.Request) string {
+	if ip := r.Header.Get("X-Real-IP"); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
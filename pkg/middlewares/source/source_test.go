@@ -0,0 +1,37 @@
+package source
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/igortoigildin/go-metrics-altering/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{name: "Agent ID", headers: map[string]string{AgentIDHeader: "agent-1", "X-Real-IP": "10.0.0.1"}, want: "agent-1"},
+		{name: "Real IP", headers: map[string]string{"X-Real-IP": "10.0.0.1"}, want: "10.0.0.1"},
+		{name: "Remote address", want: "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := WithSource(func(w http.ResponseWriter, r *http.Request) {
+				got = storage.SourceFromContext(r.Context())
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/update/", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
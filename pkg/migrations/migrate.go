@@ -1,30 +1,150 @@
+// Package migrations manages schema of the postgres storage. Migrations are embedded into the binary.
 package migrations
 
 import (
+	"context"
 	"database/sql"
+	"embed"
 	"errors"
+	"fmt"
+	"io/fs"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
-	"github.com/igortoigildin/go-metrics-altering/pkg/logger"
-	"go.uber.org/zap"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 )
 
-func New(migrationsPath string, db *sql.DB) error {
-	if migrationsPath == "" {
-		return errors.New("missing migrations path")
+//go:embed sql/*.sql
+var files embed.FS
+
+// ErrSchemaBehind is returned by Check when the database is not migrated to the latest version.
+var ErrSchemaBehind = errors.New("database schema is behind")
+
+// Status describes schema version of the database.
+type Status struct {
+	Version uint // applied version, 0 if no migrations were applied
+	Latest  uint // latest embedded version
+	Dirty   bool // last migration failed and the schema must be fixed by hand and forced
+}
+
+func (s Status) String() string {
+	res := fmt.Sprintf("version %d, latest %d", s.Version, s.Latest)
+	if s.Dirty {
+		res += ", dirty"
+	}
+	return res
+}
+
+// Migrator applies embedded migrations to the database.
+type Migrator struct {
+	migrate *migrate.Migrate
+	latest  uint
+}
+
+// New returns migrator of the database. Migrator holds a connection of the database until it is closed,
+// the database itself is not closed by the migrator.
+func New(db *sql.DB) (*Migrator, error) {
+	src, err := iofs.New(files, "sql")
+	if err != nil {
+		return nil, err
 	}
-	instance, err := postgres.WithInstance(db, &postgres.Config{})
+	latest, err := latestVersion(src)
 	if err != nil {
-		logger.Log.Fatal("migration error", zap.Error(err))
+		return nil, err
+	}
+
+	// the driver is made of the connection rather than the database, so that closing it releases
+	// the connection only
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	instance, err := postgres.WithConnection(ctx, conn, &postgres.Config{})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	m, err := migrate.NewWithInstance("iofs", src, "postgres", instance)
+	if err != nil {
+		instance.Close()
+		return nil, err
+	}
+	return &Migrator{migrate: m, latest: latest}, nil
+}
+
+// Close releases the connection held by the migrator.
+func (m *Migrator) Close() error {
+	srcErr, dbErr := m.migrate.Close()
+	return errors.Join(srcErr, dbErr)
+}
+
+// Up applies all pending migrations.
+func (m *Migrator) Up() error {
+	return ignoreNoChange(m.migrate.Up())
+}
+
+// Down rolls back n last applied migrations.
+func (m *Migrator) Down(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("invalid number of migrations %d", n)
+	}
+	return ignoreNoChange(m.migrate.Steps(-n))
+}
+
+// Force sets schema version without running migrations and clears the dirty flag.
+// Version -1 means no migrations were applied.
+func (m *Migrator) Force(version int) error {
+	return m.migrate.Force(version)
+}
+
+// Status returns applied and latest schema versions.
+func (m *Migrator) Status() (Status, error) {
+	version, dirty, err := m.migrate.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return Status{}, err
 	}
+	return Status{Version: version, Latest: m.latest, Dirty: dirty}, nil
+}
 
-	migrator, err := migrate.NewWithDatabaseInstance("file://migrations", "postgres", instance)
+// Check returns ErrSchemaBehind if the database is not migrated to the latest version or is dirty.
+func (m *Migrator) Check() error {
+	status, err := m.Status()
 	if err != nil {
-		logger.Log.Fatal("migration error", zap.Error(err))
+		return err
 	}
-	if err = migrator.Up(); err != nil && err != migrate.ErrNoChange {
-		logger.Log.Fatal("migration error", zap.Error(err))
+	return status.check()
+}
+
+func (s Status) check() error {
+	if s.Dirty || s.Version < s.Latest {
+		return fmt.Errorf("%w: %s", ErrSchemaBehind, s)
 	}
 	return nil
 }
+
+// latestVersion returns version of the last migration of the source.
+func latestVersion(src source.Driver) (uint, error) {
+	version, err := src.First()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		version = next
+	}
+}
+
+func ignoreNoChange(err error) error {
+	if errors.Is(err, migrate.ErrNoChange) {
+		return nil
+	}
+	return err
+}
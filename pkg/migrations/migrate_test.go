@@ -0,0 +1,64 @@
+package migrations
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_latestVersion(t *testing.T) {
+	src, err := iofs.New(files, "sql")
+	require.NoError(t, err)
+
+	latest, err := latestVersion(src)
+	require.NoError(t, err)
+	assert.Equal(t, uint(7), latest)
+}
+
+func TestStatus_check(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  Status
+		wantErr bool
+	}{
+		{name: "Latest", status: Status{Version: 6, Latest: 6}, wantErr: false},
+		{name: "Behind", status: Status{Version: 5, Latest: 6}, wantErr: true},
+		{name: "Empty", status: Status{Latest: 6}, wantErr: true},
+		{name: "Dirty", status: Status{Version: 6, Latest: 6, Dirty: true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.status.check()
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrSchemaBehind)
+		})
+	}
+}
+
+func TestMigrator_Close(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT CURRENT_DATABASE\(\)`).WillReturnRows(sqlmock.NewRows([]string{"db"}).AddRow("metrics"))
+	mock.ExpectQuery(`SELECT CURRENT_SCHEMA\(\)`).WillReturnRows(sqlmock.NewRows([]string{"schema"}).AddRow("public"))
+	mock.ExpectExec("SELECT pg_advisory_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT COUNT\(1\) FROM information_schema.tables`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	migrator, err := New(db)
+	require.NoError(t, err)
+	assert.Equal(t, 1, db.Stats().InUse)
+
+	// the connection is released, the database is left open
+	require.NoError(t, migrator.Close())
+	assert.Equal(t, 0, db.Stats().InUse)
+	require.NoError(t, db.Ping())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
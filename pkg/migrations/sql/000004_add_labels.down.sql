@@ -0,0 +1,17 @@
+DROP INDEX IF EXISTS counter_samples_name_labels_ts_idx;
+ALTER TABLE counter_samples DROP COLUMN IF EXISTS labels;
+CREATE INDEX IF NOT EXISTS counter_samples_name_ts_idx ON counter_samples (name, ts);
+
+DROP INDEX IF EXISTS gauge_samples_name_labels_ts_idx;
+ALTER TABLE gauge_samples DROP COLUMN IF EXISTS labels;
+CREATE INDEX IF NOT EXISTS gauge_samples_name_ts_idx ON gauge_samples (name, ts);
+
+DELETE FROM gauges WHERE labels <> '{}';
+ALTER TABLE gauges DROP CONSTRAINT IF EXISTS gauges_pkey;
+ALTER TABLE gauges DROP COLUMN IF EXISTS labels;
+ALTER TABLE gauges ADD PRIMARY KEY (name);
+
+DELETE FROM counters WHERE labels <> '{}';
+ALTER TABLE counters DROP CONSTRAINT IF EXISTS counters_pkey;
+ALTER TABLE counters DROP COLUMN IF EXISTS labels;
+ALTER TABLE counters ADD PRIMARY KEY (name);
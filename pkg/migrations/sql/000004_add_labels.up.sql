@@ -0,0 +1,15 @@
+ALTER TABLE counters ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE counters DROP CONSTRAINT IF EXISTS counters_pkey;
+ALTER TABLE counters ADD PRIMARY KEY (name, labels);
+
+ALTER TABLE gauges ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE gauges DROP CONSTRAINT IF EXISTS gauges_pkey;
+ALTER TABLE gauges ADD PRIMARY KEY (name, labels);
+
+ALTER TABLE gauge_samples ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+DROP INDEX IF EXISTS gauge_samples_name_ts_idx;
+CREATE INDEX IF NOT EXISTS gauge_samples_name_labels_ts_idx ON gauge_samples (name, labels, ts);
+
+ALTER TABLE counter_samples ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+DROP INDEX IF EXISTS counter_samples_name_ts_idx;
+CREATE INDEX IF NOT EXISTS counter_samples_name_labels_ts_idx ON counter_samples (name, labels, ts);
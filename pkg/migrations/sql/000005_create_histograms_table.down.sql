@@ -0,0 +1 @@
+DROP TABLE IF EXISTS histograms;
\ No newline at end of file
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS histograms (
+    id SERIAL,
+    name TEXT NOT NULL,
+    type TEXT NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}',
+    bounds DOUBLE PRECISION[] NOT NULL,
+    counts BIGINT[] NOT NULL,
+    sum DOUBLE PRECISION NOT NULL DEFAULT 0,
+    count BIGINT NOT NULL DEFAULT 0,
+    primary key(name, labels)
+);
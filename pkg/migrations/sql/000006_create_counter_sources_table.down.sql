@@ -0,0 +1 @@
+DROP TABLE IF EXISTS counter_sources;
\ No newline at end of file
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS counter_sources (
+    name TEXT NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}',
+    source TEXT NOT NULL,
+    value BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    primary key(name, labels, source)
+);
@@ -0,0 +1,32 @@
+DROP TABLE IF EXISTS gauge_samples_1m;
+DROP TABLE IF EXISTS gauge_samples_1h;
+DROP TABLE IF EXISTS counter_samples_1m;
+DROP TABLE IF EXISTS counter_samples_1h;
+
+ALTER TABLE gauge_samples RENAME TO gauge_samples_old;
+DROP INDEX IF EXISTS gauge_samples_name_labels_ts_idx;
+CREATE TABLE gauge_samples (
+    id BIGSERIAL,
+    name TEXT NOT NULL,
+    ts TIMESTAMPTZ NOT NULL DEFAULT now(),
+    value DOUBLE PRECISION,
+    labels JSONB NOT NULL DEFAULT '{}',
+    primary key(id)
+);
+CREATE INDEX gauge_samples_name_labels_ts_idx ON gauge_samples (name, labels, ts);
+INSERT INTO gauge_samples(name, labels, ts, value) SELECT name, labels, ts, value FROM gauge_samples_old ORDER BY ts;
+DROP TABLE gauge_samples_old;
+
+ALTER TABLE counter_samples RENAME TO counter_samples_old;
+DROP INDEX IF EXISTS counter_samples_name_labels_ts_idx;
+CREATE TABLE counter_samples (
+    id BIGSERIAL,
+    name TEXT NOT NULL,
+    ts TIMESTAMPTZ NOT NULL DEFAULT now(),
+    value bigint,
+    labels JSONB NOT NULL DEFAULT '{}',
+    primary key(id)
+);
+CREATE INDEX counter_samples_name_labels_ts_idx ON counter_samples (name, labels, ts);
+INSERT INTO counter_samples(name, labels, ts, value) SELECT name, labels, ts, value FROM counter_samples_old ORDER BY ts;
+DROP TABLE counter_samples_old;
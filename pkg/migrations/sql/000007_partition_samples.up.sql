@@ -0,0 +1,69 @@
+-- samples are partitioned by time, so that expired ones are dropped with their partitions;
+-- partitions are created by the server, rows out of their ranges go to the default partitions
+ALTER TABLE gauge_samples RENAME TO gauge_samples_old;
+DROP INDEX IF EXISTS gauge_samples_name_labels_ts_idx;
+CREATE TABLE gauge_samples (
+    name TEXT NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}',
+    ts TIMESTAMPTZ NOT NULL DEFAULT now(),
+    value DOUBLE PRECISION
+) PARTITION BY RANGE (ts);
+CREATE INDEX gauge_samples_name_labels_ts_idx ON gauge_samples (name, labels, ts);
+CREATE TABLE gauge_samples_default PARTITION OF gauge_samples DEFAULT;
+INSERT INTO gauge_samples(name, labels, ts, value) SELECT name, labels, ts, value FROM gauge_samples_old;
+DROP TABLE gauge_samples_old;
+
+ALTER TABLE counter_samples RENAME TO counter_samples_old;
+DROP INDEX IF EXISTS counter_samples_name_labels_ts_idx;
+CREATE TABLE counter_samples (
+    name TEXT NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}',
+    ts TIMESTAMPTZ NOT NULL DEFAULT now(),
+    value bigint
+) PARTITION BY RANGE (ts);
+CREATE INDEX counter_samples_name_labels_ts_idx ON counter_samples (name, labels, ts);
+CREATE TABLE counter_samples_default PARTITION OF counter_samples DEFAULT;
+INSERT INTO counter_samples(name, labels, ts, value) SELECT name, labels, ts, value FROM counter_samples_old;
+DROP TABLE counter_samples_old;
+
+-- rollups hold sum and number of gauge values, so that averages are exact at any resolution,
+-- and the last value of counters with its increase over the interval starting at ts
+CREATE TABLE IF NOT EXISTS gauge_samples_1m (
+    name TEXT NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}',
+    ts TIMESTAMPTZ NOT NULL,
+    sum DOUBLE PRECISION NOT NULL,
+    count BIGINT NOT NULL,
+    primary key(name, labels, ts)
+) PARTITION BY RANGE (ts);
+CREATE TABLE IF NOT EXISTS gauge_samples_1m_default PARTITION OF gauge_samples_1m DEFAULT;
+
+CREATE TABLE IF NOT EXISTS gauge_samples_1h (
+    name TEXT NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}',
+    ts TIMESTAMPTZ NOT NULL,
+    sum DOUBLE PRECISION NOT NULL,
+    count BIGINT NOT NULL,
+    primary key(name, labels, ts)
+) PARTITION BY RANGE (ts);
+CREATE TABLE IF NOT EXISTS gauge_samples_1h_default PARTITION OF gauge_samples_1h DEFAULT;
+
+CREATE TABLE IF NOT EXISTS counter_samples_1m (
+    name TEXT NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}',
+    ts TIMESTAMPTZ NOT NULL,
+    value BIGINT NOT NULL,
+    increase BIGINT NOT NULL,
+    primary key(name, labels, ts)
+) PARTITION BY RANGE (ts);
+CREATE TABLE IF NOT EXISTS counter_samples_1m_default PARTITION OF counter_samples_1m DEFAULT;
+
+CREATE TABLE IF NOT EXISTS counter_samples_1h (
+    name TEXT NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}',
+    ts TIMESTAMPTZ NOT NULL,
+    value BIGINT NOT NULL,
+    increase BIGINT NOT NULL,
+    primary key(name, labels, ts)
+) PARTITION BY RANGE (ts);
+CREATE TABLE IF NOT EXISTS counter_samples_1h_default PARTITION OF counter_samples_1h DEFAULT;